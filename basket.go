@@ -0,0 +1,581 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Indices are published under IndexKeyPrefix+name for the live value,
+// IndexKeyPrefix+name+":candles" for the live bars, each set as it completes
+// so that Cache.Set keeps them in the key's list, and
+// IndexKeyPrefix+name+":constituents" for the rebalance history.
+const (
+	IndexKeyPrefix     = "index:"
+	IndexHistoryMaxLen = 10000
+)
+
+type WeightingScheme int
+
+const (
+	EqualWeight WeightingScheme = iota
+	MarketCapWeight
+	InverseVolatilityWeight
+)
+
+var weightingSchemeNames = map[WeightingScheme]string{
+	EqualWeight:             "equal",
+	MarketCapWeight:         "market_cap",
+	InverseVolatilityWeight: "inverse_volatility",
+}
+
+func (w WeightingScheme) String() string {
+	if name, ok := weightingSchemeNames[w]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+func (w WeightingScheme) MarshalText() ([]byte, error) {
+	return []byte(w.String()), nil
+}
+
+func (w *WeightingScheme) UnmarshalText(text []byte) error {
+	for scheme, name := range weightingSchemeNames {
+		if name == string(text) {
+			*w = scheme
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown weighting scheme %q", text)
+}
+
+// Basket defines an index. Its constituents are Symbols or, with
+// TopByQuoteVolume, the symbols of the universe (or of Symbols when set) with
+// the highest quote volume over the last VolumeWindow bars. Market cap weights
+// need the circulating Supply of each constituent, inverse volatility weights
+// use the standard deviation of log returns over VolatilityWindow bars, by
+// default as many as the candles fetched at startup give. The basket is
+// rebalanced every Rebalance interval, such as "1d", and starts at
+// BaseValue.
+type Basket struct {
+	Name             string             `json:"name"`
+	Symbols          []string           `json:"symbols"`
+	TopByQuoteVolume int                `json:"top_by_quote_volume"`
+	VolumeWindow     int                `json:"volume_window"`
+	Weighting        WeightingScheme    `json:"weighting"`
+	Supply           map[string]float64 `json:"supply"`
+	VolatilityWindow int                `json:"volatility_window"`
+	Rebalance        string             `json:"rebalance"`
+	BaseValue        float64            `json:"base_value"`
+}
+
+// loadBaskets reads a JSON list of baskets, filling in the defaults.
+func loadBaskets(path string) ([]Basket, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var baskets []Basket
+	if err := json.Unmarshal(data, &baskets); err != nil {
+		return nil, err
+	}
+	for i := range baskets {
+		if err := baskets[i].validate(); err != nil {
+			return nil, fmt.Errorf("basket %s: %w", baskets[i].Name, err)
+		}
+	}
+	return baskets, nil
+}
+
+func (b *Basket) validate() error {
+	if b.Name == "" {
+		return errors.New("missing name")
+	}
+	if len(b.Symbols) == 0 && b.TopByQuoteVolume <= 0 {
+		return errors.New("needs symbols or top_by_quote_volume")
+	}
+	if b.Weighting == MarketCapWeight && len(b.Supply) == 0 {
+		return errors.New("market cap weights need a supply")
+	}
+	if b.VolumeWindow <= 0 {
+		b.VolumeWindow = 100
+	}
+	if b.VolatilityWindow <= 0 {
+		b.VolatilityWindow = CandleHistoryLimit - 1
+	}
+	if b.Rebalance == "" {
+		b.Rebalance = "1d"
+	}
+	if b.BaseValue <= 0 {
+		b.BaseValue = 1000
+	}
+
+	_, err := intervalDuration(b.Rebalance)
+	return err
+}
+
+// nextRebalance returns the start of the rebalance period after t's.
+func (b Basket) nextRebalance(t time.Time) time.Time {
+	every, err := intervalDuration(b.Rebalance)
+	if err != nil {
+		every = 24 * time.Hour
+	}
+	return alignTime(t, every).Add(every)
+}
+
+// Constituent is a basket member as of a rebalance. Units is the quantity held
+// per index unit, so that the index value is the sum of Units times price.
+type Constituent struct {
+	Symbol string
+	Weight float64
+	Price  float64
+	Units  float64
+}
+
+// BasketRebalance records the constituents a basket held from Time on.
+type BasketRebalance struct {
+	Time         time.Time
+	Value        float64
+	Constituents []Constituent
+}
+
+// candlesBefore returns the candles opened before t.
+func candlesBefore(data []Candlestick, t time.Time) []Candlestick {
+	end := sort.Search(len(data), func(i int) bool { return !data[i].OpenTime.Before(t) })
+	return data[:end]
+}
+
+// selectConstituents returns the basket's constituents from the candles
+// available at the rebalance, with their weights summing to 1 and their last
+// close. Symbols without the history their weighting needs are left out.
+func selectConstituents(basket Basket, candles map[string][]Candlestick, universe []string) []Constituent {
+	candidates := basket.Symbols
+	if len(candidates) == 0 {
+		candidates = universe
+	}
+
+	if basket.TopByQuoteVolume > 0 {
+		volumes := make(map[string]float64, len(candidates))
+		var ranked []string
+		for _, symbol := range candidates {
+			data := candles[symbol]
+			if len(data) == 0 {
+				continue
+			}
+			if len(data) > basket.VolumeWindow {
+				data = data[len(data)-basket.VolumeWindow:]
+			}
+			for _, candle := range data {
+				volumes[symbol] += candle.QuoteAssetVolume
+			}
+			ranked = append(ranked, symbol)
+		}
+		sort.SliceStable(ranked, func(i, j int) bool { return volumes[ranked[i]] > volumes[ranked[j]] })
+		if len(ranked) > basket.TopByQuoteVolume {
+			ranked = ranked[:basket.TopByQuoteVolume]
+		}
+		candidates = ranked
+	}
+
+	var constituents []Constituent
+	total := 0.0
+	for _, symbol := range candidates {
+		data := candles[symbol]
+		if len(data) == 0 {
+			continue
+		}
+		price := data[len(data)-1].Close
+
+		var score float64
+		switch basket.Weighting {
+		case EqualWeight:
+			score = 1
+		case MarketCapWeight:
+			score = basket.Supply[symbol] * price
+		case InverseVolatilityWeight:
+			if len(data) <= basket.VolatilityWindow {
+				continue
+			}
+			returns := logReturns(closePrices(data[len(data)-basket.VolatilityWindow-1:]))
+			variance := rollingSampleVariance(returns, basket.VolatilityWindow)
+			if variance == nil || !(variance[len(variance)-1] > 0) {
+				continue
+			}
+			score = 1 / math.Sqrt(variance[len(variance)-1])
+		}
+		if !(score > 0) || !(price > 0) {
+			continue
+		}
+		constituents = append(constituents, Constituent{Symbol: symbol, Weight: score, Price: price})
+		total += score
+	}
+
+	for i := range constituents {
+		constituents[i].Weight /= total
+	}
+	return constituents
+}
+
+// rebalance selects the constituents from the candles opened before at and
+// sizes them so the basket is worth value.
+func rebalance(basket Basket, candles map[string][]Candlestick, universe []string, at time.Time, value float64) (BasketRebalance, bool) {
+	available := make(map[string][]Candlestick, len(candles))
+	for symbol, data := range candles {
+		available[symbol] = candlesBefore(data, at)
+	}
+
+	constituents := selectConstituents(basket, available, universe)
+	if len(constituents) == 0 {
+		return BasketRebalance{}, false
+	}
+	for i := range constituents {
+		constituents[i].Units = constituents[i].Weight * value / constituents[i].Price
+	}
+	return BasketRebalance{Time: at, Value: value, Constituents: constituents}, true
+}
+
+// buildIndexCandles computes the basket's candles from its constituents'
+// candles, rebalancing at the first bar with enough history and then every
+// Rebalance interval. A constituent without a candle in a bar counts at its
+// last close. The high and low are the sums of the constituents' highs and
+// lows, the bounds of the index's range since the constituents need not peak
+// together. Volume is the quote volume in index units.
+func buildIndexCandles(basket Basket, candles map[string][]Candlestick, universe []string) ([]Candlestick, []BasketRebalance) {
+	var times []time.Time
+	seen := make(map[time.Time]bool)
+	for _, data := range candles {
+		for _, candle := range data {
+			if !seen[candle.OpenTime] {
+				seen[candle.OpenTime] = true
+				times = append(times, candle.OpenTime)
+			}
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+
+	var bars []Candlestick
+	var history []BasketRebalance
+	var next time.Time
+	for _, t := range times {
+		if len(history) == 0 || !t.Before(next) {
+			value := basket.BaseValue
+			if len(bars) > 0 {
+				value = bars[len(bars)-1].Close
+			}
+			if r, ok := rebalance(basket, candles, universe, t, value); ok {
+				history = append(history, r)
+				next = basket.nextRebalance(t)
+			}
+		}
+		if len(history) == 0 {
+			continue
+		}
+
+		bar := Candlestick{OpenTime: t}
+		for _, c := range history[len(history)-1].Constituents {
+			data := candles[c.Symbol]
+			i := sort.Search(len(data), func(i int) bool { return !data[i].OpenTime.Before(t) })
+			if i == len(data) || !data[i].OpenTime.Equal(t) {
+				price := c.Price
+				if i > 0 {
+					price = data[i-1].Close
+				}
+				bar.Open += c.Units * price
+				bar.High += c.Units * price
+				bar.Low += c.Units * price
+				bar.Close += c.Units * price
+				continue
+			}
+
+			candle := data[i]
+			bar.Open += c.Units * candle.Open
+			bar.High += c.Units * candle.High
+			bar.Low += c.Units * candle.Low
+			bar.Close += c.Units * candle.Close
+			bar.QuoteAssetVolume += candle.QuoteAssetVolume
+			if candle.CloseTime.After(bar.CloseTime) {
+				bar.CloseTime = candle.CloseTime
+			}
+		}
+		if bar.Close > 0 {
+			bar.Volume = bar.QuoteAssetVolume / bar.Close
+		}
+		bars = append(bars, bar)
+	}
+	return bars, history
+}
+
+// indexCandles builds the basket's candles from the cached candles of the
+// universe, resampled to interval unless it is empty.
+func indexCandles(cache *Cache, basket Basket, universe []string, interval string) ([]Candlestick, []BasketRebalance, error) {
+	candles := make(map[string][]Candlestick, len(universe))
+	for _, symbol := range basketUniverse(basket, universe) {
+		data, err := cache.GetCandlesticks(symbol)
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading candles for %s: %w", symbol, err)
+		}
+		candles[symbol] = data
+	}
+
+	bars, history := buildIndexCandles(basket, candles, universe)
+	if interval == "" {
+		return bars, history, nil
+	}
+	resampled, err := resampleCandles(bars, interval)
+	return resampled, history, err
+}
+
+// basketUniverse returns the symbols the basket may hold.
+func basketUniverse(basket Basket, universe []string) []string {
+	if len(basket.Symbols) > 0 {
+		return basket.Symbols
+	}
+	return universe
+}
+
+// IndexSnapshot is the live value of an index.
+type IndexSnapshot struct {
+	Name         string
+	Time         time.Time
+	Value        float64
+	Constituents []Constituent
+}
+
+type basketState struct {
+	basket  Basket
+	current BasketRebalance
+	history []BasketRebalance
+	bars    []Candlestick
+	updated time.Time
+}
+
+// IndexTracker computes the baskets' values live from the trade stream, in
+// bars of the given interval, and publishes each completed bar.
+type IndexTracker struct {
+	interval time.Duration
+	cache    *Cache
+
+	mu         sync.Mutex
+	baskets    map[string]*basketState
+	prices     map[string]float64
+	tradeTimes map[string]int64
+}
+
+func NewIndexTracker(baskets []Basket, interval time.Duration, cache *Cache) *IndexTracker {
+	states := make(map[string]*basketState, len(baskets))
+	for _, basket := range baskets {
+		states[basket.Name] = &basketState{basket: basket}
+	}
+	return &IndexTracker{
+		interval:   interval,
+		cache:      cache,
+		baskets:    states,
+		prices:     make(map[string]float64),
+		tradeTimes: make(map[string]int64),
+	}
+}
+
+// value returns the basket's value at the latest trade prices, using the
+// rebalance price for constituents that have not traded since.
+func (t *IndexTracker) value(state *basketState) float64 {
+	value := 0.0
+	for _, c := range state.current.Constituents {
+		price, ok := t.prices[c.Symbol]
+		if !ok {
+			price = c.Price
+		}
+		value += c.Units * price
+	}
+	return value
+}
+
+// AddTrade updates the value of every basket holding the symbol. A late trade,
+// older than the symbol's latest, adds its volume to its bar if the index has
+// one, but its price is not used.
+func (t *IndexTracker) AddTrade(symbol string, trade Trade) {
+	tradeTime := time.UnixMilli(trade.Time)
+	openTime := alignTime(tradeTime, t.interval)
+
+	t.mu.Lock()
+	late := trade.Time < t.tradeTimes[symbol]
+	if !late {
+		t.prices[symbol] = trade.Price
+		t.tradeTimes[symbol] = trade.Time
+	}
+	completed := make(map[string]Candlestick)
+	for name, state := range t.baskets {
+		held := false
+		for _, c := range state.current.Constituents {
+			if c.Symbol == symbol {
+				held = true
+				break
+			}
+		}
+		if !held {
+			continue
+		}
+
+		value := t.value(state)
+		if len(state.bars) == 0 || state.bars[len(state.bars)-1].OpenTime.Before(openTime) {
+			if len(state.bars) > 0 {
+				completed[name] = state.bars[len(state.bars)-1]
+			}
+			state.bars = append(state.bars, Candlestick{
+				OpenTime:  openTime,
+				Open:      value,
+				High:      value,
+				Low:       value,
+				CloseTime: openTime.Add(t.interval - time.Millisecond),
+			})
+			if len(state.bars) > IndexHistoryMaxLen {
+				state.bars = state.bars[len(state.bars)-IndexHistoryMaxLen:]
+			}
+		}
+
+		last := &state.bars[len(state.bars)-1]
+		last.High = math.Max(last.High, value)
+		last.Low = math.Min(last.Low, value)
+		last.Close = value
+
+		i := sort.Search(len(state.bars), func(j int) bool { return !state.bars[j].OpenTime.Before(openTime) })
+		if i == len(state.bars) || !state.bars[i].OpenTime.Equal(openTime) {
+			continue
+		}
+		bar := &state.bars[i]
+		bar.QuoteAssetVolume += trade.Price * trade.Quantity
+		if bar.Close > 0 {
+			bar.Volume = bar.QuoteAssetVolume / bar.Close
+		}
+		if tradeTime.After(state.updated) {
+			state.updated = tradeTime
+		}
+	}
+	t.mu.Unlock()
+
+	if t.cache == nil {
+		return
+	}
+	for name, bar := range completed {
+		if err := t.cache.Set(IndexKeyPrefix+name+":candles", bar, 0); err != nil {
+			log.Printf("Error publishing index candle for %s: %v\n", name, err)
+		}
+	}
+}
+
+// Rebalance reselects the basket's constituents from the candles if it is due
+// at now, keeping the index value continuous.
+func (t *IndexTracker) Rebalance(name string, candles map[string][]Candlestick, universe []string, now time.Time) (BasketRebalance, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.baskets[name]
+	if !ok {
+		return BasketRebalance{}, false
+	}
+	if len(state.history) > 0 && now.Before(state.basket.nextRebalance(state.current.Time)) {
+		return BasketRebalance{}, false
+	}
+
+	value := state.basket.BaseValue
+	if len(state.history) > 0 {
+		value = t.value(state)
+	}
+	r, ok := rebalance(state.basket, candles, universe, now, value)
+	if !ok {
+		return BasketRebalance{}, false
+	}
+
+	// Trades since the candles closed are priced in already
+	for i, c := range r.Constituents {
+		if price, ok := t.prices[c.Symbol]; ok {
+			r.Constituents[i].Price = price
+			r.Constituents[i].Units = c.Weight * value / price
+		}
+	}
+	state.current = r
+	state.history = append(state.history, r)
+	return r, true
+}
+
+// Current returns the live value of the index.
+func (t *IndexTracker) Current(name string) (IndexSnapshot, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.baskets[name]
+	if !ok || len(state.history) == 0 {
+		return IndexSnapshot{}, false
+	}
+	return IndexSnapshot{
+		Name:         name,
+		Time:         state.updated,
+		Value:        t.value(state),
+		Constituents: append([]Constituent(nil), state.current.Constituents...),
+	}, true
+}
+
+// History returns a copy of the index's live bars, the last one still in
+// progress.
+func (t *IndexTracker) History(name string) []Candlestick {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.baskets[name]
+	if !ok {
+		return nil
+	}
+	return append([]Candlestick(nil), state.bars...)
+}
+
+// Constituents returns the index's rebalance history.
+func (t *IndexTracker) Constituents(name string) []BasketRebalance {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.baskets[name]
+	if !ok {
+		return nil
+	}
+	return append([]BasketRebalance(nil), state.history...)
+}
+
+// indexRoutine periodically rebalances the baskets that are due from the
+// closed cached candles and publishes their live values and constituents.
+func indexRoutine(cache *Cache, tracker *IndexTracker, baskets []Basket, universe []string, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for ; true; <-ticker.C {
+		now := time.Now()
+		for _, basket := range baskets {
+			candles := make(map[string][]Candlestick)
+			for _, symbol := range basketUniverse(basket, universe) {
+				data, err := cache.GetCandlesticks(symbol)
+				if err != nil {
+					log.Printf("Error reading candles for symbol %s: %v\n", symbol, err)
+					continue
+				}
+				candles[symbol] = closedCandles(data, now)
+			}
+
+			if _, ok := tracker.Rebalance(basket.Name, candles, universe, now); ok {
+				if err := cache.Put(IndexKeyPrefix+basket.Name+":constituents", tracker.Constituents(basket.Name), 0); err != nil {
+					log.Printf("Error publishing constituents of %s: %v\n", basket.Name, err)
+				}
+			}
+			if snapshot, ok := tracker.Current(basket.Name); ok {
+				if err := cache.Put(IndexKeyPrefix+basket.Name, snapshot, 0); err != nil {
+					log.Printf("Error publishing index %s: %v\n", basket.Name, err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// alternatingCandles returns candles whose log returns alternate between
+// +step and -step, so their volatility is proportional to step.
+func alternatingCandles(start time.Time, n int, step float64) []Candlestick {
+	return walkCandles(start, n, func(i int) float64 {
+		if i%2 == 0 {
+			return -step
+		}
+		return step
+	})
+}
+
+func constituentWeights(constituents []Constituent) map[string]float64 {
+	weights := make(map[string]float64, len(constituents))
+	for _, c := range constituents {
+		weights[c.Symbol] = c.Weight
+	}
+	return weights
+}
+
+func TestSelectConstituentsWeighting(t *testing.T) {
+	start := time.Unix(1700000040, 0)
+	candles := map[string][]Candlestick{
+		"AUSDT": alternatingCandles(start, 21, 0.01),
+		"BUSDT": alternatingCandles(start, 21, 0.02),
+		"CUSDT": alternatingCandles(start, 21, 0.04),
+	}
+	for s, symbol := range []string{"AUSDT", "BUSDT", "CUSDT"} {
+		for i := range candles[symbol] {
+			candles[symbol][i].QuoteAssetVolume = float64(s + 1)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		basket Basket
+		want   map[string]float64
+	}{
+		{"equal", Basket{Symbols: []string{"AUSDT", "BUSDT"}, Weighting: EqualWeight},
+			map[string]float64{"AUSDT": 0.5, "BUSDT": 0.5}},
+		// The last closes of AUSDT and BUSDT are both 100
+		{"market cap", Basket{Symbols: []string{"AUSDT", "BUSDT"}, Weighting: MarketCapWeight, Supply: map[string]float64{"AUSDT": 10, "BUSDT": 30}},
+			map[string]float64{"AUSDT": 0.25, "BUSDT": 0.75}},
+		{"inverse volatility", Basket{Symbols: []string{"AUSDT", "BUSDT"}, Weighting: InverseVolatilityWeight, VolatilityWindow: 10},
+			map[string]float64{"AUSDT": 2.0 / 3, "BUSDT": 1.0 / 3}},
+		// Without enough history a symbol is left out
+		{"short history", Basket{Symbols: []string{"AUSDT", "BUSDT"}, Weighting: InverseVolatilityWeight, VolatilityWindow: 21},
+			map[string]float64{}},
+		{"top by quote volume", Basket{TopByQuoteVolume: 2, VolumeWindow: 10, Weighting: EqualWeight},
+			map[string]float64{"BUSDT": 0.5, "CUSDT": 0.5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := constituentWeights(selectConstituents(tt.basket, candles, []string{"AUSDT", "BUSDT", "CUSDT"}))
+			if len(got) != len(tt.want) {
+				t.Fatalf("weights = %v, want %v", got, tt.want)
+			}
+			for symbol, weight := range tt.want {
+				if math.Abs(got[symbol]-weight) > 1e-9 {
+					t.Errorf("weights = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildIndexCandlesContinuity(t *testing.T) {
+	start := time.Unix(1700000040, 0)
+	candles := map[string][]Candlestick{
+		"AUSDT": walkCandles(start, 180, func(i int) float64 { return 0.01 * math.Sin(float64(i)/5) }),
+		"BUSDT": walkCandles(start, 180, func(i int) float64 { return 0.02 * math.Cos(float64(i)/7) }),
+	}
+	basket := Basket{Name: "test", Symbols: []string{"AUSDT", "BUSDT"}, Weighting: InverseVolatilityWeight, VolatilityWindow: 20, Rebalance: "1h"}
+	if err := basket.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	bars, history := buildIndexCandles(basket, candles, nil)
+	if len(history) < 3 {
+		t.Fatalf("got %d rebalances, want at least 3", len(history))
+	}
+	if history[0].Value != basket.BaseValue {
+		t.Errorf("first rebalance at %v, want %v", history[0].Value, basket.BaseValue)
+	}
+
+	// Each rebalance keeps the value of the previous bar
+	for _, r := range history[1:] {
+		i := 0
+		for i < len(bars) && bars[i].OpenTime.Before(r.Time) {
+			i++
+		}
+		value := 0.0
+		for _, c := range r.Constituents {
+			value += c.Units * c.Price
+		}
+		if math.Abs(r.Value-bars[i-1].Close) > 1e-9 || math.Abs(value-r.Value) > 1e-9 {
+			t.Errorf("rebalance at %v is worth %v and %v, previous close %v", r.Time, r.Value, value, bars[i-1].Close)
+		}
+	}
+}
+
+func TestIndexTracker(t *testing.T) {
+	start := time.Unix(1700000040, 0)
+	candles := map[string][]Candlestick{
+		"AUSDT": {testCandle(start, 100)},
+		"BUSDT": {testCandle(start, 50)},
+	}
+	basket := Basket{Name: "test", Symbols: []string{"AUSDT", "BUSDT"}, Weighting: EqualWeight}
+	if err := basket.validate(); err != nil {
+		t.Fatal(err)
+	}
+	tracker := NewIndexTracker([]Basket{basket}, time.Minute, nil)
+
+	now := start.Add(time.Minute)
+	if _, ok := tracker.Rebalance("test", candles, nil, now); !ok {
+		t.Fatal("no initial rebalance")
+	}
+	trade := func(at time.Duration, symbol string, price, quantity float64) {
+		tracker.AddTrade(symbol, Trade{Price: price, Quantity: quantity, Time: now.Add(at).UnixMilli()})
+	}
+
+	// 5 AUSDT and 10 BUSDT per index unit
+	trade(10*time.Second, "AUSDT", 110, 1)
+	trade(70*time.Second, "AUSDT", 120, 1)
+	// A late trade adds its volume to the first bar but keeps the latest price
+	trade(20*time.Second, "AUSDT", 90, 2)
+
+	snapshot, _ := tracker.Current("test")
+	if snapshot.Value != 1100 {
+		t.Errorf("value = %v, want 1100", snapshot.Value)
+	}
+	bars := tracker.History("test")
+	if len(bars) != 2 {
+		t.Fatalf("got %d bars, want 2", len(bars))
+	}
+	if bars[0].QuoteAssetVolume != 290 || bars[0].Close != 1050 || bars[0].Volume != 290.0/1050 {
+		t.Errorf("first bar = %+v, want a quote volume of 290 and a close of 1050", bars[0])
+	}
+	if bars[1].QuoteAssetVolume != 120 || bars[1].Close != 1100 {
+		t.Errorf("second bar = %+v, want a quote volume of 120 and a close of 1100", bars[1])
+	}
+
+	// Rebalancing is only due the next day and keeps the value continuous
+	if _, ok := tracker.Rebalance("test", candles, nil, now.Add(time.Hour)); ok {
+		t.Error("rebalanced before the next day")
+	}
+	r, ok := tracker.Rebalance("test", candles, nil, now.Add(24*time.Hour))
+	if !ok {
+		t.Fatal("no rebalance the next day")
+	}
+	snapshot, _ = tracker.Current("test")
+	if r.Value != 1100 || snapshot.Value != 1100 {
+		t.Errorf("value after the rebalance = %v, %v, want 1100", r.Value, snapshot.Value)
+	}
+	if weights := constituentWeights(snapshot.Constituents); weights["AUSDT"] != 0.5 || weights["BUSDT"] != 0.5 {
+		t.Errorf("weights = %v after the rebalance", weights)
+	}
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+)
+
+// Breadth series are published as Series under BreadthKeyPrefix+name, see
+// MarketBreadth.series for the names.
+const (
+	BreadthKeyPrefix     = "breadth:"
+	BreadthHistoryMaxLen = 10000
+)
+
+// BreadthOptions holds the breadth windows. A symbol makes a new high (low)
+// when its high (low) is beyond those of the previous HighLowWindow bars. The
+// composite weights each symbol's return by its quote volume when
+// VolumeWeighted is set, equally otherwise. The default windows fit in the
+// candles fetched at startup.
+type BreadthOptions struct {
+	SMAWindows     []int
+	HighLowWindow  int
+	McClellanFast  int
+	McClellanSlow  int
+	VolumeWeighted bool
+}
+
+func defaultBreadthOptions() BreadthOptions {
+	return BreadthOptions{
+		SMAWindows:    []int{20, 50, CandleHistoryLimit},
+		HighLowWindow: 50,
+		McClellanFast: 19,
+		McClellanSlow: 39,
+	}
+}
+
+// MarketBreadth holds the breadth of a set of symbols on the union of their
+// bars. The McClellan oscillator is computed on the ratio-adjusted net
+// advances, (advances - declines) / (advances + declines) * 1000, so it does
+// not depend on the number of symbols. Composite is an index starting at 100
+// that follows the weighted mean return of the symbols.
+type MarketBreadth struct {
+	Times              []time.Time
+	Advances           []float64
+	Declines           []float64
+	ADLine             []float64
+	PercentAboveSMA    map[int][]float64
+	NewHighs           []float64
+	NewLows            []float64
+	McClellan          []float64
+	McClellanSummation []float64
+	Composite          []float64
+
+	// returns are the weighted mean returns of the bars, NaN without weight,
+	// and reported the number of symbols having each bar
+	returns  []float64
+	reported []int
+}
+
+// runningEMA is an exponential moving average updated one value at a time,
+// seeded with the mean of the first Window values like calculateEMA.
+type runningEMA struct {
+	Window int
+	Count  int
+	Sum    float64
+	Value  float64
+}
+
+// Add adds a value and returns the average, NaN until Window values were added.
+func (e *runningEMA) Add(value float64) float64 {
+	if e.Window <= 0 {
+		return math.NaN()
+	}
+	if e.Count < e.Window {
+		e.Count++
+		e.Sum += value
+		if e.Count < e.Window {
+			return math.NaN()
+		}
+		e.Value = e.Sum / float64(e.Window)
+		return e.Value
+	}
+	e.Value += (value - e.Value) * 2 / (float64(e.Window) + 1)
+	return e.Value
+}
+
+// BreadthTotals are the running totals of the cumulative breadth series after
+// the bar opened at Time, from which the next bars continue.
+type BreadthTotals struct {
+	Time       time.Time
+	ADLine     float64
+	Composite  float64
+	Fast, Slow runningEMA
+	Summation  float64
+}
+
+func newBreadthTotals(opts BreadthOptions) BreadthTotals {
+	return BreadthTotals{
+		Composite: 100,
+		Fast:      runningEMA{Window: opts.McClellanFast},
+		Slow:      runningEMA{Window: opts.McClellanSlow},
+	}
+}
+
+// calculateBreadth computes the breadth of the symbols' candles.
+func calculateBreadth(candles map[string][]Candlestick, symbols []string, opts BreadthOptions) MarketBreadth {
+	breadth := countBreadth(candles, symbols, opts)
+	totals := newBreadthTotals(opts)
+	breadth.accumulate(&totals)
+	return breadth
+}
+
+// countBreadth computes the breadth of each bar of the symbols' candles,
+// leaving the cumulative series to accumulate.
+func countBreadth(candles map[string][]Candlestick, symbols []string, opts BreadthOptions) MarketBreadth {
+	index := make(map[time.Time]int)
+	for _, symbol := range symbols {
+		for _, candle := range candles[symbol] {
+			index[candle.OpenTime] = 0
+		}
+	}
+	times := make([]time.Time, 0, len(index))
+	for t := range index {
+		times = append(times, t)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	for i, t := range times {
+		index[t] = i
+	}
+
+	n := len(times)
+	breadth := MarketBreadth{
+		Times:           times,
+		Advances:        make([]float64, n),
+		Declines:        make([]float64, n),
+		NewHighs:        make([]float64, n),
+		NewLows:         make([]float64, n),
+		PercentAboveSMA: make(map[int][]float64),
+		returns:         nanSlice(n),
+		reported:        make([]int, n),
+	}
+	above := make(map[int][]float64)
+	counted := make(map[int][]float64)
+	for _, window := range opts.SMAWindows {
+		above[window] = make([]float64, n)
+		counted[window] = make([]float64, n)
+	}
+	weightedReturns := make([]float64, n)
+	weights := make([]float64, n)
+
+	for _, symbol := range symbols {
+		data := candles[symbol]
+		closes := closePrices(data)
+		smas := make(map[int][]float64)
+		for _, window := range opts.SMAWindows {
+			smas[window] = calculateSMA(closes, window)
+		}
+
+		for i, candle := range data {
+			bar := index[candle.OpenTime]
+			breadth.reported[bar]++
+			for window, sma := range smas {
+				if sma == nil || math.IsNaN(sma[i]) {
+					continue
+				}
+				counted[window][bar]++
+				if candle.Close > sma[i] {
+					above[window][bar]++
+				}
+			}
+			if i == 0 {
+				continue
+			}
+
+			switch {
+			case candle.Close > data[i-1].Close:
+				breadth.Advances[bar]++
+			case candle.Close < data[i-1].Close:
+				breadth.Declines[bar]++
+			}
+
+			weight := 1.0
+			if opts.VolumeWeighted {
+				weight = candle.QuoteAssetVolume
+			}
+			weightedReturns[bar] += weight * (candle.Close/data[i-1].Close - 1)
+			weights[bar] += weight
+
+			if opts.HighLowWindow <= 0 || i < opts.HighLowWindow {
+				continue
+			}
+			high, low := math.Inf(-1), math.Inf(1)
+			for _, prior := range data[i-opts.HighLowWindow : i] {
+				high = math.Max(high, prior.High)
+				low = math.Min(low, prior.Low)
+			}
+			if candle.High > high {
+				breadth.NewHighs[bar]++
+			}
+			if candle.Low < low {
+				breadth.NewLows[bar]++
+			}
+		}
+	}
+
+	for _, window := range opts.SMAWindows {
+		percent := nanSlice(n)
+		for i := range percent {
+			if counted[window][i] > 0 {
+				percent[i] = above[window][i] / counted[window][i] * 100
+			}
+		}
+		breadth.PercentAboveSMA[window] = percent
+	}
+
+	for i := range times {
+		if weights[i] > 0 {
+			breadth.returns[i] = weightedReturns[i] / weights[i]
+		}
+	}
+	return breadth
+}
+
+// accumulate computes the cumulative series of the bars, continuing from and
+// updating the totals.
+func (b *MarketBreadth) accumulate(totals *BreadthTotals) {
+	n := len(b.Times)
+	b.ADLine = make([]float64, n)
+	b.Composite = make([]float64, n)
+	b.McClellan = nanSlice(n)
+	b.McClellanSummation = nanSlice(n)
+	for i, t := range b.Times {
+		totals.ADLine += b.Advances[i] - b.Declines[i]
+		b.ADLine[i] = totals.ADLine
+		if !math.IsNaN(b.returns[i]) {
+			totals.Composite *= 1 + b.returns[i]
+		}
+		b.Composite[i] = totals.Composite
+
+		netAdvances := 0.0
+		if total := b.Advances[i] + b.Declines[i]; total > 0 {
+			netAdvances = (b.Advances[i] - b.Declines[i]) / total * 1000
+		}
+		fast, slow := totals.Fast.Add(netAdvances), totals.Slow.Add(netAdvances)
+		b.McClellan[i] = fast - slow
+		if !math.IsNaN(b.McClellan[i]) {
+			totals.Summation += b.McClellan[i]
+			b.McClellanSummation[i] = totals.Summation
+		}
+		totals.Time = t
+	}
+}
+
+// slice returns the bars from i to j. Series not computed yet stay nil.
+func (b MarketBreadth) slice(i, j int) MarketBreadth {
+	part := func(values []float64) []float64 {
+		if values == nil {
+			return nil
+		}
+		return values[i:j]
+	}
+	sliced := MarketBreadth{
+		Times:              b.Times[i:j],
+		Advances:           part(b.Advances),
+		Declines:           part(b.Declines),
+		ADLine:             part(b.ADLine),
+		PercentAboveSMA:    make(map[int][]float64, len(b.PercentAboveSMA)),
+		NewHighs:           part(b.NewHighs),
+		NewLows:            part(b.NewLows),
+		McClellan:          part(b.McClellan),
+		McClellanSummation: part(b.McClellanSummation),
+		Composite:          part(b.Composite),
+		returns:            part(b.returns),
+		reported:           b.reported[i:j],
+	}
+	for window, percent := range b.PercentAboveSMA {
+		sliced.PercentAboveSMA[window] = percent[i:j]
+	}
+	return sliced
+}
+
+// BreadthTracker keeps the breadth history across runs. Each run appends the
+// bars closed since the previous one, so the cumulative series keep running
+// instead of restarting from the first cached candle.
+type BreadthTracker struct {
+	opts    BreadthOptions
+	totals  BreadthTotals
+	history MarketBreadth
+}
+
+func NewBreadthTracker(opts BreadthOptions) *BreadthTracker {
+	return &BreadthTracker{opts: opts, totals: newBreadthTotals(opts)}
+}
+
+// Update appends the bars of the candles opened after the last bar added.
+// The newest bar is held back while some symbols with candles do not have it
+// yet, giving late symbols one more run before it is added without them.
+func (t *BreadthTracker) Update(candles map[string][]Candlestick, symbols []string) MarketBreadth {
+	breadth := countBreadth(candles, symbols, t.opts)
+
+	end := len(breadth.Times)
+	available := 0
+	for _, symbol := range symbols {
+		if len(candles[symbol]) > 0 {
+			available++
+		}
+	}
+	if end > 0 && breadth.reported[end-1] < available {
+		end--
+	}
+	from := sort.Search(end, func(i int) bool { return breadth.Times[i].After(t.totals.Time) })
+
+	added := breadth.slice(from, end)
+	added.accumulate(&t.totals)
+	t.history = appendBreadth(t.history, added, BreadthHistoryMaxLen)
+	return t.history
+}
+
+// appendBreadth appends the bars of added to history, keeping at most maxLen.
+func appendBreadth(history, added MarketBreadth, maxLen int) MarketBreadth {
+	n := len(history.Times) + len(added.Times)
+	percent := make(map[int][]float64, len(added.PercentAboveSMA))
+	for window, values := range added.PercentAboveSMA {
+		previous, ok := history.PercentAboveSMA[window]
+		if !ok {
+			previous = nanSlice(len(history.Times))
+		}
+		percent[window] = append(append([]float64(nil), previous...), values...)
+	}
+	merged := MarketBreadth{
+		Times:              append(append([]time.Time(nil), history.Times...), added.Times...),
+		Advances:           append(append([]float64(nil), history.Advances...), added.Advances...),
+		Declines:           append(append([]float64(nil), history.Declines...), added.Declines...),
+		ADLine:             append(append([]float64(nil), history.ADLine...), added.ADLine...),
+		PercentAboveSMA:    percent,
+		NewHighs:           append(append([]float64(nil), history.NewHighs...), added.NewHighs...),
+		NewLows:            append(append([]float64(nil), history.NewLows...), added.NewLows...),
+		McClellan:          append(append([]float64(nil), history.McClellan...), added.McClellan...),
+		McClellanSummation: append(append([]float64(nil), history.McClellanSummation...), added.McClellanSummation...),
+		Composite:          append(append([]float64(nil), history.Composite...), added.Composite...),
+		returns:            append(append([]float64(nil), history.returns...), added.returns...),
+		reported:           append(append([]int(nil), history.reported...), added.reported...),
+	}
+	if n > maxLen {
+		merged = merged.slice(n-maxLen, n)
+	}
+	return merged
+}
+
+// series returns the breadth as named Series.
+func (b MarketBreadth) series() map[string]Series {
+	series := map[string]Series{
+		"advances":            newSeries(b.Times, b.Advances),
+		"declines":            newSeries(b.Times, b.Declines),
+		"ad_line":             newSeries(b.Times, b.ADLine),
+		"new_highs":           newSeries(b.Times, b.NewHighs),
+		"new_lows":            newSeries(b.Times, b.NewLows),
+		"mcclellan":           newSeries(b.Times, b.McClellan),
+		"mcclellan_summation": newSeries(b.Times, b.McClellanSummation),
+		"composite":           newSeries(b.Times, b.Composite),
+	}
+	for window, percent := range b.PercentAboveSMA {
+		series[fmt.Sprintf("above_sma%d", window)] = newSeries(b.Times, percent)
+	}
+	return series
+}
+
+// getBreadth reads a published breadth series from the cache.
+func getBreadth(cache *Cache, name string) (Series, bool, error) {
+	var series Series
+	found, err := cache.Get(BreadthKeyPrefix+name, &series)
+	return series, found, err
+}
+
+// breadthRoutine periodically adds the closed cached candles of the symbols
+// to the breadth history and publishes it.
+func breadthRoutine(cache *Cache, symbols []string, opts BreadthOptions, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	tracker := NewBreadthTracker(opts)
+	for ; true; <-ticker.C {
+		now := time.Now()
+		candles := make(map[string][]Candlestick, len(symbols))
+		for _, symbol := range symbols {
+			data, err := cache.GetCandlesticks(symbol)
+			if err != nil {
+				log.Printf("Error reading candles for symbol %s: %v\n", symbol, err)
+				continue
+			}
+			candles[symbol] = closedCandles(data, now)
+		}
+
+		for name, series := range tracker.Update(candles, symbols).series() {
+			if err := cache.Put(BreadthKeyPrefix+name, series, 0); err != nil {
+				log.Printf("Error publishing breadth %s: %v\n", name, err)
+			}
+		}
+	}
+}
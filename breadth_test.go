@@ -0,0 +1,98 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func breadthFixture(n int) (map[string][]Candlestick, []string) {
+	start := time.Unix(1700000040, 0)
+	symbols := []string{"AUSDT", "BUSDT", "CUSDT"}
+	candles := make(map[string][]Candlestick, len(symbols))
+	for s, symbol := range symbols {
+		phase := float64(s)
+		candles[symbol] = walkCandles(start, n, func(i int) float64 { return 0.01 * math.Sin(float64(i)/3+phase) })
+	}
+	return candles, symbols
+}
+
+func sameValues(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if math.IsNaN(a[i]) != math.IsNaN(b[i]) || !math.IsNaN(a[i]) && math.Abs(a[i]-b[i]) > 1e-9 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestBreadthTrackerContinues(t *testing.T) {
+	opts := defaultBreadthOptions()
+	candles, symbols := breadthFixture(150)
+	want := calculateBreadth(candles, symbols, opts)
+
+	// The cache only holds the last 100 candles of each symbol at any time
+	tracker := NewBreadthTracker(opts)
+	var got MarketBreadth
+	for end := 100; end <= 150; end += 10 {
+		window := make(map[string][]Candlestick, len(symbols))
+		for _, symbol := range symbols {
+			window[symbol] = candles[symbol][end-100 : end]
+		}
+		got = tracker.Update(window, symbols)
+	}
+
+	if len(got.Times) != 150 {
+		t.Fatalf("got %d bars, want 150", len(got.Times))
+	}
+	for name, values := range map[string][2][]float64{
+		"ad_line":             {got.ADLine, want.ADLine},
+		"composite":           {got.Composite, want.Composite},
+		"mcclellan":           {got.McClellan, want.McClellan},
+		"mcclellan_summation": {got.McClellanSummation, want.McClellanSummation},
+	} {
+		if !sameValues(values[0], values[1]) {
+			t.Errorf("%s restarted: got %v, want %v", name, values[0][100:], values[1][100:])
+		}
+	}
+}
+
+func TestBreadthTrackerLateSymbol(t *testing.T) {
+	candles, symbols := breadthFixture(50)
+	late := map[string][]Candlestick{
+		"AUSDT": candles["AUSDT"],
+		"BUSDT": candles["BUSDT"],
+		"CUSDT": candles["CUSDT"][:49],
+	}
+
+	tracker := NewBreadthTracker(defaultBreadthOptions())
+	if got := tracker.Update(late, symbols); len(got.Times) != 49 {
+		t.Fatalf("got %d bars while CUSDT is late, want 49", len(got.Times))
+	}
+
+	got := tracker.Update(candles, symbols)
+	want := calculateBreadth(candles, symbols, defaultBreadthOptions())
+	if len(got.Times) != 50 || got.Advances[49]+got.Declines[49] != want.Advances[49]+want.Declines[49] {
+		t.Errorf("last bar has %v advances and %v declines, want %v and %v",
+			got.Advances[49], got.Declines[49], want.Advances[49], want.Declines[49])
+	}
+}
+
+func TestBreadthDefaultsFitHistory(t *testing.T) {
+	opts := defaultBreadthOptions()
+	candles, symbols := breadthFixture(CandleHistoryLimit)
+	breadth := calculateBreadth(candles, symbols, opts)
+
+	last := len(breadth.Times) - 1
+	for _, window := range opts.SMAWindows {
+		if math.IsNaN(breadth.PercentAboveSMA[window][last]) {
+			t.Errorf("above_sma%d is not valid with %d candles", window, CandleHistoryLimit)
+		}
+	}
+	if math.IsNaN(breadth.McClellanSummation[last]) {
+		t.Errorf("mcclellan_summation is not valid with %d candles", CandleHistoryLimit)
+	}
+}
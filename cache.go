@@ -37,6 +37,9 @@ const (
 	DepthCacheMaxSize = 100000
 )
 
+// OrderBookKeyPrefix holds the latest OrderBookSnapshot of a symbol.
+const OrderBookKeyPrefix = "orderbook:"
+
 // NewCache creates a new Cache instance with a Redis client.
 func NewCache() (*Cache, error) {
 	rdb := redis.NewClient(&redis.Options{
@@ -77,6 +80,21 @@ func (c *Cache) Set(key string, value interface{}, expiration time.Duration) err
 	return nil
 }
 
+// Put stores value under key with a plain SET. Unlike Set it keeps no history
+// list, so it suits values that are replaced as a whole.
+func (c *Cache) Put(key string, value interface{}, expiration time.Duration) error {
+	if value == nil {
+		return errors.New("value cannot be nil")
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return c.client.Set(key, data, expiration).Err()
+}
+
 func (c *Cache) Get(key string, target interface{}) (bool, error) {
 	data, err := c.client.Get(key).Bytes()
 	if err != nil {
@@ -102,31 +120,33 @@ func (c *Cache) Delete(key string) error {
 	return nil
 }
 
-func (c *Cache) UpdateKline(symbol string, klineData *Kline, expiration time.Duration) error {
-	key := KlineKeyPrefix + symbol
-
-	// Get the existing klines for the symbol from the cache
-	var klines []*Kline
-	_, err := c.Get(key, &klines)
+// UpdateKlines stores candle under KlineKeyPrefix+symbol. It replaces the last
+// candle while that bar is still open and appends it once a new bar starts.
+func (c *Cache) UpdateKlines(symbol string, candle Candlestick) error {
+	candles, err := c.GetCandlesticks(symbol)
 	if err != nil {
 		return err
 	}
 
-	// Append the new kline to the list
-	klines = append(klines, klineData)
-
-	// If the list is too long, remove the oldest kline
-	if len(klines) > KlineCacheMaxSize {
-		klines = klines[len(klines)-KlineCacheMaxSize:]
+	if last := len(candles) - 1; last >= 0 && candles[last].OpenTime.Equal(candle.OpenTime) {
+		candles[last] = candle
+	} else if last < 0 || candle.OpenTime.After(candles[last].OpenTime) {
+		candles = append(candles, candle)
+	} else {
+		// An update for an older bar, e.g. a delayed event after a reconnect
+		return nil
 	}
 
-	// Update the cache with the new klines
-	err = c.Set(key, klines, expiration)
-	if err != nil {
-		return err
-	}
+	return c.SetCandlesticks(symbol, candles)
+}
 
-	return nil
+// SetCandlesticks replaces the candles of symbol, keeping the last
+// KlineCacheMaxSize.
+func (c *Cache) SetCandlesticks(symbol string, candles []Candlestick) error {
+	if len(candles) > KlineCacheMaxSize {
+		candles = candles[len(candles)-KlineCacheMaxSize:]
+	}
+	return c.Put(KlineKeyPrefix+symbol, candles, 0)
 }
 
 func (c *Cache) UpdateTrade(symbol string, tradeData *AggTrade, expiration time.Duration) error {
@@ -183,29 +203,6 @@ func (c *Cache) UpdateDepth(symbol string, depthData *Depth, expiration time.Dur
 	return nil
 }
 
-func (c *Cache) GetKlines(symbol string, limit int) ([]*Kline, error) {
-	key := KlineKeyPrefix + symbol
-
-	// Get the klines for the symbol from the cache
-	var klines []*Kline
-	found, err := c.Get(key, &klines)
-	if err != nil {
-		return nil, err
-	}
-
-	// If the klines were not found in the cache, return an empty slice
-	if !found {
-		return []*Kline{}, nil
-	}
-
-	// Return the last `limit` klines
-	if len(klines) > limit {
-		klines = klines[len(klines)-limit:]
-	}
-
-	return klines, nil
-}
-
 func (c *Cache) GetTrades(symbol string, limit int) ([]*AggTrade, error) {
 	key := TradeKeyPrefix + symbol
 
@@ -251,3 +248,19 @@ func (c *Cache) GetDepth(symbol string, limit int) ([]*Depth, error) {
 
 	return depths, nil
 }
+
+// GetCandlesticks returns the candles of symbol stored by processSymbols and
+// the kline stream.
+func (c *Cache) GetCandlesticks(symbol string) ([]Candlestick, error) {
+	var candles []Candlestick
+	found, err := c.Get(KlineKeyPrefix+symbol, &candles)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return []Candlestick{}, nil
+	}
+
+	return candles, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// newTestCache returns a Cache backed by an in-memory Redis server.
+func newTestCache(t *testing.T) *Cache {
+	t.Helper()
+	server := miniredis.RunT(t)
+	t.Setenv("REDIS_ADDR", server.Addr())
+	cache, err := NewCache()
+	if err != nil {
+		t.Fatalf("NewCache: %v", err)
+	}
+	return cache
+}
+
+func testCandle(openTime time.Time, price float64) Candlestick {
+	return Candlestick{
+		OpenTime:  openTime,
+		CloseTime: openTime.Add(time.Minute - time.Second),
+		Open:      price,
+		High:      price,
+		Low:       price,
+		Close:     price,
+		Volume:    1,
+	}
+}
+
+func TestUpdateKlines(t *testing.T) {
+	cache := newTestCache(t)
+	start := time.Unix(1700000000, 0)
+
+	history := []Candlestick{testCandle(start, 1), testCandle(start.Add(time.Minute), 2)}
+	if err := cache.SetCandlesticks("BTCUSDT", history); err != nil {
+		t.Fatal(err)
+	}
+
+	// An update of the open bar replaces it, a new bar is appended and an
+	// older bar is ignored
+	updates := []Candlestick{
+		testCandle(start.Add(time.Minute), 3),
+		testCandle(start.Add(2*time.Minute), 4),
+		testCandle(start, 5),
+	}
+	for _, candle := range updates {
+		if err := cache.UpdateKlines("BTCUSDT", candle); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Other values stored under the bare symbol must not clobber the candles
+	if err := cache.Set("BTCUSDT", OrderBook{}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	candles, err := cache.GetCandlesticks("BTCUSDT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []float64{1, 3, 4}
+	if len(candles) != len(want) {
+		t.Fatalf("got %d candles, want %d", len(candles), len(want))
+	}
+	for i, candle := range candles {
+		if candle.Close != want[i] || !candle.OpenTime.Equal(start.Add(time.Duration(i)*time.Minute)) {
+			t.Errorf("candle %d = %v %v, want close %v", i, candle.OpenTime, candle.Close, want[i])
+		}
+	}
+}
+
+func TestUpdateKlinesEmpty(t *testing.T) {
+	cache := newTestCache(t)
+	start := time.Unix(1700000000, 0)
+
+	// The kline stream alone, e.g. in replay mode, builds up the series
+	if err := cache.UpdateKlines("ETHUSDT", testCandle(start, 1)); err != nil {
+		t.Fatal(err)
+	}
+	candles, err := cache.GetCandlesticks("ETHUSDT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candles) != 1 || candles[0].Close != 1 {
+		t.Fatalf("got %+v", candles)
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import "math"
+
+type CandlePattern int
+
+const (
+	PatternDoji CandlePattern = iota
+	PatternDragonflyDoji
+	PatternGravestoneDoji
+	PatternLongLeggedDoji
+	PatternSpinningTop
+	PatternMarubozu
+	PatternHammer
+	PatternHangingMan
+	PatternInvertedHammer
+	PatternShootingStar
+	PatternEngulfing
+	PatternHarami
+	PatternPiercingLine
+	PatternDarkCloudCover
+	PatternMorningStar
+	PatternEveningStar
+	PatternThreeWhiteSoldiers
+	PatternThreeBlackCrows
+)
+
+var candlePatternNames = map[CandlePattern]string{
+	PatternDoji:               "doji",
+	PatternDragonflyDoji:      "dragonfly_doji",
+	PatternGravestoneDoji:     "gravestone_doji",
+	PatternLongLeggedDoji:     "long_legged_doji",
+	PatternSpinningTop:        "spinning_top",
+	PatternMarubozu:           "marubozu",
+	PatternHammer:             "hammer",
+	PatternHangingMan:         "hanging_man",
+	PatternInvertedHammer:     "inverted_hammer",
+	PatternShootingStar:       "shooting_star",
+	PatternEngulfing:          "engulfing",
+	PatternHarami:             "harami",
+	PatternPiercingLine:       "piercing_line",
+	PatternDarkCloudCover:     "dark_cloud_cover",
+	PatternMorningStar:        "morning_star",
+	PatternEveningStar:        "evening_star",
+	PatternThreeWhiteSoldiers: "three_white_soldiers",
+	PatternThreeBlackCrows:    "three_black_crows",
+}
+
+func (p CandlePattern) String() string {
+	if name, ok := candlePatternNames[p]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+type PatternDirection int
+
+const (
+	Neutral PatternDirection = iota
+	Bullish
+	Bearish
+)
+
+func (d PatternDirection) String() string {
+	switch d {
+	case Bullish:
+		return "bullish"
+	case Bearish:
+		return "bearish"
+	}
+	return "neutral"
+}
+
+// PatternMatch is a pattern completed at a bar.
+type PatternMatch struct {
+	Pattern   CandlePattern
+	Direction PatternDirection
+}
+
+// CandlePatternOptions holds the pattern thresholds. Body and shadow
+// thresholds are multiples of the ATR at the bar, so they adapt to the
+// symbol's volatility.
+type CandlePatternOptions struct {
+	ATRWindow int
+	// DojiBody is the largest body of a doji.
+	DojiBody float64
+	// SmallBody is the largest body of spinning tops, hammers and stars.
+	SmallBody float64
+	// LongBody is the smallest body of marubozu and of the candles that
+	// frame harami, piercing lines and stars.
+	LongBody float64
+	// ShortShadow is the longest shadow still considered absent.
+	ShortShadow float64
+	// LongShadow is the shortest shadow considered long.
+	LongShadow float64
+	// ShadowBodyRatio is how many bodies the long shadow of a hammer or
+	// shooting star must be.
+	ShadowBodyRatio float64
+	// StarPenetration is how far into the first body, as a fraction of it,
+	// the third candle of a morning or evening star must close.
+	StarPenetration float64
+	// TrendWindow is the number of bars used to tell whether a hammer-like
+	// candle follows a down- or an uptrend.
+	TrendWindow int
+}
+
+func defaultCandlePatternOptions() CandlePatternOptions {
+	return CandlePatternOptions{
+		ATRWindow:       14,
+		DojiBody:        0.1,
+		SmallBody:       0.3,
+		LongBody:        0.7,
+		ShortShadow:     0.1,
+		LongShadow:      0.5,
+		ShadowBodyRatio: 2,
+		StarPenetration: 0.3,
+		TrendWindow:     5,
+	}
+}
+
+type candleShape struct {
+	body, upper, lower float64
+	bullish, bearish   bool
+	bodyHigh, bodyLow  float64
+}
+
+func shapeOf(candle Candlestick) candleShape {
+	bodyHigh := math.Max(candle.Open, candle.Close)
+	bodyLow := math.Min(candle.Open, candle.Close)
+	return candleShape{
+		body:     bodyHigh - bodyLow,
+		upper:    candle.High - bodyHigh,
+		lower:    bodyLow - candle.Low,
+		bullish:  candle.Close > candle.Open,
+		bearish:  candle.Close < candle.Open,
+		bodyHigh: bodyHigh,
+		bodyLow:  bodyLow,
+	}
+}
+
+func (s candleShape) midpoint() float64 {
+	return (s.bodyHigh + s.bodyLow) / 2
+}
+
+func colorDirection(s candleShape) PatternDirection {
+	switch {
+	case s.bullish:
+		return Bullish
+	case s.bearish:
+		return Bearish
+	}
+	return Neutral
+}
+
+// detectCandlePatterns returns the patterns completed at each bar. Bars in the
+// ATR warm-up period have no matches.
+func detectCandlePatterns(data []Candlestick, opts CandlePatternOptions) [][]PatternMatch {
+	matches := make([][]PatternMatch, len(data))
+
+	high := make([]float64, len(data))
+	low := make([]float64, len(data))
+	closes := make([]float64, len(data))
+	for i, candle := range data {
+		high[i] = candle.High
+		low[i] = candle.Low
+		closes[i] = candle.Close
+	}
+	atr := calculateATR(high, low, closes, opts.ATRWindow)
+	if atr == nil {
+		return matches
+	}
+
+	for i := range data {
+		a := atr[i]
+		if math.IsNaN(a) || a == 0 {
+			continue
+		}
+
+		matches[i] = append(matches[i], singleCandlePatterns(data, closes, i, a, opts)...)
+		if i >= 1 {
+			matches[i] = append(matches[i], doubleCandlePatterns(data[i-1], data[i], a, opts)...)
+		}
+		if i >= 2 {
+			matches[i] = append(matches[i], tripleCandlePatterns(data[i-2], data[i-1], data[i], a, opts)...)
+		}
+	}
+	return matches
+}
+
+// priorTrend returns the direction of the closes over the TrendWindow bars
+// before bar i.
+func priorTrend(closes []float64, i int, window int) PatternDirection {
+	if window <= 0 || i-1-window < 0 {
+		return Neutral
+	}
+	change := closes[i-1] - closes[i-1-window]
+	switch {
+	case change > 0:
+		return Bullish
+	case change < 0:
+		return Bearish
+	}
+	return Neutral
+}
+
+func singleCandlePatterns(data []Candlestick, closes []float64, i int, atr float64, opts CandlePatternOptions) []PatternMatch {
+	var matches []PatternMatch
+	s := shapeOf(data[i])
+
+	isDoji := s.body <= opts.DojiBody*atr
+	shortUpper := s.upper <= opts.ShortShadow*atr
+	shortLower := s.lower <= opts.ShortShadow*atr
+	longUpper := s.upper >= opts.LongShadow*atr
+	longLower := s.lower >= opts.LongShadow*atr
+
+	if isDoji {
+		matches = append(matches, PatternMatch{PatternDoji, Neutral})
+		switch {
+		case shortUpper && longLower:
+			matches = append(matches, PatternMatch{PatternDragonflyDoji, Bullish})
+		case shortLower && longUpper:
+			matches = append(matches, PatternMatch{PatternGravestoneDoji, Bearish})
+		case longUpper && longLower:
+			matches = append(matches, PatternMatch{PatternLongLeggedDoji, Neutral})
+		}
+	} else if s.body <= opts.SmallBody*atr && s.upper > s.body && s.lower > s.body {
+		matches = append(matches, PatternMatch{PatternSpinningTop, Neutral})
+	}
+
+	if s.body >= opts.LongBody*atr && shortUpper && shortLower {
+		matches = append(matches, PatternMatch{PatternMarubozu, colorDirection(s)})
+	}
+
+	if !isDoji && s.body <= opts.SmallBody*atr {
+		trend := priorTrend(closes, i, opts.TrendWindow)
+
+		if longLower && shortUpper && s.lower >= opts.ShadowBodyRatio*s.body {
+			switch trend {
+			case Bearish:
+				matches = append(matches, PatternMatch{PatternHammer, Bullish})
+			case Bullish:
+				matches = append(matches, PatternMatch{PatternHangingMan, Bearish})
+			}
+		}
+		if longUpper && shortLower && s.upper >= opts.ShadowBodyRatio*s.body {
+			switch trend {
+			case Bearish:
+				matches = append(matches, PatternMatch{PatternInvertedHammer, Bullish})
+			case Bullish:
+				matches = append(matches, PatternMatch{PatternShootingStar, Bearish})
+			}
+		}
+	}
+
+	return matches
+}
+
+func doubleCandlePatterns(prevCandle, candle Candlestick, atr float64, opts CandlePatternOptions) []PatternMatch {
+	var matches []PatternMatch
+	p := shapeOf(prevCandle)
+	c := shapeOf(candle)
+
+	// Engulfing: the body engulfs the opposite-colored previous body
+	if c.bullish && p.bearish && c.bodyLow <= p.bodyLow && c.bodyHigh >= p.bodyHigh && c.body > p.body {
+		matches = append(matches, PatternMatch{PatternEngulfing, Bullish})
+	}
+	if c.bearish && p.bullish && c.bodyLow <= p.bodyLow && c.bodyHigh >= p.bodyHigh && c.body > p.body {
+		matches = append(matches, PatternMatch{PatternEngulfing, Bearish})
+	}
+
+	prevLong := p.body >= opts.LongBody*atr
+
+	// Harami: a small body inside the previous long body
+	if prevLong && c.body <= opts.SmallBody*atr && c.bodyHigh <= p.bodyHigh && c.bodyLow >= p.bodyLow {
+		switch {
+		case p.bearish:
+			matches = append(matches, PatternMatch{PatternHarami, Bullish})
+		case p.bullish:
+			matches = append(matches, PatternMatch{PatternHarami, Bearish})
+		}
+	}
+
+	// Piercing line and dark cloud cover: open beyond the previous close and
+	// close past the midpoint of the previous body without engulfing it
+	if prevLong && p.bearish && c.bullish && candle.Open <= prevCandle.Close &&
+		candle.Close > p.midpoint() && candle.Close < prevCandle.Open {
+		matches = append(matches, PatternMatch{PatternPiercingLine, Bullish})
+	}
+	if prevLong && p.bullish && c.bearish && candle.Open >= prevCandle.Close &&
+		candle.Close < p.midpoint() && candle.Close > prevCandle.Open {
+		matches = append(matches, PatternMatch{PatternDarkCloudCover, Bearish})
+	}
+
+	return matches
+}
+
+func tripleCandlePatterns(first, second, third Candlestick, atr float64, opts CandlePatternOptions) []PatternMatch {
+	var matches []PatternMatch
+	a := shapeOf(first)
+	b := shapeOf(second)
+	c := shapeOf(third)
+
+	// Morning and evening star: a long body, a small-bodied star whose body
+	// gaps away from it and a body that is not small closing at least
+	// StarPenetration into the first body
+	if a.body >= opts.LongBody*atr && b.body <= opts.SmallBody*atr && c.body > opts.SmallBody*atr {
+		penetration := opts.StarPenetration * a.body
+		if a.bearish && c.bullish && b.bodyHigh < a.bodyLow && third.Close >= first.Close+penetration {
+			matches = append(matches, PatternMatch{PatternMorningStar, Bullish})
+		}
+		if a.bullish && c.bearish && b.bodyLow > a.bodyHigh && third.Close <= first.Close-penetration {
+			matches = append(matches, PatternMatch{PatternEveningStar, Bearish})
+		}
+	}
+
+	// Three soldiers and crows: three bodies in the same direction, each
+	// opening within the previous body and closing near its extreme
+	minBody := opts.SmallBody * atr
+	maxShadow := opts.LongShadow * atr
+	if a.body > minBody && b.body > minBody && c.body > minBody {
+		if a.bullish && b.bullish && c.bullish &&
+			second.Close > first.Close && third.Close > second.Close &&
+			second.Open >= a.bodyLow && second.Open <= a.bodyHigh &&
+			third.Open >= b.bodyLow && third.Open <= b.bodyHigh &&
+			a.upper < maxShadow && b.upper < maxShadow && c.upper < maxShadow {
+			matches = append(matches, PatternMatch{PatternThreeWhiteSoldiers, Bullish})
+		}
+		if a.bearish && b.bearish && c.bearish &&
+			second.Close < first.Close && third.Close < second.Close &&
+			second.Open >= a.bodyLow && second.Open <= a.bodyHigh &&
+			third.Open >= b.bodyLow && third.Open <= b.bodyHigh &&
+			a.lower < maxShadow && b.lower < maxShadow && c.lower < maxShadow {
+			matches = append(matches, PatternMatch{PatternThreeBlackCrows, Bearish})
+		}
+	}
+
+	return matches
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// patternFixture returns 20 quiet bars with a true range of 1, so the ATR is
+// about 1, followed by the given bars as open, high, low, close.
+func patternFixture(bars ...[4]float64) []Candlestick {
+	start := time.Unix(1700000000, 0)
+	var data []Candlestick
+	add := func(open, high, low, close float64) {
+		openTime := start.Add(time.Duration(len(data)) * time.Minute)
+		data = append(data, Candlestick{OpenTime: openTime, CloseTime: openTime.Add(time.Minute - time.Second), Open: open, High: high, Low: low, Close: close})
+	}
+	for i := 0; i < 20; i++ {
+		add(100.4, 101, 100, 100.6)
+	}
+	for _, bar := range bars {
+		add(bar[0], bar[1], bar[2], bar[3])
+	}
+	return data
+}
+
+// mirror reflects bars around price 100, turning bullish bars bearish.
+func mirror(bars ...[4]float64) [][4]float64 {
+	mirrored := make([][4]float64, len(bars))
+	for i, bar := range bars {
+		mirrored[i] = [4]float64{200 - bar[0], 200 - bar[2], 200 - bar[1], 200 - bar[3]}
+	}
+	return mirrored
+}
+
+func hasPattern(matches []PatternMatch, pattern CandlePattern, direction PatternDirection) bool {
+	for _, match := range matches {
+		if match.Pattern == pattern && match.Direction == direction {
+			return true
+		}
+	}
+	return false
+}
+
+func TestStarPatterns(t *testing.T) {
+	long := [4]float64{100.6, 100.8, 97.5, 97.8}
+	star := [4]float64{97.2, 97.4, 96.8, 97.0}
+	rally := [4]float64{97.5, 100.2, 97.4, 100}
+
+	tests := []struct {
+		name string
+		bars [][4]float64
+		want bool
+	}{
+		{"star", [][4]float64{long, star, rally}, true},
+		// The star's body overlaps the first body, there is no gap
+		{"no gap", [][4]float64{long, {98.1, 98.3, 97.7, 97.9}, rally}, false},
+		// The star's body is not small
+		{"large star", [][4]float64{long, {97.6, 97.7, 96.0, 96.3}, rally}, false},
+		// The third candle closes less than 30% into the first body
+		{"shallow", [][4]float64{long, star, {97.5, 98.6, 97.4, 98.5}}, false},
+		// The third candle's body is small
+		{"small third", [][4]float64{long, star, {98.6, 98.9, 97.4, 98.8}}, false},
+	}
+	opts := defaultCandlePatternOptions()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches := detectCandlePatterns(patternFixture(tt.bars...), opts)
+			if got := hasPattern(matches[len(matches)-1], PatternMorningStar, Bullish); got != tt.want {
+				t.Errorf("morning star = %v, want %v", got, tt.want)
+			}
+			matches = detectCandlePatterns(patternFixture(mirror(tt.bars...)...), opts)
+			if got := hasPattern(matches[len(matches)-1], PatternEveningStar, Bearish); got != tt.want {
+				t.Errorf("evening star = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTwoCandlePatterns(t *testing.T) {
+	opts := defaultCandlePatternOptions()
+
+	matches := detectCandlePatterns(patternFixture([4]float64{100.6, 100.7, 99.7, 99.8}, [4]float64{99.6, 101.2, 99.5, 101}), opts)
+	if !hasPattern(matches[len(matches)-1], PatternEngulfing, Bullish) {
+		t.Errorf("expected a bullish engulfing, got %v", matches[len(matches)-1])
+	}
+
+	matches = detectCandlePatterns(patternFixture(mirror([4]float64{100.6, 100.7, 99.7, 99.8}, [4]float64{99.6, 101.2, 99.5, 101})...), opts)
+	if !hasPattern(matches[len(matches)-1], PatternEngulfing, Bearish) {
+		t.Errorf("expected a bearish engulfing, got %v", matches[len(matches)-1])
+	}
+}
+
+func TestNoPatternsDuringWarmup(t *testing.T) {
+	matches := detectCandlePatterns(patternFixture(), defaultCandlePatternOptions())
+	for i := 0; i < 14; i++ {
+		if len(matches[i]) > 0 {
+			t.Errorf("bar %d has matches %v during the ATR warm-up", i, matches[i])
+		}
+	}
+}
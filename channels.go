@@ -0,0 +1,190 @@
+package main
+
+import "math"
+
+// Ichimoku holds the Ichimoku Kinko Hyo lines. Tenkan and Kijun are aligned to
+// the input bars. SenkouA and SenkouB are displaced forward and have
+// displacement extra values past the last bar, so SenkouA[i] is the cloud
+// drawn at bar i. Chikou is the close displaced backwards, Chikou[i] is the
+// close of bar i+displacement and the last displacement values are NaN.
+type Ichimoku struct {
+	Tenkan  []float64
+	Kijun   []float64
+	SenkouA []float64
+	SenkouB []float64
+	Chikou  []float64
+}
+
+// rollingHighLow returns the highest high and lowest low over window bars
+// ending at each bar.
+func rollingHighLow(data []Candlestick, window int) ([]float64, []float64) {
+	highest := nanSlice(len(data))
+	lowest := nanSlice(len(data))
+
+	for i := window - 1; i < len(data); i++ {
+		high := data[i-window+1].High
+		low := data[i-window+1].Low
+		for j := i - window + 2; j <= i; j++ {
+			high = math.Max(high, data[j].High)
+			low = math.Min(low, data[j].Low)
+		}
+		highest[i] = high
+		lowest[i] = low
+	}
+	return highest, lowest
+}
+
+// calculateDonchianChannels returns the highest high, the midpoint and the
+// lowest low over window bars.
+func calculateDonchianChannels(data []Candlestick, window int) ([]float64, []float64, []float64) {
+	if window <= 0 || len(data) < window {
+		return nil, nil, nil
+	}
+
+	upper, lower := rollingHighLow(data, window)
+	middle := make([]float64, len(data))
+	for i := range data {
+		middle[i] = (upper[i] + lower[i]) / 2
+	}
+	return upper, middle, lower
+}
+
+// calculateIchimoku computes the Ichimoku lines, commonly with windows 9, 26
+// and 52 and a displacement of 26.
+func calculateIchimoku(data []Candlestick, tenkanWindow, kijunWindow, senkouBWindow, displacement int) Ichimoku {
+	if tenkanWindow <= 0 || kijunWindow <= 0 || senkouBWindow <= 0 || displacement < 0 || len(data) < tenkanWindow || len(data) < kijunWindow {
+		return Ichimoku{}
+	}
+
+	_, tenkan, _ := calculateDonchianChannels(data, tenkanWindow)
+	_, kijun, _ := calculateDonchianChannels(data, kijunWindow)
+
+	senkouA := nanSlice(len(data) + displacement)
+	senkouB := nanSlice(len(data) + displacement)
+	for i := range data {
+		senkouA[i+displacement] = (tenkan[i] + kijun[i]) / 2
+	}
+	if len(data) >= senkouBWindow {
+		_, senkouBBase, _ := calculateDonchianChannels(data, senkouBWindow)
+		for i := range data {
+			senkouB[i+displacement] = senkouBBase[i]
+		}
+	}
+
+	chikou := nanSlice(len(data))
+	for i := 0; i+displacement < len(data); i++ {
+		chikou[i] = data[i+displacement].Close
+	}
+
+	return Ichimoku{
+		Tenkan:  tenkan,
+		Kijun:   kijun,
+		SenkouA: senkouA,
+		SenkouB: senkouB,
+		Chikou:  chikou,
+	}
+}
+
+// KeltnerOptions sets the window and moving average of the middle line and
+// the window, moving average and multiplier of the ATR bands around it.
+type KeltnerOptions struct {
+	Window     int
+	MAType     MAType
+	ATRWindow  int
+	ATRMAType  MAType
+	Multiplier float64
+}
+
+func defaultKeltnerOptions() KeltnerOptions {
+	return KeltnerOptions{Window: 20, MAType: ExponentialMA, ATRWindow: 10, ATRMAType: WilderMA, Multiplier: 2}
+}
+
+// calculateKeltnerChannels returns an EMA of the close with bands multiplier
+// ATRs above and below.
+func calculateKeltnerChannels(data []Candlestick, emaWindow, atrWindow int, multiplier float64) ([]float64, []float64, []float64) {
+	opts := defaultKeltnerOptions()
+	opts.Window, opts.ATRWindow, opts.Multiplier = emaWindow, atrWindow, multiplier
+	return calculateKeltnerChannelsWithOptions(data, opts)
+}
+
+func calculateKeltnerChannelsWithOptions(data []Candlestick, opts KeltnerOptions) ([]float64, []float64, []float64) {
+	high := make([]float64, len(data))
+	low := make([]float64, len(data))
+	closes := make([]float64, len(data))
+	for i, candle := range data {
+		high[i] = candle.High
+		low[i] = candle.Low
+		closes[i] = candle.Close
+	}
+
+	middle := calculateMA(closes, opts.MAType, opts.Window)
+	atr := calculateATRWithOptions(high, low, closes, ATROptions{Window: opts.ATRWindow, MAType: opts.ATRMAType})
+	if middle == nil || atr == nil {
+		return nil, nil, nil
+	}
+
+	upper := make([]float64, len(data))
+	lower := make([]float64, len(data))
+	for i := range data {
+		upper[i] = middle[i] + opts.Multiplier*atr[i]
+		lower[i] = middle[i] - opts.Multiplier*atr[i]
+	}
+	return upper, middle, lower
+}
+
+// calculateEnvelopes returns an SMA with bands percent above and below, e.g.
+// 2.5 for 2.5%.
+func calculateEnvelopes(data []float64, window int, percent float64) ([]float64, []float64, []float64) {
+	middle := calculateSMA(data, window)
+	if middle == nil {
+		return nil, nil, nil
+	}
+
+	upper := make([]float64, len(data))
+	lower := make([]float64, len(data))
+	for i := range data {
+		upper[i] = middle[i] * (1 + percent/100)
+		lower[i] = middle[i] * (1 - percent/100)
+	}
+	return upper, middle, lower
+}
+
+// calculateBollingerPercentB returns where the price sits relative to the
+// Bollinger Bands, 0 at the lower and 1 at the upper band. Bands without width
+// give 0.5.
+func calculateBollingerPercentB(data []float64, window int, numStdDev float64) []float64 {
+	upper, _, lower := calculateBollingerBands(data, window, numStdDev)
+	if upper == nil {
+		return nil
+	}
+
+	percentB := make([]float64, len(data))
+	for i := range data {
+		width := upper[i] - lower[i]
+		if width == 0 {
+			percentB[i] = 0.5
+			continue
+		}
+		percentB[i] = (data[i] - lower[i]) / width
+	}
+	return percentB
+}
+
+// calculateBollingerBandwidth returns the width of the Bollinger Bands
+// relative to the middle band.
+func calculateBollingerBandwidth(data []float64, window int, numStdDev float64) []float64 {
+	upper, middle, lower := calculateBollingerBands(data, window, numStdDev)
+	if upper == nil {
+		return nil
+	}
+
+	bandwidth := make([]float64, len(data))
+	for i := range data {
+		if middle[i] == 0 {
+			bandwidth[i] = math.NaN()
+			continue
+		}
+		bandwidth[i] = (upper[i] - lower[i]) / middle[i]
+	}
+	return bandwidth
+}
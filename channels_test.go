@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestIchimokuDisplacement(t *testing.T) {
+	data := loadOHLCV(t)
+	want := loadReference(t, "channels.csv")
+	n := len(data)
+	ichimoku := calculateIchimoku(data, 9, 26, 52, 26)
+
+	// The senkou spans run 26 bars past the last bar, SenkouA[i] is the cloud
+	// drawn at bar i
+	if len(ichimoku.SenkouA) != n+26 || len(ichimoku.SenkouB) != n+26 {
+		t.Fatalf("senkou spans have %d and %d values, want %d", len(ichimoku.SenkouA), len(ichimoku.SenkouB), n+26)
+	}
+	assertSeries(t, "senkou_a", ichimoku.SenkouA[:n], want["senkou_a"])
+	assertSeries(t, "senkou_b", ichimoku.SenkouB[:n], want["senkou_b"])
+	for i := n; i < n+26; i++ {
+		if projected := (ichimoku.Tenkan[i-26] + ichimoku.Kijun[i-26]) / 2; ichimoku.SenkouA[i] != projected {
+			t.Errorf("projected senkou_a[%d] = %v, want %v", i, ichimoku.SenkouA[i], projected)
+		}
+	}
+
+	assertSeries(t, "tenkan", ichimoku.Tenkan, want["tenkan"])
+	assertSeries(t, "kijun", ichimoku.Kijun, want["kijun"])
+	assertSeries(t, "chikou", ichimoku.Chikou, want["chikou"])
+
+	// The registry cuts the spans at the last bar
+	study, err := CompileStudy("ichimoku.senkou_a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSeries(t, "ichimoku.senkou_a", study.Evaluate(data), want["senkou_a"])
+}
+
+func TestChannelsReference(t *testing.T) {
+	data := loadOHLCV(t)
+	want := loadReference(t, "channels.csv")
+	close := closePrices(data)
+
+	donchianUpper, donchianMiddle, donchianLower := calculateDonchianChannels(data, 20)
+	keltnerUpper, keltnerMiddle, keltnerLower := calculateKeltnerChannelsWithOptions(data, defaultKeltnerOptions())
+	envelopeUpper, envelopeMiddle, envelopeLower := calculateEnvelopes(close, 20, 2.5)
+
+	tests := []struct {
+		name string
+		got  []float64
+	}{
+		{"donchian_upper20", donchianUpper},
+		{"donchian_middle20", donchianMiddle},
+		{"donchian_lower20", donchianLower},
+		{"keltner_upper", keltnerUpper},
+		{"keltner_middle", keltnerMiddle},
+		{"keltner_lower", keltnerLower},
+		{"envelope_upper", envelopeUpper},
+		{"envelope_middle", envelopeMiddle},
+		{"envelope_lower", envelopeLower},
+		{"percent_b20", calculateBollingerPercentB(close, 20, 2)},
+		{"bandwidth20", calculateBollingerBandwidth(close, 20, 2)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertSeries(t, tt.name, tt.got, want[tt.name])
+		})
+	}
+}
+
+func TestBollingerPercentBFlat(t *testing.T) {
+	// Bands without width put the price in the middle
+	flat := make([]float64, 20)
+	for i := range flat {
+		flat[i] = 100
+	}
+	percentB := calculateBollingerPercentB(flat, 20, 2)
+	if percentB[19] != 0.5 {
+		t.Errorf("%%B of a flat series = %v, want 0.5", percentB[19])
+	}
+	if !math.IsNaN(percentB[18]) {
+		t.Errorf("%%B during the warm-up = %v, want NaN", percentB[18])
+	}
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// SwingPoint is a zigzag turning point at the high (High) or low of a bar.
+// Confirmed is the bar at which the reversal away from it reached the
+// threshold, the first bar at which the swing is known.
+type SwingPoint struct {
+	Index     int
+	Time      time.Time
+	Price     float64
+	High      bool
+	Confirmed int
+}
+
+// ZigzagOptions sets the reversal needed for a new swing: ATRMultiple times
+// the ATR over ATRWindow when ATRMultiple is set, Percent of the price
+// otherwise.
+type ZigzagOptions struct {
+	Percent     float64
+	ATRMultiple float64
+	ATRWindow   int
+}
+
+func defaultZigzagOptions() ZigzagOptions {
+	return ZigzagOptions{ATRMultiple: 3, ATRWindow: 14}
+}
+
+// calculateZigzag returns the alternating swing highs and lows of the
+// candles. The last extreme is not a swing until the price reverses from it.
+func calculateZigzag(data []Candlestick, opts ZigzagOptions) []SwingPoint {
+	if len(data) == 0 {
+		return nil
+	}
+
+	var atr []float64
+	if opts.ATRMultiple > 0 {
+		high := make([]float64, len(data))
+		low := make([]float64, len(data))
+		for i, candle := range data {
+			high[i] = candle.High
+			low[i] = candle.Low
+		}
+		if atr = calculateATR(high, low, closePrices(data), opts.ATRWindow); atr == nil {
+			return nil
+		}
+	}
+	threshold := func(i int, price float64) float64 {
+		if atr != nil {
+			return atr[i] * opts.ATRMultiple
+		}
+		return price * opts.Percent / 100
+	}
+
+	var swings []SwingPoint
+	swing := func(index int, high bool, confirmed int) {
+		price := data[index].Low
+		if high {
+			price = data[index].High
+		}
+		swings = append(swings, SwingPoint{Index: index, Time: data[index].OpenTime, Price: price, High: high, Confirmed: confirmed})
+	}
+
+	// direction is 1 while looking for the next high, -1 for the next low
+	direction := 0
+	highest, lowest := 0, 0
+	for i, candle := range data {
+		if candle.High > data[highest].High {
+			highest = i
+		}
+		if candle.Low < data[lowest].Low {
+			lowest = i
+		}
+
+		switch {
+		case direction >= 0 && candle.Low <= data[highest].High-threshold(i, data[highest].High) &&
+			(direction > 0 || highest < i):
+			swing(highest, true, i)
+			direction, lowest = -1, i
+		case direction <= 0 && candle.High >= data[lowest].Low+threshold(i, data[lowest].Low) &&
+			(direction < 0 || lowest < i):
+			swing(lowest, false, i)
+			direction, highest = 1, i
+		}
+	}
+	return swings
+}
+
+// TrendLine is the line price = Intercept + Slope*index over bar indices.
+type TrendLine struct {
+	Slope     float64
+	Intercept float64
+}
+
+func (l TrendLine) At(index int) float64 {
+	return l.Intercept + l.Slope*float64(index)
+}
+
+func flatLine(price float64) TrendLine {
+	return TrendLine{Intercept: price}
+}
+
+// fitTrendLine fits a least squares line through the swing points.
+func fitTrendLine(points ...SwingPoint) TrendLine {
+	n := float64(len(points))
+	var sumX, sumY, sumXY, sumXX float64
+	for _, p := range points {
+		x := float64(p.Index)
+		sumX += x
+		sumY += p.Price
+		sumXY += x * p.Price
+		sumXX += x * x
+	}
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return flatLine(sumY / n)
+	}
+	slope := (n*sumXY - sumX*sumY) / denominator
+	return TrendLine{Slope: slope, Intercept: (sumY - slope*sumX) / n}
+}
+
+type ChartPatternKind int
+
+const (
+	DoubleTop ChartPatternKind = iota
+	DoubleBottom
+	TripleTop
+	TripleBottom
+	HeadAndShoulders
+	InverseHeadAndShoulders
+	AscendingTriangle
+	DescendingTriangle
+	SymmetricTriangle
+	BullFlag
+	BearFlag
+	RisingWedge
+	FallingWedge
+)
+
+var chartPatternNames = map[ChartPatternKind]string{
+	DoubleTop:               "double_top",
+	DoubleBottom:            "double_bottom",
+	TripleTop:               "triple_top",
+	TripleBottom:            "triple_bottom",
+	HeadAndShoulders:        "head_and_shoulders",
+	InverseHeadAndShoulders: "inverse_head_and_shoulders",
+	AscendingTriangle:       "ascending_triangle",
+	DescendingTriangle:      "descending_triangle",
+	SymmetricTriangle:       "symmetric_triangle",
+	BullFlag:                "bull_flag",
+	BearFlag:                "bear_flag",
+	RisingWedge:             "rising_wedge",
+	FallingWedge:            "falling_wedge",
+}
+
+func (k ChartPatternKind) String() string {
+	if name, ok := chartPatternNames[k]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// ChartPattern is a pattern formed by consecutive swings. Upper and Lower are
+// the lines a breakout is measured against: the neckline is Lower for tops
+// and Upper for bottoms. Direction is the expected breakout, Neutral for
+// symmetric triangles that may break either way.
+//
+// Breakout is the first bar after the pattern became known whose close is
+// beyond the line in the pattern's direction, -1 if there is none yet, and
+// BreakoutDirection the side it broke to. Target is the measured move from
+// the breakout level.
+type ChartPattern struct {
+	Kind              ChartPatternKind
+	Direction         PatternDirection
+	Points            []SwingPoint
+	Upper             TrendLine
+	Lower             TrendLine
+	Breakout          int
+	BreakoutDirection PatternDirection
+	Target            float64
+}
+
+// ChartPatternOptions holds the pattern thresholds. Tolerance is how far two
+// prices may differ, as a share of the price, to count as the same level.
+// FlatSlope is the largest slope per bar, as a share of the price, of a flat
+// line. A flag's pole must be at least FlagPoleRatio times the flag's height.
+// Breakouts are looked for within BreakoutBars bars.
+type ChartPatternOptions struct {
+	Zigzag        ZigzagOptions
+	Tolerance     float64
+	FlatSlope     float64
+	FlagPoleRatio float64
+	BreakoutBars  int
+}
+
+func defaultChartPatternOptions() ChartPatternOptions {
+	return ChartPatternOptions{
+		Zigzag:        defaultZigzagOptions(),
+		Tolerance:     0.01,
+		FlatSlope:     0.0002,
+		FlagPoleRatio: 2,
+		BreakoutBars:  50,
+	}
+}
+
+// detectChartPatterns finds the patterns formed by the candles' zigzag swings,
+// ordered by the bar at which they became known.
+func detectChartPatterns(data []Candlestick, opts ChartPatternOptions) []ChartPattern {
+	swings := calculateZigzag(data, opts.Zigzag)
+
+	var patterns []ChartPattern
+	for end := range swings {
+		for _, detect := range []func([]SwingPoint, ChartPatternOptions) (ChartPattern, bool){
+			doubleTopOrBottom,
+			tripleTopOrBottom,
+			headAndShoulders,
+			triangleOrWedge,
+			flagPattern,
+		} {
+			pattern, ok := detect(swings[:end+1], opts)
+			if !ok {
+				continue
+			}
+			confirmBreakout(data, &pattern, opts.BreakoutBars)
+			patterns = append(patterns, pattern)
+		}
+	}
+
+	sort.SliceStable(patterns, func(i, j int) bool {
+		return lastPoint(patterns[i]).Confirmed < lastPoint(patterns[j]).Confirmed
+	})
+	return patterns
+}
+
+func lastPoint(pattern ChartPattern) SwingPoint {
+	return pattern.Points[len(pattern.Points)-1]
+}
+
+// lastSwings returns the last n swings, or false if there are fewer.
+func lastSwings(swings []SwingPoint, n int) ([]SwingPoint, bool) {
+	if len(swings) < n {
+		return nil, false
+	}
+	return swings[len(swings)-n:], true
+}
+
+func sameLevel(a, b, tolerance float64) bool {
+	return math.Abs(a-b) <= tolerance*math.Max(math.Abs(a), math.Abs(b))
+}
+
+// doubleTopOrBottom matches high, low, high at the same level (or the mirror
+// for bottoms) ending at the last swing.
+func doubleTopOrBottom(swings []SwingPoint, opts ChartPatternOptions) (ChartPattern, bool) {
+	points, ok := lastSwings(swings, 3)
+	if !ok || !sameLevel(points[0].Price, points[2].Price, opts.Tolerance) {
+		return ChartPattern{}, false
+	}
+	if sameLevel(points[0].Price, points[1].Price, opts.Tolerance) {
+		return ChartPattern{}, false
+	}
+
+	level := (points[0].Price + points[2].Price) / 2
+	height := level - points[1].Price
+	if points[0].High {
+		return ChartPattern{Kind: DoubleTop, Direction: Bearish, Points: points,
+			Upper: flatLine(level), Lower: flatLine(points[1].Price), Target: points[1].Price - height}, true
+	}
+	return ChartPattern{Kind: DoubleBottom, Direction: Bullish, Points: points,
+		Upper: flatLine(points[1].Price), Lower: flatLine(level), Target: points[1].Price - height}, true
+}
+
+// tripleTopOrBottom matches three highs (or lows) at the same level with the
+// neckline at the outermost of the two swings between them.
+func tripleTopOrBottom(swings []SwingPoint, opts ChartPatternOptions) (ChartPattern, bool) {
+	points, ok := lastSwings(swings, 5)
+	if !ok {
+		return ChartPattern{}, false
+	}
+	for _, p := range []SwingPoint{points[2], points[4]} {
+		if !sameLevel(points[0].Price, p.Price, opts.Tolerance) {
+			return ChartPattern{}, false
+		}
+	}
+	if sameLevel(points[0].Price, points[1].Price, opts.Tolerance) {
+		return ChartPattern{}, false
+	}
+
+	level := (points[0].Price + points[2].Price + points[4].Price) / 3
+	if points[0].High {
+		neckline := math.Min(points[1].Price, points[3].Price)
+		return ChartPattern{Kind: TripleTop, Direction: Bearish, Points: points,
+			Upper: flatLine(level), Lower: flatLine(neckline), Target: neckline - (level - neckline)}, true
+	}
+	neckline := math.Max(points[1].Price, points[3].Price)
+	return ChartPattern{Kind: TripleBottom, Direction: Bullish, Points: points,
+		Upper: flatLine(neckline), Lower: flatLine(level), Target: neckline + (neckline - level)}, true
+}
+
+// headAndShoulders matches shoulder, neck, head, neck, shoulder with the head
+// beyond both shoulders and the shoulders at the same level.
+func headAndShoulders(swings []SwingPoint, opts ChartPatternOptions) (ChartPattern, bool) {
+	points, ok := lastSwings(swings, 5)
+	if !ok {
+		return ChartPattern{}, false
+	}
+	left, head, right := points[0], points[2], points[4]
+	if !sameLevel(left.Price, right.Price, 2*opts.Tolerance) {
+		return ChartPattern{}, false
+	}
+
+	neckline := fitTrendLine(points[1], points[3])
+	height := head.Price - neckline.At(head.Index)
+	if left.High {
+		if head.Price <= math.Max(left.Price, right.Price)*(1+opts.Tolerance) {
+			return ChartPattern{}, false
+		}
+		return ChartPattern{Kind: HeadAndShoulders, Direction: Bearish, Points: points,
+			Upper: fitTrendLine(left, right), Lower: neckline, Target: neckline.At(right.Index) - height}, true
+	}
+	if head.Price >= math.Min(left.Price, right.Price)*(1-opts.Tolerance) {
+		return ChartPattern{}, false
+	}
+	return ChartPattern{Kind: InverseHeadAndShoulders, Direction: Bullish, Points: points,
+		Upper: neckline, Lower: fitTrendLine(left, right), Target: neckline.At(right.Index) - height}, true
+}
+
+// triangleOrWedge fits lines through the highs and the lows of the last five
+// swings and classifies converging lines by their slopes.
+func triangleOrWedge(swings []SwingPoint, opts ChartPatternOptions) (ChartPattern, bool) {
+	points, ok := lastSwings(swings, 5)
+	if !ok {
+		return ChartPattern{}, false
+	}
+
+	var highs, lows []SwingPoint
+	for _, p := range points {
+		if p.High {
+			highs = append(highs, p)
+		} else {
+			lows = append(lows, p)
+		}
+	}
+	upper, lower := fitTrendLine(highs...), fitTrendLine(lows...)
+
+	first, last := points[0].Index, points[len(points)-1].Index
+	startWidth, endWidth := upper.At(first)-lower.At(first), upper.At(last)-lower.At(last)
+	if endWidth <= 0 || endWidth >= startWidth {
+		return ChartPattern{}, false
+	}
+
+	price := points[len(points)-1].Price
+	flat := opts.FlatSlope * price
+	upperSlope, lowerSlope := upper.Slope, lower.Slope
+	pattern := ChartPattern{Points: points, Upper: upper, Lower: lower}
+	switch {
+	case math.Abs(upperSlope) <= flat && lowerSlope > flat:
+		pattern.Kind, pattern.Direction = AscendingTriangle, Bullish
+	case upperSlope < -flat && math.Abs(lowerSlope) <= flat:
+		pattern.Kind, pattern.Direction = DescendingTriangle, Bearish
+	case upperSlope < -flat && lowerSlope > flat:
+		pattern.Kind, pattern.Direction = SymmetricTriangle, Neutral
+	case upperSlope > flat && lowerSlope > upperSlope:
+		pattern.Kind, pattern.Direction = RisingWedge, Bearish
+	case lowerSlope < -flat && upperSlope < lowerSlope:
+		pattern.Kind, pattern.Direction = FallingWedge, Bullish
+	default:
+		return ChartPattern{}, false
+	}
+
+	// Targets are the widest part of the pattern from the breakout level
+	switch pattern.Direction {
+	case Bullish:
+		pattern.Target = upper.At(last) + startWidth
+	case Bearish:
+		pattern.Target = lower.At(last) - startWidth
+	}
+	return pattern, true
+}
+
+// flagPattern matches a pole from the first to the second of the last six swings
+// followed by a channel against the pole's direction that is at most
+// 1/FlagPoleRatio of the pole's height.
+func flagPattern(swings []SwingPoint, opts ChartPatternOptions) (ChartPattern, bool) {
+	points, ok := lastSwings(swings, 6)
+	if !ok {
+		return ChartPattern{}, false
+	}
+
+	pole := points[1].Price - points[0].Price
+	channel := points[1:]
+	var highs, lows []SwingPoint
+	for _, p := range channel {
+		if p.High {
+			highs = append(highs, p)
+		} else {
+			lows = append(lows, p)
+		}
+	}
+	upper, lower := fitTrendLine(highs...), fitTrendLine(lows...)
+
+	height := 0.0
+	for _, p := range channel {
+		height = math.Max(height, math.Abs(upper.At(p.Index)-lower.At(p.Index)))
+	}
+	if height == 0 || math.Abs(pole) < opts.FlagPoleRatio*height {
+		return ChartPattern{}, false
+	}
+
+	// The channel's lines must be roughly parallel
+	price := points[1].Price
+	if math.Abs(upper.Slope-lower.Slope) > opts.FlatSlope*price {
+		return ChartPattern{}, false
+	}
+	slope := (upper.Slope + lower.Slope) / 2
+	last := channel[len(channel)-1].Index
+
+	if pole > 0 && slope <= opts.FlatSlope*price {
+		return ChartPattern{Kind: BullFlag, Direction: Bullish, Points: points,
+			Upper: upper, Lower: lower, Target: upper.At(last) + pole}, true
+	}
+	if pole < 0 && slope >= -opts.FlatSlope*price {
+		return ChartPattern{Kind: BearFlag, Direction: Bearish, Points: points,
+			Upper: upper, Lower: lower, Target: lower.At(last) + pole}, true
+	}
+	return ChartPattern{}, false
+}
+
+// confirmBreakout looks for the first close beyond the pattern's lines after
+// the pattern became known. Neutral patterns accept both sides and get their
+// target once the side is known.
+func confirmBreakout(data []Candlestick, pattern *ChartPattern, bars int) {
+	pattern.Breakout = -1
+	start := lastPoint(*pattern).Confirmed
+	height := pattern.Upper.At(pattern.Points[0].Index) - pattern.Lower.At(pattern.Points[0].Index)
+
+	for i := start; i < len(data) && i <= start+bars; i++ {
+		up := data[i].Close > pattern.Upper.At(i)
+		down := data[i].Close < pattern.Lower.At(i)
+		switch {
+		case up && pattern.Direction != Bearish:
+			pattern.Breakout, pattern.BreakoutDirection = i, Bullish
+			if pattern.Direction == Neutral {
+				pattern.Target = pattern.Upper.At(i) + height
+			}
+			return
+		case down && pattern.Direction != Bullish:
+			pattern.Breakout, pattern.BreakoutDirection = i, Bearish
+			if pattern.Direction == Neutral {
+				pattern.Target = pattern.Lower.At(i) - height
+			}
+			return
+		case up || down:
+			// A break against the expected direction invalidates the pattern
+			return
+		}
+	}
+}
+
+// FibonacciLevel is a retracement (ratio below 1) or extension (above 1) of a
+// swing, 0 being the swing's end and 1 its start.
+type FibonacciLevel struct {
+	Ratio float64
+	Price float64
+}
+
+var fibonacciRatios = []float64{0, 0.236, 0.382, 0.5, 0.618, 0.786, 1, 1.272, 1.618}
+
+// calculateFibonacciLevels returns the levels of the move from one swing to
+// the next.
+func calculateFibonacciLevels(from, to SwingPoint) []FibonacciLevel {
+	levels := make([]FibonacciLevel, len(fibonacciRatios))
+	for i, ratio := range fibonacciRatios {
+		levels[i] = FibonacciLevel{Ratio: ratio, Price: to.Price - ratio*(to.Price-from.Price)}
+	}
+	return levels
+}
+
+// lastSwingFibonacci returns the Fibonacci levels of the last zigzag swing of
+// the candles.
+func lastSwingFibonacci(data []Candlestick, opts ZigzagOptions) ([]FibonacciLevel, bool) {
+	swings := calculateZigzag(data, opts)
+	if len(swings) < 2 {
+		return nil, false
+	}
+	return calculateFibonacciLevels(swings[len(swings)-2], swings[len(swings)-1]), true
+}
+
+// symbolChartPatterns detects the chart patterns of a symbol from its cached
+// candles.
+func symbolChartPatterns(cache *Cache, symbol string, opts ChartPatternOptions) ([]ChartPattern, error) {
+	candles, err := cache.GetCandlesticks(symbol)
+	if err != nil {
+		return nil, fmt.Errorf("reading candles for %s: %w", symbol, err)
+	}
+	return detectChartPatterns(candles, opts), nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// swingFixture returns alternating swings at the given prices, 10 bars apart.
+// The first swing is a high if it is above the second.
+func swingFixture(prices ...float64) []SwingPoint {
+	swings := make([]SwingPoint, len(prices))
+	high := prices[0] > prices[1]
+	for i, price := range prices {
+		swings[i] = SwingPoint{Index: 10 * i, Price: price, High: high, Confirmed: 10*i + 5}
+		high = !high
+	}
+	return swings
+}
+
+// pathCandles returns candles whose closes move in straight lines between the
+// given prices, legBars bars per leg.
+func pathCandles(legBars int, prices ...float64) []Candlestick {
+	closes := []float64{prices[0]}
+	for i := 1; i < len(prices); i++ {
+		for k := 1; k <= legBars; k++ {
+			closes = append(closes, prices[i-1]+(prices[i]-prices[i-1])*float64(k)/float64(legBars))
+		}
+	}
+
+	start := time.Unix(1700000040, 0)
+	data := make([]Candlestick, len(closes))
+	for i, close := range closes {
+		open := close
+		if i > 0 {
+			open = closes[i-1]
+		}
+		openTime := start.Add(time.Duration(i) * time.Minute)
+		data[i] = Candlestick{OpenTime: openTime, CloseTime: openTime.Add(time.Minute - time.Second),
+			Open: open, High: math.Max(open, close), Low: math.Min(open, close), Close: close}
+	}
+	return data
+}
+
+func TestChartPatternDetectors(t *testing.T) {
+	type detector func([]SwingPoint, ChartPatternOptions) (ChartPattern, bool)
+	tests := []struct {
+		name      string
+		detect    detector
+		prices    []float64
+		want      ChartPatternKind
+		direction PatternDirection
+		found     bool
+	}{
+		{"double top", doubleTopOrBottom, []float64{110, 100, 110.5}, DoubleTop, Bearish, true},
+		{"double bottom", doubleTopOrBottom, []float64{100, 110, 100.5}, DoubleBottom, Bullish, true},
+		{"uneven tops", doubleTopOrBottom, []float64{110, 100, 115}, 0, 0, false},
+		{"triple top", tripleTopOrBottom, []float64{110, 100, 110.3, 101, 109.8}, TripleTop, Bearish, true},
+		{"triple bottom", tripleTopOrBottom, []float64{100, 110, 100.4, 109, 99.8}, TripleBottom, Bullish, true},
+		{"uneven triple", tripleTopOrBottom, []float64{110, 100, 110.3, 101, 104}, 0, 0, false},
+		{"head and shoulders", headAndShoulders, []float64{105, 100, 112, 100.5, 105.5}, HeadAndShoulders, Bearish, true},
+		{"inverse head and shoulders", headAndShoulders, []float64{95, 100, 88, 100.5, 94.6}, InverseHeadAndShoulders, Bullish, true},
+		{"no head", headAndShoulders, []float64{105, 100, 105.5, 100.5, 105.2}, 0, 0, false},
+		{"ascending triangle", triangleOrWedge, []float64{110, 100, 110, 105, 110.05}, AscendingTriangle, Bullish, true},
+		{"descending triangle", triangleOrWedge, []float64{100, 110, 100, 105, 99.95}, DescendingTriangle, Bearish, true},
+		{"symmetric triangle", triangleOrWedge, []float64{115, 100, 111, 104, 107}, SymmetricTriangle, Neutral, true},
+		{"rising wedge", triangleOrWedge, []float64{110, 100, 112, 108, 114}, RisingWedge, Bearish, true},
+		{"falling wedge", triangleOrWedge, []float64{90, 100, 88, 92, 86}, FallingWedge, Bullish, true},
+		{"broadening", triangleOrWedge, []float64{105, 100, 108, 96, 111}, 0, 0, false},
+		{"bull flag", flagPattern, []float64{100, 120, 116, 119, 115, 118}, BullFlag, Bullish, true},
+		{"bear flag", flagPattern, []float64{120, 100, 104, 101, 105, 102}, BearFlag, Bearish, true},
+		{"short pole", flagPattern, []float64{114, 120, 116, 119, 115, 118}, 0, 0, false},
+	}
+
+	opts := defaultChartPatternOptions()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pattern, ok := tt.detect(swingFixture(tt.prices...), opts)
+			if ok != tt.found {
+				t.Fatalf("found = %v, want %v (%v)", ok, tt.found, pattern.Kind)
+			}
+			if !ok {
+				return
+			}
+			if pattern.Kind != tt.want || pattern.Direction != tt.direction {
+				t.Errorf("got %v %v, want %v %v", pattern.Kind, pattern.Direction, tt.want, tt.direction)
+			}
+			// Targets lie beyond the pattern in its direction
+			last := pattern.Points[len(pattern.Points)-1].Index
+			switch pattern.Direction {
+			case Bullish:
+				if pattern.Target <= pattern.Upper.At(last) {
+					t.Errorf("target %v is not above the upper line %v", pattern.Target, pattern.Upper.At(last))
+				}
+			case Bearish:
+				if pattern.Target >= pattern.Lower.At(last) {
+					t.Errorf("target %v is not below the lower line %v", pattern.Target, pattern.Lower.At(last))
+				}
+			}
+		})
+	}
+}
+
+func TestConfirmBreakout(t *testing.T) {
+	// A double top known at bar 25 with its neckline at 100
+	pattern, ok := doubleTopOrBottom(swingFixture(110, 100, 110), defaultChartPatternOptions())
+	if !ok {
+		t.Fatal("no double top")
+	}
+	candles := func(base float64, breakAt int, close float64) []Candlestick {
+		data := pathCandles(1, make([]float64, 60)...)
+		for i := range data {
+			data[i].Close = base
+			if i == breakAt {
+				data[i].Close = close
+			}
+		}
+		return data
+	}
+
+	confirmBreakout(candles(105, 31, 99), &pattern, 50)
+	if pattern.Breakout != 31 || pattern.BreakoutDirection != Bearish {
+		t.Errorf("breakout = %d %v, want 31 bearish", pattern.Breakout, pattern.BreakoutDirection)
+	}
+
+	// A break before the pattern was known does not count
+	confirmBreakout(candles(105, 20, 99), &pattern, 50)
+	if pattern.Breakout != -1 {
+		t.Errorf("breakout = %d before the pattern was known", pattern.Breakout)
+	}
+
+	// Closing above the tops first invalidates the pattern
+	data := candles(105, 31, 111)
+	data[35].Close = 99
+	confirmBreakout(data, &pattern, 50)
+	if pattern.Breakout != -1 {
+		t.Errorf("breakout = %d after the pattern was invalidated", pattern.Breakout)
+	}
+
+	// Breakouts are only looked for within the given bars
+	confirmBreakout(candles(105, 40, 99), &pattern, 10)
+	if pattern.Breakout != -1 {
+		t.Errorf("breakout = %d beyond 10 bars", pattern.Breakout)
+	}
+
+	// A symmetric triangle breaks either way and gets its target then
+	// known at bar 45, where its lines are at 111 and 107
+	triangle, ok := triangleOrWedge(swingFixture(120, 100, 116, 104, 112), defaultChartPatternOptions())
+	if !ok || triangle.Kind != SymmetricTriangle {
+		t.Fatal("no symmetric triangle")
+	}
+	confirmBreakout(candles(109, 48, 90), &triangle, 50)
+	if triangle.Breakout != 48 || triangle.BreakoutDirection != Bearish || triangle.Target >= triangle.Lower.At(50) {
+		t.Errorf("breakout = %d %v with target %v", triangle.Breakout, triangle.BreakoutDirection, triangle.Target)
+	}
+}
+
+func TestDetectChartPatterns(t *testing.T) {
+	opts := defaultChartPatternOptions()
+	opts.Zigzag = ZigzagOptions{Percent: 3}
+	data := pathCandles(10, 100, 110, 100, 110, 95)
+
+	swings := calculateZigzag(data, opts.Zigzag)
+	if len(swings) != 4 {
+		t.Fatalf("got %d swings, want 4: %+v", len(swings), swings)
+	}
+
+	var top, bottom *ChartPattern
+	patterns := detectChartPatterns(data, opts)
+	for i := range patterns {
+		switch patterns[i].Kind {
+		case DoubleTop:
+			top = &patterns[i]
+		case DoubleBottom:
+			bottom = &patterns[i]
+		}
+	}
+
+	// The final drop breaks the double top's neckline
+	if top == nil {
+		t.Fatalf("no double top in %+v", patterns)
+	}
+	if top.Breakout < 0 || top.BreakoutDirection != Bearish || data[top.Breakout].Close >= 100 {
+		t.Errorf("double top breakout = %d %v", top.Breakout, top.BreakoutDirection)
+	}
+	if top.Target != 90 {
+		t.Errorf("double top target = %v, want 90", top.Target)
+	}
+
+	// and invalidates the earlier double bottom, which never closed above 110
+	if bottom == nil {
+		t.Fatalf("no double bottom in %+v", patterns)
+	}
+	if bottom.Breakout != -1 {
+		t.Errorf("double bottom breakout = %d, want none", bottom.Breakout)
+	}
+}
@@ -0,0 +1,666 @@
+package main
+
+import (
+	"log"
+	"math"
+	"sort"
+	"time"
+)
+
+// Cross-symbol statistics are published under StatsKeyPrefix: the correlation
+// matrix under "correlation", each symbol's beta and relative strength under
+// "benchmark:"+symbol and the ranked pairs under "pairs".
+const StatsKeyPrefix = "stats:"
+
+// engleGrangerCritical is MacKinnon's 5% critical value of the Engle-Granger
+// ADF statistic for two variables with a constant.
+const engleGrangerCritical = -3.34
+
+// StatsOptions holds the windows, in bars, of the cross-symbol statistics.
+// Pairs whose return correlation reaches MinCorrelation are tested for
+// cointegration over the last CointegrationWindow bars of log prices, and the
+// MaxPairs pairs with the lowest ADF statistic are published. The default
+// windows fit in the candles fetched at startup, which give one return less
+// than candles.
+type StatsOptions struct {
+	CorrelationWindow   int
+	BetaWindow          int
+	Benchmarks          []string
+	MinCorrelation      float64
+	CointegrationWindow int
+	ADFLags             int
+	ZScoreWindow        int
+	MaxPairs            int
+}
+
+func defaultStatsOptions() StatsOptions {
+	return StatsOptions{
+		CorrelationWindow:   CandleHistoryLimit - 1,
+		BetaWindow:          CandleHistoryLimit - 1,
+		Benchmarks:          []string{"BTCUSDT", "ETHUSDT"},
+		MinCorrelation:      0.7,
+		CointegrationWindow: CandleHistoryLimit,
+		ADFLags:             1,
+		ZScoreWindow:        CandleHistoryLimit / 2,
+		MaxPairs:            50,
+	}
+}
+
+// nullableFloat returns nil for values JSON cannot encode.
+func nullableFloat(value float64) *float64 {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil
+	}
+	return &value
+}
+
+// closedCandles drops the trailing candles that have not closed by now.
+func closedCandles(data []Candlestick, now time.Time) []Candlestick {
+	end := len(data)
+	for end > 0 && barEnd(data[end-1]).After(now) {
+		end--
+	}
+	return data[:end]
+}
+
+// alignLogReturns puts the log returns of the symbols on the union of their
+// open times. returns[bar][symbol] is NaN for bars a symbol does not have.
+func alignLogReturns(candles map[string][]Candlestick, symbols []string) ([]time.Time, [][]float64) {
+	byTime := make(map[time.Time][]float64)
+	for s, symbol := range symbols {
+		data := candles[symbol]
+		for i := 1; i < len(data); i++ {
+			row, ok := byTime[data[i].OpenTime]
+			if !ok {
+				row = nanSlice(len(symbols))
+				byTime[data[i].OpenTime] = row
+			}
+			row[s] = math.Log(data[i].Close / data[i-1].Close)
+		}
+	}
+
+	times := make([]time.Time, 0, len(byTime))
+	for t := range byTime {
+		times = append(times, t)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+
+	returns := make([][]float64, len(times))
+	for i, t := range times {
+		returns[i] = byTime[t]
+	}
+	return times, returns
+}
+
+// pairSums are the running sums of the bars in which both returns of a pair
+// are present.
+type pairSums struct {
+	n, x, y, xx, yy, xy float64
+}
+
+// CorrelationMatrix keeps the rolling correlations of the returns of a set of
+// symbols over the last Window bars. Each new bar updates the running sums of
+// every pair instead of recomputing the window, and the sums are rebuilt from
+// the window once per Window bars so rounding errors do not accumulate.
+type CorrelationMatrix struct {
+	Symbols []string
+	Window  int
+	Time    time.Time
+
+	rows  [][]float64
+	next  int
+	sums  []pairSums
+	index map[string]int
+}
+
+func NewCorrelationMatrix(symbols []string, window int) *CorrelationMatrix {
+	index := make(map[string]int, len(symbols))
+	for i, symbol := range symbols {
+		index[symbol] = i
+	}
+	return &CorrelationMatrix{
+		Symbols: symbols,
+		Window:  window,
+		sums:    make([]pairSums, len(symbols)*len(symbols)),
+		index:   index,
+	}
+}
+
+// update adds (sign 1) or removes (sign -1) a bar's returns from the sums.
+func (m *CorrelationMatrix) update(row []float64, sign float64) {
+	n := len(m.Symbols)
+	for i := 0; i < n; i++ {
+		if math.IsNaN(row[i]) {
+			continue
+		}
+		for j := i + 1; j < n; j++ {
+			if math.IsNaN(row[j]) {
+				continue
+			}
+			s := &m.sums[i*n+j]
+			s.n += sign
+			s.x += sign * row[i]
+			s.y += sign * row[j]
+			s.xx += sign * row[i] * row[i]
+			s.yy += sign * row[j] * row[j]
+			s.xy += sign * row[i] * row[j]
+		}
+	}
+}
+
+// Add adds the returns of the bar opened at t, in the order of Symbols with
+// NaN for missing symbols, and drops the oldest bar once the window is full.
+func (m *CorrelationMatrix) Add(t time.Time, returns []float64) {
+	if m.Window <= 0 || len(returns) != len(m.Symbols) {
+		return
+	}
+	row := append([]float64(nil), returns...)
+
+	if len(m.rows) < m.Window {
+		m.rows = append(m.rows, row)
+	} else {
+		m.update(m.rows[m.next], -1)
+		m.rows[m.next] = row
+	}
+	m.update(row, 1)
+	m.next = (m.next + 1) % m.Window
+	m.Time = t
+
+	if m.next == 0 {
+		m.sums = make([]pairSums, len(m.sums))
+		for _, row := range m.rows {
+			m.update(row, 1)
+		}
+	}
+}
+
+// AddBars adds the bars opened after the latest one added, as returned by
+// alignLogReturns. Starting from scratch, only the last Window bars are added.
+// The newest bar is held back while a symbol has no return for it, giving late
+// symbols one more bar to deliver it before the bar is added without them.
+func (m *CorrelationMatrix) AddBars(times []time.Time, returns [][]float64) {
+	end := len(times)
+	if end > 0 {
+		for _, value := range returns[end-1] {
+			if math.IsNaN(value) {
+				end--
+				break
+			}
+		}
+	}
+
+	from := 0
+	if m.Time.IsZero() && end > m.Window {
+		from = end - m.Window
+	}
+	for i := from; i < end; i++ {
+		if times[i].After(m.Time) {
+			m.Add(times[i], returns[i])
+		}
+	}
+}
+
+// Correlation returns the correlation of the returns of the ith and jth
+// symbols, NaN with fewer than three common bars or without variance.
+func (m *CorrelationMatrix) Correlation(i, j int) float64 {
+	if i == j {
+		return 1
+	}
+	if i > j {
+		i, j = j, i
+	}
+	s := m.sums[i*len(m.Symbols)+j]
+	if s.n < 3 {
+		return math.NaN()
+	}
+	varX := s.xx - s.x*s.x/s.n
+	varY := s.yy - s.y*s.y/s.n
+	if varX <= 0 || varY <= 0 {
+		return math.NaN()
+	}
+	return (s.xy - s.x*s.y/s.n) / math.Sqrt(varX*varY)
+}
+
+// SymbolCorrelation returns the correlation of two symbols by name.
+func (m *CorrelationMatrix) SymbolCorrelation(first, second string) (float64, bool) {
+	i, ok := m.index[first]
+	if !ok {
+		return math.NaN(), false
+	}
+	j, ok := m.index[second]
+	if !ok {
+		return math.NaN(), false
+	}
+	return m.Correlation(i, j), true
+}
+
+// CorrelationSnapshot is the published correlation matrix, with null for
+// pairs without a correlation.
+type CorrelationSnapshot struct {
+	Time    time.Time
+	Window  int
+	Symbols []string
+	Values  [][]*float64
+}
+
+func (m *CorrelationMatrix) Snapshot() CorrelationSnapshot {
+	values := make([][]*float64, len(m.Symbols))
+	for i := range values {
+		values[i] = make([]*float64, len(m.Symbols))
+		for j := range values[i] {
+			values[i][j] = nullableFloat(m.Correlation(i, j))
+		}
+	}
+	return CorrelationSnapshot{Time: m.Time, Window: m.Window, Symbols: m.Symbols, Values: values}
+}
+
+// SymbolPair is a pair of symbols with the correlation of their returns.
+type SymbolPair struct {
+	First       string
+	Second      string
+	Correlation float64
+}
+
+// Pairs returns the pairs correlated at least minCorrelation, most correlated
+// first.
+func (m *CorrelationMatrix) Pairs(minCorrelation float64) []SymbolPair {
+	var pairs []SymbolPair
+	for i := range m.Symbols {
+		for j := i + 1; j < len(m.Symbols); j++ {
+			if correlation := m.Correlation(i, j); correlation >= minCorrelation {
+				pairs = append(pairs, SymbolPair{m.Symbols[i], m.Symbols[j], correlation})
+			}
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Correlation > pairs[j].Correlation })
+	return pairs
+}
+
+// alignCandles returns the candles of both symbols that share an open time.
+// Both must be ordered by time.
+func alignCandles(a, b []Candlestick) ([]Candlestick, []Candlestick) {
+	var alignedA, alignedB []Candlestick
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i].OpenTime.Before(b[j].OpenTime):
+			i++
+		case b[j].OpenTime.Before(a[i].OpenTime):
+			j++
+		default:
+			alignedA = append(alignedA, a[i])
+			alignedB = append(alignedB, b[j])
+			i++
+			j++
+		}
+	}
+	return alignedA, alignedB
+}
+
+func logReturns(data []float64) []float64 {
+	returns := nanSlice(len(data))
+	for i := 1; i < len(data); i++ {
+		returns[i] = math.Log(data[i] / data[i-1])
+	}
+	return returns
+}
+
+// calculateRollingBeta returns the beta and correlation of the asset's returns
+// to the benchmark's over window bars. Both must be aligned.
+func calculateRollingBeta(asset, benchmark []float64, window int) ([]float64, []float64) {
+	start := firstValid(asset)
+	if benchmarkStart := firstValid(benchmark); benchmarkStart > start {
+		start = benchmarkStart
+	}
+	if window < 2 || len(asset) != len(benchmark) || len(asset)-start < window {
+		return nil, nil
+	}
+
+	beta := nanSlice(len(asset))
+	correlation := nanSlice(len(asset))
+	var s pairSums
+	for i := start; i < len(asset); i++ {
+		s.n++
+		s.x += benchmark[i]
+		s.y += asset[i]
+		s.xx += benchmark[i] * benchmark[i]
+		s.yy += asset[i] * asset[i]
+		s.xy += benchmark[i] * asset[i]
+		if i-start >= window {
+			old := i - window
+			s.n--
+			s.x -= benchmark[old]
+			s.y -= asset[old]
+			s.xx -= benchmark[old] * benchmark[old]
+			s.yy -= asset[old] * asset[old]
+			s.xy -= benchmark[old] * asset[old]
+		}
+		if i-start < window-1 {
+			continue
+		}
+
+		covariance := s.xy - s.x*s.y/s.n
+		varX := s.xx - s.x*s.x/s.n
+		varY := s.yy - s.y*s.y/s.n
+		if varX > 0 {
+			beta[i] = covariance / varX
+		}
+		if varX > 0 && varY > 0 {
+			correlation[i] = covariance / math.Sqrt(varX*varY)
+		}
+	}
+	return beta, correlation
+}
+
+// calculateRelativeStrength returns the asset's performance over window bars
+// divided by the benchmark's. Above 1 the asset outperformed. Both must be
+// aligned.
+func calculateRelativeStrength(asset, benchmark []float64, window int) []float64 {
+	if window <= 0 || len(asset) != len(benchmark) || len(asset) <= window {
+		return nil
+	}
+	rs := nanSlice(len(asset))
+	for i := window; i < len(asset); i++ {
+		rs[i] = (asset[i] / asset[i-window]) / (benchmark[i] / benchmark[i-window])
+	}
+	return rs
+}
+
+// BenchmarkStats is the latest beta, correlation and relative strength of a
+// symbol to a benchmark.
+type BenchmarkStats struct {
+	Symbol           string
+	Benchmark        string
+	Beta             float64
+	Correlation      float64
+	RelativeStrength float64
+}
+
+// benchmarkStats compares a symbol's candles with a benchmark's over window
+// bars.
+func benchmarkStats(symbol, benchmark string, data, benchmarkData []Candlestick, window int) (BenchmarkStats, bool) {
+	asset, bench := alignCandles(data, benchmarkData)
+	assetCloses, benchCloses := closePrices(asset), closePrices(bench)
+
+	beta, correlation := calculateRollingBeta(logReturns(assetCloses), logReturns(benchCloses), window)
+	rs := calculateRelativeStrength(assetCloses, benchCloses, window)
+	if beta == nil || rs == nil {
+		return BenchmarkStats{}, false
+	}
+
+	last := len(asset) - 1
+	stats := BenchmarkStats{
+		Symbol:           symbol,
+		Benchmark:        benchmark,
+		Beta:             beta[last],
+		Correlation:      correlation[last],
+		RelativeStrength: rs[last],
+	}
+	if math.IsNaN(stats.Beta) || math.IsNaN(stats.Correlation) || math.IsNaN(stats.RelativeStrength) {
+		return BenchmarkStats{}, false
+	}
+	return stats, true
+}
+
+// olsFit regresses y on the columns of x (one row per observation) and returns
+// the coefficients with their standard errors. Add a column of ones for a
+// constant.
+func olsFit(x [][]float64, y []float64) ([]float64, []float64, bool) {
+	if len(x) == 0 || len(x) != len(y) {
+		return nil, nil, false
+	}
+	k := len(x[0])
+	if len(x) <= k {
+		return nil, nil, false
+	}
+
+	// Invert X'X by Gauss-Jordan elimination on [X'X | I]
+	a := make([][]float64, k)
+	xty := make([]float64, k)
+	for i := range a {
+		a[i] = make([]float64, 2*k)
+		a[i][k+i] = 1
+	}
+	for r, row := range x {
+		for i := 0; i < k; i++ {
+			xty[i] += row[i] * y[r]
+			for j := 0; j < k; j++ {
+				a[i][j] += row[i] * row[j]
+			}
+		}
+	}
+	for col := 0; col < k; col++ {
+		pivot := col
+		for r := col + 1; r < k; r++ {
+			if math.Abs(a[r][col]) > math.Abs(a[pivot][col]) {
+				pivot = r
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-12 {
+			return nil, nil, false
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		scale := a[col][col]
+		for j := range a[col] {
+			a[col][j] /= scale
+		}
+		for r := 0; r < k; r++ {
+			if r == col || a[r][col] == 0 {
+				continue
+			}
+			factor := a[r][col]
+			for j := range a[r] {
+				a[r][j] -= factor * a[col][j]
+			}
+		}
+	}
+
+	coef := make([]float64, k)
+	for i := 0; i < k; i++ {
+		for j := 0; j < k; j++ {
+			coef[i] += a[i][k+j] * xty[j]
+		}
+	}
+
+	ssr := 0.0
+	for r, row := range x {
+		fitted := 0.0
+		for i := range coef {
+			fitted += row[i] * coef[i]
+		}
+		ssr += (y[r] - fitted) * (y[r] - fitted)
+	}
+	variance := ssr / float64(len(x)-k)
+	stderr := make([]float64, k)
+	for i := range stderr {
+		stderr[i] = math.Sqrt(variance * a[i][k+i])
+	}
+	return coef, stderr, true
+}
+
+// adfStatistic returns the augmented Dickey-Fuller t-statistic of the
+// series, regressing its changes on its previous level and lags previous
+// changes without a constant, as suits regression residuals.
+func adfStatistic(series []float64, lags int) (float64, bool) {
+	var x [][]float64
+	var y []float64
+	for t := lags + 1; t < len(series); t++ {
+		row := []float64{series[t-1]}
+		for l := 1; l <= lags; l++ {
+			row = append(row, series[t-l]-series[t-l-1])
+		}
+		x = append(x, row)
+		y = append(y, series[t]-series[t-1])
+	}
+	coef, stderr, ok := olsFit(x, y)
+	if !ok || stderr[0] == 0 {
+		return math.NaN(), false
+	}
+	return coef[0] / stderr[0], true
+}
+
+// halfLife returns the number of bars in which a deviation of the spread
+// halves, from an AR(1) fit of its changes, or 0 if it does not revert.
+func halfLife(spread []float64) float64 {
+	var x [][]float64
+	var y []float64
+	for t := 1; t < len(spread); t++ {
+		x = append(x, []float64{1, spread[t-1]})
+		y = append(y, spread[t]-spread[t-1])
+	}
+	coef, _, ok := olsFit(x, y)
+	if !ok || coef[1] >= 0 {
+		return 0
+	}
+	return -math.Ln2 / coef[1]
+}
+
+// calculateSpreadZScore returns the rolling z-score of the spread over window
+// bars.
+func calculateSpreadZScore(spread []float64, window int) []float64 {
+	mean := calculateSMA(spread, window)
+	variance := rollingSampleVariance(spread, window)
+	if mean == nil || variance == nil {
+		return nil
+	}
+	z := nanSlice(len(spread))
+	for i := range spread {
+		if variance[i] > 0 {
+			z[i] = (spread[i] - mean[i]) / math.Sqrt(variance[i])
+		}
+	}
+	return z
+}
+
+// CointegrationResult is the Engle-Granger test of a pair on log prices, with
+// the spread log(First) - HedgeRatio*log(Second) - Intercept. The pair is
+// Cointegrated when the ADF statistic of the spread is below the 5% critical
+// value. ZScore is the spread's latest z-score and HalfLife its mean-reversion
+// half-life in bars, 0 if it does not revert.
+type CointegrationResult struct {
+	First        string
+	Second       string
+	Correlation  float64
+	HedgeRatio   float64
+	Intercept    float64
+	ADFStatistic float64
+	Cointegrated bool
+	HalfLife     float64
+	ZScore       float64
+}
+
+// testCointegration runs the Engle-Granger test on the last window aligned
+// candles of a pair.
+func testCointegration(first, second string, firstData, secondData []Candlestick, opts StatsOptions) (CointegrationResult, bool) {
+	a, b := alignCandles(firstData, secondData)
+	if len(a) > opts.CointegrationWindow {
+		a, b = a[len(a)-opts.CointegrationWindow:], b[len(b)-opts.CointegrationWindow:]
+	}
+	if len(a) < opts.ZScoreWindow || len(a) < 3 {
+		return CointegrationResult{}, false
+	}
+
+	x := make([][]float64, len(a))
+	y := make([]float64, len(a))
+	for i := range a {
+		x[i] = []float64{1, math.Log(b[i].Close)}
+		y[i] = math.Log(a[i].Close)
+	}
+	coef, _, ok := olsFit(x, y)
+	if !ok {
+		return CointegrationResult{}, false
+	}
+
+	spread := make([]float64, len(a))
+	for i := range spread {
+		spread[i] = y[i] - coef[1]*x[i][1] - coef[0]
+	}
+	adf, ok := adfStatistic(spread, opts.ADFLags)
+	if !ok {
+		return CointegrationResult{}, false
+	}
+	z := calculateSpreadZScore(spread, opts.ZScoreWindow)
+	if z == nil || math.IsNaN(z[len(z)-1]) {
+		return CointegrationResult{}, false
+	}
+
+	return CointegrationResult{
+		First:        first,
+		Second:       second,
+		HedgeRatio:   coef[1],
+		Intercept:    coef[0],
+		ADFStatistic: adf,
+		Cointegrated: adf < engleGrangerCritical,
+		HalfLife:     halfLife(spread),
+		ZScore:       z[len(z)-1],
+	}, true
+}
+
+// rankPairs tests the candidate pairs for cointegration and returns the
+// results ordered from the most to the least cointegrated, at most MaxPairs.
+func rankPairs(candles map[string][]Candlestick, candidates []SymbolPair, opts StatsOptions) []CointegrationResult {
+	var results []CointegrationResult
+	for _, pair := range candidates {
+		result, ok := testCointegration(pair.First, pair.Second, candles[pair.First], candles[pair.Second], opts)
+		if !ok {
+			continue
+		}
+		result.Correlation = pair.Correlation
+		results = append(results, result)
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].ADFStatistic < results[j].ADFStatistic })
+	if opts.MaxPairs > 0 && len(results) > opts.MaxPairs {
+		results = results[:opts.MaxPairs]
+	}
+	return results
+}
+
+// statsRoutine periodically updates the correlation matrix with the bars
+// closed since the last run and publishes the matrix, the benchmark
+// statistics of every symbol and the ranked pairs.
+func statsRoutine(cache *Cache, symbols []string, opts StatsOptions, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	matrix := NewCorrelationMatrix(symbols, opts.CorrelationWindow)
+	for ; true; <-ticker.C {
+		now := time.Now()
+		candles := make(map[string][]Candlestick, len(symbols))
+		for _, symbol := range symbols {
+			data, err := cache.GetCandlesticks(symbol)
+			if err != nil {
+				log.Printf("Error reading candles for symbol %s: %v\n", symbol, err)
+				continue
+			}
+			candles[symbol] = closedCandles(data, now)
+		}
+
+		matrix.AddBars(alignLogReturns(candles, symbols))
+		if err := cache.Put(StatsKeyPrefix+"correlation", matrix.Snapshot(), 0); err != nil {
+			log.Printf("Error publishing correlation matrix: %v\n", err)
+		}
+
+		for _, symbol := range symbols {
+			var stats []BenchmarkStats
+			for _, benchmark := range opts.Benchmarks {
+				if benchmark == symbol {
+					continue
+				}
+				if s, ok := benchmarkStats(symbol, benchmark, candles[symbol], candles[benchmark], opts.BetaWindow); ok {
+					stats = append(stats, s)
+				}
+			}
+			if len(stats) == 0 {
+				continue
+			}
+			if err := cache.Put(StatsKeyPrefix+"benchmark:"+symbol, stats, 0); err != nil {
+				log.Printf("Error publishing benchmark stats for symbol %s: %v\n", symbol, err)
+			}
+		}
+
+		pairs := rankPairs(candles, matrix.Pairs(opts.MinCorrelation), opts)
+		if err := cache.Put(StatsKeyPrefix+"pairs", pairs, 0); err != nil {
+			log.Printf("Error publishing pairs: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// walkCandles returns n one-minute candles whose closes follow the given
+// log returns from 100.
+func walkCandles(start time.Time, n int, step func(i int) float64) []Candlestick {
+	data := make([]Candlestick, n)
+	price := 100.0
+	for i := range data {
+		if i > 0 {
+			price *= math.Exp(step(i))
+		}
+		data[i] = testCandle(start.Add(time.Duration(i)*time.Minute), price)
+	}
+	return data
+}
+
+func TestCorrelationMatrixAddBars(t *testing.T) {
+	start := time.Unix(1700000040, 0)
+	candles := map[string][]Candlestick{
+		"AUSDT": walkCandles(start, 30, func(i int) float64 { return 0.01 * math.Sin(float64(i)) }),
+		"BUSDT": walkCandles(start, 30, func(i int) float64 { return 0.02*math.Sin(float64(i)) + 0.001*math.Cos(float64(3*i)) }),
+	}
+	symbols := []string{"AUSDT", "BUSDT"}
+
+	// BUSDT's last bar is late, the first run holds that bar back
+	late := map[string][]Candlestick{"AUSDT": candles["AUSDT"], "BUSDT": candles["BUSDT"][:29]}
+	matrix := NewCorrelationMatrix(symbols, 10)
+	matrix.AddBars(alignLogReturns(late, symbols))
+	if want := candles["AUSDT"][28].OpenTime; !matrix.Time.Equal(want) {
+		t.Fatalf("matrix time = %v, want %v", matrix.Time, want)
+	}
+
+	// and adds it with both returns once it arrived
+	matrix.AddBars(alignLogReturns(candles, symbols))
+	if want := candles["AUSDT"][29].OpenTime; !matrix.Time.Equal(want) {
+		t.Fatalf("matrix time = %v, want %v", matrix.Time, want)
+	}
+
+	a, b := logReturns(closePrices(candles["AUSDT"])), logReturns(closePrices(candles["BUSDT"]))
+	_, want := calculateRollingBeta(a, b, 10)
+	got, _ := matrix.SymbolCorrelation("AUSDT", "BUSDT")
+	if math.Abs(got-want[29]) > 1e-9 {
+		t.Errorf("correlation = %v, want %v", got, want[29])
+	}
+}
+
+func TestStatsDefaultsFitHistory(t *testing.T) {
+	start := time.Unix(1700000040, 0)
+	opts := defaultStatsOptions()
+	btc := walkCandles(start, CandleHistoryLimit, func(i int) float64 { return 0.01 * math.Sin(float64(i)) })
+	asset := walkCandles(start, CandleHistoryLimit, func(i int) float64 { return 0.015*math.Sin(float64(i)) + 0.002*math.Cos(float64(5*i)) })
+
+	if _, ok := benchmarkStats("AUSDT", "BTCUSDT", asset, btc, opts.BetaWindow); !ok {
+		t.Error("no benchmark statistics from the startup history")
+	}
+	if _, ok := testCointegration("AUSDT", "BTCUSDT", asset, btc, opts); !ok {
+		t.Error("no cointegration test from the startup history")
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import "math"
+
+// Ehlers' filters are recursive, so their first values depend on how they are
+// seeded. They are seeded with the input and, like the other indicators, are
+// NaN during a warm-up of window bars.
+
+// dropWarmup sets the first window-1 values from start to NaN.
+func dropWarmup(values []float64, start, window int) []float64 {
+	for i := start; i < start+window-1 && i < len(values); i++ {
+		values[i] = math.NaN()
+	}
+	return values
+}
+
+// calculateSuperSmoother is Ehlers' two-pole super smoother, a low-pass filter
+// removing cycles shorter than period bars with little lag.
+func calculateSuperSmoother(data []float64, period int) []float64 {
+	start := firstValid(data)
+	if period <= 0 || len(data)-start < period || len(data)-start < 2 {
+		return nil
+	}
+
+	a1 := math.Exp(-math.Sqrt2 * math.Pi / float64(period))
+	c2 := 2 * a1 * math.Cos(math.Sqrt2*math.Pi/float64(period))
+	c3 := -a1 * a1
+	c1 := 1 - c2 - c3
+
+	filt := nanSlice(len(data))
+	filt[start], filt[start+1] = data[start], data[start+1]
+	for i := start + 2; i < len(data); i++ {
+		filt[i] = c1*(data[i]+data[i-1])/2 + c2*filt[i-1] + c3*filt[i-2]
+	}
+	return dropWarmup(filt, start, period)
+}
+
+// calculateHighPass is Ehlers' two-pole high-pass filter, removing cycles
+// longer than period bars.
+func calculateHighPass(data []float64, period int) []float64 {
+	start := firstValid(data)
+	if period <= 0 || len(data)-start < period || len(data)-start < 2 {
+		return nil
+	}
+
+	angle := 0.707 * 2 * math.Pi / float64(period)
+	a1 := (math.Cos(angle) + math.Sin(angle) - 1) / math.Cos(angle)
+
+	hp := nanSlice(len(data))
+	hp[start], hp[start+1] = 0, 0
+	for i := start + 2; i < len(data); i++ {
+		hp[i] = (1-a1/2)*(1-a1/2)*(data[i]-2*data[i-1]+data[i-2]) +
+			2*(1-a1)*hp[i-1] - (1-a1)*(1-a1)*hp[i-2]
+	}
+	return dropWarmup(hp, start, period)
+}
+
+// calculateRoofingFilter is Ehlers' roofing filter, a high-pass filter of
+// highPassPeriod followed by a super smoother of smoothPeriod. It keeps the
+// cycles between the two periods and oscillates around zero.
+func calculateRoofingFilter(data []float64, highPassPeriod, smoothPeriod int) []float64 {
+	return calculateSuperSmoother(calculateHighPass(data, highPassPeriod), smoothPeriod)
+}
+
+// hilbertWarmup is the number of bars before the Hilbert transform's period
+// estimate settles.
+const hilbertWarmup = 32
+
+// hilbertTransform runs Ehlers' Hilbert transform discriminator over the valid
+// data from start and returns, per bar, the smoothed dominant cycle period and
+// the phase in degrees.
+func hilbertTransform(data []float64, start int) (period, phase []float64) {
+	n := len(data)
+	smooth := make([]float64, n)
+	detrender := make([]float64, n)
+	i1 := make([]float64, n)
+	q1 := make([]float64, n)
+	i2 := make([]float64, n)
+	q2 := make([]float64, n)
+	re := make([]float64, n)
+	im := make([]float64, n)
+	rawPeriod := make([]float64, n)
+	period = make([]float64, n)
+	phase = make([]float64, n)
+
+	// hilbert is the FIR approximation of the Hilbert transform
+	hilbert := func(x []float64, i int) float64 {
+		return 0.0962*x[i] + 0.5769*x[i-2] - 0.5769*x[i-4] - 0.0962*x[i-6]
+	}
+
+	for i := start; i < n; i++ {
+		if i-start < 6 {
+			smooth[i] = data[i]
+			continue
+		}
+		smooth[i] = (4*data[i] + 3*data[i-1] + 2*data[i-2] + data[i-3]) / 10
+		gain := 0.075*rawPeriod[i-1] + 0.54
+
+		detrender[i] = hilbert(smooth, i) * gain
+		q1[i] = hilbert(detrender, i) * gain
+		i1[i] = detrender[i-3]
+
+		// Advance the phases of I1 and Q1 by 90 degrees
+		jI := hilbert(i1, i) * gain
+		jQ := hilbert(q1, i) * gain
+
+		i2[i] = 0.2*(i1[i]-jQ) + 0.8*i2[i-1]
+		q2[i] = 0.2*(q1[i]+jI) + 0.8*q2[i-1]
+
+		re[i] = 0.2*(i2[i]*i2[i-1]+q2[i]*q2[i-1]) + 0.8*re[i-1]
+		im[i] = 0.2*(i2[i]*q2[i-1]-q2[i]*i2[i-1]) + 0.8*im[i-1]
+
+		p := rawPeriod[i-1]
+		if im[i] != 0 && re[i] != 0 {
+			p = 2 * math.Pi / math.Atan(im[i]/re[i])
+		}
+		p = math.Min(math.Max(p, 0.67*rawPeriod[i-1]), 1.5*rawPeriod[i-1])
+		p = math.Min(math.Max(p, 6), 50)
+		rawPeriod[i] = 0.2*p + 0.8*rawPeriod[i-1]
+		period[i] = 0.33*rawPeriod[i] + 0.67*period[i-1]
+
+		phase[i] = phase[i-1]
+		if i1[i] != 0 {
+			phase[i] = math.Atan(q1[i]/i1[i]) * 180 / math.Pi
+		}
+	}
+	return period, phase
+}
+
+// calculateDominantCycle returns the period in bars of the dominant cycle
+// measured by Ehlers' Hilbert transform, between 6 and 50.
+func calculateDominantCycle(data []float64) []float64 {
+	start := firstValid(data)
+	if len(data)-start < hilbertWarmup {
+		return nil
+	}
+	period, _ := hilbertTransform(data, start)
+	for i := 0; i < start; i++ {
+		period[i] = math.NaN()
+	}
+	return dropWarmup(period, start, hilbertWarmup)
+}
+
+// calculateMAMA returns Ehlers' MESA adaptive moving average and its following
+// average FAMA. The smoothing follows the rate of change of the Hilbert
+// transform's phase, between slowLimit and fastLimit (0.05 and 0.5 usually).
+func calculateMAMA(data []float64, fastLimit, slowLimit float64) ([]float64, []float64) {
+	start := firstValid(data)
+	if len(data)-start < hilbertWarmup || fastLimit <= 0 || slowLimit <= 0 {
+		return nil, nil
+	}
+	_, phase := hilbertTransform(data, start)
+
+	mama := nanSlice(len(data))
+	fama := nanSlice(len(data))
+	mama[start], fama[start] = data[start], data[start]
+	for i := start + 1; i < len(data); i++ {
+		deltaPhase := math.Max(phase[i-1]-phase[i], 1)
+		alpha := math.Min(math.Max(fastLimit/deltaPhase, slowLimit), fastLimit)
+		mama[i] = alpha*data[i] + (1-alpha)*mama[i-1]
+		fama[i] = 0.5*alpha*mama[i] + (1-0.5*alpha)*fama[i-1]
+	}
+	return dropWarmup(mama, start, hilbertWarmup), dropWarmup(fama, start, hilbertWarmup)
+}
+
+// calculateKalmanFilter smooths prices with a one-dimensional Kalman filter
+// that models the price as a random walk observed with noise. Only the ratio
+// of processNoise to measurementNoise matters: the smaller it is, the smoother
+// and slower the output.
+func calculateKalmanFilter(data []float64, processNoise, measurementNoise float64) []float64 {
+	start := firstValid(data)
+	if start == len(data) || processNoise <= 0 || measurementNoise <= 0 {
+		return nil
+	}
+
+	kalman := nanSlice(len(data))
+	estimate, variance := data[start], measurementNoise
+	kalman[start] = estimate
+	for i := start + 1; i < len(data); i++ {
+		variance += processNoise
+		gain := variance / (variance + measurementNoise)
+		estimate += gain * (data[i] - estimate)
+		variance *= 1 - gain
+		kalman[i] = estimate
+	}
+	return kalman
+}
+
+// calculateHurstExponent returns the rolling Hurst exponent of the log prices
+// over window bars, estimated from how the root mean square of price changes
+// grows with the lag, for lags 2 to maxLag. Around 0.5 the prices walk
+// randomly, above they trend and below they revert to the mean.
+func calculateHurstExponent(data []float64, window, maxLag int) []float64 {
+	start := firstValid(data)
+	if maxLag < 2 || window <= maxLag || len(data)-start < window {
+		return nil
+	}
+
+	logs := make([]float64, len(data))
+	for i := start; i < len(data); i++ {
+		logs[i] = math.Log(data[i])
+	}
+
+	hurst := nanSlice(len(data))
+	for i := start + window - 1; i < len(data); i++ {
+		prices := logs[i-window+1 : i+1]
+
+		// Fit log(rms) = H*log(lag) + c
+		var sumX, sumY, sumXY, sumXX, n float64
+		for lag := 2; lag <= maxLag; lag++ {
+			sumSq := 0.0
+			for j := lag; j < len(prices); j++ {
+				change := prices[j] - prices[j-lag]
+				sumSq += change * change
+			}
+			if sumSq <= 0 {
+				continue
+			}
+			x, y := math.Log(float64(lag)), 0.5*math.Log(sumSq/float64(len(prices)-lag))
+			sumX += x
+			sumY += y
+			sumXY += x * y
+			sumXX += x * x
+			n++
+		}
+		if n >= 2 {
+			hurst[i] = (n*sumXY - sumX*sumY) / (n*sumXX - sumX*sumX)
+		}
+	}
+	return hurst
+}
+
+// calculateFractalDimension returns Ehlers' rolling fractal dimension over an
+// even window, comparing the ranges of the window's two halves with the range
+// of the whole window. It is near 1 for trending prices and near 2 for noise.
+func calculateFractalDimension(data []float64, window int) []float64 {
+	start := firstValid(data)
+	if window < 2 || window%2 != 0 || len(data)-start < window {
+		return nil
+	}
+
+	// rangeOf returns the high-low range of values per bar
+	rangeOf := func(values []float64) float64 {
+		high, low := values[0], values[0]
+		for _, value := range values {
+			high = math.Max(high, value)
+			low = math.Min(low, value)
+		}
+		return (high - low) / float64(len(values))
+	}
+
+	half := window / 2
+	fd := nanSlice(len(data))
+	for i := start + window - 1; i < len(data); i++ {
+		values := data[i-window+1 : i+1]
+		n1, n2, n3 := rangeOf(values[:half]), rangeOf(values[half:]), rangeOf(values)
+		if n1+n2 > 0 && n3 > 0 {
+			fd[i] = (math.Log(n1+n2) - math.Log(n3)) / math.Ln2
+		}
+	}
+	return fd
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCyclesReference(t *testing.T) {
+	close := closePrices(loadOHLCV(t))
+	want := loadReference(t, "cycles.csv")
+
+	mama, fama := calculateMAMA(close, 0.5, 0.05)
+
+	tests := []struct {
+		name string
+		got  []float64
+	}{
+		{"supersmoother10", calculateSuperSmoother(close, 10)},
+		{"dcperiod", calculateDominantCycle(close)},
+		{"mama", mama},
+		{"fama", fama},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertSeries(t, tt.name, tt.got, want[tt.name])
+		})
+	}
+}
+
+func TestDominantCycleSine(t *testing.T) {
+	sine := make([]float64, 300)
+	for i := range sine {
+		sine[i] = 100 + 10*math.Sin(2*math.Pi*float64(i)/20)
+	}
+
+	period := calculateDominantCycle(sine)
+	if got := period[len(period)-1]; math.Abs(got-20) > 1 {
+		t.Errorf("dominant cycle of a 20-bar sine = %v", got)
+	}
+}
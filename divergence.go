@@ -0,0 +1,245 @@
+package main
+
+import "math"
+
+// findPivotHighs returns the indices of the bars whose value is higher than
+// the left bars before and at least as high as the right bars after it. A
+// pivot is only known right bars after it formed.
+func findPivotHighs(values []float64, left, right int) []int {
+	return findPivots(values, left, right, func(a, b float64) bool { return a > b })
+}
+
+// findPivotLows is findPivotHighs for swing lows.
+func findPivotLows(values []float64, left, right int) []int {
+	return findPivots(values, left, right, func(a, b float64) bool { return a < b })
+}
+
+func findPivots(values []float64, left, right int, beyond func(a, b float64) bool) []int {
+	if left < 0 || right < 0 {
+		return nil
+	}
+	var pivots []int
+	for i := left; i+right < len(values); i++ {
+		if math.IsNaN(values[i]) {
+			continue
+		}
+		pivot := true
+		for j := i - left; j <= i+right && pivot; j++ {
+			switch {
+			case j == i:
+			case math.IsNaN(values[j]):
+				pivot = false
+			case j < i:
+				pivot = beyond(values[i], values[j])
+			default:
+				pivot = !beyond(values[j], values[i])
+			}
+		}
+		if pivot {
+			pivots = append(pivots, i)
+		}
+	}
+	return pivots
+}
+
+type DivergenceKind int
+
+const (
+	RegularDivergence DivergenceKind = iota
+	HiddenDivergence
+)
+
+func (k DivergenceKind) String() string {
+	if k == HiddenDivergence {
+		return "hidden"
+	}
+	return "regular"
+}
+
+// DivergencePoint is a price pivot and the oscillator value at the same bar.
+type DivergencePoint struct {
+	Index      int
+	Price      float64
+	Oscillator float64
+}
+
+// Divergence is a disagreement between two consecutive price pivots and the
+// oscillator. Bullish divergences are found on swing lows, bearish ones on
+// swing highs. Confirmed is the bar at which the second pivot, and so the
+// divergence, became known.
+//
+// Strength is the mean of the price and the oscillator move between the
+// pivots, each relative to the range of its series over the same bars, from 0
+// to 1.
+type Divergence struct {
+	Kind      DivergenceKind
+	Direction PatternDirection
+	First     DivergencePoint
+	Second    DivergencePoint
+	Confirmed int
+	Strength  float64
+}
+
+// DivergenceOptions sets the pivot lookbacks and how far apart the two
+// pivots of a divergence may be, in bars. None of them may be negative, a
+// MaxDistance of 0 has no limit.
+type DivergenceOptions struct {
+	LeftBars    int
+	RightBars   int
+	MinDistance int
+	MaxDistance int
+}
+
+func defaultDivergenceOptions() DivergenceOptions {
+	return DivergenceOptions{
+		LeftBars:    5,
+		RightBars:   5,
+		MinDistance: 5,
+		MaxDistance: 60,
+	}
+}
+
+// detectDivergences finds regular and hidden divergences between the candles
+// and an oscillator aligned to them, ordered by confirmation bar.
+//
+//	regular bullish: price lower low, oscillator higher low
+//	hidden bullish:  price higher low, oscillator lower low
+//	regular bearish: price higher high, oscillator lower high
+//	hidden bearish:  price lower high, oscillator higher high
+func detectDivergences(data []Candlestick, oscillator []float64, opts DivergenceOptions) []Divergence {
+	if len(oscillator) != len(data) || opts.LeftBars < 0 || opts.RightBars < 0 || opts.MinDistance < 0 || opts.MaxDistance < 0 {
+		return nil
+	}
+
+	high := make([]float64, len(data))
+	low := make([]float64, len(data))
+	for i, candle := range data {
+		high[i] = candle.High
+		low[i] = candle.Low
+	}
+
+	lows := divergencesAt(low, oscillator, findPivotLows(low, opts.LeftBars, opts.RightBars), Bullish, opts)
+	highs := divergencesAt(high, oscillator, findPivotHighs(high, opts.LeftBars, opts.RightBars), Bearish, opts)
+
+	// Merge both lists by confirmation bar
+	divergences := make([]Divergence, 0, len(lows)+len(highs))
+	for len(lows) > 0 || len(highs) > 0 {
+		if len(highs) == 0 || len(lows) > 0 && lows[0].Confirmed <= highs[0].Confirmed {
+			divergences = append(divergences, lows[0])
+			lows = lows[1:]
+		} else {
+			divergences = append(divergences, highs[0])
+			highs = highs[1:]
+		}
+	}
+	return divergences
+}
+
+// divergenceSeries returns, for the regular and the hidden divergences, 1 at
+// the bar confirming a bullish divergence, -1 at the bar confirming a bearish
+// one and 0 elsewhere.
+func divergenceSeries(n int, divergences []Divergence) ([]float64, []float64) {
+	regular := make([]float64, n)
+	hidden := make([]float64, n)
+	for _, divergence := range divergences {
+		if divergence.Confirmed >= n {
+			continue
+		}
+		value := 1.0
+		if divergence.Direction == Bearish {
+			value = -1
+		}
+		if divergence.Kind == HiddenDivergence {
+			hidden[divergence.Confirmed] = value
+		} else {
+			regular[divergence.Confirmed] = value
+		}
+	}
+	return regular, hidden
+}
+
+func divergencesAt(price, oscillator []float64, pivots []int, direction PatternDirection, opts DivergenceOptions) []Divergence {
+	var divergences []Divergence
+	for n := 1; n < len(pivots); n++ {
+		first, second := pivots[n-1], pivots[n]
+		distance := second - first
+		if distance < opts.MinDistance || opts.MaxDistance > 0 && distance > opts.MaxDistance {
+			continue
+		}
+		if math.IsNaN(oscillator[first]) || math.IsNaN(oscillator[second]) {
+			continue
+		}
+
+		priceMove := price[second] - price[first]
+		oscillatorMove := oscillator[second] - oscillator[first]
+		if direction == Bearish {
+			// Mirror highs so that the lows' rules apply
+			priceMove, oscillatorMove = -priceMove, -oscillatorMove
+		}
+
+		var kind DivergenceKind
+		switch {
+		case priceMove < 0 && oscillatorMove > 0:
+			kind = RegularDivergence
+		case priceMove > 0 && oscillatorMove < 0:
+			kind = HiddenDivergence
+		default:
+			continue
+		}
+
+		divergences = append(divergences, Divergence{
+			Kind:      kind,
+			Direction: direction,
+			First:     DivergencePoint{Index: first, Price: price[first], Oscillator: oscillator[first]},
+			Second:    DivergencePoint{Index: second, Price: price[second], Oscillator: oscillator[second]},
+			Confirmed: second + opts.RightBars,
+			Strength:  (relativeMove(price, first, second) + relativeMove(oscillator, first, second)) / 2,
+		})
+	}
+	return divergences
+}
+
+// relativeMove returns |values[to] - values[from]| as a share of the range of
+// values between both bars.
+func relativeMove(values []float64, from, to int) float64 {
+	lowest, highest := math.Inf(1), math.Inf(-1)
+	for i := from; i <= to; i++ {
+		if math.IsNaN(values[i]) {
+			continue
+		}
+		lowest = math.Min(lowest, values[i])
+		highest = math.Max(highest, values[i])
+	}
+	if highest <= lowest {
+		return 0
+	}
+	return math.Abs(values[to]-values[from]) / (highest - lowest)
+}
+
+func closePrices(data []Candlestick) []float64 {
+	closes := make([]float64, len(data))
+	for i, candle := range data {
+		closes[i] = candle.Close
+	}
+	return closes
+}
+
+func detectRSIDivergences(data []Candlestick, window int, opts DivergenceOptions) []Divergence {
+	return detectDivergences(data, calculateRSI(closePrices(data), window), opts)
+}
+
+// detectMACDDivergences uses the MACD histogram.
+func detectMACDDivergences(data []Candlestick, shortWindow, longWindow, signalWindow int, opts DivergenceOptions) []Divergence {
+	_, _, histogram := calculateMACD(closePrices(data), shortWindow, longWindow, signalWindow)
+	return detectDivergences(data, histogram, opts)
+}
+
+// detectStochasticDivergences uses %K.
+func detectStochasticDivergences(data []Candlestick, window int, opts DivergenceOptions) []Divergence {
+	k, _ := calculateStochasticOscillator(data, window)
+	return detectDivergences(data, k, opts)
+}
+
+func detectMomentumDivergences(data []Candlestick, window int, opts DivergenceOptions) []Divergence {
+	return detectDivergences(data, calculateMomentum(closePrices(data), window), opts)
+}
@@ -0,0 +1,96 @@
+package main
+
+import "testing"
+
+// oscillatorAt returns n values of 50 with the given values at the pivots.
+func oscillatorAt(n int, values map[int]float64) []float64 {
+	oscillator := make([]float64, n)
+	for i := range oscillator {
+		oscillator[i] = 50
+	}
+	for i, value := range values {
+		oscillator[i] = value
+	}
+	return oscillator
+}
+
+func TestDetectDivergences(t *testing.T) {
+	opts := DivergenceOptions{LeftBars: 2, RightBars: 2, MinDistance: 5, MaxDistance: 60}
+	tests := []struct {
+		name      string
+		prices    []float64
+		first     float64
+		second    float64
+		kind      DivergenceKind
+		direction PatternDirection
+	}{
+		// Pivots at bars 5 and 15
+		{"regular bullish", []float64{100, 90, 100, 85, 100}, 30, 35, RegularDivergence, Bullish},
+		{"hidden bullish", []float64{100, 90, 100, 95, 100}, 30, 25, HiddenDivergence, Bullish},
+		{"regular bearish", []float64{100, 110, 100, 115, 100}, 70, 65, RegularDivergence, Bearish},
+		{"hidden bearish", []float64{100, 110, 100, 105, 100}, 70, 75, HiddenDivergence, Bearish},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := pathCandles(5, tt.prices...)
+			divergences := detectDivergences(data, oscillatorAt(len(data), map[int]float64{5: tt.first, 15: tt.second}), opts)
+			if len(divergences) != 1 {
+				t.Fatalf("got %d divergences, want 1: %+v", len(divergences), divergences)
+			}
+			d := divergences[0]
+			if d.Kind != tt.kind || d.Direction != tt.direction {
+				t.Errorf("got a %v %v divergence, want %v %v", d.Kind, d.Direction, tt.kind, tt.direction)
+			}
+			if d.First.Index != 5 || d.Second.Index != 15 || d.Confirmed != 17 {
+				t.Errorf("pivots at %d and %d confirmed at %d, want 5 and 15 confirmed at 17", d.First.Index, d.Second.Index, d.Confirmed)
+			}
+			if d.Strength <= 0 || d.Strength > 1 {
+				t.Errorf("strength = %v, want within (0, 1]", d.Strength)
+			}
+
+			// The oscillator agreeing with the price is no divergence
+			agreeing := oscillatorAt(len(data), map[int]float64{5: tt.first, 15: 2*tt.first - tt.second})
+			if divergences := detectDivergences(data, agreeing, opts); len(divergences) != 0 {
+				t.Errorf("got %+v when the oscillator agrees", divergences)
+			}
+		})
+	}
+}
+
+func TestDetectDivergencesOptions(t *testing.T) {
+	data := pathCandles(5, 100, 90, 100, 85, 100)
+	oscillator := oscillatorAt(len(data), map[int]float64{5: 30, 15: 35})
+
+	for _, opts := range []DivergenceOptions{
+		// Pivots 10 bars apart are too close or too far
+		{LeftBars: 2, RightBars: 2, MinDistance: 11},
+		{LeftBars: 2, RightBars: 2, MaxDistance: 9},
+		// Negative options find nothing rather than indexing before the data
+		{LeftBars: -1, RightBars: 2},
+		{LeftBars: 2, RightBars: -1},
+		{LeftBars: 2, RightBars: 2, MinDistance: -1},
+	} {
+		if divergences := detectDivergences(data, oscillator, opts); len(divergences) != 0 {
+			t.Errorf("options %+v found %+v", opts, divergences)
+		}
+	}
+}
+
+func TestDivergenceStudy(t *testing.T) {
+	data := loadOHLCV(t)
+	divergences := detectRSIDivergences(data, 14, defaultDivergenceOptions())
+	if len(divergences) == 0 {
+		t.Fatal("no RSI divergences in the reference candles")
+	}
+	regular, hidden := divergenceSeries(len(data), divergences)
+
+	for output, want := range map[string][]float64{"regular": regular, "hidden": hidden} {
+		expr := "divergence(rsi(close, 14))." + output
+		study, err := CompileStudy(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertSeries(t, expr, study.Evaluate(data), want)
+	}
+}
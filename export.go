@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+type ExportFormat string
+
+const (
+	ExportCSV     ExportFormat = "csv"
+	ExportJSONL   ExportFormat = "jsonl"
+	ExportParquet ExportFormat = "parquet"
+)
+
+func parseExportFormat(value string) (ExportFormat, error) {
+	switch format := ExportFormat(value); format {
+	case ExportCSV, ExportJSONL, ExportParquet:
+		return format, nil
+	}
+	return "", fmt.Errorf("unsupported export format %q", value)
+}
+
+// ExportSource provides the series that can be exported. Storage implements it
+// directly, cacheExportSource adapts the Redis cache.
+type ExportSource interface {
+	QueryCandles(symbol, interval string, from, to time.Time) ([]Candlestick, error)
+	QueryTrades(symbol string, from, to time.Time) ([]Trade, error)
+	QueryOrderBooks(symbol string, from, to time.Time) ([]OrderBookSnapshot, error)
+}
+
+type cacheExportSource struct {
+	cache *Cache
+}
+
+// QueryCandles returns the cached candles for symbol within [from, to]. The
+// cache holds the candles of a single interval, other intervals are rejected.
+func (s cacheExportSource) QueryCandles(symbol, interval string, from, to time.Time) ([]Candlestick, error) {
+	duration, err := intervalDuration(interval)
+	if err != nil {
+		return nil, err
+	}
+	candles, err := s.cache.GetCandlesticks(symbol)
+	if err != nil {
+		return nil, err
+	}
+	if base := baseDuration(candles); base > 0 && base != duration {
+		return nil, fmt.Errorf("the cache holds %s candles, not %s", base, interval)
+	}
+
+	filtered := make([]Candlestick, 0, len(candles))
+	for _, candle := range candles {
+		if !candle.OpenTime.Before(from) && !candle.OpenTime.After(to) {
+			filtered = append(filtered, candle)
+		}
+	}
+	return filtered, nil
+}
+
+// QueryTrades fails, the cache does not keep the trade stream.
+func (s cacheExportSource) QueryTrades(symbol string, from, to time.Time) ([]Trade, error) {
+	return nil, errors.New("trades are not cached, export them from storage")
+}
+
+// QueryOrderBooks returns the latest cached order book snapshot of symbol if
+// it was taken within [from, to].
+func (s cacheExportSource) QueryOrderBooks(symbol string, from, to time.Time) ([]OrderBookSnapshot, error) {
+	var snapshot OrderBookSnapshot
+	found, err := s.cache.Get(OrderBookKeyPrefix+symbol, &snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if !found || snapshot.Time.Before(from) || snapshot.Time.After(to) {
+		return []OrderBookSnapshot{}, nil
+	}
+	return []OrderBookSnapshot{snapshot}, nil
+}
+
+// Export rows are flat records shared by all formats. Times are Unix
+// milliseconds so they survive every format without timezone ambiguity.
+
+type candleRow struct {
+	OpenTime                 int64   `json:"open_time" parquet:"name=open_time, type=INT64"`
+	CloseTime                int64   `json:"close_time" parquet:"name=close_time, type=INT64"`
+	Open                     float64 `json:"open" parquet:"name=open, type=DOUBLE"`
+	High                     float64 `json:"high" parquet:"name=high, type=DOUBLE"`
+	Low                      float64 `json:"low" parquet:"name=low, type=DOUBLE"`
+	Close                    float64 `json:"close" parquet:"name=close, type=DOUBLE"`
+	Volume                   float64 `json:"volume" parquet:"name=volume, type=DOUBLE"`
+	QuoteAssetVolume         float64 `json:"quote_asset_volume" parquet:"name=quote_asset_volume, type=DOUBLE"`
+	TakerBuyBaseAssetVolume  float64 `json:"taker_buy_base_asset_volume" parquet:"name=taker_buy_base_asset_volume, type=DOUBLE"`
+	TakerBuyQuoteAssetVolume float64 `json:"taker_buy_quote_asset_volume" parquet:"name=taker_buy_quote_asset_volume, type=DOUBLE"`
+}
+
+var candleHeader = []string{
+	"open_time", "close_time", "open", "high", "low", "close", "volume",
+	"quote_asset_volume", "taker_buy_base_asset_volume", "taker_buy_quote_asset_volume",
+}
+
+func (r candleRow) record() []string {
+	return []string{
+		strconv.FormatInt(r.OpenTime, 10),
+		strconv.FormatInt(r.CloseTime, 10),
+		formatExportFloat(r.Open),
+		formatExportFloat(r.High),
+		formatExportFloat(r.Low),
+		formatExportFloat(r.Close),
+		formatExportFloat(r.Volume),
+		formatExportFloat(r.QuoteAssetVolume),
+		formatExportFloat(r.TakerBuyBaseAssetVolume),
+		formatExportFloat(r.TakerBuyQuoteAssetVolume),
+	}
+}
+
+type tradeRow struct {
+	ID           int64   `json:"id" parquet:"name=id, type=INT64"`
+	Time         int64   `json:"time" parquet:"name=time, type=INT64"`
+	Price        float64 `json:"price" parquet:"name=price, type=DOUBLE"`
+	Quantity     float64 `json:"quantity" parquet:"name=quantity, type=DOUBLE"`
+	BuyerIsMaker bool    `json:"buyer_is_maker" parquet:"name=buyer_is_maker, type=BOOLEAN"`
+}
+
+var tradeHeader = []string{"id", "time", "price", "quantity", "buyer_is_maker"}
+
+func (r tradeRow) record() []string {
+	return []string{
+		strconv.FormatInt(r.ID, 10),
+		strconv.FormatInt(r.Time, 10),
+		formatExportFloat(r.Price),
+		formatExportFloat(r.Quantity),
+		strconv.FormatBool(r.BuyerIsMaker),
+	}
+}
+
+// bookRow is one price level of an order book snapshot, Level counts from 0
+// at the top of each side.
+type bookRow struct {
+	Time     int64   `json:"time" parquet:"name=time, type=INT64"`
+	Side     string  `json:"side" parquet:"name=side, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Level    int32   `json:"level" parquet:"name=level, type=INT32"`
+	Price    float64 `json:"price" parquet:"name=price, type=DOUBLE"`
+	Quantity float64 `json:"quantity" parquet:"name=quantity, type=DOUBLE"`
+}
+
+var bookHeader = []string{"time", "side", "level", "price", "quantity"}
+
+func (r bookRow) record() []string {
+	return []string{
+		strconv.FormatInt(r.Time, 10),
+		r.Side,
+		strconv.FormatInt(int64(r.Level), 10),
+		formatExportFloat(r.Price),
+		formatExportFloat(r.Quantity),
+	}
+}
+
+func formatExportFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+type exportRow interface {
+	record() []string
+}
+
+// writeRows writes rows to w in the given format. schema is a pointer to the
+// row type and is only used for Parquet.
+func writeRows(w io.Writer, format ExportFormat, header []string, schema interface{}, rows []exportRow) error {
+	switch format {
+	case ExportCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(header); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if err := cw.Write(row.record()); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+
+	case ExportJSONL:
+		bw := bufio.NewWriter(w)
+		enc := json.NewEncoder(bw)
+		for _, row := range rows {
+			if err := enc.Encode(row); err != nil {
+				return err
+			}
+		}
+		return bw.Flush()
+
+	case ExportParquet:
+		pw, err := writer.NewParquetWriterFromWriter(w, schema, 4)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if err := pw.Write(row); err != nil {
+				return err
+			}
+		}
+		return pw.WriteStop()
+	}
+
+	return fmt.Errorf("unsupported export format %q", format)
+}
+
+func writeCandles(w io.Writer, format ExportFormat, candles []Candlestick) error {
+	rows := make([]exportRow, 0, len(candles))
+	for _, candle := range candles {
+		rows = append(rows, candleRow{
+			OpenTime:                 candle.OpenTime.UnixMilli(),
+			CloseTime:                candle.CloseTime.UnixMilli(),
+			Open:                     candle.Open,
+			High:                     candle.High,
+			Low:                      candle.Low,
+			Close:                    candle.Close,
+			Volume:                   candle.Volume,
+			QuoteAssetVolume:         candle.QuoteAssetVolume,
+			TakerBuyBaseAssetVolume:  candle.TakerBuyBaseAssetVolume,
+			TakerBuyQuoteAssetVolume: candle.TakerBuyQuoteAssetVolume,
+		})
+	}
+	return writeRows(w, format, candleHeader, new(candleRow), rows)
+}
+
+func writeTrades(w io.Writer, format ExportFormat, trades []Trade) error {
+	rows := make([]exportRow, 0, len(trades))
+	for _, trade := range trades {
+		rows = append(rows, tradeRow{
+			ID:           trade.ID,
+			Time:         trade.Time,
+			Price:        trade.Price,
+			Quantity:     trade.Quantity,
+			BuyerIsMaker: trade.BuyerIsMaker,
+		})
+	}
+	return writeRows(w, format, tradeHeader, new(tradeRow), rows)
+}
+
+func writeOrderBooks(w io.Writer, format ExportFormat, snapshots []OrderBookSnapshot) error {
+	var rows []exportRow
+	for _, snapshot := range snapshots {
+		snapshotTime := snapshot.Time.UnixMilli()
+		for level, entry := range snapshot.Bids {
+			rows = append(rows, bookRow{Time: snapshotTime, Side: "bid", Level: int32(level), Price: entry.Price, Quantity: entry.Quantity})
+		}
+		for level, entry := range snapshot.Asks {
+			rows = append(rows, bookRow{Time: snapshotTime, Side: "ask", Level: int32(level), Price: entry.Price, Quantity: entry.Quantity})
+		}
+	}
+	return writeRows(w, format, bookHeader, new(bookRow), rows)
+}
+
+// exportSeries queries kind ("candles", "trades" or "books") for symbol from
+// source and writes it to w.
+func exportSeries(w io.Writer, source ExportSource, kind string, format ExportFormat, symbol, interval string, from, to time.Time) error {
+	switch kind {
+	case "candles":
+		candles, err := source.QueryCandles(symbol, interval, from, to)
+		if err != nil {
+			return err
+		}
+		return writeCandles(w, format, candles)
+	case "trades":
+		trades, err := source.QueryTrades(symbol, from, to)
+		if err != nil {
+			return err
+		}
+		return writeTrades(w, format, trades)
+	case "books":
+		snapshots, err := source.QueryOrderBooks(symbol, from, to)
+		if err != nil {
+			return err
+		}
+		return writeOrderBooks(w, format, snapshots)
+	}
+	return fmt.Errorf("unsupported export kind %q", kind)
+}
+
+// runExport implements the "export" command:
+//
+//	export -kind candles -symbol BTCUSDT -interval 1m -from 2024-01-01T00:00:00Z -format parquet -out btc.parquet
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	kind := fs.String("kind", "candles", "series to export: candles, trades or books")
+	symbol := fs.String("symbol", "", "symbol to export, e.g. BTCUSDT")
+	interval := fs.String("interval", "1m", "candle interval")
+	fromFlag := fs.String("from", "", "start of the range (RFC3339), defaults to 24h before -to")
+	toFlag := fs.String("to", "", "end of the range (RFC3339), defaults to now")
+	formatFlag := fs.String("format", string(ExportCSV), "output format: csv, jsonl or parquet")
+	sourceFlag := fs.String("source", "storage", "data source: storage or cache")
+	storageDir := fs.String("storage", os.Getenv("STORAGE_DIR"), "storage directory")
+	out := fs.String("out", "", "output file, defaults to stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *symbol == "" {
+		return errors.New("-symbol is required")
+	}
+	format, err := parseExportFormat(*formatFlag)
+	if err != nil {
+		return err
+	}
+
+	to := time.Now()
+	if *toFlag != "" {
+		if to, err = time.Parse(time.RFC3339, *toFlag); err != nil {
+			return fmt.Errorf("failed to parse -to value: %w", err)
+		}
+	}
+	from := to.Add(-24 * time.Hour)
+	if *fromFlag != "" {
+		if from, err = time.Parse(time.RFC3339, *fromFlag); err != nil {
+			return fmt.Errorf("failed to parse -from value: %w", err)
+		}
+	}
+
+	var source ExportSource
+	switch *sourceFlag {
+	case "storage":
+		store, err := NewStorage(*storageDir)
+		if err != nil {
+			return err
+		}
+		source = store
+	case "cache":
+		cache, err := NewCache()
+		if err != nil {
+			return err
+		}
+		source = cacheExportSource{cache: cache}
+	default:
+		return fmt.Errorf("unsupported export source %q", *sourceFlag)
+	}
+
+	if *out == "" {
+		return exportSeries(os.Stdout, source, *kind, format, *symbol, *interval, from, to)
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	err = exportSeries(f, source, *kind, format, *symbol, *interval, from, to)
+	// Written data may only fail to reach the file on close
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to close %s: %w", *out, closeErr)
+	}
+	return err
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+func TestWriteCandlesParquet(t *testing.T) {
+	candles := minuteCandles(time.Unix(1700000040, 0), 50)
+
+	var out bytes.Buffer
+	if err := writeCandles(&out, ExportParquet, candles); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := buffer.NewBufferFile(out.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, err := reader.NewParquetReader(file, new(candleRow), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.ReadStop()
+
+	rows := make([]candleRow, pr.GetNumRows())
+	if err := pr.Read(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != len(candles) {
+		t.Fatalf("read %d rows, want %d", len(rows), len(candles))
+	}
+	for i, row := range rows {
+		candle := candles[i]
+		if row.OpenTime != candle.OpenTime.UnixMilli() || row.CloseTime != candle.CloseTime.UnixMilli() ||
+			row.Open != candle.Open || row.High != candle.High || row.Low != candle.Low || row.Close != candle.Close || row.Volume != candle.Volume {
+			t.Fatalf("row %d = %+v, want %+v", i, row, candle)
+		}
+	}
+}
+
+func TestWriteOrderBooksCSV(t *testing.T) {
+	snapshot := OrderBookSnapshot{
+		Time: time.UnixMilli(1700000000123),
+		OrderBook: OrderBook{
+			Bids: []OrderBookEntry{{Price: 99.5, Quantity: 1}, {Price: 99, Quantity: 2}},
+			Asks: []OrderBookEntry{{Price: 100.5, Quantity: 3}},
+		},
+	}
+
+	var out bytes.Buffer
+	if err := writeOrderBooks(&out, ExportCSV, []OrderBookSnapshot{snapshot}); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{
+		bookHeader,
+		{"1700000000123", "bid", "0", "99.5", "1"},
+		{"1700000000123", "bid", "1", "99", "2"},
+		{"1700000000123", "ask", "0", "100.5", "3"},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("got %d records, want %d", len(records), len(want))
+	}
+	for i := range want {
+		for j := range want[i] {
+			if records[i][j] != want[i][j] {
+				t.Errorf("record %d = %v, want %v", i, records[i], want[i])
+				break
+			}
+		}
+	}
+}
+
+func TestCacheExportSource(t *testing.T) {
+	cache := newTestCache(t)
+	start := time.Unix(1700000040, 0)
+	if err := cache.SetCandlesticks("BTCUSDT", minuteCandles(start, 10)); err != nil {
+		t.Fatal(err)
+	}
+	source := cacheExportSource{cache: cache}
+
+	candles, err := source.QueryCandles("BTCUSDT", "1m", start.Add(2*time.Minute), start.Add(4*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candles) != 3 || !candles[0].OpenTime.Equal(start.Add(2*time.Minute)) {
+		t.Errorf("got %d candles from %v, want 3 from %v", len(candles), candles[0].OpenTime, start.Add(2*time.Minute))
+	}
+
+	// The cache only holds 1m candles
+	if _, err := source.QueryCandles("BTCUSDT", "1h", start, start.Add(time.Hour)); err == nil {
+		t.Error("1h candles exported from a 1m cache")
+	}
+	if _, err := source.QueryTrades("BTCUSDT", start, start.Add(time.Hour)); err == nil {
+		t.Error("trades exported from the cache")
+	}
+
+	snapshot := OrderBookSnapshot{Time: start.Add(time.Minute), OrderBook: OrderBook{Bids: []OrderBookEntry{{Price: 1, Quantity: 2}}}}
+	if err := cache.Put(OrderBookKeyPrefix+"BTCUSDT", snapshot, 0); err != nil {
+		t.Fatal(err)
+	}
+	books, err := source.QueryOrderBooks("BTCUSDT", start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(books) != 1 || !books[0].Time.Equal(snapshot.Time) || len(books[0].Bids) != 1 {
+		t.Errorf("got order books %+v, want the cached snapshot", books)
+	}
+	if books, _ := source.QueryOrderBooks("BTCUSDT", start.Add(time.Hour), start.Add(2*time.Hour)); len(books) != 0 {
+		t.Errorf("got %d order books outside the range", len(books))
+	}
+}
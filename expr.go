@@ -0,0 +1,845 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const StudyKeyPrefix = "study:"
+
+// Studies are small expressions over a symbol's candles, e.g.
+//
+//	ema(close, 20) - sma(close, 50)
+//	rsi(close, 14) < 30 and crossover(macd.line, macd.signal)
+//
+// Identifiers are candle series (open, high, low, close, volume, hl2, hlc3,
+// ohlc4, ...) and registered indicators. An indicator used without a call
+// takes its default parameters and close as its source, ".name" selects one
+// of its outputs. Parameters must be numbers, except moving average types,
+// which are names such as "ema", e.g. bb(close, 20, 2, "ema"). Comparisons and logical
+// operators yield 1 or 0, and NaN wherever an operand is NaN, so warm-up
+// periods stay undefined. The built-in functions are crossover, crossunder,
+// abs, log, min, max and prev(series, bars). tf("1h", expression) evaluates
+// an expression on a higher interval, e.g. tf("1h", ema(close, 50)), and only
+// uses higher-interval bars that had closed by each bar.
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenIdent
+	tokenOperator
+	tokenString
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value float64
+	pos   int
+}
+
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case unicode.IsDigit(c) || c == '.' && i+1 < len(src) && unicode.IsDigit(rune(src[i+1])):
+			start := i
+			for i < len(src) && (unicode.IsDigit(rune(src[i])) || src[i] == '.') {
+				i++
+			}
+			value, err := strconv.ParseFloat(src[start:i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at %d", src[start:i], start)
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: src[start:i], value: value, pos: start})
+
+		case c == '"' || c == '\'':
+			end := strings.IndexRune(src[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: src[i+1 : i+1+end], pos: i})
+			i += end + 2
+
+		case unicode.IsLetter(c) || c == '_':
+			start := i
+			for i < len(src) && (unicode.IsLetter(rune(src[i])) || unicode.IsDigit(rune(src[i])) || src[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: strings.ToLower(src[start:i]), pos: start})
+
+		default:
+			if i+1 < len(src) {
+				switch two := src[i : i+2]; two {
+				case "<=", ">=", "==", "!=", "&&", "||":
+					tokens = append(tokens, token{kind: tokenOperator, text: two, pos: i})
+					i += 2
+					continue
+				}
+			}
+			if !strings.ContainsRune("+-*/<>(),.!", c) {
+				return nil, fmt.Errorf("unexpected character %q at %d", c, i)
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: string(c), pos: i})
+			i++
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(src)}), nil
+}
+
+type exprNode interface{}
+
+type numberNode struct {
+	value float64
+}
+
+type stringNode struct {
+	value string
+}
+
+type identNode struct {
+	name string
+}
+
+type callNode struct {
+	name string
+	args []exprNode
+}
+
+type fieldNode struct {
+	target exprNode
+	field  string
+}
+
+type unaryNode struct {
+	op      string
+	operand exprNode
+}
+
+type binaryNode struct {
+	op          string
+	left, right exprNode
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is one of the given operators or
+// keywords.
+func (p *parser) accept(texts ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != tokenOperator && t.kind != tokenIdent {
+		return "", false
+	}
+	for _, text := range texts {
+		if t.text == text {
+			p.next()
+			return text, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) expect(text string) error {
+	if _, ok := p.accept(text); !ok {
+		t := p.peek()
+		if t.kind == tokenEOF {
+			return fmt.Errorf("expected %q at end of expression", text)
+		}
+		return fmt.Errorf("expected %q at %d, got %q", text, t.pos, t.text)
+	}
+	return nil
+}
+
+func parseExpression(src string) (exprNode, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+	}
+	return node, nil
+}
+
+func (p *parser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("or", "||"); !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: "or", left: left, right: right}
+	}
+}
+
+func (p *parser) parseAnd() (exprNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("and", "&&"); !ok {
+			return left, nil
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: "and", left: left, right: right}
+	}
+}
+
+func (p *parser) parseNot() (exprNode, error) {
+	if _, ok := p.accept("not", "!"); ok {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return unaryNode{op: "not", operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (exprNode, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.accept("<", "<=", ">", ">=", "==", "!=")
+	if !ok {
+		return left, nil
+	}
+	right, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	return binaryNode{op: op, left: left, right: right}, nil
+}
+
+func (p *parser) parseAdditive() (exprNode, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("+", "-")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) parseMultiplicative() (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("*", "/")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) parseUnary() (exprNode, error) {
+	if _, ok := p.accept("-"); ok {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if number, ok := operand.(numberNode); ok {
+			return numberNode{value: -number.value}, nil
+		}
+		return unaryNode{op: "-", operand: operand}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *parser) parsePostfix() (exprNode, error) {
+	node, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("."); !ok {
+			return node, nil
+		}
+		t := p.next()
+		if t.kind != tokenIdent {
+			return nil, fmt.Errorf("expected output name at %d", t.pos)
+		}
+		node = fieldNode{target: node, field: t.text}
+	}
+}
+
+func (p *parser) parsePrimary() (exprNode, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenNumber:
+		return numberNode{value: t.value}, nil
+
+	case tokenString:
+		return stringNode{value: t.text}, nil
+
+	case tokenIdent:
+		if _, ok := p.accept("("); !ok {
+			return identNode{name: t.text}, nil
+		}
+		var args []exprNode
+		if _, ok := p.accept(")"); ok {
+			return callNode{name: t.text}, nil
+		}
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if _, ok := p.accept(","); ok {
+				continue
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return callNode{name: t.text, args: args}, nil
+		}
+
+	case tokenOperator:
+		if t.text == "(" {
+			node, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return node, nil
+		}
+	}
+
+	if t.kind == tokenEOF {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+}
+
+// exprFunction is a built-in function over series. arity -1 accepts any
+// number of arguments greater than zero.
+type exprFunction struct {
+	arity int
+	eval  func(args [][]float64) []float64
+}
+
+var exprFunctions = map[string]exprFunction{
+	"crossover": {2, func(args [][]float64) []float64 {
+		return crossSeries(args[0], args[1], func(prevA, prevB, a, b float64) bool { return prevA <= prevB && a > b })
+	}},
+	"crossunder": {2, func(args [][]float64) []float64 {
+		return crossSeries(args[0], args[1], func(prevA, prevB, a, b float64) bool { return prevA >= prevB && a < b })
+	}},
+	"abs": {1, func(args [][]float64) []float64 {
+		return mapSeries(args[0], math.Abs)
+	}},
+	"log": {1, func(args [][]float64) []float64 {
+		return mapSeries(args[0], math.Log)
+	}},
+	"max": {-1, func(args [][]float64) []float64 {
+		return foldSeries(args, math.Max)
+	}},
+	"min": {-1, func(args [][]float64) []float64 {
+		return foldSeries(args, math.Min)
+	}},
+	"prev": {2, func(args [][]float64) []float64 {
+		result := nanSlice(len(args[0]))
+		for i, bars := range args[1] {
+			// Checked before converting, a huge count would overflow int
+			if bars < 0 || bars != math.Trunc(bars) || bars > float64(i) {
+				continue
+			}
+			result[i] = args[0][i-int(bars)]
+		}
+		return result
+	}},
+}
+
+func mapSeries(values []float64, fn func(float64) float64) []float64 {
+	result := make([]float64, len(values))
+	for i, value := range values {
+		result[i] = fn(value)
+	}
+	return result
+}
+
+func foldSeries(args [][]float64, fn func(a, b float64) float64) []float64 {
+	result := append([]float64(nil), args[0]...)
+	for _, arg := range args[1:] {
+		for i := range result {
+			result[i] = fn(result[i], arg[i])
+		}
+	}
+	return result
+}
+
+func crossSeries(a, b []float64, crossed func(prevA, prevB, a, b float64) bool) []float64 {
+	result := nanSlice(len(a))
+	for i := 1; i < len(a); i++ {
+		if math.IsNaN(a[i-1]) || math.IsNaN(b[i-1]) || math.IsNaN(a[i]) || math.IsNaN(b[i]) {
+			continue
+		}
+		result[i] = boolValue(crossed(a[i-1], b[i-1], a[i], b[i]))
+	}
+	return result
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Study is a compiled expression that can be evaluated over any candle series.
+type Study struct {
+	Source string
+	root   exprNode
+}
+
+// CompileStudy parses and validates an expression.
+func CompileStudy(src string) (*Study, error) {
+	root, err := parseExpression(src)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateNode(root); err != nil {
+		return nil, err
+	}
+	return &Study{Source: src, root: root}, nil
+}
+
+// indicatorCall returns the spec, source argument and parameters of a call
+// or bare identifier naming an indicator.
+func indicatorCall(node exprNode) (IndicatorSpec, exprNode, []float64, bool, error) {
+	var name string
+	var args []exprNode
+	switch n := node.(type) {
+	case identNode:
+		name = n.name
+	case callNode:
+		name, args = n.name, n.args
+	default:
+		return IndicatorSpec{}, nil, nil, false, nil
+	}
+
+	spec, ok := indicatorRegistry[name]
+	if !ok {
+		return IndicatorSpec{}, nil, nil, false, nil
+	}
+
+	var source exprNode = identNode{name: "close"}
+	if spec.Source && len(args) > 0 {
+		source, args = args[0], args[1:]
+	}
+
+	params := make([]float64, len(args))
+	for i, arg := range args {
+		params[i] = math.NaN()
+		if i >= len(spec.Params) {
+			// validateParams reports the extra parameters
+			continue
+		}
+		param := spec.Params[i]
+		switch value := arg.(type) {
+		case numberNode:
+			if param.MAType {
+				return spec, nil, nil, true, fmt.Errorf("%s parameter %s must be a moving average type such as \"ema\"", name, param.Name)
+			}
+			params[i] = value.value
+		case stringNode:
+			if !param.MAType {
+				return spec, nil, nil, true, fmt.Errorf("%s parameter %s must be a number", name, param.Name)
+			}
+			maType, err := parseMAType(strings.ToLower(value.value))
+			if err != nil {
+				return spec, nil, nil, true, fmt.Errorf("%s parameter %s: %w", name, param.Name, err)
+			}
+			params[i] = float64(maType)
+		default:
+			return spec, nil, nil, true, fmt.Errorf("%s parameters must be numbers or moving average types", name)
+		}
+	}
+	params, err := spec.validateParams(params)
+	return spec, source, params, true, err
+}
+
+func validateNode(node exprNode) error {
+	switch n := node.(type) {
+	case numberNode:
+		return nil
+
+	case identNode:
+		if n.name == "and" || n.name == "or" || n.name == "not" {
+			return fmt.Errorf("unexpected keyword %q", n.name)
+		}
+		if _, ok := candleSource(nil, n.name); ok {
+			return nil
+		}
+		if _, ok := indicatorRegistry[n.name]; ok {
+			return nil
+		}
+		return fmt.Errorf("unknown identifier %q", n.name)
+
+	case callNode:
+		spec, source, _, ok, err := indicatorCall(n)
+		if err != nil {
+			return err
+		}
+		if ok {
+			if spec.Source {
+				return validateNode(source)
+			}
+			return nil
+		}
+
+		if n.name == "tf" {
+			return validateTimeframe(n)
+		}
+
+		fn, ok := exprFunctions[n.name]
+		if !ok {
+			return fmt.Errorf("unknown function %q", n.name)
+		}
+		if fn.arity < 0 && len(n.args) == 0 {
+			return fmt.Errorf("%s takes at least one argument", n.name)
+		}
+		if fn.arity >= 0 && len(n.args) != fn.arity {
+			return fmt.Errorf("%s takes %d arguments, got %d", n.name, fn.arity, len(n.args))
+		}
+		for _, arg := range n.args {
+			if err := validateNode(arg); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case fieldNode:
+		spec, _, _, ok, err := indicatorCall(n.target)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("only indicators have outputs, cannot select %q", n.field)
+		}
+		if spec.outputIndex(n.field) < 0 {
+			return fmt.Errorf("%s has no output %q, outputs are %s", spec.Name, n.field, strings.Join(spec.Outputs, ", "))
+		}
+		return validateNode(n.target)
+
+	case stringNode:
+		return fmt.Errorf("unexpected string %q, strings are only used as tf intervals and moving average types", n.value)
+
+	case unaryNode:
+		return validateNode(n.operand)
+
+	case binaryNode:
+		if err := validateNode(n.left); err != nil {
+			return err
+		}
+		return validateNode(n.right)
+	}
+	return fmt.Errorf("unsupported expression")
+}
+
+// validateTimeframe checks a tf(interval, expression) call, which evaluates
+// the expression on closed bars of the higher interval.
+func validateTimeframe(n callNode) error {
+	if len(n.args) != 2 {
+		return fmt.Errorf("tf takes an interval and an expression, got %d arguments", len(n.args))
+	}
+	interval, ok := n.args[0].(stringNode)
+	if !ok {
+		return fmt.Errorf("tf interval must be a string such as \"1h\"")
+	}
+	if _, err := intervalDuration(interval.value); err != nil {
+		return fmt.Errorf("tf: %w", err)
+	}
+	return validateNode(n.args[1])
+}
+
+// ValidateInterval checks that every tf interval of the study is a multiple of
+// the interval it is evaluated on, base for the outermost.
+func (s *Study) ValidateInterval(base string) error {
+	duration, err := intervalDuration(base)
+	if err != nil {
+		return err
+	}
+	return validateTimeframes(s.root, duration)
+}
+
+func validateTimeframes(node exprNode, base time.Duration) error {
+	var children []exprNode
+	switch n := node.(type) {
+	case callNode:
+		if n.name == "tf" {
+			interval := n.args[0].(stringNode).value
+			if err := checkTimeframe(base, interval); err != nil {
+				return fmt.Errorf("tf: %w", err)
+			}
+			base, _ = intervalDuration(interval)
+			children = n.args[1:]
+		} else {
+			children = n.args
+		}
+	case fieldNode:
+		children = []exprNode{n.target}
+	case unaryNode:
+		children = []exprNode{n.operand}
+	case binaryNode:
+		children = []exprNode{n.left, n.right}
+	}
+
+	for _, child := range children {
+		if err := validateTimeframes(child, base); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Evaluate evaluates the study over the candles, one value per candle.
+// Higher timeframes are resampled from the candles.
+func (s *Study) Evaluate(data []Candlestick) []float64 {
+	return evaluateNode(s.root, NewMultiTimeframe(data))
+}
+
+// EvaluateSeries evaluates the study and returns the values with the open
+// times of the candles.
+func (s *Study) EvaluateSeries(data []Candlestick) Series {
+	return alignedSeries(data, s.Evaluate(data))
+}
+
+// EvaluateFrames evaluates the study over the base series of frames, using
+// its higher-interval candles for tf.
+func (s *Study) EvaluateFrames(frames *MultiTimeframe) []float64 {
+	return evaluateNode(s.root, frames)
+}
+
+func evaluateIndicator(node exprNode, frames *MultiTimeframe, output string) []float64 {
+	data := frames.Base
+	spec, sourceNode, params, _, _ := indicatorCall(node)
+	var source []float64
+	if spec.Source {
+		source = evaluateNode(sourceNode, frames)
+	}
+	outputs := spec.run(source, data, params)
+	if output == "" {
+		return outputs[0]
+	}
+	return outputs[spec.outputIndex(output)]
+}
+
+func evaluateNode(node exprNode, frames *MultiTimeframe) []float64 {
+	data := frames.Base
+	switch n := node.(type) {
+	case numberNode:
+		values := make([]float64, len(data))
+		for i := range values {
+			values[i] = n.value
+		}
+		return values
+
+	case identNode:
+		if series, ok := candleSource(data, n.name); ok {
+			return series
+		}
+		return evaluateIndicator(n, frames, "")
+
+	case callNode:
+		if _, ok := indicatorRegistry[n.name]; ok {
+			return evaluateIndicator(n, frames, "")
+		}
+		if n.name == "tf" {
+			interval := n.args[0].(stringNode).value
+			values, err := frames.Evaluate(interval, func(higher []Candlestick) []float64 {
+				return evaluateNode(n.args[1], NewMultiTimeframe(higher))
+			})
+			if err != nil {
+				return nanSlice(len(data))
+			}
+			return values
+		}
+		args := make([][]float64, len(n.args))
+		for i, arg := range n.args {
+			args[i] = evaluateNode(arg, frames)
+		}
+		return exprFunctions[n.name].eval(args)
+
+	case fieldNode:
+		return evaluateIndicator(n.target, frames, n.field)
+
+	case unaryNode:
+		operand := evaluateNode(n.operand, frames)
+		if n.op == "-" {
+			return mapSeries(operand, func(v float64) float64 { return -v })
+		}
+		return mapSeries(operand, func(v float64) float64 {
+			if math.IsNaN(v) {
+				return v
+			}
+			return boolValue(v == 0)
+		})
+
+	case binaryNode:
+		left := evaluateNode(n.left, frames)
+		right := evaluateNode(n.right, frames)
+		result := make([]float64, len(data))
+		for i := range result {
+			result[i] = applyBinary(n.op, left[i], right[i])
+		}
+		return result
+	}
+	return nanSlice(len(data))
+}
+
+func applyBinary(op string, a, b float64) float64 {
+	switch op {
+	case "+":
+		return a + b
+	case "-":
+		return a - b
+	case "*":
+		return a * b
+	case "/":
+		if b == 0 {
+			return math.NaN()
+		}
+		return a / b
+	}
+
+	if math.IsNaN(a) || math.IsNaN(b) {
+		return math.NaN()
+	}
+	switch op {
+	case "<":
+		return boolValue(a < b)
+	case "<=":
+		return boolValue(a <= b)
+	case ">":
+		return boolValue(a > b)
+	case ">=":
+		return boolValue(a >= b)
+	case "==":
+		return boolValue(a == b)
+	case "!=":
+		return boolValue(a != b)
+	case "and":
+		return boolValue(a != 0 && b != 0)
+	case "or":
+		return boolValue(a != 0 || b != 0)
+	}
+	return math.NaN()
+}
+
+// loadStudies reads a JSON object of study names to expressions, e.g.
+// {"trend": "ema(close, 20) > ema(close, 50)"}, and compiles them for candles
+// of the given interval.
+func loadStudies(path string, interval string) (map[string]*Study, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var sources map[string]string
+	if err := json.Unmarshal(data, &sources); err != nil {
+		return nil, err
+	}
+
+	studies := make(map[string]*Study, len(sources))
+	for name, src := range sources {
+		study, err := CompileStudy(src)
+		if err == nil {
+			err = study.ValidateInterval(interval)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("study %s: %w", name, err)
+		}
+		studies[name] = study
+	}
+	return studies, nil
+}
+
+// publishStudies evaluates every study over each symbol's cached candles and
+// stores the resulting Series under StudyKeyPrefix+name+":"+symbol.
+func publishStudies(cache *Cache, symbols []string, studies map[string]*Study) {
+	for _, symbol := range symbols {
+		candles, err := cache.GetCandlesticks(symbol)
+		if err != nil {
+			log.Printf("Error reading candles for symbol %s: %v\n", symbol, err)
+			continue
+		}
+		for name, study := range studies {
+			key := StudyKeyPrefix + name + ":" + symbol
+			if err := cache.Put(key, study.EvaluateSeries(candles), 0); err != nil {
+				log.Printf("Error publishing study %s for symbol %s: %v\n", name, symbol, err)
+			}
+		}
+	}
+}
+
+func studyRoutine(cache *Cache, symbols []string, studies map[string]*Study, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for ; true; <-ticker.C {
+		publishStudies(cache, symbols, studies)
+	}
+}
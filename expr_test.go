@@ -0,0 +1,191 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestStudyOperators(t *testing.T) {
+	data := minuteCandles(time.Unix(1700000040, 0), 3)
+	tests := []struct {
+		expr string
+		want float64
+	}{
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"10 - 2 - 3", 5},
+		{"12 / 3 / 2", 2},
+		{"-2 * 3", -6},
+		{"-(1 + 2)", -3},
+		{"1 + 2 > 2", 1},
+		{"1 < 2 and 2 < 1", 0},
+		{"1 < 2 && 2 < 1 || 1", 1},
+		{"not 1 < 2 or 1", 1},
+		{"!0", 1},
+		{"2 != 2", 0},
+		{"max(1, 3, 2) - min(4, 2)", 1},
+		{"abs(-2.5)", 2.5},
+		{"1 / 0", math.NaN()},
+	}
+	for _, tt := range tests {
+		study, err := CompileStudy(tt.expr)
+		if err != nil {
+			t.Errorf("CompileStudy(%s): %v", tt.expr, err)
+			continue
+		}
+		for i, got := range study.Evaluate(data) {
+			if math.IsNaN(tt.want) != math.IsNaN(got) || !math.IsNaN(got) && got != tt.want {
+				t.Errorf("%s = %v at %d, want %v", tt.expr, got, i, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestStudyErrors(t *testing.T) {
+	for _, expr := range []string{
+		// Syntax
+		"",
+		"1 +",
+		"ema(close, 20",
+		"(close",
+		`tf("1h, close)`,
+		"1 $ 2",
+		"close 1",
+		"macd.",
+		// Validation
+		"foo",
+		"foo(close)",
+		"abs(1, 2)",
+		"max()",
+		"prev(close)",
+		"macd.nope",
+		"close.line",
+		`"ema"`,
+		"and",
+		"ema(close, 0)",
+		"ema(close, 2.5)",
+		"ema(close, 20000)",
+		"ichimoku(9, 26, 52, 10000000000)",
+		"ema(close, 20, 30)",
+		"ema(close, close)",
+		"tf(1, close)",
+		`tf("1x", close)`,
+		`tf("1h")`,
+		`tf("1h", foo)`,
+	} {
+		if _, err := CompileStudy(expr); err == nil {
+			t.Errorf("CompileStudy(%q) succeeded", expr)
+		}
+	}
+}
+
+func TestStudyEvaluate(t *testing.T) {
+	data := loadOHLCV(t)
+	close := closePrices(data)
+
+	diff := nanSlice(len(close))
+	for i := 1; i < len(close); i++ {
+		diff[i] = close[i] - close[i-1]
+	}
+	_, signal, _ := calculateMACD(close, 12, 26, 9)
+	spread := make([]float64, len(close))
+	fast, slow := calculateEMA(close, 5), calculateSMA(close, 10)
+	for i := range spread {
+		spread[i] = fast[i] - slow[i]
+	}
+
+	tests := []struct {
+		expr string
+		want []float64
+	}{
+		{"close", close},
+		{"close - prev(close, 1)", diff},
+		// Offsets beyond the series, however large, are undefined
+		{"prev(close, 9300000000000000000)", nanSlice(len(close))},
+		{"prev(close, -1)", nanSlice(len(close))},
+		{"ema(close, 5) - sma(close, 10)", spread},
+		// A bare indicator takes its defaults and close
+		{"rsi", calculateRSI(close, 14)},
+		{"macd.signal", signal},
+		{"ema(hl2, 3)", calculateEMA(mapCandles(data, func(c Candlestick) float64 { return (c.High + c.Low) / 2 }), 3)},
+	}
+	for _, tt := range tests {
+		study, err := CompileStudy(tt.expr)
+		if err != nil {
+			t.Fatalf("CompileStudy(%s): %v", tt.expr, err)
+		}
+		assertSeries(t, tt.expr, study.Evaluate(data), tt.want)
+	}
+
+	// Comparisons stay undefined during the warm-up
+	study, err := CompileStudy("sma(close, 5) > 0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := study.Evaluate(data)
+	for i := 0; i < 4; i++ {
+		if !math.IsNaN(values[i]) {
+			t.Errorf("value %d is %v during the warm-up", i, values[i])
+		}
+	}
+	if values[4] != 1 {
+		t.Errorf("value 4 = %v, want 1", values[4])
+	}
+
+	// Crossovers fire on the bar of the cross only
+	study, err = CompileStudy("crossover(close, prev(close, 1))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	values = study.Evaluate(data)
+	for i := 2; i < len(data); i++ {
+		want := boolValue(close[i-1] <= close[i-2] && close[i] > close[i-1])
+		if values[i] != want {
+			t.Fatalf("crossover at %d = %v, want %v", i, values[i], want)
+		}
+	}
+}
+
+func mapCandles(data []Candlestick, fn func(Candlestick) float64) []float64 {
+	values := make([]float64, len(data))
+	for i, candle := range data {
+		values[i] = fn(candle)
+	}
+	return values
+}
+
+func TestPublishStudies(t *testing.T) {
+	cache := newTestCache(t)
+	data := minuteCandles(time.Unix(1700000040, 0), 30)
+	if err := cache.SetCandlesticks("BTCUSDT", data); err != nil {
+		t.Fatal(err)
+	}
+	study, err := CompileStudy("sma(close, 10)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		publishStudies(cache, []string{"BTCUSDT"}, map[string]*Study{"trend": study})
+	}
+
+	var series Series
+	key := StudyKeyPrefix + "trend:BTCUSDT"
+	if found, err := cache.Get(key, &series); err != nil || !found {
+		t.Fatalf("study not published: %v", err)
+	}
+	if series.Len() != len(data) || series.Valid[8] || !series.Valid[9] {
+		t.Errorf("published series has %d values, valid from %v", series.Len(), series.Valid)
+	}
+	if _, value, _ := series.Last(); math.Abs(value-calculateSMA(closePrices(data), 10)[len(data)-1]) > 1e-9 {
+		t.Errorf("last value = %v", value)
+	}
+
+	// Each run replaces the value instead of growing a history list
+	var list []Series
+	if found, err := cache.Get(key+":list", &list); found || err != nil {
+		t.Error("publishing the study keeps a history list")
+	}
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// FakeScenario scripts what FakeBinanceServer serves. Series missing for a
+// symbol are generated deterministically from the symbol name.
+type FakeScenario struct {
+	Symbols []string
+	Candles map[string][]Candlestick
+	Trades  map[string][]Trade
+	Books   map[string]OrderBook
+
+	// StreamDelay is the pause between two stream messages.
+	StreamDelay time.Duration
+	// DisconnectAfter closes every stream connection after this many messages.
+	DisconnectAfter int
+	// SequenceGapEvery skips update IDs on every Nth depth event.
+	SequenceGapEvery int
+	// MalformedEvery replaces the price of every Nth REST row and stream
+	// message with a non-numeric value.
+	MalformedEvery int
+	// RateLimitAfter answers REST requests with 429 once this many have been
+	// served, RateLimitCount times or, when 0, from then on.
+	RateLimitAfter int
+	RateLimitCount int
+}
+
+// FakeBinanceServer is a local stand-in for the Binance REST API
+// (exchangeInfo, klines, depth, aggTrades) and the kline, aggTrade, depth and
+// partial depth WebSocket streams.
+type FakeBinanceServer struct {
+	scenario FakeScenario
+	server   *httptest.Server
+	upgrader websocket.Upgrader
+	closed   chan struct{}
+	close    sync.Once
+
+	mu          sync.Mutex
+	requests    int
+	connections map[string]int
+}
+
+func NewFakeBinanceServer(scenario FakeScenario) *FakeBinanceServer {
+	s := &FakeBinanceServer{
+		scenario:    scenario,
+		closed:      make(chan struct{}),
+		connections: make(map[string]int),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v3/exchangeInfo", s.rateLimited(s.handleExchangeInfo))
+	mux.HandleFunc("/api/v3/klines", s.rateLimited(s.handleKlines))
+	mux.HandleFunc("/api/v3/depth", s.rateLimited(s.handleDepth))
+	mux.HandleFunc("/api/v3/aggTrades", s.rateLimited(s.handleAggTrades))
+	mux.HandleFunc("/ws/", s.handleStream)
+	s.server = httptest.NewServer(mux)
+
+	return s
+}
+
+// URL returns the REST base URL, suitable for binance.Client.BaseURL.
+func (s *FakeBinanceServer) URL() string {
+	return s.server.URL
+}
+
+// WsURL returns the WebSocket base URL, suitable for binance.BaseWsMainURL.
+func (s *FakeBinanceServer) WsURL() string {
+	return "ws" + strings.TrimPrefix(s.server.URL, "http") + "/ws"
+}
+
+func (s *FakeBinanceServer) Close() {
+	s.close.Do(func() {
+		close(s.closed)
+		s.server.CloseClientConnections()
+		s.server.Close()
+	})
+}
+
+// Requests returns the number of REST requests received.
+func (s *FakeBinanceServer) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+// Connections returns the number of connections opened to a stream such as
+// "btcusdt@kline_1m".
+func (s *FakeBinanceServer) Connections(stream string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connections[stream]
+}
+
+func (s *FakeBinanceServer) candles(symbol string) []Candlestick {
+	if candles, ok := s.scenario.Candles[symbol]; ok {
+		return candles
+	}
+	return generateFakeCandles(symbol, 500, time.Unix(1700000000, 0))
+}
+
+func (s *FakeBinanceServer) trades(symbol string) []Trade {
+	if trades, ok := s.scenario.Trades[symbol]; ok {
+		return trades
+	}
+	return generateFakeTrades(s.candles(symbol))
+}
+
+func (s *FakeBinanceServer) book(symbol string) OrderBook {
+	if book, ok := s.scenario.Books[symbol]; ok {
+		return book
+	}
+	candles := s.candles(symbol)
+	return generateFakeBook(candles[len(candles)-1].Close)
+}
+
+func (s *FakeBinanceServer) malformed(n int) bool {
+	return s.scenario.MalformedEvery > 0 && n%s.scenario.MalformedEvery == s.scenario.MalformedEvery-1
+}
+
+func (s *FakeBinanceServer) price(value float64, n int) string {
+	if s.malformed(n) {
+		return "not-a-number"
+	}
+	return formatExportFloat(value)
+}
+
+func (s *FakeBinanceServer) rateLimited(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests++
+		limited := s.scenario.RateLimitAfter > 0 && s.requests > s.scenario.RateLimitAfter &&
+			(s.scenario.RateLimitCount == 0 || s.requests <= s.scenario.RateLimitAfter+s.scenario.RateLimitCount)
+		s.mu.Unlock()
+
+		if limited {
+			w.Header().Set("Retry-After", "1")
+			writeFakeJSON(w, http.StatusTooManyRequests, map[string]interface{}{
+				"code": -1003,
+				"msg":  "Too many requests; current limit is exceeded.",
+			})
+			return
+		}
+		next(w, r)
+	}
+}
+
+func writeFakeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func fakeLimit(r *http.Request, fallback int) int {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		return fallback
+	}
+	return limit
+}
+
+func (s *FakeBinanceServer) handleExchangeInfo(w http.ResponseWriter, r *http.Request) {
+	symbols := make([]map[string]interface{}, 0, len(s.scenario.Symbols))
+	for _, symbol := range s.scenario.Symbols {
+		symbols = append(symbols, map[string]interface{}{
+			"symbol":     symbol,
+			"status":     "TRADING",
+			"baseAsset":  strings.TrimSuffix(symbol, "USDT"),
+			"quoteAsset": "USDT",
+		})
+	}
+	writeFakeJSON(w, http.StatusOK, map[string]interface{}{
+		"timezone":   "UTC",
+		"serverTime": time.Now().UnixMilli(),
+		"symbols":    symbols,
+	})
+}
+
+func (s *FakeBinanceServer) handleKlines(w http.ResponseWriter, r *http.Request) {
+	candles := s.candles(r.URL.Query().Get("symbol"))
+	if limit := fakeLimit(r, 500); len(candles) > limit {
+		candles = candles[len(candles)-limit:]
+	}
+
+	rows := make([][]interface{}, 0, len(candles))
+	for i, candle := range candles {
+		rows = append(rows, []interface{}{
+			candle.OpenTime.UnixMilli(),
+			formatExportFloat(candle.Open),
+			formatExportFloat(candle.High),
+			formatExportFloat(candle.Low),
+			s.price(candle.Close, i),
+			formatExportFloat(candle.Volume),
+			candle.CloseTime.UnixMilli(),
+			formatExportFloat(candle.QuoteAssetVolume),
+			0,
+			formatExportFloat(candle.TakerBuyBaseAssetVolume),
+			formatExportFloat(candle.TakerBuyQuoteAssetVolume),
+			"0",
+		})
+	}
+	writeFakeJSON(w, http.StatusOK, rows)
+}
+
+func (s *FakeBinanceServer) fakeLevels(entries []OrderBookEntry, limit int) [][]string {
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	levels := make([][]string, 0, len(entries))
+	for i, entry := range entries {
+		levels = append(levels, []string{s.price(entry.Price, i), formatExportFloat(entry.Quantity)})
+	}
+	return levels
+}
+
+func (s *FakeBinanceServer) handleDepth(w http.ResponseWriter, r *http.Request) {
+	book := s.book(r.URL.Query().Get("symbol"))
+	limit := fakeLimit(r, 100)
+	writeFakeJSON(w, http.StatusOK, map[string]interface{}{
+		"lastUpdateId": 1,
+		"bids":         s.fakeLevels(book.Bids, limit),
+		"asks":         s.fakeLevels(book.Asks, limit),
+	})
+}
+
+func (s *FakeBinanceServer) aggTradeMessage(symbol string, trade Trade, n int) map[string]interface{} {
+	return map[string]interface{}{
+		"e": "aggTrade",
+		"E": trade.Time,
+		"s": symbol,
+		"a": trade.ID,
+		"p": s.price(trade.Price, n),
+		"q": formatExportFloat(trade.Quantity),
+		"f": trade.ID,
+		"l": trade.ID,
+		"T": trade.Time,
+		"m": trade.BuyerIsMaker,
+		"M": true,
+	}
+}
+
+func (s *FakeBinanceServer) handleAggTrades(w http.ResponseWriter, r *http.Request) {
+	symbol := r.URL.Query().Get("symbol")
+	trades := s.trades(symbol)
+	if limit := fakeLimit(r, 500); len(trades) > limit {
+		trades = trades[len(trades)-limit:]
+	}
+
+	rows := make([]map[string]interface{}, 0, len(trades))
+	for i, trade := range trades {
+		row := s.aggTradeMessage(symbol, trade, i)
+		delete(row, "e")
+		delete(row, "E")
+		delete(row, "s")
+		rows = append(rows, row)
+	}
+	writeFakeJSON(w, http.StatusOK, rows)
+}
+
+// streamMessages builds the messages of a stream such as "btcusdt@kline_1m",
+// "btcusdt@aggTrade", "btcusdt@depth" or the partial book "btcusdt@depth20".
+func (s *FakeBinanceServer) streamMessages(stream string) ([]interface{}, bool) {
+	parts := strings.SplitN(stream, "@", 2)
+	if len(parts) != 2 {
+		return nil, false
+	}
+	symbol := strings.ToUpper(parts[0])
+
+	var messages []interface{}
+	switch {
+	case strings.HasPrefix(parts[1], "kline_"):
+		interval := strings.TrimPrefix(parts[1], "kline_")
+		for i, candle := range s.candles(symbol) {
+			// Every candle is sent once in progress and once final
+			for _, final := range []bool{false, true} {
+				messages = append(messages, map[string]interface{}{
+					"e": "kline",
+					"E": candle.CloseTime.UnixMilli(),
+					"s": symbol,
+					"k": map[string]interface{}{
+						"t": candle.OpenTime.UnixMilli(),
+						"T": candle.CloseTime.UnixMilli(),
+						"s": symbol,
+						"i": interval,
+						"o": formatExportFloat(candle.Open),
+						"c": s.price(candle.Close, len(messages)),
+						"h": formatExportFloat(candle.High),
+						"l": formatExportFloat(candle.Low),
+						"v": formatExportFloat(candle.Volume),
+						"n": i,
+						"x": final,
+						"q": formatExportFloat(candle.QuoteAssetVolume),
+						"V": formatExportFloat(candle.TakerBuyBaseAssetVolume),
+						"Q": formatExportFloat(candle.TakerBuyQuoteAssetVolume),
+					},
+				})
+			}
+		}
+
+	case parts[1] == "aggTrade":
+		for i, trade := range s.trades(symbol) {
+			messages = append(messages, s.aggTradeMessage(symbol, trade, i))
+		}
+
+	case parts[1] == "depth":
+		book := s.book(symbol)
+		updateID := int64(1)
+		for i := 0; i < 100; i++ {
+			first := updateID + 1
+			if s.scenario.SequenceGapEvery > 0 && i%s.scenario.SequenceGapEvery == s.scenario.SequenceGapEvery-1 {
+				first += 10
+			}
+			updateID = first + 1
+			messages = append(messages, map[string]interface{}{
+				"e": "depthUpdate",
+				"E": time.Unix(1700000000, 0).Add(time.Duration(i) * time.Second).UnixMilli(),
+				"s": symbol,
+				"U": first,
+				"u": updateID,
+				"b": s.fakeLevels(book.Bids, 20),
+				"a": s.fakeLevels(book.Asks, 20),
+			})
+		}
+
+	case strings.HasPrefix(parts[1], "depth"):
+		levels, err := strconv.Atoi(strings.TrimPrefix(parts[1], "depth"))
+		if err != nil {
+			return nil, false
+		}
+		book := s.book(symbol)
+		updateID := int64(0)
+		for i := 0; i < 100; i++ {
+			updateID++
+			if s.scenario.SequenceGapEvery > 0 && i%s.scenario.SequenceGapEvery == s.scenario.SequenceGapEvery-1 {
+				updateID += 10
+			}
+			messages = append(messages, map[string]interface{}{
+				"lastUpdateId": updateID,
+				"bids":         s.fakeLevels(book.Bids, levels),
+				"asks":         s.fakeLevels(book.Asks, levels),
+			})
+		}
+
+	default:
+		return nil, false
+	}
+
+	return messages, true
+}
+
+func (s *FakeBinanceServer) handleStream(w http.ResponseWriter, r *http.Request) {
+	stream := strings.TrimPrefix(r.URL.Path, "/ws/")
+	messages, ok := s.streamMessages(stream)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	s.mu.Lock()
+	s.connections[stream]++
+	s.mu.Unlock()
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	for i, message := range messages {
+		if s.scenario.DisconnectAfter > 0 && i >= s.scenario.DisconnectAfter {
+			return
+		}
+		if err := conn.WriteJSON(message); err != nil {
+			return
+		}
+		if s.scenario.StreamDelay > 0 {
+			select {
+			case <-s.closed:
+				return
+			case <-time.After(s.scenario.StreamDelay):
+			}
+		}
+	}
+
+	// Keep the connection open like an idle stream until the client or the
+	// server goes away
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				conn.Close()
+				return
+			}
+		}
+	}()
+	<-s.closed
+}
+
+func fakeSeed(symbol string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(symbol))
+	return int64(h.Sum64())
+}
+
+// generateFakeCandles returns count one-minute candles following a random walk
+// seeded by symbol, so every run serves the same data.
+func generateFakeCandles(symbol string, count int, start time.Time) []Candlestick {
+	rng := rand.New(rand.NewSource(fakeSeed(symbol)))
+	price := 10 + rng.Float64()*1000
+
+	candles := make([]Candlestick, 0, count)
+	for i := 0; i < count; i++ {
+		open := price
+		price *= 1 + (rng.Float64()-0.5)*0.01
+		high := open
+		if price > high {
+			high = price
+		}
+		low := open
+		if price < low {
+			low = price
+		}
+		high *= 1 + rng.Float64()*0.002
+		low *= 1 - rng.Float64()*0.002
+		volume := 1 + rng.Float64()*100
+		takerBuy := volume * rng.Float64()
+
+		openTime := start.Add(time.Duration(i) * time.Minute)
+		candles = append(candles, Candlestick{
+			OpenTime:                 openTime,
+			Open:                     open,
+			High:                     high,
+			Low:                      low,
+			Close:                    price,
+			Volume:                   volume,
+			CloseTime:                openTime.Add(time.Minute - time.Millisecond),
+			QuoteAssetVolume:         volume * (open + price) / 2,
+			TakerBuyBaseAssetVolume:  takerBuy,
+			TakerBuyQuoteAssetVolume: takerBuy * (open + price) / 2,
+		})
+	}
+	return candles
+}
+
+// generateFakeTrades returns one buy and one sell trade per candle.
+func generateFakeTrades(candles []Candlestick) []Trade {
+	trades := make([]Trade, 0, 2*len(candles))
+	for i, candle := range candles {
+		openMs := candle.OpenTime.UnixMilli()
+		trades = append(trades,
+			Trade{ID: int64(2 * i), Price: candle.Open, Quantity: candle.TakerBuyBaseAssetVolume, BuyerIsMaker: false, Time: openMs},
+			Trade{ID: int64(2*i + 1), Price: candle.Close, Quantity: candle.Volume - candle.TakerBuyBaseAssetVolume, BuyerIsMaker: true, Time: openMs + 30000},
+		)
+	}
+	return trades
+}
+
+func generateFakeBook(mid float64) OrderBook {
+	var book OrderBook
+	for i := 1; i <= 100; i++ {
+		step := mid * 0.0001 * float64(i)
+		book.Bids = append(book.Bids, OrderBookEntry{Price: mid - step, Quantity: float64(i)})
+		book.Asks = append(book.Asks, OrderBookEntry{Price: mid + step, Quantity: float64(i)})
+	}
+	return book
+}
@@ -2,29 +2,57 @@ package main
 
 import "math"
 
+// Indicators return a slice of the same length as their input, where the value
+// at index i belongs to the input bar at index i. Bars without enough history
+// (the warm-up period) are NaN. Leading NaNs in the input are skipped, so
+// indicators can be chained, e.g. an EMA of a MACD line.
+
+// nanSlice returns a slice of length n filled with NaN.
+func nanSlice(n int) []float64 {
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = math.NaN()
+	}
+	return values
+}
+
+// firstValid returns the index of the first non-NaN value in data, or
+// len(data) if there is none.
+func firstValid(data []float64) int {
+	for i, value := range data {
+		if !math.IsNaN(value) {
+			return i
+		}
+	}
+	return len(data)
+}
+
 func calculateSMA(data []float64, window int) []float64 {
-	if len(data) < window {
+	start := firstValid(data)
+	if window <= 0 || len(data)-start < window {
 		return nil
 	}
-	sma := make([]float64, len(data))
+	sma := nanSlice(len(data))
 	sum := 0.0
-	for i := 0; i < window; i++ {
+	for i := start; i < start+window; i++ {
 		sum += data[i]
 	}
-	sma[window-1] = sum / float64(window)
+	sma[start+window-1] = sum / float64(window)
 
-	for i := window; i < len(data); i++ {
+	for i := start + window; i < len(data); i++ {
 		sum += data[i] - data[i-window]
 		sma[i] = sum / float64(window)
 	}
 	return sma
 }
 
+// calculateRSI uses Wilder's smoothing. A window without losses is 100, a
+// window without any change is 50.
 func calculateRSI(data []float64, window int) []float64 {
-	if len(data) < window+1 {
+	if window <= 0 || len(data) < window+1 {
 		return nil
 	}
-	rsi := make([]float64, len(data))
+	rsi := nanSlice(len(data))
 
 	gain, loss := 0.0, 0.0
 	for i := 1; i <= window; i++ {
@@ -38,98 +66,160 @@ func calculateRSI(data []float64, window int) []float64 {
 
 	avgGain := gain / float64(window)
 	avgLoss := loss / float64(window)
-
-	rs := avgGain / avgLoss
-	rsi[window] = 100 - (100 / (1 + rs))
+	rsi[window] = relativeStrengthIndex(avgGain, avgLoss)
 
 	for i := window + 1; i < len(data); i++ {
 		change := data[i] - data[i-1]
+		gain, loss := 0.0, 0.0
 		if change > 0 {
-			avgGain = (avgGain*(float64(window)-1) + change) / float64(window)
+			gain = change
 		} else {
-			avgLoss = (avgLoss*(float64(window)-1) - change) / float64(window)
+			loss = -change
 		}
-		rs = avgGain / avgLoss
-		rsi[i] = 100 - (100 / (1 + rs))
+		avgGain = (avgGain*(float64(window)-1) + gain) / float64(window)
+		avgLoss = (avgLoss*(float64(window)-1) + loss) / float64(window)
+		rsi[i] = relativeStrengthIndex(avgGain, avgLoss)
 	}
 
 	return rsi
 }
 
+func relativeStrengthIndex(avgGain, avgLoss float64) float64 {
+	if avgLoss == 0 {
+		if avgGain == 0 {
+			return 50
+		}
+		return 100
+	}
+	rs := avgGain / avgLoss
+	return 100 - (100 / (1 + rs))
+}
+
+// calculateReturns returns the percentage change over window bars. Changes
+// from a zero price are undefined and NaN.
 func calculateReturns(data []float64, window int) []float64 {
-	if len(data) < window {
+	start := firstValid(data)
+	if window <= 0 || len(data)-start <= window {
 		return nil
 	}
-	returns := make([]float64, len(data))
-	for i := 1; i < len(data); i++ {
-		returns[i] = (data[i] - data[i-1]) / data[i-1] * 100
+	returns := nanSlice(len(data))
+	for i := start + window; i < len(data); i++ {
+		if base := data[i-window]; base != 0 {
+			returns[i] = (data[i] - base) / base * 100
+		}
 	}
 	return returns
 }
 
 func calculateEMA(data []float64, window int) []float64 {
-	if len(data) < window {
+	start := firstValid(data)
+	if window <= 0 || len(data)-start < window {
 		return nil
 	}
-	ema := make([]float64, len(data))
+	ema := nanSlice(len(data))
 	multiplier := 2 / (float64(window) + 1)
 	sma := calculateSMA(data, window)
-	ema[window-1] = sma[window-1]
+	ema[start+window-1] = sma[start+window-1]
 
-	for i := window; i < len(data); i++ {
+	for i := start + window; i < len(data); i++ {
 		ema[i] = (data[i]-ema[i-1])*multiplier + ema[i-1]
 	}
 	return ema
 }
 
+// MACDOptions sets the MACD windows and the moving averages of the MACD line
+// and of the signal line.
+type MACDOptions struct {
+	ShortWindow  int
+	LongWindow   int
+	SignalWindow int
+	MAType       MAType
+	SignalMAType MAType
+}
+
+func defaultMACDOptions() MACDOptions {
+	return MACDOptions{ShortWindow: 12, LongWindow: 26, SignalWindow: 9, MAType: ExponentialMA, SignalMAType: ExponentialMA}
+}
+
 func calculateMACD(data []float64, shortWindow, longWindow, signalWindow int) ([]float64, []float64, []float64) {
-	if len(data) < longWindow+signalWindow {
+	opts := defaultMACDOptions()
+	opts.ShortWindow, opts.LongWindow, opts.SignalWindow = shortWindow, longWindow, signalWindow
+	return calculateMACDWithOptions(data, opts)
+}
+
+func calculateMACDWithOptions(data []float64, opts MACDOptions) ([]float64, []float64, []float64) {
+	if len(data) < opts.LongWindow+opts.SignalWindow-1 {
+		return nil, nil, nil
+	}
+	shortMA := calculateMA(data, opts.MAType, opts.ShortWindow)
+	longMA := calculateMA(data, opts.MAType, opts.LongWindow)
+	if shortMA == nil || longMA == nil {
 		return nil, nil, nil
 	}
-	shortEMA := calculateEMA(data, shortWindow)
-	longEMA := calculateEMA(data, longWindow)
 	macdLine := make([]float64, len(data))
-	for i := longWindow; i < len(data); i++ {
-		macdLine[i] = shortEMA[i] - longEMA[i]
+	for i := range data {
+		macdLine[i] = shortMA[i] - longMA[i]
+	}
+	signalLine := calculateMA(macdLine, opts.SignalMAType, opts.SignalWindow)
+	if signalLine == nil {
+		return nil, nil, nil
 	}
-	signalLine := calculateEMA(macdLine, signalWindow)
 	histogram := make([]float64, len(data))
-	for i := signalWindow; i < len(data); i++ {
+	for i := range data {
 		histogram[i] = macdLine[i] - signalLine[i]
 	}
 	return macdLine, signalLine, histogram
 }
 
+// BollingerOptions sets the window and moving average of the middle band and
+// the width of the bands in standard deviations around it.
+type BollingerOptions struct {
+	Window    int
+	NumStdDev float64
+	MAType    MAType
+}
+
+func defaultBollingerOptions() BollingerOptions {
+	return BollingerOptions{Window: 20, NumStdDev: 2, MAType: SimpleMA}
+}
+
 func calculateBollingerBands(data []float64, window int, numStdDev float64) ([]float64, []float64, []float64) {
-	if len(data) < window {
+	opts := defaultBollingerOptions()
+	opts.Window, opts.NumStdDev = window, numStdDev
+	return calculateBollingerBandsWithOptions(data, opts)
+}
+
+func calculateBollingerBandsWithOptions(data []float64, opts BollingerOptions) ([]float64, []float64, []float64) {
+	window := opts.Window
+	middle := calculateMA(data, opts.MAType, window)
+	if middle == nil {
 		return nil, nil, nil
 	}
 
-	sma := calculateSMA(data, window)
-	upperBand := make([]float64, len(data))
-	lowerBand := make([]float64, len(data))
+	upperBand := nanSlice(len(data))
+	lowerBand := nanSlice(len(data))
 
-	for i := window - 1; i < len(data); i++ {
+	for i := firstValid(middle); i < len(data); i++ {
 		sumOfSquaredDeviations := 0.0
 		for j := 0; j < window; j++ {
-			deviation := data[i-j] - sma[i]
+			deviation := data[i-j] - middle[i]
 			sumOfSquaredDeviations += deviation * deviation
 		}
 		variance := sumOfSquaredDeviations / float64(window)
 		stdDev := math.Sqrt(variance)
 
-		upperBand[i] = sma[i] + numStdDev*stdDev
-		lowerBand[i] = sma[i] - numStdDev*stdDev
+		upperBand[i] = middle[i] + opts.NumStdDev*stdDev
+		lowerBand[i] = middle[i] - opts.NumStdDev*stdDev
 	}
 
-	return upperBand, sma, lowerBand
+	return upperBand, middle, lowerBand
 }
 
 func calculateVolumeWeightedMovingAverage(data, volume []float64, window int) []float64 {
-	if len(data) < window || len(volume) < window {
+	if window <= 0 || len(data) < window || len(volume) != len(data) {
 		return nil
 	}
-	vwma := make([]float64, len(data))
+	vwma := nanSlice(len(data))
 	vwSum := 0.0
 	vSum := 0.0
 
@@ -137,21 +227,25 @@ func calculateVolumeWeightedMovingAverage(data, volume []float64, window int) []
 		vwSum += data[i] * volume[i]
 		vSum += volume[i]
 	}
-	vwma[window-1] = vwSum / vSum
+	if vSum != 0 {
+		vwma[window-1] = vwSum / vSum
+	}
 
 	for i := window; i < len(data); i++ {
 		vwSum += (data[i] * volume[i]) - (data[i-window] * volume[i-window])
 		vSum += volume[i] - volume[i-window]
-		vwma[i] = vwSum / vSum
+		if vSum != 0 {
+			vwma[i] = vwSum / vSum
+		}
 	}
 	return vwma
 }
 
 func calculateMomentum(data []float64, window int) []float64 {
-	if len(data) < window {
+	if window <= 0 || len(data) <= window {
 		return nil
 	}
-	momentum := make([]float64, len(data))
+	momentum := nanSlice(len(data))
 	for i := window; i < len(data); i++ {
 		momentum[i] = data[i] - data[i-window]
 	}
@@ -159,16 +253,17 @@ func calculateMomentum(data []float64, window int) []float64 {
 }
 
 func calculateWMA(data []float64, window int) []float64 {
-	if len(data) < window {
+	start := firstValid(data)
+	if window <= 0 || len(data)-start < window {
 		return nil
 	}
-	wma := make([]float64, len(data)-window+1)
+	wma := nanSlice(len(data))
 	denominator := float64(window * (window + 1) / 2)
 
-	for i := 0; i < len(data)-window+1; i++ {
+	for i := start + window - 1; i < len(data); i++ {
 		numerator := 0.0
 		for j := 0; j < window; j++ {
-			numerator += data[i+j] * float64(j+1)
+			numerator += data[i-window+1+j] * float64(j+1)
 		}
 		wma[i] = numerator / denominator
 	}
@@ -176,56 +271,70 @@ func calculateWMA(data []float64, window int) []float64 {
 }
 
 func calculateHMA(data []float64, window int) []float64 {
-	if len(data) < window {
+	if window < 2 || len(data)-firstValid(data) < window {
 		return nil
 	}
-	hma := make([]float64, len(data))
 
 	wmaHalf := calculateWMA(data, window/2)
 	wmaFull := calculateWMA(data, window)
 
 	points := make([]float64, len(data))
-	for i := 0; i < len(data); i++ {
+	for i := range data {
 		points[i] = 2*wmaHalf[i] - wmaFull[i]
 	}
 
-	hma = calculateWMA(points, int(math.Sqrt(float64(window))))
-	return hma
+	return calculateWMA(points, int(math.Sqrt(float64(window))))
 }
 
-func calculateATR(high, low, close []float64, window int) []float64 {
-	if len(high) < window || len(low) < window || len(close) < window {
-		return nil
-	}
-	atr := make([]float64, len(high))
-	tr := make([]float64, len(high))
-
+// trueRange returns the true range of every bar. The first bar has no previous
+// close and is NaN.
+func trueRange(high, low, close []float64) []float64 {
+	tr := nanSlice(len(high))
 	for i := 1; i < len(high); i++ {
 		tr1 := high[i] - low[i]
 		tr2 := math.Abs(high[i] - close[i-1])
 		tr3 := math.Abs(low[i] - close[i-1])
 		tr[i] = math.Max(tr1, math.Max(tr2, tr3))
 	}
+	return tr
+}
 
-	atr[window-1] = calculateSMA(tr, window)[window-1]
-	k := 1.0 / float64(window)
+// ATROptions sets the window and the moving average of the true range.
+type ATROptions struct {
+	Window int
+	MAType MAType
+}
 
-	for i := window; i < len(high); i++ {
-		atr[i] = (1-k)*atr[i-1] + k*tr[i]
-	}
+func defaultATROptions() ATROptions {
+	return ATROptions{Window: 14, MAType: WilderMA}
+}
 
-	return atr
+// calculateATR uses Wilder's smoothing seeded with the mean of the first window
+// true ranges, so the first value is at index window.
+func calculateATR(high, low, close []float64, window int) []float64 {
+	opts := defaultATROptions()
+	opts.Window = window
+	return calculateATRWithOptions(high, low, close, opts)
+}
+
+// calculateATRWithOptions smooths the true range, which starts at the second
+// bar.
+func calculateATRWithOptions(high, low, close []float64, opts ATROptions) []float64 {
+	if opts.Window <= 0 || len(high) <= opts.Window || len(low) != len(high) || len(close) != len(high) {
+		return nil
+	}
+	return calculateMA(trueRange(high, low, close), opts.MAType, opts.Window)
 }
 
 func calculateChaikinVolatility(highs, lows []float64, window int) []float64 {
-	if len(highs) < window || len(lows) < window {
+	if window <= 0 || len(highs) < window || len(lows) != len(highs) {
 		return nil
 	}
 
-	chaikinVolatility := make([]float64, len(highs))
-	for i := window; i < len(highs); i++ {
+	chaikinVolatility := nanSlice(len(highs))
+	for i := window - 1; i < len(highs); i++ {
 		sum := 0.0
-		for j := i - window; j < i; j++ {
+		for j := i - window + 1; j <= i; j++ {
 			sum += highs[j] - lows[j]
 		}
 		chaikinVolatility[i] = sum / float64(window)
@@ -233,15 +342,37 @@ func calculateChaikinVolatility(highs, lows []float64, window int) []float64 {
 	return chaikinVolatility
 }
 
-func calculateADX(data []Candlestick, window int) []float64 {
-	if len(data) < 2*window {
-		return nil
+// ADXOptions sets the window and moving average of TR, DM+ and DM- for the
+// directional indicators, and the window and moving average of DX for the ADX.
+type ADXOptions struct {
+	DIWindow  int
+	DIMAType  MAType
+	ADXWindow int
+	ADXMAType MAType
+}
+
+func defaultADXOptions() ADXOptions {
+	return ADXOptions{DIWindow: 14, DIMAType: WilderMA, ADXWindow: 14, ADXMAType: WilderMA}
+}
+
+// calculateDMI returns the directional indicators +DI and -DI, using
+// Wilder's smoothing for TR, DM+ and DM-. The first values are at index
+// window.
+func calculateDMI(data []Candlestick, window int) ([]float64, []float64) {
+	opts := defaultADXOptions()
+	opts.DIWindow = window
+	return calculateDMIWithOptions(data, opts)
+}
+
+func calculateDMIWithOptions(data []Candlestick, opts ADXOptions) ([]float64, []float64) {
+	if opts.DIWindow <= 0 || len(data) <= opts.DIWindow {
+		return nil, nil
 	}
 
-	adx := make([]float64, len(data))
-	dmPlus := make([]float64, len(data))
-	dmMinus := make([]float64, len(data))
-	tr := make([]float64, len(data))
+	// DM+, DM- and TR start at the second bar
+	dmPlus := nanSlice(len(data))
+	dmMinus := nanSlice(len(data))
+	tr := nanSlice(len(data))
 
 	// Calculate True Range, DM+, DM-
 	for i := 1; i < len(data); i++ {
@@ -259,33 +390,82 @@ func calculateADX(data []Candlestick, window int) []float64 {
 		}
 	}
 
-	// Calculate the smoothed values for TR, DM+ and DM-
-	smoothedTR := calculateEMA(tr[1:], window)
-	smoothedDMPlus := calculateEMA(dmPlus[1:], window)
-	smoothedDMMinus := calculateEMA(dmMinus[1:], window)
+	smoothedTR := calculateMA(tr, opts.DIMAType, opts.DIWindow)
+	smoothedDMPlus := calculateMA(dmPlus, opts.DIMAType, opts.DIWindow)
+	smoothedDMMinus := calculateMA(dmMinus, opts.DIMAType, opts.DIWindow)
+	if smoothedTR == nil || smoothedDMPlus == nil || smoothedDMMinus == nil {
+		return nil, nil
+	}
 
-	// Calculate DX
-	dx := make([]float64, len(data))
-	for i := window; i < len(data); i++ {
-		diPlus := smoothedDMPlus[i-1] / smoothedTR[i-1]
-		diMinus := smoothedDMMinus[i-1] / smoothedTR[i-1]
+	diPlus := nanSlice(len(data))
+	diMinus := nanSlice(len(data))
+	for i := firstValid(smoothedTR); i < len(data); i++ {
+		if smoothedTR[i] == 0 {
+			diPlus[i], diMinus[i] = 0, 0
+			continue
+		}
+		diPlus[i] = smoothedDMPlus[i] / smoothedTR[i] * 100
+		diMinus[i] = smoothedDMMinus[i] / smoothedTR[i] * 100
+	}
+
+	return diPlus, diMinus
+}
+
+// calculateADX is the Wilder-smoothed DX of calculateDMI, so the first value
+// is at index 2*window-1.
+func calculateADX(data []Candlestick, window int) []float64 {
+	opts := defaultADXOptions()
+	opts.DIWindow, opts.ADXWindow = window, window
+	return calculateADXWithOptions(data, opts)
+}
 
-		dx[i] = math.Abs(diPlus-diMinus) / (diPlus + diMinus) * 100
+func calculateADXWithOptions(data []Candlestick, opts ADXOptions) []float64 {
+	diPlus, diMinus := calculateDMIWithOptions(data, opts)
+	if diPlus == nil {
+		return nil
 	}
 
-	// Calculate ADX
-	adx = calculateEMA(dx[window:], window)
+	// Calculate DX
+	dx := nanSlice(len(data))
+	for i := firstValid(diPlus); i < len(data); i++ {
+		if diPlus[i]+diMinus[i] == 0 {
+			dx[i] = 0
+			continue
+		}
+		dx[i] = math.Abs(diPlus[i]-diMinus[i]) / (diPlus[i] + diMinus[i]) * 100
+	}
 
-	return adx
+	return calculateMA(dx, opts.ADXMAType, opts.ADXWindow)
 }
 
+// StochasticOptions sets the %K lookback, the smoothing of %K (1 for the fast
+// stochastic) and the window of %D, all smoothed with MAType.
+type StochasticOptions struct {
+	KWindow    int
+	KSmoothing int
+	DWindow    int
+	MAType     MAType
+}
+
+func defaultStochasticOptions() StochasticOptions {
+	return StochasticOptions{KWindow: 14, KSmoothing: 1, DWindow: 3, MAType: SimpleMA}
+}
+
+// calculateStochasticOscillator returns %K and its SMA %D over the same
+// window. %K is 0 when the window has no range.
 func calculateStochasticOscillator(data []Candlestick, window int) ([]float64, []float64) {
-	if len(data) < window {
+	opts := defaultStochasticOptions()
+	opts.KWindow, opts.DWindow = window, window
+	return calculateStochasticOscillatorWithOptions(data, opts)
+}
+
+func calculateStochasticOscillatorWithOptions(data []Candlestick, opts StochasticOptions) ([]float64, []float64) {
+	window := opts.KWindow
+	if window <= 0 || len(data) < window {
 		return nil, nil
 	}
 
-	k := make([]float64, len(data))
-	d := make([]float64, len(data))
+	k := nanSlice(len(data))
 
 	for i := window - 1; i < len(data); i++ {
 		lowest := data[i-window+1].Low
@@ -300,62 +480,110 @@ func calculateStochasticOscillator(data []Candlestick, window int) ([]float64, [
 			}
 		}
 
+		if highest == lowest {
+			k[i] = 0
+			continue
+		}
 		k[i] = ((data[i].Close - lowest) / (highest - lowest)) * 100
 	}
 
-	// Calculate the simple moving average of %K values
-	d = calculateSMA(k, window)
+	if opts.KSmoothing > 1 {
+		k = calculateMA(k, opts.MAType, opts.KSmoothing)
+		if k == nil {
+			return nil, nil
+		}
+	}
+
+	// Calculate the moving average of %K values
+	d := calculateMA(k, opts.MAType, opts.DWindow)
+	if d == nil {
+		d = nanSlice(len(data))
+	}
 
 	return k, d
 }
 
+// PSAROptions sets the acceleration factor at the start of each trend, its
+// increment at every new extreme point and its maximum.
+type PSAROptions struct {
+	AccelerationStart float64
+	AccelerationStep  float64
+	AccelerationMax   float64
+}
+
+func defaultPSAROptions() PSAROptions {
+	return PSAROptions{AccelerationStart: 0.02, AccelerationStep: 0.02, AccelerationMax: 0.2}
+}
+
+// calculateParabolicSAR follows Wilder's rules. The initial direction comes
+// from the directional movement between the first two bars and the extreme
+// point starts at the second bar's high (long) or low (short).
 func calculateParabolicSAR(high, low []float64) []float64 {
+	return calculateParabolicSARWithOptions(high, low, defaultPSAROptions())
+}
+
+func calculateParabolicSARWithOptions(high, low []float64, opts PSAROptions) []float64 {
 	length := len(high)
-	if length != len(low) {
+	if length != len(low) || length < 2 {
 		return nil
 	}
 
-	psar := make([]float64, length)
-	isLong := true
-	af := 0.02
-	ep := 0.0
-	hp := 0.0
-	lp := 0.0
+	psar := nanSlice(length)
+	af := opts.AccelerationStart
 
-	psar[0] = low[0]
+	upMove := high[1] - high[0]
+	downMove := low[0] - low[1]
+	isLong := !(downMove > upMove && downMove > 0)
 
-	for i := 1; i < length; i++ {
-		psar[i] = psar[i-1] + af*(ep-psar[i-1])
+	var sar, ep float64
+	if isLong {
+		sar = low[0]
+		ep = high[1]
+	} else {
+		sar = high[0]
+		ep = low[1]
+	}
+	prevHigh, prevLow := high[0], low[0]
 
+	for i := 1; i < length; i++ {
 		if isLong {
-			if high[i] > hp {
-				hp = high[i]
-				af = math.Min(af+0.02, 0.2)
-			}
-			if low[i] <= psar[i] {
+			if low[i] <= sar {
+				// Reverse to short, the SAR jumps to the extreme point
 				isLong = false
-				af = 0.02
+				sar = math.Max(ep, math.Max(prevHigh, high[i]))
+				psar[i] = sar
+
+				af = opts.AccelerationStart
 				ep = low[i]
-				lp = low[i]
-				psar[i] = hp
+				sar = math.Max(sar+af*(ep-sar), math.Max(prevHigh, high[i]))
 			} else {
-				ep = hp
+				psar[i] = sar
+				if high[i] > ep {
+					ep = high[i]
+					af = math.Min(af+opts.AccelerationStep, opts.AccelerationMax)
+				}
+				sar = math.Min(sar+af*(ep-sar), math.Min(prevLow, low[i]))
 			}
 		} else {
-			if low[i] < lp {
-				lp = low[i]
-				af = math.Min(af+0.02, 0.2)
-			}
-			if high[i] >= psar[i] {
+			if high[i] >= sar {
+				// Reverse to long, the SAR jumps to the extreme point
 				isLong = true
-				af = 0.02
+				sar = math.Min(ep, math.Min(prevLow, low[i]))
+				psar[i] = sar
+
+				af = opts.AccelerationStart
 				ep = high[i]
-				hp = high[i]
-				psar[i] = lp
+				sar = math.Min(sar+af*(ep-sar), math.Min(prevLow, low[i]))
 			} else {
-				ep = lp
+				psar[i] = sar
+				if low[i] < ep {
+					ep = low[i]
+					af = math.Min(af+opts.AccelerationStep, opts.AccelerationMax)
+				}
+				sar = math.Max(sar+af*(ep-sar), math.Max(prevHigh, high[i]))
 			}
 		}
+		prevHigh, prevLow = high[i], low[i]
 	}
 	return psar
 }
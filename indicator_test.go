@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/csv"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// The reference values in testdata are produced by testdata/reference.py, an
+// independent implementation of the published definitions, with the values
+// TA-Lib computes the same way regenerated by testdata/reference_talib.py.
+
+// loadOHLCV reads the candles of testdata/ohlcv.csv.
+func loadOHLCV(t *testing.T) []Candlestick {
+	t.Helper()
+	columns := loadReference(t, "ohlcv.csv")
+	candles := make([]Candlestick, len(columns["time"]))
+	for i := range candles {
+		openTime := time.Unix(int64(columns["time"][i]), 0)
+		candles[i] = Candlestick{
+			OpenTime:  openTime,
+			CloseTime: openTime.Add(time.Minute - time.Second),
+			Open:      columns["open"][i],
+			High:      columns["high"][i],
+			Low:       columns["low"][i],
+			Close:     columns["close"][i],
+			Volume:    columns["volume"][i],
+		}
+	}
+	return candles
+}
+
+// loadReference reads a CSV file of testdata into columns by header name.
+// Empty cells are NaN.
+func loadReference(t *testing.T, name string) map[string][]float64 {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("reading %s: %v", name, err)
+	}
+	columns := make(map[string][]float64)
+	for _, record := range records[1:] {
+		for j, cell := range record {
+			value := math.NaN()
+			if cell != "" {
+				if value, err = strconv.ParseFloat(cell, 64); err != nil {
+					t.Fatalf("reading %s: %v", name, err)
+				}
+			}
+			columns[records[0][j]] = append(columns[records[0][j]], value)
+		}
+	}
+	return columns
+}
+
+// assertSeries fails unless got matches want bar by bar, NaN included.
+func assertSeries(t *testing.T, name string, got, want []float64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s: got %d values, want %d", name, len(got), len(want))
+		return
+	}
+	for i := range want {
+		if math.IsNaN(want[i]) != math.IsNaN(got[i]) ||
+			math.Abs(got[i]-want[i]) > 1e-9*math.Max(1, math.Abs(want[i])) {
+			t.Errorf("%s[%d] = %v, want %v", name, i, got[i], want[i])
+			return
+		}
+	}
+}
+
+func TestIndicatorsReference(t *testing.T) {
+	data := loadOHLCV(t)
+	want := loadReference(t, "indicators.csv")
+	high, low, close := mustCandleSource(data, "high"), mustCandleSource(data, "low"), closePrices(data)
+
+	macdLine, macdSignal, macdHist := calculateMACD(close, 12, 26, 9)
+	bbUpper, bbMiddle, bbLower := calculateBollingerBands(close, 20, 2)
+	diPlus, diMinus := calculateDMI(data, 14)
+	stochK, stochD := calculateStochasticOscillatorWithOptions(data, StochasticOptions{KWindow: 14, KSmoothing: 1, DWindow: 3, MAType: SimpleMA})
+
+	tests := []struct {
+		name string
+		got  []float64
+	}{
+		{"sma20", calculateSMA(close, 20)},
+		{"ema20", calculateEMA(close, 20)},
+		{"wma20", calculateWMA(close, 20)},
+		{"hma20", calculateHMA(close, 20)},
+		{"rsi14", calculateRSI(close, 14)},
+		{"macd_line", macdLine},
+		{"macd_signal", macdSignal},
+		{"macd_hist", macdHist},
+		{"bb_upper", bbUpper},
+		{"bb_middle", bbMiddle},
+		{"bb_lower", bbLower},
+		{"atr14", calculateATR(high, low, close, 14)},
+		{"di_plus14", diPlus},
+		{"di_minus14", diMinus},
+		{"adx14", calculateADX(data, 14)},
+		{"stoch_k14", stochK},
+		{"stoch_d3", stochD},
+		{"sar", calculateParabolicSAR(high, low)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertSeries(t, tt.name, tt.got, want[tt.name])
+		})
+	}
+}
+
+func TestRSIEdgeCases(t *testing.T) {
+	rising := []float64{1, 2, 3, 4, 5, 6}
+	flat := []float64{5, 5, 5, 5, 5, 5}
+	nan := math.NaN()
+
+	assertSeries(t, "rising", calculateRSI(rising, 3), []float64{nan, nan, nan, 100, 100, 100})
+	assertSeries(t, "flat", calculateRSI(flat, 3), []float64{nan, nan, nan, 50, 50, 50})
+	if calculateRSI(rising, 6) != nil {
+		t.Error("expected nil without window+1 values")
+	}
+}
+
+func TestFlatInput(t *testing.T) {
+	flat := make([]Candlestick, 40)
+	for i := range flat {
+		flat[i] = Candlestick{Open: 10, High: 10, Low: 10, Close: 10}
+	}
+	high, low, close := mustCandleSource(flat, "high"), mustCandleSource(flat, "low"), closePrices(flat)
+
+	constant := func(name string, values []float64, want float64) {
+		t.Helper()
+		start := firstValid(values)
+		if start == len(values) {
+			t.Errorf("%s: no values", name)
+			return
+		}
+		for i := start; i < len(values); i++ {
+			if values[i] != want {
+				t.Errorf("%s[%d] = %v, want %v", name, i, values[i], want)
+				return
+			}
+		}
+	}
+
+	upper, middle, lower := calculateBollingerBands(close, 20, 2)
+	constant("bb_upper", upper, 10)
+	constant("bb_middle", middle, 10)
+	constant("bb_lower", lower, 10)
+	constant("ema", calculateEMA(close, 10), 10)
+	constant("hma", calculateHMA(close, 10), 10)
+	constant("atr", calculateATR(high, low, close, 14), 0)
+	constant("adx", calculateADX(flat, 14), 0)
+	k, _ := calculateStochasticOscillator(flat, 14)
+	constant("stoch_k", k, 0)
+	constant("returns", calculateReturns(close, 1), 0)
+}
+
+func TestLeadingNaNs(t *testing.T) {
+	data := closePrices(loadOHLCV(t))
+	padded := append(nanSlice(5), data...)
+
+	tests := []struct {
+		name      string
+		calculate func([]float64) []float64
+	}{
+		{"sma", func(x []float64) []float64 { return calculateSMA(x, 20) }},
+		{"ema", func(x []float64) []float64 { return calculateEMA(x, 20) }},
+		{"wma", func(x []float64) []float64 { return calculateWMA(x, 20) }},
+		{"hma", func(x []float64) []float64 { return calculateHMA(x, 20) }},
+		{"rma", func(x []float64) []float64 { return calculateRMA(x, 14) }},
+		{"returns", func(x []float64) []float64 { return calculateReturns(x, 3) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Leading NaNs shift the output without changing it
+			got := tt.calculate(padded)
+			assertSeries(t, tt.name, got, append(nanSlice(5), tt.calculate(data)...))
+		})
+	}
+}
+
+func TestCalculateReturns(t *testing.T) {
+	nan := math.NaN()
+	assertSeries(t, "window 1", calculateReturns([]float64{100, 110, 99}, 1), []float64{nan, 10, -10})
+	assertSeries(t, "window 2", calculateReturns([]float64{100, 110, 120, 0, 60}, 2), []float64{nan, nan, 20, -100, -50})
+	assertSeries(t, "zero base", calculateReturns([]float64{0, 5, 10}, 1), []float64{nan, nan, 100})
+	if calculateReturns([]float64{1, 2}, 2) != nil {
+		t.Error("expected nil without window+1 values")
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+)
+
+// waitFor polls cond until it holds or the timeout expires.
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// startFakeBinance serves the scenario and points the Binance clients at it.
+// The WebSocket URL is left pointing at the closed server after the test, so
+// that nothing reconnects to Binance.
+func startFakeBinance(t *testing.T, scenario FakeScenario) (*FakeBinanceServer, *binance.Client) {
+	fake := NewFakeBinanceServer(scenario)
+	t.Cleanup(fake.Close)
+	t.Setenv("BINANCE_API_URL", fake.URL())
+	t.Setenv("BINANCE_WS_URL", fake.WsURL())
+	return fake, newBinanceClient()
+}
+
+// startStreams runs the WebSocket routines of a symbol against the fake
+// server. When the test ends the server is closed and the routines are
+// waited for, as they return once reconnecting fails.
+func startStreams(t *testing.T, fake *FakeBinanceServer, cache *Cache, store *Storage, flow *OrderFlowTracker, symbol string) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go websocketRoutine(cache, store, flow, nil, symbol, "1m", &wg)
+	go orderBookWebSocketRoutine(cache, store, symbol, &wg)
+
+	t.Cleanup(func() {
+		fake.Close()
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Minute):
+			t.Error("the WebSocket routines did not stop")
+		}
+	})
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use, to capture the log.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func captureLog(t *testing.T) *syncBuffer {
+	var buf syncBuffer
+	out := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(out) })
+	return &buf
+}
+
+func TestFakeBinanceIntegration(t *testing.T) {
+	fake, client := startFakeBinance(t, FakeScenario{Symbols: []string{"BTCUSDT", "ETHUSDT"}})
+
+	symbols, err := getAllUSDTTradingPairs(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(symbols) != 2 {
+		t.Fatalf("got symbols %v, want BTCUSDT and ETHUSDT", symbols)
+	}
+
+	// Historical candles are fetched over REST into the cache
+	newTestCache(t)
+	cache := processSymbols(client, symbols, "1m", 100, make(chan struct{}))
+	for _, symbol := range symbols {
+		candles, err := cache.GetCandlesticks(symbol)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(candles) != 100 {
+			t.Fatalf("%s has %d cached candles, want 100", symbol, len(candles))
+		}
+	}
+
+	store, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	flow := NewOrderFlowTracker(time.Minute, cache)
+	startStreams(t, fake, cache, store, flow, "BTCUSDT")
+
+	// The kline stream brings the cached candles up to the last final candle
+	last := fake.candles("BTCUSDT")[499]
+	waitFor(t, 30*time.Second, "the last candle", func() bool {
+		candles, err := cache.GetCandlesticks("BTCUSDT")
+		return err == nil && len(candles) > 0 && candles[len(candles)-1].OpenTime.Equal(last.OpenTime) && candles[len(candles)-1].Close == last.Close
+	})
+
+	// Trades reach the storage and the order flow tracker
+	trades := fake.trades("BTCUSDT")
+	from, to := time.UnixMilli(trades[0].Time), time.UnixMilli(trades[len(trades)-1].Time)
+	waitFor(t, 30*time.Second, "the trades", func() bool {
+		stored, err := store.QueryTrades("BTCUSDT", from, to)
+		return err == nil && len(stored) == len(trades)
+	})
+	if history := flow.History("BTCUSDT"); len(history) != len(fake.candles("BTCUSDT")) {
+		t.Errorf("order flow has %d bars, want one per candle", len(history))
+	}
+
+	// Order book snapshots are cached and stored
+	var snapshot OrderBookSnapshot
+	waitFor(t, 30*time.Second, "an order book", func() bool {
+		found, err := cache.Get(OrderBookKeyPrefix+"BTCUSDT", &snapshot)
+		return err == nil && found
+	})
+	if len(snapshot.Bids) != 20 || len(snapshot.Asks) != 20 {
+		t.Errorf("snapshot has %d bids and %d asks, want 20 of each", len(snapshot.Bids), len(snapshot.Asks))
+	}
+	books, err := store.QueryOrderBooks("BTCUSDT", snapshot.Time.Add(-time.Hour), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(books) == 0 {
+		t.Error("no order book snapshot stored")
+	}
+}
+
+func TestFakeBinanceDisconnect(t *testing.T) {
+	fake, client := startFakeBinance(t, FakeScenario{Symbols: []string{"DISCUSDT"}, DisconnectAfter: 10})
+	newTestCache(t)
+	cache := processSymbols(client, []string{"DISCUSDT"}, "1m", 100, make(chan struct{}))
+	flow := NewOrderFlowTracker(time.Minute, nil)
+	startStreams(t, fake, cache, nil, flow, "DISCUSDT")
+
+	// Every stream is dropped after 10 messages and reconnected after a
+	// backoff of 2 seconds
+	for _, stream := range []string{"discusdt@kline_1m", "discusdt@aggTrade", "discusdt@depth" + OrderBookDepthLevels} {
+		waitFor(t, 30*time.Second, "a reconnect to "+stream, func() bool { return fake.Connections(stream) >= 2 })
+	}
+
+	// Each connection replays the first 10 trades, which go to their 5 bars
+	if history := flow.History("DISCUSDT"); len(history) != 5 {
+		t.Errorf("order flow has %d bars after reconnecting, want 5", len(history))
+	}
+}
+
+func TestFakeBinanceRateLimit(t *testing.T) {
+	// The first klines request of each symbol is answered with 429
+	fake, client := startFakeBinance(t, FakeScenario{Symbols: []string{"RATEUSDT", "LIMITUSDT"}, RateLimitAfter: 1, RateLimitCount: 2})
+	symbols, err := getAllUSDTTradingPairs(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newTestCache(t)
+	cache := processSymbols(client, symbols, "1m", 100, make(chan struct{}))
+	for _, symbol := range symbols {
+		candles, err := cache.GetCandlesticks(symbol)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(candles) != 100 {
+			t.Errorf("%s has %d cached candles after the retry, want 100", symbol, len(candles))
+		}
+	}
+	if requests := fake.Requests(); requests != 5 {
+		t.Errorf("server got %d requests, want 5", requests)
+	}
+}
+
+func TestFakeBinanceMalformed(t *testing.T) {
+	logged := captureLog(t)
+	fake, client := startFakeBinance(t, FakeScenario{Symbols: []string{"BADUSDT"}, MalformedEvery: 7})
+
+	// Rows 6, 13, ... 97 of the 100 klines have a malformed close
+	newTestCache(t)
+	cache := processSymbols(client, []string{"BADUSDT"}, "1m", 100, make(chan struct{}))
+	candles, err := cache.GetCandlesticks("BADUSDT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candles) != 86 {
+		t.Errorf("got %d cached candles, want the 86 well-formed ones", len(candles))
+	}
+
+	store, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	startStreams(t, fake, cache, store, nil, "BADUSDT")
+
+	// Every 7th trade has a malformed price and is skipped
+	trades := fake.trades("BADUSDT")
+	want := len(trades) - len(trades)/7
+	from, to := time.UnixMilli(trades[0].Time), time.UnixMilli(trades[len(trades)-1].Time)
+	waitFor(t, 30*time.Second, "the well-formed trades", func() bool {
+		stored, err := store.QueryTrades("BADUSDT", from, to)
+		return err == nil && len(stored) == want
+	})
+
+	// The last final kline is well formed and still arrives
+	last := fake.candles("BADUSDT")[499]
+	waitFor(t, 30*time.Second, "the last candle", func() bool {
+		candles, err := cache.GetCandlesticks("BADUSDT")
+		return err == nil && len(candles) > 0 && candles[len(candles)-1].OpenTime.Equal(last.OpenTime) && candles[len(candles)-1].Close == last.Close
+	})
+
+	for _, message := range []string{"Error converting kline for symbol BADUSDT", "Error parsing trade price for symbol BADUSDT"} {
+		if !strings.Contains(logged.String(), message) {
+			t.Errorf("%q was not logged", message)
+		}
+	}
+}
+
+func TestFakeBinanceSequenceGap(t *testing.T) {
+	fake, client := startFakeBinance(t, FakeScenario{Symbols: []string{"GAPUSDT"}, SequenceGapEvery: 10})
+	newTestCache(t)
+	cache := processSymbols(client, []string{"GAPUSDT"}, "1m", 100, make(chan struct{}))
+	store, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Now()
+	startStreams(t, fake, cache, store, nil, "GAPUSDT")
+
+	// Partial depth events are whole snapshots, a gap in their update IDs
+	// loses nothing and every one of them is stored
+	waitFor(t, 30*time.Second, "the order book snapshots", func() bool {
+		books, err := store.QueryOrderBooks("GAPUSDT", from, time.Now())
+		return err == nil && len(books) == 100
+	})
+}
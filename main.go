@@ -5,8 +5,12 @@ import (
 	"fmt"
 	"github.com/adshao/go-binance/v2"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
 	"sync" // Added sync package for WaitGroup
+	"syscall"
+	"time"
 )
 
 func getAllUSDTTradingPairs(client *binance.Client) ([]string, error) {
@@ -25,26 +29,169 @@ func getAllUSDTTradingPairs(client *binance.Client) ([]string, error) {
 	return usdtSymbols, nil
 }
 
+// newBinanceClient creates the REST client from the environment.
+// BINANCE_API_URL and BINANCE_WS_URL point the REST and WebSocket clients at
+// another server, e.g. the fake server of the tests.
+func newBinanceClient() *binance.Client {
+	client := binance.NewClient(os.Getenv("BINANCE_API_KEY"), os.Getenv("BINANCE_SECRET_KEY"))
+	if url := os.Getenv("BINANCE_API_URL"); url != "" {
+		client.BaseURL = url
+	}
+	if url := os.Getenv("BINANCE_WS_URL"); url != "" {
+		binance.BaseWsMainURL = url
+	}
+	return client
+}
+
+// CandleHistoryLimit is the number of candles fetched per symbol at startup.
+// Until the kline streams have added more, it bounds the windows of the
+// statistics computed from the cache.
+const CandleHistoryLimit = 100
+
 func main() {
-	apiKey := os.Getenv("BINANCE_API_KEY")
-	secretKey := os.Getenv("BINANCE_SECRET_KEY")
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		if err := runExport(os.Args[2:]); err != nil {
+			fmt.Printf("Error exporting data: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	client := newBinanceClient()
+
+	interval := "1m"
+	limit := CandleHistoryLimit
+
+	var symbols []string
+	var cache *Cache
+	var err error
+
+	if path := os.Getenv("REPLAY_FILE"); path != "" {
+		// Replay a recording offline instead of connecting to Binance
+		speed := 1.0
+		if value := os.Getenv("REPLAY_SPEED"); value != "" {
+			speed, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				fmt.Printf("Error parsing REPLAY_SPEED: %v\n", err)
+				return
+			}
+		}
+
+		replay, err := NewReplayStreamSource(path, speed)
+		if err != nil {
+			fmt.Printf("Error opening replay file: %v\n", err)
+			return
+		}
+		symbols, err = replay.Symbols()
+		if err != nil {
+			fmt.Printf("Error reading replay symbols: %v\n", err)
+			return
+		}
+		cache, err = NewCache()
+		if err != nil {
+			fmt.Printf("Error connecting to cache: %v\n", err)
+			return
+		}
+		wsSource = replay
+	} else {
+		// Fetch all USDT trading pairs
+		symbols, err = getAllUSDTTradingPairs(client)
+		if err != nil {
+			fmt.Printf("Error fetching trading pairs: %v\n", err)
+			return
+		}
 
-	client := binance.NewClient(apiKey, secretKey)
+		// Initialize cache with historical data using REST API
+		// Retrieve historical data using REST API
+		doneFetching := make(chan struct{})
+		cache = processSymbols(client, symbols, interval, limit, doneFetching)
 
-	// Fetch all USDT trading pairs
-	symbols, err := getAllUSDTTradingPairs(client)
+		// Publish the daily and weekly pivot levels, which change once a day
+		go pivotRoutine(client, cache, symbols, time.Hour)
+	}
+
+	// Record every stream event to a compressed file for later replay
+	var recorder *StreamRecorder
+	if path := os.Getenv("RECORD_FILE"); path != "" {
+		recorder, err = NewStreamRecorder(path)
+		if err != nil {
+			fmt.Printf("Error creating recording: %v\n", err)
+			return
+		}
+		wsSource = recordingStreamSource{source: wsSource, recorder: recorder}
+	}
+
+	// Persist finalized candles, trades and order books to disk when a storage directory is configured
+	var store *Storage
+	if dir := os.Getenv("STORAGE_DIR"); dir != "" {
+		store, err = NewStorage(dir)
+		if err != nil {
+			fmt.Printf("Error opening storage: %v\n", err)
+			return
+		}
+		go compactionRoutine(store, time.Hour)
+	}
+
+	// Flush the recording and the storage partitions on shutdown
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigC
+		if recorder != nil {
+			if err := recorder.Close(); err != nil {
+				fmt.Printf("Error closing recording: %v\n", err)
+			}
+		}
+		if store != nil {
+			if err := store.Close(); err != nil {
+				fmt.Printf("Error closing storage: %v\n", err)
+			}
+		}
+		os.Exit(0)
+	}()
+
+	// Track taker buy/sell flow from the trade stream in bars of the kline interval
+	flowInterval, err := intervalDuration(interval)
 	if err != nil {
-		fmt.Printf("Error fetching trading pairs: %v\n", err)
+		fmt.Printf("Error parsing interval: %v\n", err)
 		return
 	}
+	flow := NewOrderFlowTracker(flowInterval, cache)
 
-	interval := "1m"
-	limit := 100
+	// Classify each symbol's market regime every interval
+	regimes := NewRegimeTracker(interval, defaultRegimeOptions(), cache)
+	go regimeRoutine(cache, regimes, symbols, flowInterval)
+
+	// Publish correlations, benchmark betas and cointegrated pairs every interval
+	go statsRoutine(cache, symbols, defaultStatsOptions(), flowInterval)
+
+	// Publish the market breadth of all symbols every interval
+	go breadthRoutine(cache, symbols, defaultBreadthOptions(), flowInterval)
 
-	// Initialize cache with historical data using REST API
-	// Retrieve historical data using REST API
-	doneFetching := make(chan struct{})
-	cache := processSymbols(client, symbols, interval, limit, doneFetching)
+	// Rank all symbols by their annualized volatility every interval
+	go volatilityRoutine(cache, symbols, interval, defaultVolatilityOptions(), flowInterval)
+
+	// Evaluate the configured studies over the cached candles every interval
+	if path := os.Getenv("STUDIES_FILE"); path != "" {
+		studies, err := loadStudies(path, interval)
+		if err != nil {
+			fmt.Printf("Error loading studies: %v\n", err)
+			return
+		}
+		go studyRoutine(cache, symbols, studies, flowInterval)
+	}
+
+	// Compute the configured baskets live from the trade stream
+	var indices *IndexTracker
+	if path := os.Getenv("BASKETS_FILE"); path != "" {
+		baskets, err := loadBaskets(path)
+		if err != nil {
+			fmt.Printf("Error loading baskets: %v\n", err)
+			return
+		}
+		indices = NewIndexTracker(baskets, flowInterval, cache)
+		go indexRoutine(cache, indices, baskets, symbols, flowInterval)
+	}
 
 	// Add a WaitGroup to wait for all goroutines to complete
 	var wg sync.WaitGroup
@@ -52,8 +199,8 @@ func main() {
 	// Start WebSocket routines for each symbol
 	for _, symbol := range symbols {
 		wg.Add(2) // Added 2 for each symbol for both websocketRoutine and orderBookWebSocketRoutine
-		go websocketRoutine(cache, symbol, interval, &wg)
-		go orderBookWebSocketRoutine(cache, symbol, &wg)
+		go websocketRoutine(cache, store, flow, indices, symbol, interval, &wg)
+		go orderBookWebSocketRoutine(cache, store, symbol, &wg)
 	}
 
 	// Wait for all goroutines to complete
@@ -0,0 +1,116 @@
+package main
+
+import "fmt"
+
+// MAType selects the moving average used by composite indicators.
+type MAType int
+
+const (
+	SimpleMA MAType = iota
+	ExponentialMA
+	WeightedMA
+	WilderMA
+	HullMA
+	DoubleEMA
+	TripleEMA
+	KaufmanMA
+)
+
+var maTypeNames = map[MAType]string{
+	SimpleMA:      "sma",
+	ExponentialMA: "ema",
+	WeightedMA:    "wma",
+	WilderMA:      "rma",
+	HullMA:        "hma",
+	DoubleEMA:     "dema",
+	TripleEMA:     "tema",
+	KaufmanMA:     "kama",
+}
+
+func (t MAType) String() string {
+	if name, ok := maTypeNames[t]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// parseMAType returns the MAType of a name such as "ema".
+func parseMAType(name string) (MAType, error) {
+	for maType, maName := range maTypeNames {
+		if maName == name {
+			return maType, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown moving average type %q", name)
+}
+
+// calculateMA returns the moving average of the given type. KAMA uses the
+// standard fast and slow windows of 2 and 30.
+func calculateMA(data []float64, maType MAType, window int) []float64 {
+	switch maType {
+	case SimpleMA:
+		return calculateSMA(data, window)
+	case ExponentialMA:
+		return calculateEMA(data, window)
+	case WeightedMA:
+		return calculateWMA(data, window)
+	case WilderMA:
+		return calculateRMA(data, window)
+	case HullMA:
+		return calculateHMA(data, window)
+	case DoubleEMA:
+		return calculateDEMA(data, window)
+	case TripleEMA:
+		return calculateTEMA(data, window)
+	case KaufmanMA:
+		return calculateKAMA(data, window, 2, 30)
+	}
+	return nil
+}
+
+// calculateRMA is Wilder's moving average, an EMA with alpha 1/window seeded
+// with the SMA of the first window values.
+func calculateRMA(data []float64, window int) []float64 {
+	start := firstValid(data)
+	if window <= 0 || len(data)-start < window {
+		return nil
+	}
+	rma := nanSlice(len(data))
+	rma[start+window-1] = calculateSMA(data, window)[start+window-1]
+
+	for i := start + window; i < len(data); i++ {
+		rma[i] = (rma[i-1]*float64(window-1) + data[i]) / float64(window)
+	}
+	return rma
+}
+
+// calculateDEMA is 2*EMA - EMA(EMA).
+func calculateDEMA(data []float64, window int) []float64 {
+	ema := calculateEMA(data, window)
+	emaOfEMA := calculateEMA(ema, window)
+	if emaOfEMA == nil {
+		return nil
+	}
+
+	dema := make([]float64, len(data))
+	for i := range data {
+		dema[i] = 2*ema[i] - emaOfEMA[i]
+	}
+	return dema
+}
+
+// calculateTEMA is 3*EMA - 3*EMA(EMA) + EMA(EMA(EMA)).
+func calculateTEMA(data []float64, window int) []float64 {
+	ema := calculateEMA(data, window)
+	emaOfEMA := calculateEMA(ema, window)
+	emaOfEMAOfEMA := calculateEMA(emaOfEMA, window)
+	if emaOfEMAOfEMA == nil {
+		return nil
+	}
+
+	tema := make([]float64, len(data))
+	for i := range data {
+		tema[i] = 3*ema[i] - 3*emaOfEMA[i] + emaOfEMAOfEMA[i]
+	}
+	return tema
+}
@@ -0,0 +1,63 @@
+package main
+
+import "testing"
+
+func TestParseMAType(t *testing.T) {
+	for maType, name := range maTypeNames {
+		parsed, err := parseMAType(name)
+		if err != nil || parsed != maType {
+			t.Errorf("parseMAType(%q) = %v, %v, want %v", name, parsed, err, maType)
+		}
+	}
+	if _, err := parseMAType("zlema"); err == nil {
+		t.Error("expected an error for an unknown type")
+	}
+}
+
+func TestMATypeParams(t *testing.T) {
+	data := loadOHLCV(t)
+	close := closePrices(data)
+
+	tests := []struct {
+		expr string
+		want []float64
+	}{
+		{`ma(close, 20, "wma")`, calculateWMA(close, 20)},
+		{`bb(close, 20, 2, "ema").middle`, calculateEMA(close, 20)},
+		{`keltner(20, 10, 2, "hma").middle`, calculateHMA(close, 20)},
+		{`trix(close, 15, "ema")`, calculateTRIX(close, 15)},
+		{`macd(close, 12, 26, 9, "EMA", "ema").line`, func() []float64 { line, _, _ := calculateMACD(close, 12, 26, 9); return line }()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			study, err := CompileStudy(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertSeries(t, tt.expr, study.Evaluate(data), tt.want)
+		})
+	}
+
+	// Composites follow the selected smoothing
+	wilder, _ := calculateSupertrendWithOptions(data, SupertrendOptions{ATRWindow: 10, ATRMAType: WilderMA, Multiplier: 3})
+	simple, _ := calculateSupertrendWithOptions(data, SupertrendOptions{ATRWindow: 10, ATRMAType: SimpleMA, Multiplier: 3})
+	study, err := CompileStudy(`supertrend(10, 3, "sma")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSeries(t, "supertrend sma", study.Evaluate(data), simple)
+	if last := len(data) - 1; wilder[last] == simple[last] {
+		t.Error("supertrend ignores the ATR moving average")
+	}
+
+	for _, expr := range []string{
+		`ma(close, 20, "zlema")`,
+		`ma(close, 20, 1)`,
+		`bb(close, "ema")`,
+		`ema(close, "20")`,
+	} {
+		if _, err := CompileStudy(expr); err == nil {
+			t.Errorf("CompileStudy(%s) succeeded", expr)
+		}
+	}
+}
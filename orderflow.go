@@ -0,0 +1,182 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	OrderFlowKeyPrefix     = "orderflow:"
+	OrderFlowHistoryMaxLen = 10000
+)
+
+// OrderFlow splits the volume of a bar into taker buys and taker sells.
+// CumulativeDelta is the running sum of Delta up to and including the bar.
+type OrderFlow struct {
+	OpenTime        time.Time
+	BuyVolume       float64
+	SellVolume      float64
+	Delta           float64
+	CumulativeDelta float64
+	BuyRatio        float64
+}
+
+func (f *OrderFlow) add(buyVolume, sellVolume float64) {
+	f.BuyVolume += buyVolume
+	f.SellVolume += sellVolume
+	f.Delta += buyVolume - sellVolume
+	f.CumulativeDelta += buyVolume - sellVolume
+	if total := f.BuyVolume + f.SellVolume; total > 0 {
+		f.BuyRatio = f.BuyVolume / total
+	}
+}
+
+// calculateCandleOrderFlow derives the order flow of each candle from its
+// taker buy base volume. It is an approximation of calculateTradeOrderFlow for
+// when only candles are available.
+func calculateCandleOrderFlow(data []Candlestick) []OrderFlow {
+	flow := make([]OrderFlow, len(data))
+	cumulativeDelta := 0.0
+	for i, candle := range data {
+		flow[i] = OrderFlow{OpenTime: candle.OpenTime, CumulativeDelta: cumulativeDelta}
+		flow[i].add(candle.TakerBuyBaseAssetVolume, candle.Volume-candle.TakerBuyBaseAssetVolume)
+		cumulativeDelta = flow[i].CumulativeDelta
+	}
+	return flow
+}
+
+// tradeVolumes returns the taker buy and taker sell volume of a trade. A
+// buyer-maker trade was initiated by the seller.
+func tradeVolumes(trade Trade) (float64, float64) {
+	if trade.BuyerIsMaker {
+		return 0, trade.Quantity
+	}
+	return trade.Quantity, 0
+}
+
+// calculateTradeOrderFlow buckets trades ordered by time into bars of the
+// given interval. Bars without trades are left out.
+func calculateTradeOrderFlow(trades []Trade, interval time.Duration) []OrderFlow {
+	var flow []OrderFlow
+	cumulativeDelta := 0.0
+	for _, trade := range trades {
+		openTime := alignTime(time.UnixMilli(trade.Time), interval)
+		if len(flow) == 0 || !flow[len(flow)-1].OpenTime.Equal(openTime) {
+			flow = append(flow, OrderFlow{OpenTime: openTime, CumulativeDelta: cumulativeDelta})
+		}
+		bar := &flow[len(flow)-1]
+		bar.add(tradeVolumes(trade))
+		cumulativeDelta = bar.CumulativeDelta
+	}
+	return flow
+}
+
+// calculateFlowImbalance returns (buy - sell) / (buy + sell) over a rolling
+// window of bars, from -1 for all selling to +1 for all buying.
+func calculateFlowImbalance(flow []OrderFlow, window int) []float64 {
+	if window <= 0 || len(flow) < window {
+		return nil
+	}
+	imbalance := nanSlice(len(flow))
+
+	buySum, sellSum := 0.0, 0.0
+	for i := range flow {
+		buySum += flow[i].BuyVolume
+		sellSum += flow[i].SellVolume
+		if i >= window {
+			buySum -= flow[i-window].BuyVolume
+			sellSum -= flow[i-window].SellVolume
+		}
+		if i >= window-1 && buySum+sellSum > 0 {
+			imbalance[i] = (buySum - sellSum) / (buySum + sellSum)
+		}
+	}
+	return imbalance
+}
+
+// OrderFlowTracker maintains live order flow bars per symbol from the trade
+// stream. Completed bars are kept as history and, when a cache is set, each is
+// published under OrderFlowKeyPrefix+symbol as it completes, Cache.Set keeping
+// the published bars in the key's list.
+type OrderFlowTracker struct {
+	interval time.Duration
+	cache    *Cache
+
+	mu      sync.Mutex
+	history map[string][]OrderFlow
+}
+
+func NewOrderFlowTracker(interval time.Duration, cache *Cache) *OrderFlowTracker {
+	return &OrderFlowTracker{
+		interval: interval,
+		cache:    cache,
+		history:  make(map[string][]OrderFlow),
+	}
+}
+
+// AddTrade adds a trade to the symbol's bar of the trade time and returns the
+// updated bar. A late trade updates its earlier bar and the cumulative delta
+// of the bars after it, but a bar that was already published is not
+// published again.
+func (t *OrderFlowTracker) AddTrade(symbol string, trade Trade) OrderFlow {
+	openTime := alignTime(time.UnixMilli(trade.Time), t.interval)
+	buyVolume, sellVolume := tradeVolumes(trade)
+
+	t.mu.Lock()
+	flow := t.history[symbol]
+	var completed *OrderFlow
+	i := sort.Search(len(flow), func(j int) bool { return !flow[j].OpenTime.Before(openTime) })
+	if i == len(flow) || !flow[i].OpenTime.Equal(openTime) {
+		if i == len(flow) && i > 0 {
+			bar := flow[i-1]
+			completed = &bar
+		}
+		cumulativeDelta := 0.0
+		if i > 0 {
+			cumulativeDelta = flow[i-1].CumulativeDelta
+		}
+		flow = append(flow, OrderFlow{})
+		copy(flow[i+1:], flow[i:])
+		flow[i] = OrderFlow{OpenTime: openTime, CumulativeDelta: cumulativeDelta}
+	}
+	flow[i].add(buyVolume, sellVolume)
+	for j := i + 1; j < len(flow); j++ {
+		flow[j].CumulativeDelta += buyVolume - sellVolume
+	}
+	updated := flow[i]
+	if len(flow) > OrderFlowHistoryMaxLen {
+		flow = flow[len(flow)-OrderFlowHistoryMaxLen:]
+	}
+	t.history[symbol] = flow
+	t.mu.Unlock()
+
+	if completed != nil && t.cache != nil {
+		if err := t.cache.Set(OrderFlowKeyPrefix+symbol, *completed, 0); err != nil {
+			log.Printf("Error publishing order flow for symbol %s: %v\n", symbol, err)
+		}
+	}
+
+	return updated
+}
+
+// Current returns the symbol's bar in progress.
+func (t *OrderFlowTracker) Current(symbol string) (OrderFlow, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	flow := t.history[symbol]
+	if len(flow) == 0 {
+		return OrderFlow{}, false
+	}
+	return flow[len(flow)-1], true
+}
+
+// History returns a copy of the symbol's bars, the last one still in progress.
+func (t *OrderFlowTracker) History(symbol string) []OrderFlow {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]OrderFlow(nil), t.history[symbol]...)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+type wantFlow struct {
+	buy, sell, delta, cumulativeDelta, buyRatio float64
+}
+
+func assertFlow(t *testing.T, flow []OrderFlow, want []wantFlow) {
+	t.Helper()
+	if len(flow) != len(want) {
+		t.Fatalf("got %d bars, want %d", len(flow), len(want))
+	}
+	for i, w := range want {
+		got := wantFlow{flow[i].BuyVolume, flow[i].SellVolume, flow[i].Delta, flow[i].CumulativeDelta, flow[i].BuyRatio}
+		if got != w {
+			t.Errorf("bar %d = %+v, want %+v", i, got, w)
+		}
+	}
+}
+
+func TestCandleOrderFlow(t *testing.T) {
+	start := time.Unix(1700000040, 0)
+	data := []Candlestick{
+		{OpenTime: start, Volume: 10, TakerBuyBaseAssetVolume: 7},
+		{OpenTime: start.Add(time.Minute), Volume: 5, TakerBuyBaseAssetVolume: 1},
+		{OpenTime: start.Add(2 * time.Minute)},
+		{OpenTime: start.Add(3 * time.Minute), Volume: 4, TakerBuyBaseAssetVolume: 4},
+	}
+
+	// The sell volume is what the takers did not buy, the cumulative delta
+	// carries over bars without volume
+	flow := calculateCandleOrderFlow(data)
+	assertFlow(t, flow, []wantFlow{
+		{7, 3, 4, 4, 0.7},
+		{1, 4, -3, 1, 0.2},
+		{0, 0, 0, 1, 0},
+		{4, 0, 4, 5, 1},
+	})
+	for i := range flow {
+		if !flow[i].OpenTime.Equal(data[i].OpenTime) {
+			t.Errorf("bar %d opens at %v, want %v", i, flow[i].OpenTime, data[i].OpenTime)
+		}
+	}
+}
+
+func TestTradeOrderFlow(t *testing.T) {
+	start := time.Unix(1700000040, 0)
+	at := func(offset time.Duration) int64 { return start.Add(offset).UnixMilli() }
+	trades := []Trade{
+		{Quantity: 2, Time: at(0)},
+		{Quantity: 1, BuyerIsMaker: true, Time: at(59 * time.Second)},
+		{Quantity: 3, BuyerIsMaker: true, Time: at(time.Minute)},
+		// No trades in the third minute
+		{Quantity: 1, Time: at(3*time.Minute + time.Second)},
+		{Quantity: 3, Time: at(3*time.Minute + 2*time.Second)},
+	}
+
+	// Buyer-maker trades are taker sells and the minute without trades has
+	// no bar
+	flow := calculateTradeOrderFlow(trades, time.Minute)
+	assertFlow(t, flow, []wantFlow{
+		{2, 1, 1, 1, 2.0 / 3},
+		{0, 3, -3, -2, 0},
+		{4, 0, 4, 2, 1},
+	})
+	for i, openTime := range []time.Time{start, start.Add(time.Minute), start.Add(3 * time.Minute)} {
+		if !flow[i].OpenTime.Equal(openTime) {
+			t.Errorf("bar %d opens at %v, want %v", i, flow[i].OpenTime, openTime)
+		}
+	}
+
+	if flow := calculateTradeOrderFlow(nil, time.Minute); len(flow) != 0 {
+		t.Errorf("got %d bars without trades, want none", len(flow))
+	}
+}
+
+func TestFlowImbalance(t *testing.T) {
+	flow := []OrderFlow{
+		{BuyVolume: 3, SellVolume: 1},
+		{BuyVolume: 0, SellVolume: 4},
+		{},
+		{},
+		{BuyVolume: 2},
+	}
+
+	// Sums over the window, a window without volume is undefined
+	nan := math.NaN()
+	assertSeries(t, "imbalance", calculateFlowImbalance(flow, 2), []float64{nan, -0.25, -1, nan, 1})
+	assertSeries(t, "imbalance", calculateFlowImbalance(flow, 1), []float64{0.5, -1, nan, nan, 1})
+
+	if imbalance := calculateFlowImbalance(flow, 6); imbalance != nil {
+		t.Errorf("got %v with fewer bars than the window, want nil", imbalance)
+	}
+	if imbalance := calculateFlowImbalance(flow, 0); imbalance != nil {
+		t.Errorf("got %v with an empty window, want nil", imbalance)
+	}
+}
+
+func TestOrderFlowTracker(t *testing.T) {
+	cache := newTestCache(t)
+	tracker := NewOrderFlowTracker(time.Minute, cache)
+	start := time.Unix(1700000040, 0) // 00:00 of a minute
+	at := func(offset time.Duration) int64 { return start.Add(offset).UnixMilli() }
+
+	tracker.AddTrade("BTCUSDT", Trade{Quantity: 2, Time: at(0)})
+	tracker.AddTrade("BTCUSDT", Trade{Quantity: 1, BuyerIsMaker: true, Time: at(10 * time.Second)})
+	tracker.AddTrade("BTCUSDT", Trade{Quantity: 3, Time: at(time.Minute)})
+
+	// Opening the second bar publishes the first one only
+	var published OrderFlow
+	if ok, err := cache.Get(OrderFlowKeyPrefix+"BTCUSDT", &published); err != nil || !ok {
+		t.Fatalf("no published bar: %v", err)
+	}
+	if !published.OpenTime.Equal(start) || published.Delta != 1 {
+		t.Errorf("published %+v, want the first bar with a delta of 1", published)
+	}
+
+	// A late trade goes to its own bar and shifts the cumulative delta after it
+	updated := tracker.AddTrade("BTCUSDT", Trade{Quantity: 4, BuyerIsMaker: true, Time: at(50 * time.Second)})
+	if !updated.OpenTime.Equal(start) || updated.Delta != -3 {
+		t.Errorf("late trade updated %+v, want the first bar with a delta of -3", updated)
+	}
+	// A late trade in a bar without trades inserts it
+	tracker.AddTrade("BTCUSDT", Trade{Quantity: 1, Time: at(-time.Minute)})
+
+	history := tracker.History("BTCUSDT")
+	want := []struct {
+		openTime        time.Time
+		delta           float64
+		cumulativeDelta float64
+	}{
+		{start.Add(-time.Minute), 1, 1},
+		{start, -3, -2},
+		{start.Add(time.Minute), 3, 1},
+	}
+	if len(history) != len(want) {
+		t.Fatalf("got %d bars, want %d", len(history), len(want))
+	}
+	for i, w := range want {
+		bar := history[i]
+		if !bar.OpenTime.Equal(w.openTime) || bar.Delta != w.delta || bar.CumulativeDelta != w.cumulativeDelta {
+			t.Errorf("bar %d = %+v, want open %v, delta %v, cumulative %v", i, bar, w.openTime, w.delta, w.cumulativeDelta)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import "math"
+
+// SupertrendOptions sets the window and moving average of the ATR and the
+// width of the bands in ATRs.
+type SupertrendOptions struct {
+	ATRWindow  int
+	ATRMAType  MAType
+	Multiplier float64
+}
+
+func defaultSupertrendOptions() SupertrendOptions {
+	return SupertrendOptions{ATRWindow: 10, ATRMAType: WilderMA, Multiplier: 3}
+}
+
+// calculateSupertrend returns the Supertrend line and its direction, +1 in an
+// uptrend (line below price) and -1 in a downtrend. The bands are hl2 plus and
+// minus multiplier ATRs and only tighten while the trend lasts. It starts in a
+// downtrend at the first ATR value.
+func calculateSupertrend(data []Candlestick, atrWindow int, multiplier float64) ([]float64, []float64) {
+	opts := defaultSupertrendOptions()
+	opts.ATRWindow, opts.Multiplier = atrWindow, multiplier
+	return calculateSupertrendWithOptions(data, opts)
+}
+
+func calculateSupertrendWithOptions(data []Candlestick, opts SupertrendOptions) ([]float64, []float64) {
+	high := make([]float64, len(data))
+	low := make([]float64, len(data))
+	for i, candle := range data {
+		high[i] = candle.High
+		low[i] = candle.Low
+	}
+	atr := calculateATRWithOptions(high, low, closePrices(data), ATROptions{Window: opts.ATRWindow, MAType: opts.ATRMAType})
+	if atr == nil {
+		return nil, nil
+	}
+	start := firstValid(atr)
+
+	supertrend := nanSlice(len(data))
+	direction := nanSlice(len(data))
+	var upper, lower float64
+	for i := start; i < len(data); i++ {
+		hl2 := (data[i].High + data[i].Low) / 2
+		basicUpper := hl2 + opts.Multiplier*atr[i]
+		basicLower := hl2 - opts.Multiplier*atr[i]
+
+		if i == start {
+			upper, lower = basicUpper, basicLower
+			supertrend[i], direction[i] = upper, -1
+			continue
+		}
+
+		prevClose := data[i-1].Close
+		if basicUpper < upper || prevClose > upper {
+			upper = basicUpper
+		}
+		if basicLower > lower || prevClose < lower {
+			lower = basicLower
+		}
+
+		if direction[i-1] < 0 {
+			direction[i] = -1
+			if data[i].Close > upper {
+				direction[i] = 1
+			}
+		} else {
+			direction[i] = 1
+			if data[i].Close < lower {
+				direction[i] = -1
+			}
+		}
+
+		if direction[i] > 0 {
+			supertrend[i] = lower
+		} else {
+			supertrend[i] = upper
+		}
+	}
+	return supertrend, direction
+}
+
+// calculateAroon returns Aroon up, Aroon down and the Aroon oscillator. Up is
+// 100 when the highest high of the last window+1 bars is the current bar and 0
+// when it is the oldest, the most recent bar wins ties.
+func calculateAroon(data []Candlestick, window int) ([]float64, []float64, []float64) {
+	if window <= 0 || len(data) <= window {
+		return nil, nil, nil
+	}
+
+	up := nanSlice(len(data))
+	down := nanSlice(len(data))
+	oscillator := nanSlice(len(data))
+	for i := window; i < len(data); i++ {
+		highest, lowest := i-window, i-window
+		for j := i - window + 1; j <= i; j++ {
+			if data[j].High >= data[highest].High {
+				highest = j
+			}
+			if data[j].Low <= data[lowest].Low {
+				lowest = j
+			}
+		}
+		up[i] = float64(window-(i-highest)) / float64(window) * 100
+		down[i] = float64(window-(i-lowest)) / float64(window) * 100
+		oscillator[i] = up[i] - down[i]
+	}
+	return up, down, oscillator
+}
+
+// calculateCCI returns the commodity channel index of the typical price. It
+// is 0 when the mean deviation is 0.
+func calculateCCI(data []Candlestick, window int) []float64 {
+	if window <= 0 || len(data) < window {
+		return nil
+	}
+
+	typical := make([]float64, len(data))
+	for i, candle := range data {
+		typical[i] = (candle.High + candle.Low + candle.Close) / 3
+	}
+	mean := calculateSMA(typical, window)
+
+	cci := nanSlice(len(data))
+	for i := window - 1; i < len(data); i++ {
+		deviation := 0.0
+		for j := i - window + 1; j <= i; j++ {
+			deviation += math.Abs(typical[j] - mean[i])
+		}
+		deviation /= float64(window)
+		if deviation == 0 {
+			cci[i] = 0
+			continue
+		}
+		cci[i] = (typical[i] - mean[i]) / (0.015 * deviation)
+	}
+	return cci
+}
+
+// calculateWilliamsR returns Williams %R, from -100 at the lowest low to 0 at
+// the highest high of the window. It is 0 when the window has no range.
+func calculateWilliamsR(data []Candlestick, window int) []float64 {
+	if window <= 0 || len(data) < window {
+		return nil
+	}
+
+	williamsR := nanSlice(len(data))
+	for i := window - 1; i < len(data); i++ {
+		highest, lowest := math.Inf(-1), math.Inf(1)
+		for j := i - window + 1; j <= i; j++ {
+			highest = math.Max(highest, data[j].High)
+			lowest = math.Min(lowest, data[j].Low)
+		}
+		if highest == lowest {
+			williamsR[i] = 0
+			continue
+		}
+		williamsR[i] = (highest - data[i].Close) / (highest - lowest) * -100
+	}
+	return williamsR
+}
+
+// calculateTRIX returns the one-bar percentage change of a triple-smoothed EMA.
+func calculateTRIX(data []float64, window int) []float64 {
+	return calculateTRIXWithMA(data, window, ExponentialMA)
+}
+
+// calculateTRIXWithMA smooths three times with the given moving average.
+func calculateTRIXWithMA(data []float64, window int, maType MAType) []float64 {
+	smoothed := calculateMA(calculateMA(calculateMA(data, maType, window), maType, window), maType, window)
+	if smoothed == nil {
+		return nil
+	}
+
+	trix := nanSlice(len(data))
+	for i := 1; i < len(smoothed); i++ {
+		if math.IsNaN(smoothed[i-1]) || smoothed[i-1] == 0 {
+			continue
+		}
+		trix[i] = (smoothed[i] - smoothed[i-1]) / smoothed[i-1] * 100
+	}
+	return trix
+}
+
+// calculateKAMA returns Kaufman's adaptive moving average. The smoothing
+// constant moves between the fast and slow EMA constants with the efficiency
+// ratio over window bars. It is seeded with the value window bars after the
+// first, so the first value is at index window.
+func calculateKAMA(data []float64, window, fastWindow, slowWindow int) []float64 {
+	start := firstValid(data)
+	if window <= 0 || fastWindow <= 0 || slowWindow <= 0 || len(data)-start <= window {
+		return nil
+	}
+
+	fast := 2 / float64(fastWindow+1)
+	slow := 2 / float64(slowWindow+1)
+	er := calculateEfficiencyRatio(data, window)
+
+	kama := nanSlice(len(data))
+	prev := data[start+window-1]
+	for i := start + window; i < len(data); i++ {
+		sc := math.Pow(er[i]*(fast-slow)+slow, 2)
+		kama[i] = prev + sc*(data[i]-prev)
+		prev = kama[i]
+	}
+	return kama
+}
+
+// calculateEfficiencyRatio returns Kaufman's efficiency ratio, the net change
+// over window bars divided by the sum of the absolute one-bar changes. It is 1
+// for a straight line and near 0 for noise, and 0 when prices did not move.
+func calculateEfficiencyRatio(data []float64, window int) []float64 {
+	start := firstValid(data)
+	if window <= 0 || len(data)-start <= window {
+		return nil
+	}
+
+	er := nanSlice(len(data))
+	volatility := 0.0
+	for i := start + 1; i < len(data); i++ {
+		volatility += math.Abs(data[i] - data[i-1])
+		if i-start > window {
+			volatility -= math.Abs(data[i-window] - data[i-window-1])
+		}
+		if i-start < window {
+			continue
+		}
+		if volatility <= 0 {
+			er[i] = 0
+			continue
+		}
+		er[i] = math.Min(math.Abs(data[i]-data[i-window])/volatility, 1)
+	}
+	return er
+}
+
+// calculateVortex returns the positive and negative vortex indicators over
+// window bars. The first values are at index window.
+func calculateVortex(data []Candlestick, window int) ([]float64, []float64) {
+	if window <= 0 || len(data) <= window {
+		return nil, nil
+	}
+
+	high := make([]float64, len(data))
+	low := make([]float64, len(data))
+	for i, candle := range data {
+		high[i] = candle.High
+		low[i] = candle.Low
+	}
+	tr := trueRange(high, low, closePrices(data))
+
+	plus := nanSlice(len(data))
+	minus := nanSlice(len(data))
+	sumPlus, sumMinus, sumTR := 0.0, 0.0, 0.0
+	for i := 1; i < len(data); i++ {
+		sumPlus += math.Abs(high[i] - low[i-1])
+		sumMinus += math.Abs(low[i] - high[i-1])
+		sumTR += tr[i]
+		if i > window {
+			sumPlus -= math.Abs(high[i-window] - low[i-window-1])
+			sumMinus -= math.Abs(low[i-window] - high[i-window-1])
+			sumTR -= tr[i-window]
+		}
+		if i < window || sumTR <= 0 {
+			continue
+		}
+		plus[i] = sumPlus / sumTR
+		minus[i] = sumMinus / sumTR
+	}
+	return plus, minus
+}
+
+// calculateUltimateOscillator weights the buying pressure over the short,
+// medium and long windows 4:2:1. The first value is at index longWindow.
+func calculateUltimateOscillator(data []Candlestick, shortWindow, mediumWindow, longWindow int) []float64 {
+	if shortWindow <= 0 || mediumWindow <= 0 || len(data) <= longWindow ||
+		longWindow < mediumWindow || longWindow < shortWindow {
+		return nil
+	}
+
+	pressure := make([]float64, len(data))
+	tr := make([]float64, len(data))
+	for i := 1; i < len(data); i++ {
+		prevClose := data[i-1].Close
+		trueLow := math.Min(data[i].Low, prevClose)
+		pressure[i] = data[i].Close - trueLow
+		tr[i] = math.Max(data[i].High, prevClose) - trueLow
+	}
+
+	average := func(i, window int) float64 {
+		sumPressure, sumTR := 0.0, 0.0
+		for j := i - window + 1; j <= i; j++ {
+			sumPressure += pressure[j]
+			sumTR += tr[j]
+		}
+		if sumTR == 0 {
+			return 0
+		}
+		return sumPressure / sumTR
+	}
+
+	ultimate := nanSlice(len(data))
+	for i := longWindow; i < len(data); i++ {
+		ultimate[i] = 100 * (4*average(i, shortWindow) + 2*average(i, mediumWindow) + average(i, longWindow)) / 7
+	}
+	return ultimate
+}
+
+// StochasticRSIOptions sets the RSI window, the stochastic lookback over the
+// RSI and the windows and moving average of %K and %D.
+type StochasticRSIOptions struct {
+	RSIWindow   int
+	StochWindow int
+	KWindow     int
+	DWindow     int
+	MAType      MAType
+}
+
+func defaultStochasticRSIOptions() StochasticRSIOptions {
+	return StochasticRSIOptions{RSIWindow: 14, StochWindow: 14, KWindow: 3, DWindow: 3, MAType: SimpleMA}
+}
+
+// calculateStochasticRSI applies the stochastic oscillator to the RSI and
+// returns %K, the SMA of the raw value over kWindow, and %D, the SMA of %K
+// over dWindow. The raw value is 0 when the RSI has no range.
+func calculateStochasticRSI(data []float64, rsiWindow, stochWindow, kWindow, dWindow int) ([]float64, []float64) {
+	opts := defaultStochasticRSIOptions()
+	opts.RSIWindow, opts.StochWindow, opts.KWindow, opts.DWindow = rsiWindow, stochWindow, kWindow, dWindow
+	return calculateStochasticRSIWithOptions(data, opts)
+}
+
+func calculateStochasticRSIWithOptions(data []float64, opts StochasticRSIOptions) ([]float64, []float64) {
+	rsi := calculateRSI(data, opts.RSIWindow)
+	start := firstValid(rsi)
+	if rsi == nil || opts.StochWindow <= 0 || len(rsi)-start < opts.StochWindow {
+		return nil, nil
+	}
+
+	raw := nanSlice(len(data))
+	for i := start + opts.StochWindow - 1; i < len(rsi); i++ {
+		highest, lowest := math.Inf(-1), math.Inf(1)
+		for j := i - opts.StochWindow + 1; j <= i; j++ {
+			highest = math.Max(highest, rsi[j])
+			lowest = math.Min(lowest, rsi[j])
+		}
+		if highest == lowest {
+			raw[i] = 0
+			continue
+		}
+		raw[i] = (rsi[i] - lowest) / (highest - lowest) * 100
+	}
+
+	k := calculateMA(raw, opts.MAType, opts.KWindow)
+	d := calculateMA(k, opts.MAType, opts.DWindow)
+	return k, d
+}
@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestOscillatorsReference(t *testing.T) {
+	data := loadOHLCV(t)
+	want := loadReference(t, "oscillators.csv")
+	close := closePrices(data)
+
+	supertrend, direction := calculateSupertrend(data, 10, 3)
+	aroonUp, aroonDown, aroonOscillator := calculateAroon(data, 25)
+	vortexPlus, vortexMinus := calculateVortex(data, 14)
+	stochRSIK, stochRSID := calculateStochasticRSI(close, 14, 14, 3, 3)
+	diPlus, diMinus := calculateDMI(data, 7)
+
+	tests := []struct {
+		name string
+		got  []float64
+	}{
+		{"supertrend", supertrend},
+		{"supertrend_dir", direction},
+		{"aroon_up", aroonUp},
+		{"aroon_down", aroonDown},
+		{"aroon_osc", aroonOscillator},
+		{"cci20", calculateCCI(data, 20)},
+		{"willr14", calculateWilliamsR(data, 14)},
+		{"trix15", calculateTRIX(close, 15)},
+		{"kama10", calculateKAMA(close, 10, 2, 30)},
+		{"vortex_plus14", vortexPlus},
+		{"vortex_minus14", vortexMinus},
+		{"ultosc", calculateUltimateOscillator(data, 7, 14, 28)},
+		{"stochrsi_k", stochRSIK},
+		{"stochrsi_d", stochRSID},
+		{"di_plus7", diPlus},
+		{"di_minus7", diMinus},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertSeries(t, tt.name, tt.got, want[tt.name])
+		})
+	}
+}
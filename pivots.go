@@ -0,0 +1,328 @@
+package main
+
+import (
+	"fmt"
+	"github.com/adshao/go-binance/v2"
+	"log"
+	"math"
+	"sort"
+	"time"
+)
+
+const PivotKeyPrefix = "pivots:"
+
+// PivotHistoryLimit is the number of klines of each pivot period fetched per
+// symbol.
+const PivotHistoryLimit = 30
+
+// PivotPeriods are the periods whose pivot levels are published, fetched as
+// klines of that interval since the cached candles span a few days at most.
+var PivotPeriods = []string{"1d", "1w"}
+
+type PivotMethod int
+
+const (
+	ClassicPivots PivotMethod = iota
+	FibonacciPivots
+	WoodiePivots
+	CamarillaPivots
+	DeMarkPivots
+)
+
+var pivotMethodNames = map[PivotMethod]string{
+	ClassicPivots:   "classic",
+	FibonacciPivots: "fibonacci",
+	WoodiePivots:    "woodie",
+	CamarillaPivots: "camarilla",
+	DeMarkPivots:    "demark",
+}
+
+func (m PivotMethod) String() string {
+	if name, ok := pivotMethodNames[m]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// PivotLevels are the pivot, resistance (R1 first) and support (S1 first)
+// levels of the period starting at Start, computed from the previous period.
+// Classic, Fibonacci and Woodie have three levels on each side, Camarilla four
+// and DeMark one.
+type PivotLevels struct {
+	Method     PivotMethod
+	Start      time.Time
+	Pivot      float64
+	Resistance []float64
+	Support    []float64
+}
+
+// pivotLevelsFrom computes the levels following the prior period's candle.
+func pivotLevelsFrom(prior Candlestick, method PivotMethod) PivotLevels {
+	h, l, c := prior.High, prior.Low, prior.Close
+	r := h - l
+	levels := PivotLevels{Method: method, Pivot: (h + l + c) / 3}
+	p := levels.Pivot
+
+	switch method {
+	case ClassicPivots:
+		levels.Resistance = []float64{2*p - l, p + r, h + 2*(p-l)}
+		levels.Support = []float64{2*p - h, p - r, l - 2*(h-p)}
+
+	case FibonacciPivots:
+		levels.Resistance = []float64{p + 0.382*r, p + 0.618*r, p + r}
+		levels.Support = []float64{p - 0.382*r, p - 0.618*r, p - r}
+
+	case WoodiePivots:
+		p = (h + l + 2*c) / 4
+		levels.Pivot = p
+		levels.Resistance = []float64{2*p - l, p + r, h + 2*(p-l)}
+		levels.Support = []float64{2*p - h, p - r, l - 2*(h-p)}
+
+	case CamarillaPivots:
+		levels.Resistance = []float64{c + r*1.1/12, c + r*1.1/6, c + r*1.1/4, c + r*1.1/2}
+		levels.Support = []float64{c - r*1.1/12, c - r*1.1/6, c - r*1.1/4, c - r*1.1/2}
+
+	case DeMarkPivots:
+		var x float64
+		switch {
+		case c < prior.Open:
+			x = h + 2*l + c
+		case c > prior.Open:
+			x = 2*h + l + c
+		default:
+			x = h + l + 2*c
+		}
+		levels.Pivot = x / 4
+		levels.Resistance = []float64{x/2 - l}
+		levels.Support = []float64{x/2 - h}
+	}
+	return levels
+}
+
+// calculatePivotPoints returns the levels of every period of the candles that
+// follows a complete period, e.g. period "1d" or "1w". The last entry holds
+// the levels of the period in progress.
+func calculatePivotPoints(data []Candlestick, period string, method PivotMethod) ([]PivotLevels, error) {
+	periods, err := resampleCandles(data, period)
+	if err != nil {
+		return nil, err
+	}
+
+	levels := make([]PivotLevels, len(periods))
+	for i, prior := range periods {
+		levels[i] = pivotLevelsFrom(prior, method)
+		levels[i].Start = barEnd(prior)
+	}
+	return levels, nil
+}
+
+// pivotSeries returns the pivot, R1 to R4 and S1 to S4 of each candle's
+// period, NaN where the previous period is not in the candles or the method
+// has fewer levels.
+func pivotSeries(data []Candlestick, period string, method PivotMethod) ([][]float64, error) {
+	duration, err := intervalDuration(period)
+	if err != nil {
+		return nil, err
+	}
+	levels, err := calculatePivotPoints(data, period, method)
+	if err != nil {
+		return nil, err
+	}
+
+	byStart := make(map[time.Time]PivotLevels, len(levels))
+	for _, level := range levels {
+		byStart[level.Start] = level
+	}
+
+	series := make([][]float64, 9)
+	for i := range series {
+		series[i] = nanSlice(len(data))
+	}
+	for i, candle := range data {
+		level, ok := byStart[alignTime(candle.OpenTime.UTC(), duration)]
+		if !ok {
+			continue
+		}
+		series[0][i] = level.Pivot
+		for n := range level.Resistance {
+			series[1+n][i] = level.Resistance[n]
+			series[5+n][i] = level.Support[n]
+		}
+	}
+	return series, nil
+}
+
+// SupportResistanceZone is a price zone where swings have repeatedly turned.
+// Touches is the number of swing highs and lows within the zone, FirstIndex
+// and LastIndex the bars of the first and the latest of them.
+type SupportResistanceZone struct {
+	Low        float64
+	High       float64
+	Center     float64
+	Touches    int
+	FirstIndex int
+	LastIndex  int
+	Support    bool
+}
+
+// SupportResistanceOptions sets the swing pivot lookbacks, the ATR window and
+// the width of a zone in ATRs. Zones with fewer than MinTouches swings are
+// left out.
+type SupportResistanceOptions struct {
+	LeftBars   int
+	RightBars  int
+	ATRWindow  int
+	ZoneWidth  float64
+	MinTouches int
+}
+
+func defaultSupportResistanceOptions() SupportResistanceOptions {
+	return SupportResistanceOptions{
+		LeftBars:   5,
+		RightBars:  5,
+		ATRWindow:  14,
+		ZoneWidth:  0.5,
+		MinTouches: 2,
+	}
+}
+
+// detectSupportResistance clusters swing highs and lows whose prices are
+// within ZoneWidth times the latest ATR of each other. Zones below the last
+// close are support, the others resistance. They are ordered by touches,
+// then by the latest touch.
+func detectSupportResistance(data []Candlestick, opts SupportResistanceOptions) []SupportResistanceZone {
+	high := make([]float64, len(data))
+	low := make([]float64, len(data))
+	for i, candle := range data {
+		high[i] = candle.High
+		low[i] = candle.Low
+	}
+	atr := calculateATR(high, low, closePrices(data), opts.ATRWindow)
+	if len(atr) == 0 || math.IsNaN(atr[len(atr)-1]) {
+		return nil
+	}
+	width := opts.ZoneWidth * atr[len(atr)-1]
+
+	type swing struct {
+		index int
+		price float64
+	}
+	var swings []swing
+	for _, i := range findPivotHighs(high, opts.LeftBars, opts.RightBars) {
+		swings = append(swings, swing{i, high[i]})
+	}
+	for _, i := range findPivotLows(low, opts.LeftBars, opts.RightBars) {
+		swings = append(swings, swing{i, low[i]})
+	}
+	sort.Slice(swings, func(i, j int) bool { return swings[i].price < swings[j].price })
+
+	lastClose := data[len(data)-1].Close
+	var zones []SupportResistanceZone
+	for start := 0; start < len(swings); {
+		// Grow the zone while the next swing is within width of its lowest one
+		end := start + 1
+		for end < len(swings) && swings[end].price-swings[start].price <= width {
+			end++
+		}
+
+		zone := SupportResistanceZone{
+			Low:        swings[start].price,
+			High:       swings[end-1].price,
+			Touches:    end - start,
+			FirstIndex: swings[start].index,
+			LastIndex:  swings[start].index,
+		}
+		sum := 0.0
+		for _, s := range swings[start:end] {
+			sum += s.price
+			if s.index < zone.FirstIndex {
+				zone.FirstIndex = s.index
+			}
+			if s.index > zone.LastIndex {
+				zone.LastIndex = s.index
+			}
+		}
+		zone.Center = sum / float64(zone.Touches)
+		zone.Support = zone.Center < lastClose
+		if zone.Touches >= opts.MinTouches {
+			zones = append(zones, zone)
+		}
+		start = end
+	}
+
+	sort.SliceStable(zones, func(i, j int) bool {
+		if zones[i].Touches != zones[j].Touches {
+			return zones[i].Touches > zones[j].Touches
+		}
+		return zones[i].LastIndex > zones[j].LastIndex
+	})
+	return zones
+}
+
+// fetchPeriodCandles fetches the closed klines of a pivot period, e.g. "1d"
+// or "1w", over REST.
+func fetchPeriodCandles(client *binance.Client, symbol, period string) ([]Candlestick, error) {
+	candles, err := fetchKlinesWithRetry(client, symbol, period, PivotHistoryLimit, 3)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s klines for %s: %w", period, symbol, err)
+	}
+	return closedCandles(candles, time.Now()), nil
+}
+
+// symbolPivotPoints computes the pivot levels of a symbol from its klines of
+// the period.
+func symbolPivotPoints(client *binance.Client, symbol, period string, method PivotMethod) ([]PivotLevels, error) {
+	candles, err := fetchPeriodCandles(client, symbol, period)
+	if err != nil {
+		return nil, err
+	}
+	return calculatePivotPoints(candles, period, method)
+}
+
+// updatePivots publishes the levels of the period in progress of every pivot
+// period and method, keyed by period then method name.
+func updatePivots(client *binance.Client, cache *Cache, symbol string) error {
+	pivots := make(map[string]map[string]PivotLevels, len(PivotPeriods))
+	for _, period := range PivotPeriods {
+		candles, err := fetchPeriodCandles(client, symbol, period)
+		if err != nil {
+			return err
+		}
+		pivots[period] = make(map[string]PivotLevels, len(pivotMethodNames))
+		for method, name := range pivotMethodNames {
+			levels, err := calculatePivotPoints(candles, period, method)
+			if err != nil {
+				return err
+			}
+			if len(levels) > 0 {
+				pivots[period][name] = levels[len(levels)-1]
+			}
+		}
+	}
+	return cache.Put(PivotKeyPrefix+symbol, pivots, 0)
+}
+
+// pivotRoutine periodically publishes the daily and weekly pivot levels of
+// every symbol.
+func pivotRoutine(client *binance.Client, cache *Cache, symbols []string, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for ; true; <-ticker.C {
+		for _, symbol := range symbols {
+			if err := updatePivots(client, cache, symbol); err != nil {
+				log.Printf("Error updating pivots for symbol %s: %v\n", symbol, err)
+			}
+		}
+	}
+}
+
+// symbolSupportResistance detects the support and resistance zones of a
+// symbol from its cached candles.
+func symbolSupportResistance(cache *Cache, symbol string, opts SupportResistanceOptions) ([]SupportResistanceZone, error) {
+	candles, err := cache.GetCandlesticks(symbol)
+	if err != nil {
+		return nil, fmt.Errorf("reading candles for %s: %w", symbol, err)
+	}
+	return detectSupportResistance(candles, opts), nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPivotLevels(t *testing.T) {
+	prior := Candlestick{Open: 100, High: 110, Low: 90, Close: 105}
+	tests := []struct {
+		method     PivotMethod
+		pivot      float64
+		resistance []float64
+		support    []float64
+	}{
+		{ClassicPivots, 305.0 / 3, []float64{340.0 / 3, 365.0 / 3, 400.0 / 3}, []float64{280.0 / 3, 245.0 / 3, 220.0 / 3}},
+		{FibonacciPivots, 305.0 / 3, []float64{305.0/3 + 7.64, 305.0/3 + 12.36, 305.0/3 + 20}, []float64{305.0/3 - 7.64, 305.0/3 - 12.36, 305.0/3 - 20}},
+		// Woodie weighs the close twice
+		{WoodiePivots, 102.5, []float64{115, 122.5, 135}, []float64{95, 82.5, 75}},
+		// Camarilla levels are around the close
+		{CamarillaPivots, 305.0 / 3, []float64{105 + 22.0/12, 105 + 22.0/6, 110.5, 116}, []float64{105 - 22.0/12, 105 - 22.0/6, 99.5, 94}},
+		// The close is above the open, DeMark weighs the high twice
+		{DeMarkPivots, 103.75, []float64{117.5}, []float64{97.5}},
+	}
+
+	for _, tt := range tests {
+		levels := pivotLevelsFrom(prior, tt.method)
+		if math.Abs(levels.Pivot-tt.pivot) > 1e-9 {
+			t.Errorf("%v pivot = %v, want %v", tt.method, levels.Pivot, tt.pivot)
+		}
+		if !sameValues(levels.Resistance, tt.resistance) {
+			t.Errorf("%v resistance = %v, want %v", tt.method, levels.Resistance, tt.resistance)
+		}
+		if !sameValues(levels.Support, tt.support) {
+			t.Errorf("%v support = %v, want %v", tt.method, levels.Support, tt.support)
+		}
+	}
+
+	// DeMark weighs the low twice after a down period and the close twice
+	// after an unchanged one
+	for _, tt := range []struct {
+		close, pivot float64
+	}{{95, 96.25}, {100, 100}} {
+		prior := Candlestick{Open: 100, High: 110, Low: 90, Close: tt.close}
+		if levels := pivotLevelsFrom(prior, DeMarkPivots); levels.Pivot != tt.pivot {
+			t.Errorf("DeMark pivot after a close of %v = %v, want %v", tt.close, levels.Pivot, tt.pivot)
+		}
+	}
+}
+
+// dailyCandles returns n daily candles whose last one opened today and is in
+// progress.
+func dailyCandles(n int) []Candlestick {
+	today := alignTime(time.Now().UTC(), 24*time.Hour)
+	data := make([]Candlestick, n)
+	for i := range data {
+		openTime := today.AddDate(0, 0, i-n+1)
+		price := 100 + float64(i)
+		data[i] = Candlestick{OpenTime: openTime, CloseTime: openTime.Add(24*time.Hour - time.Second),
+			Open: price, High: price + 10, Low: price - 10, Close: price + 5, Volume: 1}
+	}
+	return data
+}
+
+func TestSymbolPivotPoints(t *testing.T) {
+	candles := dailyCandles(10)
+	_, client := startFakeBinance(t, FakeScenario{Symbols: []string{"PIVUSDT"}, Candles: map[string][]Candlestick{"PIVUSDT": candles}})
+
+	// Today's kline is in progress, the levels of today come from yesterday
+	levels, err := symbolPivotPoints(client, "PIVUSDT", "1d", ClassicPivots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(levels) != 9 {
+		t.Fatalf("got %d days of levels, want 9", len(levels))
+	}
+	last := levels[len(levels)-1]
+	want := pivotLevelsFrom(candles[8], ClassicPivots)
+	if !last.Start.Equal(candles[9].OpenTime) || last.Pivot != want.Pivot {
+		t.Errorf("last levels start at %v with pivot %v, want %v and %v", last.Start, last.Pivot, candles[9].OpenTime, want.Pivot)
+	}
+
+	cache := newTestCache(t)
+	if err := updatePivots(client, cache, "PIVUSDT"); err != nil {
+		t.Fatal(err)
+	}
+	var pivots map[string]map[string]PivotLevels
+	if found, err := cache.Get(PivotKeyPrefix+"PIVUSDT", &pivots); err != nil || !found {
+		t.Fatalf("pivots not published: found %v, error %v", found, err)
+	}
+	for method, name := range pivotMethodNames {
+		want := pivotLevelsFrom(candles[8], method)
+		if got := pivots["1d"][name]; got.Pivot != want.Pivot || !sameValues(got.Support, want.Support) {
+			t.Errorf("published %s levels = %+v, want %+v", name, got, want)
+		}
+	}
+}
+
+func TestDetectSupportResistance(t *testing.T) {
+	// Three swing highs around 120, two swing lows around 100.75 and single
+	// swings at 110 and 135
+	data := pathCandles(5, 100, 120, 100.5, 120.5, 101, 121, 110, 135, 118)
+	opts := SupportResistanceOptions{LeftBars: 2, RightBars: 2, ATRWindow: 14, ZoneWidth: 0.5, MinTouches: 2}
+
+	zones := detectSupportResistance(data, opts)
+	want := []SupportResistanceZone{
+		{Low: 120, High: 121, Center: 120.5, Touches: 3, FirstIndex: 5, LastIndex: 25, Support: false},
+		{Low: 100.5, High: 101, Center: 100.75, Touches: 2, FirstIndex: 10, LastIndex: 20, Support: true},
+	}
+	if len(zones) != len(want) {
+		t.Fatalf("got zones %+v, want %+v", zones, want)
+	}
+	for i := range want {
+		if zones[i] != want[i] {
+			t.Errorf("zone %d = %+v, want %+v", i, zones[i], want[i])
+		}
+	}
+
+	// Single swings are zones of their own once one touch is enough
+	opts.MinTouches = 1
+	if zones := detectSupportResistance(data, opts); len(zones) != 4 {
+		t.Errorf("got %d zones with one touch, want 4", len(zones))
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/adshao/go-binance/v2"
+	"log"
 	"strconv"
 	"sync"
 	"time"
@@ -17,6 +18,36 @@ func parseFloat(value string, name string) (float64, error) {
 	return parsed, nil
 }
 
+// intervalDuration converts a Binance kline interval such as "1m", "4h" or
+// "1w" into a duration. Monthly intervals have no fixed length and are
+// rejected.
+func intervalDuration(interval string) (time.Duration, error) {
+	if len(interval) < 2 {
+		return 0, fmt.Errorf("invalid interval %q", interval)
+	}
+	count, err := strconv.Atoi(interval[:len(interval)-1])
+	if err != nil || count <= 0 {
+		return 0, fmt.Errorf("invalid interval %q", interval)
+	}
+
+	var unit time.Duration
+	switch interval[len(interval)-1] {
+	case 's':
+		unit = time.Second
+	case 'm':
+		unit = time.Minute
+	case 'h':
+		unit = time.Hour
+	case 'd':
+		unit = 24 * time.Hour
+	case 'w':
+		unit = 7 * 24 * time.Hour
+	default:
+		return 0, fmt.Errorf("unsupported interval %q", interval)
+	}
+	return time.Duration(count) * unit, nil
+}
+
 func klineToCandlestick(kline *binance.Kline) (*Candlestick, error) {
 	openTime := time.Unix(kline.OpenTime/1000, 0)
 	closeTime := time.Unix(kline.CloseTime/1000, 0)
@@ -89,7 +120,7 @@ func processSymbols(client *binance.Client, symbols []string, interval string, l
 				fmt.Printf("Failed to retrieve data for %s after %d attempts\n", symbol, 3)
 				return
 			}
-			errr := cache.Set(symbol, klines, time.Minute*5)
+			errr := cache.SetCandlesticks(symbol, klines)
 			if errr != nil {
 				return
 			}
@@ -121,9 +152,11 @@ func fetchKlines(client *binance.Client, symbol string, interval string, limit i
 
 	klines := make([]Candlestick, 0, len(binanceKlines))
 	for _, kline := range binanceKlines {
+		// A malformed kline is skipped, refetching would return it again
 		candlestick, err := klineToCandlestick(kline)
 		if err != nil {
-			return nil, err
+			log.Printf("Error converting kline for symbol %s: %v\n", symbol, err)
+			continue
 		}
 		klines = append(klines, *candlestick)
 	}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+const RegimeKeyPrefix = "regime:"
+
+type MarketRegime int
+
+const (
+	RegimeUnknown MarketRegime = iota
+	RegimeRanging
+	RegimeTrending
+	RegimeVolatile
+)
+
+func (r MarketRegime) String() string {
+	switch r {
+	case RegimeRanging:
+		return "ranging"
+	case RegimeTrending:
+		return "trending"
+	case RegimeVolatile:
+		return "volatile"
+	}
+	return "unknown"
+}
+
+// RegimeOptions holds the regime thresholds. A market is volatile when the
+// percentile of its ATR/price or of its Bollinger bandwidth over the last
+// VolatilityLookback bars reaches VolatileEnter, and stays volatile until both
+// fall below VolatileExit. It trends when the ADX reaches TrendEnter with an
+// efficiency ratio of at least MinEfficiency, and keeps trending until the ADX
+// falls below TrendExit. Anything else is ranging. A new regime must hold for
+// ConfirmBars bars before it replaces the current one.
+type RegimeOptions struct {
+	ADXWindow          int
+	ATRWindow          int
+	BandWindow         int
+	BandStdDev         float64
+	EfficiencyWindow   int
+	VolatilityLookback int
+
+	TrendEnter    float64
+	TrendExit     float64
+	MinEfficiency float64
+	VolatileEnter float64
+	VolatileExit  float64
+	ConfirmBars   int
+}
+
+func defaultRegimeOptions() RegimeOptions {
+	return RegimeOptions{
+		ADXWindow:          14,
+		ATRWindow:          14,
+		BandWindow:         20,
+		BandStdDev:         2,
+		EfficiencyWindow:   20,
+		VolatilityLookback: 50,
+		TrendEnter:         25,
+		TrendExit:          20,
+		MinEfficiency:      0.3,
+		VolatileEnter:      90,
+		VolatileExit:       70,
+		ConfirmBars:        3,
+	}
+}
+
+// RegimeReading is the regime of a bar with the measures behind it.
+// ATRPercent is the ATR as a percentage of the close, the percentiles are
+// those of ATRPercent and Bandwidth over the lookback.
+type RegimeReading struct {
+	Regime              MarketRegime
+	ADX                 float64
+	ATRPercent          float64
+	Bandwidth           float64
+	Efficiency          float64
+	ATRPercentile       float64
+	BandwidthPercentile float64
+}
+
+// classifyRegimes returns the regime of every bar. Bars before all measures
+// are available are RegimeUnknown.
+func classifyRegimes(data []Candlestick, opts RegimeOptions) []RegimeReading {
+	readings := make([]RegimeReading, len(data))
+	if len(data) == 0 {
+		return readings
+	}
+
+	closes := closePrices(data)
+	high := make([]float64, len(data))
+	low := make([]float64, len(data))
+	for i, candle := range data {
+		high[i] = candle.High
+		low[i] = candle.Low
+	}
+
+	measures := [][]float64{
+		calculateADX(data, opts.ADXWindow),
+		calculateATR(high, low, closes, opts.ATRWindow),
+		calculateBollingerBandwidth(closes, opts.BandWindow, opts.BandStdDev),
+		calculateEfficiencyRatio(closes, opts.EfficiencyWindow),
+	}
+	for i := range measures {
+		if measures[i] == nil {
+			measures[i] = nanSlice(len(data))
+		}
+	}
+	adx, atr, bandwidth, efficiency := measures[0], measures[1], measures[2], measures[3]
+
+	atrPercent := make([]float64, len(data))
+	for i := range data {
+		atrPercent[i] = atr[i] / closes[i] * 100
+	}
+	atrPercentile := calculateVolatilityPercentile(atrPercent, opts.VolatilityLookback)
+	bandwidthPercentile := calculateVolatilityPercentile(bandwidth, opts.VolatilityLookback)
+
+	for i := range data {
+		readings[i] = RegimeReading{
+			ADX:                 adx[i],
+			ATRPercent:          atrPercent[i],
+			Bandwidth:           bandwidth[i],
+			Efficiency:          efficiency[i],
+			ATRPercentile:       atrPercentile[i],
+			BandwidthPercentile: bandwidthPercentile[i],
+		}
+	}
+	assignRegimes(readings, opts)
+	return readings
+}
+
+// assignRegimes sets the regime of readings ordered by time from their
+// measures. Readings missing a measure are RegimeUnknown.
+func assignRegimes(readings []RegimeReading, opts RegimeOptions) {
+	current, candidate, held := RegimeUnknown, RegimeUnknown, 0
+	for i, reading := range readings {
+		if math.IsNaN(reading.ADX) || math.IsNaN(reading.Efficiency) || math.IsNaN(reading.ATRPercentile) || math.IsNaN(reading.BandwidthPercentile) {
+			continue
+		}
+
+		volatility := math.Max(reading.ATRPercentile, reading.BandwidthPercentile)
+		var next MarketRegime
+		switch {
+		case volatility >= opts.VolatileEnter, current == RegimeVolatile && volatility >= opts.VolatileExit:
+			next = RegimeVolatile
+		case reading.ADX >= opts.TrendEnter && reading.Efficiency >= opts.MinEfficiency,
+			current == RegimeTrending && reading.ADX >= opts.TrendExit:
+			next = RegimeTrending
+		default:
+			next = RegimeRanging
+		}
+
+		// The first classification is taken as is, later changes need confirming
+		if next == current || current == RegimeUnknown {
+			current, candidate, held = next, next, 0
+		} else {
+			if next != candidate {
+				candidate, held = next, 0
+			}
+			held++
+			if held >= opts.ConfirmBars {
+				current, held = next, 0
+			}
+		}
+		readings[i].Regime = current
+	}
+}
+
+// RegimeSnapshot is the current regime of a symbol and interval. Since is the
+// open time of the bar at which the regime started, as far back as the
+// candles go or, for a regime that was already published, as the published
+// one.
+type RegimeSnapshot struct {
+	Symbol   string
+	Interval string
+	Regime   MarketRegime
+	Since    time.Time
+	Reading  RegimeReading
+}
+
+// regimeSnapshot classifies the candles and returns the regime of the last
+// bar.
+func regimeSnapshot(symbol, interval string, data []Candlestick, opts RegimeOptions) (RegimeSnapshot, bool) {
+	readings := classifyRegimes(data, opts)
+	if len(readings) == 0 || readings[len(readings)-1].Regime == RegimeUnknown {
+		return RegimeSnapshot{}, false
+	}
+
+	last := len(readings) - 1
+	start := last
+	for start > 0 && readings[start-1].Regime == readings[last].Regime {
+		start--
+	}
+	return RegimeSnapshot{
+		Symbol:   symbol,
+		Interval: interval,
+		Regime:   readings[last].Regime,
+		Since:    data[start].OpenTime,
+		Reading:  readings[last],
+	}, true
+}
+
+// RegimeTracker keeps the current regime of each symbol for an interval and,
+// when a cache is set, publishes it under RegimeKeyPrefix+interval+":"+symbol.
+type RegimeTracker struct {
+	interval string
+	opts     RegimeOptions
+	cache    *Cache
+
+	mu      sync.Mutex
+	regimes map[string]RegimeSnapshot
+}
+
+func NewRegimeTracker(interval string, opts RegimeOptions, cache *Cache) *RegimeTracker {
+	return &RegimeTracker{
+		interval: interval,
+		opts:     opts,
+		cache:    cache,
+		regimes:  make(map[string]RegimeSnapshot),
+	}
+}
+
+// Update classifies the symbol's candles and stores the resulting regime.
+func (t *RegimeTracker) Update(symbol string, data []Candlestick) (RegimeSnapshot, bool) {
+	snapshot, ok := regimeSnapshot(symbol, t.interval, data, t.opts)
+	if !ok {
+		return RegimeSnapshot{}, false
+	}
+
+	// A regime that outlasted the candles, or a restart, keeps its start
+	previous, found := t.Current(symbol)
+	if !found && t.cache != nil {
+		var err error
+		if previous, found, err = getRegime(t.cache, symbol, t.interval); err != nil {
+			log.Printf("Error reading regime for symbol %s: %v\n", symbol, err)
+		}
+	}
+	if found && previous.Regime == snapshot.Regime && previous.Since.Before(snapshot.Since) {
+		snapshot.Since = previous.Since
+	}
+
+	t.mu.Lock()
+	t.regimes[symbol] = snapshot
+	t.mu.Unlock()
+
+	if t.cache != nil {
+		if err := t.cache.Set(RegimeKeyPrefix+t.interval+":"+symbol, snapshot, 0); err != nil {
+			log.Printf("Error publishing regime for symbol %s: %v\n", symbol, err)
+		}
+	}
+	return snapshot, true
+}
+
+// Current returns the latest regime of the symbol.
+func (t *RegimeTracker) Current(symbol string) (RegimeSnapshot, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	snapshot, ok := t.regimes[symbol]
+	return snapshot, ok
+}
+
+// Symbols returns the symbols currently in the given regime.
+func (t *RegimeTracker) Symbols(regime MarketRegime) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var symbols []string
+	for symbol, snapshot := range t.regimes {
+		if snapshot.Regime == regime {
+			symbols = append(symbols, symbol)
+		}
+	}
+	return symbols
+}
+
+// getRegime reads a symbol's published regime from the cache.
+func getRegime(cache *Cache, symbol, interval string) (RegimeSnapshot, bool, error) {
+	var snapshot RegimeSnapshot
+	found, err := cache.Get(RegimeKeyPrefix+interval+":"+symbol, &snapshot)
+	return snapshot, found, err
+}
+
+// regimeRoutine reclassifies every symbol from its cached candles periodically.
+func regimeRoutine(cache *Cache, tracker *RegimeTracker, symbols []string, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for ; true; <-ticker.C {
+		for _, symbol := range symbols {
+			candles, err := cache.GetCandlesticks(symbol)
+			if err != nil {
+				log.Printf("Error reading candles for symbol %s: %v\n", symbol, err)
+				continue
+			}
+			tracker.Update(symbol, candles)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// regimeReadings returns readings of the ADX and the volatility percentile,
+// with a trending efficiency ratio.
+func regimeReadings(values ...[2]float64) []RegimeReading {
+	readings := make([]RegimeReading, len(values))
+	for i, v := range values {
+		readings[i] = RegimeReading{ADX: v[0], Efficiency: 0.5, ATRPercentile: v[1], BandwidthPercentile: v[1]}
+	}
+	return readings
+}
+
+func regimesOf(readings []RegimeReading) []MarketRegime {
+	regimes := make([]MarketRegime, len(readings))
+	for i, reading := range readings {
+		regimes[i] = reading.Regime
+	}
+	return regimes
+}
+
+func sameRegimes(a, b []MarketRegime) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestAssignRegimesHysteresis(t *testing.T) {
+	opts := defaultRegimeOptions()
+	opts.ConfirmBars = 1
+	nan := math.NaN()
+
+	// Trends from an ADX of 25 until it falls below 20, is volatile from the
+	// 90th percentile until it falls below the 70th
+	readings := regimeReadings(
+		[2]float64{nan, 50}, [2]float64{10, 50}, [2]float64{22, 50}, [2]float64{26, 50},
+		[2]float64{21, 50}, [2]float64{19, 50}, [2]float64{22, 50}, [2]float64{22, 92},
+		[2]float64{22, 75}, [2]float64{22, 65}, [2]float64{30, 85},
+	)
+	assignRegimes(readings, opts)
+	want := []MarketRegime{
+		RegimeUnknown, RegimeRanging, RegimeRanging, RegimeTrending,
+		RegimeTrending, RegimeRanging, RegimeRanging, RegimeVolatile,
+		RegimeVolatile, RegimeRanging, RegimeTrending,
+	}
+	if got := regimesOf(readings); !sameRegimes(got, want) {
+		t.Errorf("got regimes %v, want %v", got, want)
+	}
+
+	// An ADX above the threshold is no trend when the price goes nowhere
+	readings = regimeReadings([2]float64{30, 50})
+	readings[0].Efficiency = 0.1
+	assignRegimes(readings, opts)
+	if readings[0].Regime != RegimeRanging {
+		t.Errorf("inefficient move is %v, want ranging", readings[0].Regime)
+	}
+}
+
+func TestAssignRegimesConfirmBars(t *testing.T) {
+	// A volatile blip of two bars is ignored, a trend is taken on its third
+	// bar
+	readings := regimeReadings(
+		[2]float64{10, 50}, [2]float64{10, 95}, [2]float64{10, 95}, [2]float64{10, 50},
+		[2]float64{30, 50}, [2]float64{30, 50}, [2]float64{30, 50}, [2]float64{30, 50},
+	)
+	assignRegimes(readings, defaultRegimeOptions())
+	want := []MarketRegime{
+		RegimeRanging, RegimeRanging, RegimeRanging, RegimeRanging,
+		RegimeRanging, RegimeRanging, RegimeTrending, RegimeTrending,
+	}
+	if got := regimesOf(readings); !sameRegimes(got, want) {
+		t.Errorf("got regimes %v, want %v", got, want)
+	}
+}
+
+// regimeCandles oscillates for 120 bars, trends for 45 and then swings
+// wildly.
+func regimeCandles() []Candlestick {
+	return walkCandles(time.Unix(1700000040, 0), 200, func(i int) float64 {
+		switch {
+		case i < 120:
+			return 0.05 * (math.Sin(2*math.Pi*float64(i)/20) - math.Sin(2*math.Pi*float64(i-1)/20))
+		case i < 165:
+			return 0.004
+		case i%2 == 0:
+			return 0.04
+		}
+		return -0.04
+	})
+}
+
+// regimeChanges returns the bars at which the regime changes.
+func regimeChanges(readings []RegimeReading) map[int]MarketRegime {
+	changes := make(map[int]MarketRegime)
+	for i := range readings {
+		if i > 0 && readings[i].Regime != readings[i-1].Regime {
+			changes[i] = readings[i].Regime
+		}
+	}
+	return changes
+}
+
+func TestClassifyRegimes(t *testing.T) {
+	data := regimeCandles()
+
+	// The oscillation ranges, the steady climb trends and the swings are
+	// volatile
+	readings := classifyRegimes(data, defaultRegimeOptions())
+	changes := regimeChanges(readings)
+	want := map[int]MarketRegime{69: RegimeRanging, 136: RegimeTrending, 169: RegimeVolatile}
+	if len(changes) != len(want) {
+		t.Fatalf("got changes %v, want %v", changes, want)
+	}
+	for i, regime := range want {
+		if changes[i] != regime {
+			t.Errorf("bar %d is %v, want a change to %v", i, changes[i], regime)
+		}
+	}
+
+	// Without confirmation the changes come two bars earlier, along with
+	// short lived ones
+	opts := defaultRegimeOptions()
+	opts.ConfirmBars = 1
+	unconfirmed := regimeChanges(classifyRegimes(data, opts))
+	if len(unconfirmed) <= len(changes) {
+		t.Errorf("got changes %v without confirmation, want more than %v", unconfirmed, changes)
+	}
+	if unconfirmed[134] != RegimeTrending || unconfirmed[167] != RegimeVolatile {
+		t.Errorf("got changes %v without confirmation, want trending at 134 and volatile at 167", unconfirmed)
+	}
+}
+
+func TestRegimeTracker(t *testing.T) {
+	cache := newTestCache(t)
+	data := regimeCandles()
+
+	tracker := NewRegimeTracker("1m", defaultRegimeOptions(), cache)
+	snapshot, ok := tracker.Update("REGUSDT", data)
+	if !ok || snapshot.Regime != RegimeVolatile || !snapshot.Since.Equal(data[169].OpenTime) {
+		t.Fatalf("got %+v, want volatile since bar 169", snapshot)
+	}
+	published, found, err := getRegime(cache, "REGUSDT", "1m")
+	if err != nil || !found || published.Regime != RegimeVolatile || !published.Since.Equal(snapshot.Since) {
+		t.Fatalf("published %+v, found %v, error %v", published, found, err)
+	}
+	if symbols := tracker.Symbols(RegimeVolatile); len(symbols) != 1 || symbols[0] != "REGUSDT" {
+		t.Errorf("volatile symbols = %v, want REGUSDT", symbols)
+	}
+
+	// After a restart a regime that began before the candles keeps its start
+	published.Since = data[0].OpenTime.Add(-time.Hour)
+	if err := cache.Set(RegimeKeyPrefix+"1m:REGUSDT", published, 0); err != nil {
+		t.Fatal(err)
+	}
+	restarted := NewRegimeTracker("1m", defaultRegimeOptions(), cache)
+	if snapshot, _ := restarted.Update("REGUSDT", data); !snapshot.Since.Equal(published.Since) {
+		t.Errorf("regime since %v after a restart, want %v", snapshot.Since, published.Since)
+	}
+
+	// A different regime starts from the candles
+	published.Regime = RegimeTrending
+	if err := cache.Set(RegimeKeyPrefix+"1m:REGUSDT", published, 0); err != nil {
+		t.Fatal(err)
+	}
+	restarted = NewRegimeTracker("1m", defaultRegimeOptions(), cache)
+	if snapshot, _ := restarted.Update("REGUSDT", data); !snapshot.Since.Equal(data[169].OpenTime) {
+		t.Errorf("regime since %v after a change, want %v", snapshot.Since, data[169].OpenTime)
+	}
+}
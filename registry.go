@@ -0,0 +1,664 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// IndicatorParam is a numeric parameter of an indicator. Integer parameters
+// such as windows must be whole numbers from 1 to Max. MAType parameters hold
+// the MAType of a moving average and are given by name, e.g. "ema".
+type IndicatorParam struct {
+	Name    string
+	Default float64
+	Integer bool
+	Max     float64
+	MAType  bool
+}
+
+// IndicatorSpec describes an indicator for the registry. Source indicators
+// take a price series as their first argument, the others work on the
+// symbol's candles. The first output is the one used when no output is
+// selected.
+type IndicatorSpec struct {
+	Name        string
+	Description string
+	Source      bool
+	Params      []IndicatorParam
+	Outputs     []string
+
+	compute func(source []float64, data []Candlestick, params []float64) [][]float64
+}
+
+var indicatorRegistry = make(map[string]IndicatorSpec)
+
+func registerIndicator(spec IndicatorSpec) {
+	if _, exists := indicatorRegistry[spec.Name]; exists {
+		panic(fmt.Sprintf("indicator %q registered twice", spec.Name))
+	}
+	if len(spec.Outputs) == 0 {
+		spec.Outputs = []string{"value"}
+	}
+	indicatorRegistry[spec.Name] = spec
+}
+
+// registeredIndicators returns the registered indicators ordered by name.
+func registeredIndicators() []IndicatorSpec {
+	specs := make([]IndicatorSpec, 0, len(indicatorRegistry))
+	for _, spec := range indicatorRegistry {
+		specs = append(specs, spec)
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Name < specs[j].Name })
+	return specs
+}
+
+// outputIndex returns the index of the named output, or -1.
+func (s IndicatorSpec) outputIndex(name string) int {
+	for i, output := range s.Outputs {
+		if output == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// run computes the indicator, filling outputs the indicator could not compute
+// (e.g. too little data) with NaN.
+func (s IndicatorSpec) run(source []float64, data []Candlestick, params []float64) [][]float64 {
+	outputs := s.compute(source, data, params)
+	if len(outputs) != len(s.Outputs) {
+		outputs = make([][]float64, len(s.Outputs))
+	}
+	for i, output := range outputs {
+		switch {
+		case output == nil:
+			outputs[i] = nanSlice(len(data))
+		case len(output) > len(data):
+			// Forward-displaced lines are cut at the last bar
+			outputs[i] = output[:len(data)]
+		}
+	}
+	return outputs
+}
+
+// candleSource returns a price or volume series of the candles by name.
+func candleSource(data []Candlestick, name string) ([]float64, bool) {
+	var value func(candle Candlestick) float64
+	switch name {
+	case "open":
+		value = func(c Candlestick) float64 { return c.Open }
+	case "high":
+		value = func(c Candlestick) float64 { return c.High }
+	case "low":
+		value = func(c Candlestick) float64 { return c.Low }
+	case "close":
+		value = func(c Candlestick) float64 { return c.Close }
+	case "volume":
+		value = func(c Candlestick) float64 { return c.Volume }
+	case "quote_volume":
+		value = func(c Candlestick) float64 { return c.QuoteAssetVolume }
+	case "taker_buy_volume":
+		value = func(c Candlestick) float64 { return c.TakerBuyBaseAssetVolume }
+	case "hl2":
+		value = func(c Candlestick) float64 { return (c.High + c.Low) / 2 }
+	case "hlc3":
+		value = func(c Candlestick) float64 { return (c.High + c.Low + c.Close) / 3 }
+	case "ohlc4":
+		value = func(c Candlestick) float64 { return (c.Open + c.High + c.Low + c.Close) / 4 }
+	default:
+		return nil, false
+	}
+
+	series := make([]float64, len(data))
+	for i, candle := range data {
+		series[i] = value(candle)
+	}
+	return series, true
+}
+
+func mustCandleSource(data []Candlestick, name string) []float64 {
+	series, _ := candleSource(data, name)
+	return series
+}
+
+// windowParam is a window or offset in bars, at most the number of candles the
+// cache holds.
+func windowParam(name string, def float64) IndicatorParam {
+	return IndicatorParam{Name: name, Default: def, Integer: true, Max: KlineCacheMaxSize}
+}
+
+func factorParam(name string, def float64) IndicatorParam {
+	return IndicatorParam{Name: name, Default: def}
+}
+
+func maParam(name string, def MAType) IndicatorParam {
+	return IndicatorParam{Name: name, Default: float64(def), MAType: true}
+}
+
+func oneOutput(values []float64) [][]float64 {
+	return [][]float64{values}
+}
+
+func threeOutputs(a, b, c []float64) [][]float64 {
+	return [][]float64{a, b, c}
+}
+
+func init() {
+	// Moving averages and momentum on a source series
+	registerIndicator(IndicatorSpec{
+		Name: "sma", Description: "Simple moving average", Source: true,
+		Params: []IndicatorParam{windowParam("window", 20)},
+		compute: func(src []float64, _ []Candlestick, p []float64) [][]float64 {
+			return oneOutput(calculateSMA(src, int(p[0])))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "ema", Description: "Exponential moving average", Source: true,
+		Params: []IndicatorParam{windowParam("window", 20)},
+		compute: func(src []float64, _ []Candlestick, p []float64) [][]float64 {
+			return oneOutput(calculateEMA(src, int(p[0])))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "wma", Description: "Weighted moving average", Source: true,
+		Params: []IndicatorParam{windowParam("window", 20)},
+		compute: func(src []float64, _ []Candlestick, p []float64) [][]float64 {
+			return oneOutput(calculateWMA(src, int(p[0])))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "hma", Description: "Hull moving average", Source: true,
+		Params: []IndicatorParam{windowParam("window", 20)},
+		compute: func(src []float64, _ []Candlestick, p []float64) [][]float64 {
+			return oneOutput(calculateHMA(src, int(p[0])))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "ma", Description: "Moving average of the selected type", Source: true,
+		Params: []IndicatorParam{windowParam("window", 20), maParam("ma", SimpleMA)},
+		compute: func(src []float64, _ []Candlestick, p []float64) [][]float64 {
+			return oneOutput(calculateMA(src, MAType(p[1]), int(p[0])))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "rma", Description: "Wilder's moving average", Source: true,
+		Params: []IndicatorParam{windowParam("window", 14)},
+		compute: func(src []float64, _ []Candlestick, p []float64) [][]float64 {
+			return oneOutput(calculateRMA(src, int(p[0])))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "dema", Description: "Double exponential moving average", Source: true,
+		Params: []IndicatorParam{windowParam("window", 20)},
+		compute: func(src []float64, _ []Candlestick, p []float64) [][]float64 {
+			return oneOutput(calculateDEMA(src, int(p[0])))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "tema", Description: "Triple exponential moving average", Source: true,
+		Params: []IndicatorParam{windowParam("window", 20)},
+		compute: func(src []float64, _ []Candlestick, p []float64) [][]float64 {
+			return oneOutput(calculateTEMA(src, int(p[0])))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "vwma", Description: "Volume-weighted moving average", Source: true,
+		Params: []IndicatorParam{windowParam("window", 20)},
+		compute: func(src []float64, data []Candlestick, p []float64) [][]float64 {
+			return oneOutput(calculateVolumeWeightedMovingAverage(src, mustCandleSource(data, "volume"), int(p[0])))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "rsi", Description: "Relative strength index", Source: true,
+		Params: []IndicatorParam{windowParam("window", 14)},
+		compute: func(src []float64, _ []Candlestick, p []float64) [][]float64 {
+			return oneOutput(calculateRSI(src, int(p[0])))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "momentum", Description: "Price change over window bars", Source: true,
+		Params: []IndicatorParam{windowParam("window", 10)},
+		compute: func(src []float64, _ []Candlestick, p []float64) [][]float64 {
+			return oneOutput(calculateMomentum(src, int(p[0])))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "trix", Description: "Rate of change of a triple EMA", Source: true,
+		Params: []IndicatorParam{windowParam("window", 15), maParam("ma", ExponentialMA)},
+		compute: func(src []float64, _ []Candlestick, p []float64) [][]float64 {
+			return oneOutput(calculateTRIXWithMA(src, int(p[0]), MAType(p[1])))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "kama", Description: "Kaufman adaptive moving average", Source: true,
+		Params: []IndicatorParam{windowParam("window", 10), windowParam("fast", 2), windowParam("slow", 30)},
+		compute: func(src []float64, _ []Candlestick, p []float64) [][]float64 {
+			return oneOutput(calculateKAMA(src, int(p[0]), int(p[1]), int(p[2])))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "stochrsi", Description: "Stochastic RSI", Source: true,
+		Params:  []IndicatorParam{windowParam("rsi", 14), windowParam("stoch", 14), windowParam("k", 3), windowParam("d", 3), maParam("ma", SimpleMA)},
+		Outputs: []string{"k", "d"},
+		compute: func(src []float64, _ []Candlestick, p []float64) [][]float64 {
+			k, d := calculateStochasticRSIWithOptions(src, StochasticRSIOptions{
+				RSIWindow: int(p[0]), StochWindow: int(p[1]), KWindow: int(p[2]), DWindow: int(p[3]), MAType: MAType(p[4]),
+			})
+			return [][]float64{k, d}
+		},
+	})
+
+	// Cycle and adaptive filters
+	registerIndicator(IndicatorSpec{
+		Name: "supersmoother", Description: "Ehlers super smoother", Source: true,
+		Params: []IndicatorParam{windowParam("period", 10)},
+		compute: func(src []float64, _ []Candlestick, p []float64) [][]float64 {
+			return oneOutput(calculateSuperSmoother(src, int(p[0])))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "roofing", Description: "Ehlers roofing filter", Source: true,
+		Params: []IndicatorParam{windowParam("highpass", 48), windowParam("smooth", 10)},
+		compute: func(src []float64, _ []Candlestick, p []float64) [][]float64 {
+			return oneOutput(calculateRoofingFilter(src, int(p[0]), int(p[1])))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "dcperiod", Description: "Hilbert transform dominant cycle period", Source: true,
+		compute: func(src []float64, _ []Candlestick, _ []float64) [][]float64 {
+			return oneOutput(calculateDominantCycle(src))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "mama", Description: "MESA adaptive moving average", Source: true,
+		Params:  []IndicatorParam{factorParam("fast", 0.5), factorParam("slow", 0.05)},
+		Outputs: []string{"mama", "fama"},
+		compute: func(src []float64, _ []Candlestick, p []float64) [][]float64 {
+			mama, fama := calculateMAMA(src, p[0], p[1])
+			return [][]float64{mama, fama}
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "kalman", Description: "Kalman filter", Source: true,
+		Params: []IndicatorParam{factorParam("process_noise", 0.01), factorParam("measurement_noise", 1)},
+		compute: func(src []float64, _ []Candlestick, p []float64) [][]float64 {
+			return oneOutput(calculateKalmanFilter(src, p[0], p[1]))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "hurst", Description: "Rolling Hurst exponent", Source: true,
+		Params: []IndicatorParam{windowParam("window", 100), windowParam("max_lag", 20)},
+		compute: func(src []float64, _ []Candlestick, p []float64) [][]float64 {
+			return oneOutput(calculateHurstExponent(src, int(p[0]), int(p[1])))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "fractaldim", Description: "Ehlers fractal dimension", Source: true,
+		Params: []IndicatorParam{windowParam("window", 30)},
+		compute: func(src []float64, _ []Candlestick, p []float64) [][]float64 {
+			return oneOutput(calculateFractalDimension(src, int(p[0])))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "returns", Description: "Percentage returns over window bars", Source: true,
+		Params: []IndicatorParam{windowParam("window", 1)},
+		compute: func(src []float64, _ []Candlestick, p []float64) [][]float64 {
+			return oneOutput(calculateReturns(src, int(p[0])))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "macd", Description: "Moving average convergence divergence", Source: true,
+		Params: []IndicatorParam{
+			windowParam("short", 12), windowParam("long", 26), windowParam("signal", 9),
+			maParam("ma", ExponentialMA), maParam("signalma", ExponentialMA),
+		},
+		Outputs: []string{"line", "signal", "hist"},
+		compute: func(src []float64, _ []Candlestick, p []float64) [][]float64 {
+			return threeOutputs(calculateMACDWithOptions(src, MACDOptions{
+				ShortWindow: int(p[0]), LongWindow: int(p[1]), SignalWindow: int(p[2]), MAType: MAType(p[3]), SignalMAType: MAType(p[4]),
+			}))
+		},
+	})
+
+	// Bands on a source series
+	registerIndicator(IndicatorSpec{
+		Name: "bb", Description: "Bollinger Bands", Source: true,
+		Params:  []IndicatorParam{windowParam("window", 20), factorParam("stddev", 2), maParam("ma", SimpleMA)},
+		Outputs: []string{"middle", "upper", "lower"},
+		compute: func(src []float64, _ []Candlestick, p []float64) [][]float64 {
+			upper, middle, lower := calculateBollingerBandsWithOptions(src, BollingerOptions{Window: int(p[0]), NumStdDev: p[1], MAType: MAType(p[2])})
+			return threeOutputs(middle, upper, lower)
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "percentb", Description: "Bollinger %B", Source: true,
+		Params: []IndicatorParam{windowParam("window", 20), factorParam("stddev", 2)},
+		compute: func(src []float64, _ []Candlestick, p []float64) [][]float64 {
+			return oneOutput(calculateBollingerPercentB(src, int(p[0]), p[1]))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "bandwidth", Description: "Bollinger bandwidth", Source: true,
+		Params: []IndicatorParam{windowParam("window", 20), factorParam("stddev", 2)},
+		compute: func(src []float64, _ []Candlestick, p []float64) [][]float64 {
+			return oneOutput(calculateBollingerBandwidth(src, int(p[0]), p[1]))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "envelope", Description: "Percentage envelopes around an SMA", Source: true,
+		Params:  []IndicatorParam{windowParam("window", 20), factorParam("percent", 2.5)},
+		Outputs: []string{"middle", "upper", "lower"},
+		compute: func(src []float64, _ []Candlestick, p []float64) [][]float64 {
+			upper, middle, lower := calculateEnvelopes(src, int(p[0]), p[1])
+			return threeOutputs(middle, upper, lower)
+		},
+	})
+
+	// Candle-based indicators
+	registerIndicator(IndicatorSpec{
+		Name: "atr", Description: "Average true range",
+		Params: []IndicatorParam{windowParam("window", 14), maParam("ma", WilderMA)},
+		compute: func(_ []float64, data []Candlestick, p []float64) [][]float64 {
+			opts := ATROptions{Window: int(p[0]), MAType: MAType(p[1])}
+			return oneOutput(calculateATRWithOptions(mustCandleSource(data, "high"), mustCandleSource(data, "low"), mustCandleSource(data, "close"), opts))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "adx", Description: "Average directional index",
+		Params: []IndicatorParam{windowParam("window", 14), maParam("ma", WilderMA)},
+		compute: func(_ []float64, data []Candlestick, p []float64) [][]float64 {
+			window, maType := int(p[0]), MAType(p[1])
+			return oneOutput(calculateADXWithOptions(data, ADXOptions{DIWindow: window, DIMAType: maType, ADXWindow: window, ADXMAType: maType}))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "dmi", Description: "Directional movement index",
+		Params:  []IndicatorParam{windowParam("window", 14), maParam("ma", WilderMA)},
+		Outputs: []string{"plus", "minus"},
+		compute: func(_ []float64, data []Candlestick, p []float64) [][]float64 {
+			plus, minus := calculateDMIWithOptions(data, ADXOptions{DIWindow: int(p[0]), DIMAType: MAType(p[1])})
+			return [][]float64{plus, minus}
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "supertrend", Description: "Supertrend line and direction",
+		Params:  []IndicatorParam{windowParam("atr", 10), factorParam("multiplier", 3), maParam("atrma", WilderMA)},
+		Outputs: []string{"value", "direction"},
+		compute: func(_ []float64, data []Candlestick, p []float64) [][]float64 {
+			supertrend, direction := calculateSupertrendWithOptions(data, SupertrendOptions{ATRWindow: int(p[0]), Multiplier: p[1], ATRMAType: MAType(p[2])})
+			return [][]float64{supertrend, direction}
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "aroon", Description: "Aroon up, down and oscillator",
+		Params:  []IndicatorParam{windowParam("window", 25)},
+		Outputs: []string{"up", "down", "osc"},
+		compute: func(_ []float64, data []Candlestick, p []float64) [][]float64 {
+			return threeOutputs(calculateAroon(data, int(p[0])))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "cci", Description: "Commodity channel index",
+		Params: []IndicatorParam{windowParam("window", 20)},
+		compute: func(_ []float64, data []Candlestick, p []float64) [][]float64 {
+			return oneOutput(calculateCCI(data, int(p[0])))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "willr", Description: "Williams %R",
+		Params: []IndicatorParam{windowParam("window", 14)},
+		compute: func(_ []float64, data []Candlestick, p []float64) [][]float64 {
+			return oneOutput(calculateWilliamsR(data, int(p[0])))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "vortex", Description: "Vortex indicator",
+		Params:  []IndicatorParam{windowParam("window", 14)},
+		Outputs: []string{"plus", "minus"},
+		compute: func(_ []float64, data []Candlestick, p []float64) [][]float64 {
+			plus, minus := calculateVortex(data, int(p[0]))
+			return [][]float64{plus, minus}
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "ultosc", Description: "Ultimate oscillator",
+		Params: []IndicatorParam{windowParam("short", 7), windowParam("medium", 14), windowParam("long", 28)},
+		compute: func(_ []float64, data []Candlestick, p []float64) [][]float64 {
+			return oneOutput(calculateUltimateOscillator(data, int(p[0]), int(p[1]), int(p[2])))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "stoch", Description: "Stochastic oscillator",
+		Params:  []IndicatorParam{windowParam("window", 14), maParam("ma", SimpleMA)},
+		Outputs: []string{"k", "d"},
+		compute: func(_ []float64, data []Candlestick, p []float64) [][]float64 {
+			opts := StochasticOptions{KWindow: int(p[0]), KSmoothing: 1, DWindow: int(p[0]), MAType: MAType(p[1])}
+			k, d := calculateStochasticOscillatorWithOptions(data, opts)
+			return [][]float64{k, d}
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "psar", Description: "Parabolic SAR",
+		compute: func(_ []float64, data []Candlestick, _ []float64) [][]float64 {
+			return oneOutput(calculateParabolicSAR(mustCandleSource(data, "high"), mustCandleSource(data, "low")))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "chaikinvol", Description: "Mean high-low range",
+		Params: []IndicatorParam{windowParam("window", 10)},
+		compute: func(_ []float64, data []Candlestick, p []float64) [][]float64 {
+			return oneOutput(calculateChaikinVolatility(mustCandleSource(data, "high"), mustCandleSource(data, "low"), int(p[0])))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "obv", Description: "On-balance volume",
+		compute: func(_ []float64, data []Candlestick, _ []float64) [][]float64 {
+			return oneOutput(calculateOBV(data))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "mfi", Description: "Money flow index",
+		Params: []IndicatorParam{windowParam("window", 14)},
+		compute: func(_ []float64, data []Candlestick, p []float64) [][]float64 {
+			return oneOutput(calculateMoneyFlowIndex(data, int(p[0])))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "ad", Description: "Accumulation/distribution line",
+		compute: func(_ []float64, data []Candlestick, _ []float64) [][]float64 {
+			return oneOutput(calculateAccumulationDistribution(data))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "cmf", Description: "Chaikin money flow",
+		Params: []IndicatorParam{windowParam("window", 20)},
+		compute: func(_ []float64, data []Candlestick, p []float64) [][]float64 {
+			return oneOutput(calculateChaikinMoneyFlow(data, int(p[0])))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "chaikinosc", Description: "Chaikin oscillator",
+		Params: []IndicatorParam{windowParam("short", 3), windowParam("long", 10), maParam("ma", ExponentialMA)},
+		compute: func(_ []float64, data []Candlestick, p []float64) [][]float64 {
+			return oneOutput(calculateChaikinOscillatorWithMA(data, int(p[0]), int(p[1]), MAType(p[2])))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "eom", Description: "Ease of movement",
+		Params: []IndicatorParam{windowParam("window", 14)},
+		compute: func(_ []float64, data []Candlestick, p []float64) [][]float64 {
+			return oneOutput(calculateEaseOfMovement(data, int(p[0])))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "force", Description: "Force index",
+		Params: []IndicatorParam{windowParam("window", 13), maParam("ma", ExponentialMA)},
+		compute: func(_ []float64, data []Candlestick, p []float64) [][]float64 {
+			return oneOutput(calculateForceIndexWithMA(data, int(p[0]), MAType(p[1])))
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "donchian", Description: "Donchian channels",
+		Params:  []IndicatorParam{windowParam("window", 20)},
+		Outputs: []string{"middle", "upper", "lower"},
+		compute: func(_ []float64, data []Candlestick, p []float64) [][]float64 {
+			upper, middle, lower := calculateDonchianChannels(data, int(p[0]))
+			return threeOutputs(middle, upper, lower)
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "keltner", Description: "Keltner channels",
+		Params: []IndicatorParam{
+			windowParam("ema", 20), windowParam("atr", 10), factorParam("multiplier", 2),
+			maParam("ma", ExponentialMA), maParam("atrma", WilderMA),
+		},
+		Outputs: []string{"middle", "upper", "lower"},
+		compute: func(_ []float64, data []Candlestick, p []float64) [][]float64 {
+			upper, middle, lower := calculateKeltnerChannelsWithOptions(data, KeltnerOptions{
+				Window: int(p[0]), ATRWindow: int(p[1]), Multiplier: p[2], MAType: MAType(p[3]), ATRMAType: MAType(p[4]),
+			})
+			return threeOutputs(middle, upper, lower)
+		},
+	})
+	// The chikou span looks ahead and is left out so studies cannot use it
+	registerIndicator(IndicatorSpec{
+		Name: "ichimoku", Description: "Ichimoku Kinko Hyo",
+		Params:  []IndicatorParam{windowParam("tenkan", 9), windowParam("kijun", 26), windowParam("senkou", 52), windowParam("displacement", 26)},
+		Outputs: []string{"tenkan", "kijun", "senkou_a", "senkou_b"},
+		compute: func(_ []float64, data []Candlestick, p []float64) [][]float64 {
+			ichimoku := calculateIchimoku(data, int(p[0]), int(p[1]), int(p[2]), int(p[3]))
+			return [][]float64{ichimoku.Tenkan, ichimoku.Kijun, ichimoku.SenkouA, ichimoku.SenkouB}
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "vwap", Description: "Rolling VWAP with standard deviation",
+		Params:  []IndicatorParam{windowParam("window", 20)},
+		Outputs: []string{"vwap", "stddev"},
+		compute: func(_ []float64, data []Candlestick, p []float64) [][]float64 {
+			vwap := calculateRollingVWAP(data, int(p[0]))
+			return [][]float64{vwap.VWAP, vwap.StdDev}
+		},
+	})
+	registerIndicator(IndicatorSpec{
+		Name: "imbalance", Description: "Taker flow imbalance from candles",
+		Params: []IndicatorParam{windowParam("window", 20)},
+		compute: func(_ []float64, data []Candlestick, p []float64) [][]float64 {
+			return oneOutput(calculateFlowImbalance(calculateCandleOrderFlow(data), int(p[0])))
+		},
+	})
+
+	// Divergences between the price pivots and an oscillator, 1 bullish and
+	// -1 bearish at the bar confirming them
+	registerIndicator(IndicatorSpec{
+		Name: "divergence", Description: "Price and oscillator divergences", Source: true,
+		Params: []IndicatorParam{
+			windowParam("left", 5), windowParam("right", 5),
+			windowParam("min_distance", 5), windowParam("max_distance", 60),
+		},
+		Outputs: []string{"regular", "hidden"},
+		compute: func(src []float64, data []Candlestick, p []float64) [][]float64 {
+			opts := DivergenceOptions{LeftBars: int(p[0]), RightBars: int(p[1]), MinDistance: int(p[2]), MaxDistance: int(p[3])}
+			regular, hidden := divergenceSeries(len(data), detectDivergences(data, src, opts))
+			return [][]float64{regular, hidden}
+		},
+	})
+
+	// Pivot levels of each bar's period from the previous period, days=7 for
+	// weekly pivots
+	for method, name := range pivotMethodNames {
+		method := method
+		registerIndicator(IndicatorSpec{
+			Name: "pivot_" + name, Description: "Pivot points",
+			Params:  []IndicatorParam{windowParam("days", 1)},
+			Outputs: []string{"pivot", "r1", "r2", "r3", "r4", "s1", "s2", "s3", "s4"},
+			compute: func(_ []float64, data []Candlestick, p []float64) [][]float64 {
+				series, err := pivotSeries(data, fmt.Sprintf("%dd", int(p[0])), method)
+				if err != nil {
+					return nil
+				}
+				return series
+			},
+		})
+	}
+
+	// Chart transforms, sized by the ATR when the size is 0. Price-driven bars
+	// give each candle the latest bar completed by then
+	registerIndicator(IndicatorSpec{
+		Name: "heikinashi", Description: "Heikin-Ashi candles",
+		Outputs: []string{"close", "open", "high", "low"},
+		compute: func(_ []float64, data []Candlestick, _ []float64) [][]float64 {
+			ha := calculateHeikinAshi(data)
+			return [][]float64{
+				mustCandleSource(ha, "close"), mustCandleSource(ha, "open"),
+				mustCandleSource(ha, "high"), mustCandleSource(ha, "low"),
+			}
+		},
+	})
+	barTransform := func(name, description string, params []IndicatorParam, builder func(data []Candlestick, p []float64) BarBuilder) {
+		registerIndicator(IndicatorSpec{
+			Name: name, Description: description, Params: params,
+			Outputs: []string{"close", "open", "high", "low", "direction"},
+			compute: func(_ []float64, data []Candlestick, p []float64) [][]float64 {
+				return transformSeries(data, builder(data, p))
+			},
+		})
+	}
+	barTransform("renko", "Renko bricks", []IndicatorParam{factorParam("box_size", 0)}, func(data []Candlestick, p []float64) BarBuilder {
+		return NewRenkoBuilder(transformSize(data, p[0]))
+	})
+	barTransform("rangebars", "Range bars", []IndicatorParam{factorParam("range", 0)}, func(data []Candlestick, p []float64) BarBuilder {
+		return NewRangeBarBuilder(transformSize(data, p[0]))
+	})
+	barTransform("kagi", "Kagi lines", []IndicatorParam{factorParam("reversal", 0)}, func(data []Candlestick, p []float64) BarBuilder {
+		return NewKagiBuilder(transformSize(data, p[0]))
+	})
+	barTransform("pnf", "Point and figure columns", []IndicatorParam{factorParam("box_size", 0), windowParam("reversal_boxes", 3)}, func(data []Candlestick, p []float64) BarBuilder {
+		return NewPointAndFigureBuilder(transformSize(data, p[0]), int(p[1]))
+	})
+
+	// Volatility estimators, per bar unless periods_per_year is given
+	volatility := map[string]VolatilityEstimator{
+		"hv":             calculateCloseToCloseVolatility,
+		"parkinson":      calculateParkinsonVolatility,
+		"garmanklass":    calculateGarmanKlassVolatility,
+		"rogerssatchell": calculateRogersSatchellVolatility,
+		"yangzhang":      calculateYangZhangVolatility,
+	}
+	for name, estimator := range volatility {
+		estimator := estimator
+		registerIndicator(IndicatorSpec{
+			Name: name, Description: "Realized volatility",
+			Params: []IndicatorParam{windowParam("window", 20), factorParam("periods_per_year", 1)},
+			compute: func(_ []float64, data []Candlestick, p []float64) [][]float64 {
+				return oneOutput(estimator(data, int(p[0]), p[1]))
+			},
+		})
+	}
+}
+
+// validateParams checks explicit parameters against the spec and returns the
+// full parameter list with defaults filled in.
+func (s IndicatorSpec) validateParams(params []float64) ([]float64, error) {
+	if len(params) > len(s.Params) {
+		return nil, fmt.Errorf("%s takes at most %d parameters, got %d", s.Name, len(s.Params), len(params))
+	}
+
+	full := make([]float64, len(s.Params))
+	for i, param := range s.Params {
+		full[i] = param.Default
+		if i < len(params) {
+			full[i] = params[i]
+		}
+		if param.Integer && (full[i] != math.Trunc(full[i]) || full[i] <= 0) {
+			return nil, fmt.Errorf("%s parameter %s must be a positive integer, got %v", s.Name, param.Name, full[i])
+		}
+		if param.Integer && param.Max > 0 && full[i] > param.Max {
+			return nil, fmt.Errorf("%s parameter %s must be at most %v, got %v", s.Name, param.Name, param.Max, full[i])
+		}
+		if _, ok := maTypeNames[MAType(full[i])]; param.MAType && (!ok || full[i] != math.Trunc(full[i])) {
+			return nil, fmt.Errorf("%s parameter %s must be a moving average type", s.Name, param.Name)
+		}
+	}
+	return full, nil
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+)
+
+const (
+	KlineStream    = "kline"
+	AggTradeStream = "aggTrade"
+	DepthStream    = "depth"
+)
+
+var errReplayFinished = errors.New("replay finished")
+
+// RecordedEvent is a single stream event as delivered by the Binance client,
+// stamped with the local time it was received.
+type RecordedEvent struct {
+	Stream     string          `json:"stream"`
+	Symbol     string          `json:"symbol"`
+	Interval   string          `json:"interval,omitempty"` // kline interval or depth levels
+	ReceivedAt int64           `json:"receivedAt"`         // Unix nanoseconds
+	Event      json.RawMessage `json:"event"`
+}
+
+// StreamRecorder writes stream events to a gzip-compressed JSON lines file.
+type StreamRecorder struct {
+	mu        sync.Mutex
+	file      *os.File
+	gz        *gzip.Writer
+	enc       *json.Encoder
+	lastFlush time.Time
+}
+
+func NewStreamRecorder(path string) (*StreamRecorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	gz := gzip.NewWriter(f)
+	return &StreamRecorder{
+		file:      f,
+		gz:        gz,
+		enc:       json.NewEncoder(gz),
+		lastFlush: time.Now(),
+	}, nil
+}
+
+func (r *StreamRecorder) Record(stream, symbol, interval string, event interface{}) error {
+	receivedAt := time.Now()
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err = r.enc.Encode(RecordedEvent{
+		Stream:     stream,
+		Symbol:     symbol,
+		Interval:   interval,
+		ReceivedAt: receivedAt.UnixNano(),
+		Event:      data,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Flush about once a second so a killed process still leaves a usable file
+	if receivedAt.Sub(r.lastFlush) >= time.Second {
+		r.lastFlush = receivedAt
+		return r.gz.Flush()
+	}
+	return nil
+}
+
+func (r *StreamRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.gz.Close(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+// recordingStreamSource records every event of the wrapped source before
+// passing it on to the handler.
+type recordingStreamSource struct {
+	source   StreamSource
+	recorder *StreamRecorder
+}
+
+func (s recordingStreamSource) record(stream, symbol, interval string, event interface{}) {
+	if err := s.recorder.Record(stream, symbol, interval, event); err != nil {
+		log.Printf("Error recording %s event for symbol %s: %v\n", stream, symbol, err)
+	}
+}
+
+func (s recordingStreamSource) KlineServe(symbol string, interval string, handler binance.WsKlineHandler, errHandler binance.ErrHandler) (chan struct{}, chan struct{}, error) {
+	return s.source.KlineServe(symbol, interval, func(event *binance.WsKlineEvent) {
+		s.record(KlineStream, symbol, interval, event)
+		handler(event)
+	}, errHandler)
+}
+
+func (s recordingStreamSource) AggTradeServe(symbol string, handler binance.WsAggTradeHandler, errHandler binance.ErrHandler) (chan struct{}, chan struct{}, error) {
+	return s.source.AggTradeServe(symbol, func(event *binance.WsAggTradeEvent) {
+		s.record(AggTradeStream, symbol, "", event)
+		handler(event)
+	}, errHandler)
+}
+
+func (s recordingStreamSource) PartialDepthServe(symbol string, levels string, handler binance.WsPartialDepthHandler, errHandler binance.ErrHandler) (chan struct{}, chan struct{}, error) {
+	return s.source.PartialDepthServe(symbol, levels, func(event *binance.WsPartialDepthEvent) {
+		s.record(DepthStream, symbol, levels, event)
+		handler(event)
+	}, errHandler)
+}
+
+// replaySubscribeGrace is how long after the first subscription the replay
+// waits for a stream to be subscribed before skipping its events.
+const replaySubscribeGrace = 5 * time.Second
+
+// ReplayStreamSource feeds a recording made by StreamRecorder back through the
+// stream handlers. A single reader dispatches the events in recorded order,
+// keeping their recorded spacing divided by speed from the first
+// subscription; a speed of 0 replays as fast as the handlers consume them.
+// Every stream is served once, a second subscription returns
+// errReplayFinished so the WebSocket routines stop instead of reconnecting.
+type ReplayStreamSource struct {
+	path  string
+	speed float64
+	first int64
+
+	mu            sync.Mutex
+	start         time.Time
+	subscriptions map[string]*replaySubscription
+	served        map[string]bool
+	finished      bool
+	// changed is closed and replaced whenever a stream is subscribed
+	changed chan struct{}
+}
+
+type replaySubscription struct {
+	dispatch   func(payload json.RawMessage) error
+	errHandler binance.ErrHandler
+	doneC      chan struct{}
+	stopC      chan struct{}
+}
+
+func NewReplayStreamSource(path string, speed float64) (*ReplayStreamSource, error) {
+	first := int64(0)
+	err := readRecording(path, func(event *RecordedEvent) bool {
+		first = event.ReceivedAt
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReplayStreamSource{
+		path:          path,
+		speed:         speed,
+		first:         first,
+		subscriptions: make(map[string]*replaySubscription),
+		served:        make(map[string]bool),
+		changed:       make(chan struct{}),
+	}, nil
+}
+
+// readRecording calls fn for every event in the recording at path until fn
+// returns false.
+func readRecording(path string, fn func(event *RecordedEvent) bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	dec := json.NewDecoder(gz)
+	for {
+		var event RecordedEvent
+		if err := dec.Decode(&event); err != nil {
+			// A recording cut short by a killed process ends in a truncated gzip stream
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			return err
+		}
+		if !fn(&event) {
+			return nil
+		}
+	}
+}
+
+// Symbols returns the symbols present in the recording, sorted.
+func (s *ReplayStreamSource) Symbols() ([]string, error) {
+	seen := make(map[string]bool)
+	err := readRecording(s.path, func(event *RecordedEvent) bool {
+		seen[event.Symbol] = true
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	symbols := make([]string, 0, len(seen))
+	for symbol := range seen {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	return symbols, nil
+}
+
+func replayKey(stream, symbol, interval string) string {
+	return stream + "|" + symbol + "|" + interval
+}
+
+// wait blocks until the event received at receivedAt is due. It returns false
+// if stopC is closed first.
+func (s *ReplayStreamSource) wait(receivedAt int64, stopC chan struct{}) bool {
+	if s.speed <= 0 {
+		select {
+		case <-stopC:
+			return false
+		default:
+			return true
+		}
+	}
+
+	due := s.start.Add(time.Duration(float64(receivedAt-s.first) / s.speed))
+	timer := time.NewTimer(time.Until(due))
+	defer timer.Stop()
+
+	select {
+	case <-stopC:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// subscription returns the subscription of the stream key. A stream not
+// subscribed yet is waited for until replaySubscribeGrace after the start, nil
+// means its events are skipped.
+func (s *ReplayStreamSource) subscription(key string) *replaySubscription {
+	for {
+		s.mu.Lock()
+		sub, ok := s.subscriptions[key]
+		served := s.served[key]
+		changed := s.changed
+		s.mu.Unlock()
+
+		if ok {
+			return sub
+		}
+		remaining := time.Until(s.start.Add(replaySubscribeGrace))
+		if served || remaining <= 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(remaining)
+		select {
+		case <-changed:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+func (s *ReplayStreamSource) unsubscribe(key string, sub *replaySubscription) {
+	s.mu.Lock()
+	delete(s.subscriptions, key)
+	s.mu.Unlock()
+	close(sub.doneC)
+}
+
+// run reads the recording once and dispatches every event to the handler of
+// its stream, then ends all subscriptions.
+func (s *ReplayStreamSource) run() {
+	err := readRecording(s.path, func(event *RecordedEvent) bool {
+		key := replayKey(event.Stream, event.Symbol, event.Interval)
+		sub := s.subscription(key)
+		if sub == nil {
+			return true
+		}
+		if !s.wait(event.ReceivedAt, sub.stopC) {
+			s.unsubscribe(key, sub)
+			return true
+		}
+		if err := sub.dispatch(event.Event); err != nil && sub.errHandler != nil {
+			sub.errHandler(err)
+		}
+		return true
+	})
+
+	s.mu.Lock()
+	s.finished = true
+	subscriptions := s.subscriptions
+	s.subscriptions = make(map[string]*replaySubscription)
+	s.mu.Unlock()
+
+	for _, sub := range subscriptions {
+		if err != nil && sub.errHandler != nil {
+			sub.errHandler(err)
+		}
+		close(sub.doneC)
+	}
+}
+
+// serve subscribes dispatch to a stream. The first subscription starts the
+// replay.
+func (s *ReplayStreamSource) serve(stream, symbol, interval string, dispatch func(payload json.RawMessage) error, errHandler binance.ErrHandler) (chan struct{}, chan struct{}, error) {
+	key := replayKey(stream, symbol, interval)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.served[key] || s.finished {
+		return nil, nil, errReplayFinished
+	}
+	s.served[key] = true
+
+	sub := &replaySubscription{
+		dispatch:   dispatch,
+		errHandler: errHandler,
+		doneC:      make(chan struct{}),
+		stopC:      make(chan struct{}),
+	}
+	s.subscriptions[key] = sub
+	close(s.changed)
+	s.changed = make(chan struct{})
+
+	if s.start.IsZero() {
+		s.start = time.Now()
+		go s.run()
+	}
+
+	return sub.doneC, sub.stopC, nil
+}
+
+func (s *ReplayStreamSource) KlineServe(symbol string, interval string, handler binance.WsKlineHandler, errHandler binance.ErrHandler) (chan struct{}, chan struct{}, error) {
+	return s.serve(KlineStream, symbol, interval, func(payload json.RawMessage) error {
+		event := new(binance.WsKlineEvent)
+		if err := json.Unmarshal(payload, event); err != nil {
+			return err
+		}
+		handler(event)
+		return nil
+	}, errHandler)
+}
+
+func (s *ReplayStreamSource) AggTradeServe(symbol string, handler binance.WsAggTradeHandler, errHandler binance.ErrHandler) (chan struct{}, chan struct{}, error) {
+	return s.serve(AggTradeStream, symbol, "", func(payload json.RawMessage) error {
+		event := new(binance.WsAggTradeEvent)
+		if err := json.Unmarshal(payload, event); err != nil {
+			return err
+		}
+		handler(event)
+		return nil
+	}, errHandler)
+}
+
+func (s *ReplayStreamSource) PartialDepthServe(symbol string, levels string, handler binance.WsPartialDepthHandler, errHandler binance.ErrHandler) (chan struct{}, chan struct{}, error) {
+	return s.serve(DepthStream, symbol, levels, func(payload json.RawMessage) error {
+		event := new(binance.WsPartialDepthEvent)
+		if err := json.Unmarshal(payload, event); err != nil {
+			return err
+		}
+		handler(event)
+		return nil
+	}, errHandler)
+}
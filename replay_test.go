@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+)
+
+// handlerStreamSource keeps the handlers it is served with, so a test can
+// send events through them in any order.
+type handlerStreamSource struct {
+	kline    binance.WsKlineHandler
+	aggTrade binance.WsAggTradeHandler
+	depth    binance.WsPartialDepthHandler
+}
+
+func (s *handlerStreamSource) KlineServe(symbol string, interval string, handler binance.WsKlineHandler, errHandler binance.ErrHandler) (chan struct{}, chan struct{}, error) {
+	s.kline = handler
+	return make(chan struct{}), make(chan struct{}), nil
+}
+
+func (s *handlerStreamSource) AggTradeServe(symbol string, handler binance.WsAggTradeHandler, errHandler binance.ErrHandler) (chan struct{}, chan struct{}, error) {
+	s.aggTrade = handler
+	return make(chan struct{}), make(chan struct{}), nil
+}
+
+func (s *handlerStreamSource) PartialDepthServe(symbol string, levels string, handler binance.WsPartialDepthHandler, errHandler binance.ErrHandler) (chan struct{}, chan struct{}, error) {
+	s.depth = handler
+	return make(chan struct{}), make(chan struct{}), nil
+}
+
+func TestRecordReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stream.jsonl.gz")
+	recorder, err := NewStreamRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	live := &handlerStreamSource{}
+	recording := recordingStreamSource{source: live, recorder: recorder}
+	recording.KlineServe("BTCUSDT", "1m", func(*binance.WsKlineEvent) {}, nil)
+	recording.AggTradeServe("BTCUSDT", func(*binance.WsAggTradeEvent) {}, nil)
+	recording.PartialDepthServe("BTCUSDT", OrderBookDepthLevels, func(*binance.WsPartialDepthEvent) {}, nil)
+
+	// Interleave the streams, the replay must keep this order
+	var want []string
+	for i := 0; i < 20; i++ {
+		switch i % 3 {
+		case 0:
+			live.kline(&binance.WsKlineEvent{Symbol: "BTCUSDT", Kline: binance.WsKline{Close: fmt.Sprint(i)}})
+			want = append(want, fmt.Sprintf("kline %d", i))
+		case 1:
+			live.aggTrade(&binance.WsAggTradeEvent{Symbol: "BTCUSDT", Price: fmt.Sprint(i)})
+			want = append(want, fmt.Sprintf("trade %d", i))
+		case 2:
+			live.depth(&binance.WsPartialDepthEvent{Symbol: "BTCUSDT", Bids: []binance.Bid{{Price: fmt.Sprint(i)}}})
+			want = append(want, fmt.Sprintf("depth %d", i))
+		}
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	replay, err := NewReplayStreamSource(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	symbols, err := replay.Symbols()
+	if err != nil || len(symbols) != 1 || symbols[0] != "BTCUSDT" {
+		t.Fatalf("Symbols() = %v, %v", symbols, err)
+	}
+
+	// Handlers run on the single reader goroutine, one after the other
+	var got []string
+	var done []chan struct{}
+	doneC, _, err := replay.KlineServe("BTCUSDT", "1m", func(event *binance.WsKlineEvent) {
+		got = append(got, "kline "+event.Kline.Close)
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done = append(done, doneC)
+	doneC, _, err = replay.AggTradeServe("BTCUSDT", func(event *binance.WsAggTradeEvent) {
+		got = append(got, "trade "+event.Price)
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done = append(done, doneC)
+	doneC, _, err = replay.PartialDepthServe("BTCUSDT", OrderBookDepthLevels, func(event *binance.WsPartialDepthEvent) {
+		got = append(got, "depth "+event.Bids[0].Price)
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done = append(done, doneC)
+
+	for _, doneC := range done {
+		select {
+		case <-doneC:
+		case <-time.After(10 * time.Second):
+			t.Fatal("replay did not finish")
+		}
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("replayed %d events, want %d: %v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("event %d is %q, want %q", i, got[i], want[i])
+		}
+	}
+
+	// A finished stream is not served again
+	if _, _, err := replay.KlineServe("BTCUSDT", "1m", func(*binance.WsKlineEvent) {}, nil); err != errReplayFinished {
+		t.Errorf("second subscription returned %v, want errReplayFinished", err)
+	}
+}
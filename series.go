@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Series is a sequence of values with the open time of the candle each value
+// belongs to. Valid is false for bars without a value, e.g. during an
+// indicator's warm-up, and Values holds NaN there.
+type Series struct {
+	Times  []time.Time
+	Values []float64
+	Valid  []bool
+}
+
+// newSeries pairs values with times, marking NaN and infinite values invalid.
+// It takes ownership of values.
+func newSeries(times []time.Time, values []float64) Series {
+	s := Series{Times: times, Values: values, Valid: make([]bool, len(values))}
+	for i, value := range values {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			s.Values[i] = math.NaN()
+			continue
+		}
+		s.Valid[i] = true
+	}
+	return s
+}
+
+func candleTimes(data []Candlestick) []time.Time {
+	times := make([]time.Time, len(data))
+	for i, candle := range data {
+		times[i] = candle.OpenTime
+	}
+	return times
+}
+
+// alignedSeries returns values computed from the candles as a Series. Values
+// must be aligned to the candles, missing values are invalid.
+func alignedSeries(data []Candlestick, values []float64) Series {
+	aligned := nanSlice(len(data))
+	copy(aligned, values)
+	return newSeries(candleTimes(data), aligned)
+}
+
+// candleSeries extracts a price or volume series by name: open, high, low,
+// close, volume, quote_volume, taker_buy_volume, hl2, hlc3 or ohlc4.
+func candleSeries(data []Candlestick, name string) (Series, error) {
+	values, ok := candleSource(data, name)
+	if !ok {
+		return Series{}, fmt.Errorf("unknown candle series %q", name)
+	}
+	return newSeries(candleTimes(data), values), nil
+}
+
+// indicatorSeries computes a registered indicator over the candles and
+// returns one Series per output. Source indicators are computed on the named
+// candle series.
+func indicatorSeries(data []Candlestick, name, source string, params ...float64) ([]Series, error) {
+	spec, ok := indicatorRegistry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown indicator %q", name)
+	}
+	params, err := spec.validateParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []float64
+	if spec.Source {
+		if values, ok = candleSource(data, source); !ok {
+			return nil, fmt.Errorf("unknown candle series %q", source)
+		}
+	}
+
+	outputs := spec.run(values, data, params)
+	series := make([]Series, len(outputs))
+	for i, output := range outputs {
+		series[i] = alignedSeries(data, output)
+	}
+	return series, nil
+}
+
+func (s Series) Len() int {
+	return len(s.Values)
+}
+
+// At returns the value at index i and whether it is valid.
+func (s Series) At(i int) (float64, bool) {
+	if i < 0 || i >= len(s.Values) {
+		return math.NaN(), false
+	}
+	return s.Values[i], s.Valid[i]
+}
+
+// Last returns the time and value of the last bar and whether it is valid.
+func (s Series) Last() (time.Time, float64, bool) {
+	if len(s.Values) == 0 {
+		return time.Time{}, math.NaN(), false
+	}
+	last := len(s.Values) - 1
+	return s.Times[last], s.Values[last], s.Valid[last]
+}
+
+// Lookup returns the value of the bar opened at t.
+func (s Series) Lookup(t time.Time) (float64, bool) {
+	i := sort.Search(len(s.Times), func(i int) bool { return !s.Times[i].Before(t) })
+	if i == len(s.Times) || !s.Times[i].Equal(t) {
+		return math.NaN(), false
+	}
+	return s.At(i)
+}
+
+// Between returns the bars opened within [from, to].
+func (s Series) Between(from, to time.Time) Series {
+	start := sort.Search(len(s.Times), func(i int) bool { return !s.Times[i].Before(from) })
+	end := sort.Search(len(s.Times), func(i int) bool { return s.Times[i].After(to) })
+	if end < start {
+		end = start
+	}
+	return Series{Times: s.Times[start:end], Values: s.Values[start:end], Valid: s.Valid[start:end]}
+}
+
+// Apply computes an indicator on the values and returns its result with the
+// same times, e.g. close.Apply(func(v []float64) []float64 { return
+// calculateEMA(v, 20) }).
+func (s Series) Apply(indicator func(values []float64) []float64) Series {
+	values := nanSlice(len(s.Values))
+	copy(values, indicator(s.Values))
+	return newSeries(s.Times, values)
+}
+
+type seriesJSON struct {
+	Times  []int64    `json:"times"`
+	Values []*float64 `json:"values"`
+}
+
+// MarshalJSON encodes times as Unix milliseconds and invalid values as null.
+func (s Series) MarshalJSON() ([]byte, error) {
+	encoded := seriesJSON{Times: make([]int64, len(s.Times)), Values: make([]*float64, len(s.Values))}
+	for i, t := range s.Times {
+		encoded.Times[i] = t.UnixMilli()
+	}
+	for i := range s.Values {
+		if s.Valid[i] {
+			encoded.Values[i] = &s.Values[i]
+		}
+	}
+	return json.Marshal(encoded)
+}
+
+func (s *Series) UnmarshalJSON(data []byte) error {
+	var encoded seriesJSON
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+	if len(encoded.Times) != len(encoded.Values) {
+		return fmt.Errorf("series has %d times and %d values", len(encoded.Times), len(encoded.Values))
+	}
+
+	times := make([]time.Time, len(encoded.Times))
+	values := nanSlice(len(encoded.Values))
+	for i, ms := range encoded.Times {
+		times[i] = time.UnixMilli(ms)
+		if encoded.Values[i] != nil {
+			values[i] = *encoded.Values[i]
+		}
+	}
+	*s = newSeries(times, values)
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+// assertAligned fails unless the series has the open times of the candles and
+// is valid exactly where want has a value.
+func assertAligned(t *testing.T, name string, s Series, data []Candlestick, want []float64) {
+	t.Helper()
+	if s.Len() != len(data) || len(s.Times) != len(data) || len(s.Valid) != len(data) {
+		t.Fatalf("%s has %d values, %d times and %d flags, want %d", name, s.Len(), len(s.Times), len(s.Valid), len(data))
+	}
+	for i, candle := range data {
+		if !s.Times[i].Equal(candle.OpenTime) {
+			t.Fatalf("%s time %d = %v, want %v", name, i, s.Times[i], candle.OpenTime)
+		}
+		if s.Valid[i] == math.IsNaN(want[i]) {
+			t.Fatalf("%s valid[%d] = %v with reference %v", name, i, s.Valid[i], want[i])
+		}
+	}
+	assertSeries(t, name, s.Values, want)
+}
+
+func TestIndicatorSeries(t *testing.T) {
+	data := loadOHLCV(t)
+	want := loadReference(t, "indicators.csv")
+
+	wma, err := indicatorSeries(data, "wma", "close", 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(wma) != 1 {
+		t.Fatalf("wma has %d outputs, want 1", len(wma))
+	}
+	assertAligned(t, "wma20", wma[0], data, want["wma20"])
+
+	// Candle indicators ignore the source and take their defaults
+	adx, err := indicatorSeries(data, "adx", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertAligned(t, "adx14", adx[0], data, want["adx14"])
+
+	// The first valid bar is found by its candle's open time
+	first := 0
+	for !adx[0].Valid[first] {
+		first++
+	}
+	if value, ok := adx[0].Lookup(data[first].OpenTime); !ok || value != adx[0].Values[first] {
+		t.Errorf("Lookup of the first valid bar = %v, %v, want %v", value, ok, adx[0].Values[first])
+	}
+	if _, ok := adx[0].Lookup(data[first-1].OpenTime); ok {
+		t.Error("Lookup of a warm-up bar is valid")
+	}
+	if between := adx[0].Between(data[first].OpenTime, data[first+9].OpenTime); between.Len() != 10 || !between.Valid[0] {
+		t.Errorf("Between returned %d bars, want 10 valid ones", between.Len())
+	}
+
+	for _, tt := range []struct {
+		name, source string
+		params       []float64
+	}{
+		{"foo", "close", nil},
+		{"wma", "foo", nil},
+		{"wma", "close", []float64{0}},
+		{"wma", "close", []float64{20, 1}},
+	} {
+		if _, err := indicatorSeries(data, tt.name, tt.source, tt.params...); err == nil {
+			t.Errorf("indicatorSeries(%s, %s, %v) succeeded", tt.name, tt.source, tt.params)
+		}
+	}
+}
+
+func TestCandleSeries(t *testing.T) {
+	data := loadOHLCV(t)
+	hl2, err := candleSeries(data, "hl2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertAligned(t, "hl2", hl2, data, mapCandles(data, func(c Candlestick) float64 { return (c.High + c.Low) / 2 }))
+
+	if _, err := candleSeries(data, "foo"); err == nil {
+		t.Error("candleSeries of an unknown name succeeded")
+	}
+}
+
+func TestNewSeriesValidity(t *testing.T) {
+	data := loadOHLCV(t)[:4]
+	s := newSeries(candleTimes(data), []float64{1, math.NaN(), math.Inf(1), math.Inf(-1)})
+	assertAligned(t, "series", s, data, []float64{1, math.NaN(), math.NaN(), math.NaN()})
+
+	// Invalid values are null in JSON and stay invalid when decoded
+	encoded, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Series
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	assertAligned(t, "decoded", decoded, data, []float64{1, math.NaN(), math.NaN(), math.NaN()})
+}
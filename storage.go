@@ -0,0 +1,615 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	CandlePartitionDir = "candles"
+	TradePartitionDir  = "trades"
+	BookPartitionDir   = "books"
+	PartitionDayLayout = "2006-01-02"
+	PartitionExt       = ".jsonl"
+	// CompactionStateFile records which partitions are compacted, so a
+	// restart does not compact them again.
+	CompactionStateFile = "compacted.json"
+)
+
+// Storage is an append-only on-disk store for finalized candles, trades and
+// order book snapshots. Records are written as JSON lines into one file per
+// symbol, interval and UTC day:
+//
+//	<root>/candles/<SYMBOL>/<interval>/<YYYY-MM-DD>.jsonl
+//	<root>/trades/<SYMBOL>/<YYYY-MM-DD>.jsonl
+//	<root>/books/<SYMBOL>/<YYYY-MM-DD>.jsonl
+//
+// Partitions being appended to are kept open behind buffered writers. A
+// partition is closed when a newer one of the same directory is opened, when
+// it is compacted and on Close.
+type Storage struct {
+	root string
+
+	mu      sync.Mutex
+	writers map[string]*partitionWriter
+	// compacting holds the paths of the partitions being compacted, their
+	// writers are not rotated out meanwhile
+	compacting map[string]bool
+	// compacted maps the path of each compacted partition, relative to root,
+	// to its modification time in Unix nanoseconds after compaction
+	compacted map[string]int64
+}
+
+// partitionWriter appends to one partition. Its lock serializes appends,
+// reads and compaction of the partition. A retired writer was removed from
+// Storage.writers and must not be used.
+type partitionWriter struct {
+	mu        sync.Mutex
+	file      *os.File
+	w         *bufio.Writer
+	lastFlush time.Time
+	retired   bool
+}
+
+// NewStorage creates a Storage rooted at dir, creating the directory if needed.
+func NewStorage(dir string) (*Storage, error) {
+	if dir == "" {
+		return nil, errors.New("storage directory cannot be empty")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	compacted := make(map[string]int64)
+	data, err := os.ReadFile(filepath.Join(dir, CompactionStateFile))
+	if err == nil {
+		if err := json.Unmarshal(data, &compacted); err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", CompactionStateFile, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return &Storage{
+		root:       dir,
+		writers:    make(map[string]*partitionWriter),
+		compacting: make(map[string]bool),
+		compacted:  compacted,
+	}, nil
+}
+
+func partitionDay(t time.Time) string {
+	return t.UTC().Format(PartitionDayLayout)
+}
+
+func (s *Storage) candlePartition(symbol, interval string, day time.Time) string {
+	return filepath.Join(s.root, CandlePartitionDir, symbol, interval, partitionDay(day)+PartitionExt)
+}
+
+func (s *Storage) tradePartition(symbol string, day time.Time) string {
+	return filepath.Join(s.root, TradePartitionDir, symbol, partitionDay(day)+PartitionExt)
+}
+
+func (s *Storage) bookPartition(symbol string, day time.Time) string {
+	return filepath.Join(s.root, BookPartitionDir, symbol, partitionDay(day)+PartitionExt)
+}
+
+// OrderBookSnapshot is an order book as received at a point in time.
+type OrderBookSnapshot struct {
+	Time time.Time
+	OrderBook
+}
+
+func (p *partitionWriter) append(path string, data []byte) error {
+	if p.file == nil {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		p.file = f
+		p.w = bufio.NewWriter(f)
+	}
+
+	if _, err := p.w.Write(data); err != nil {
+		return err
+	}
+	if err := p.w.WriteByte('\n'); err != nil {
+		return err
+	}
+
+	// Flush about once a second so a killed process loses little
+	if now := time.Now(); now.Sub(p.lastFlush) >= time.Second {
+		p.lastFlush = now
+		return p.w.Flush()
+	}
+	return nil
+}
+
+func (p *partitionWriter) flush() error {
+	if p.w == nil {
+		return nil
+	}
+	return p.w.Flush()
+}
+
+// close flushes and closes the partition file. The writer reopens it on the
+// next append.
+func (p *partitionWriter) close() error {
+	if p.file == nil {
+		return nil
+	}
+	err := p.w.Flush()
+	if closeErr := p.file.Close(); err == nil {
+		err = closeErr
+	}
+	p.file, p.w = nil, nil
+	return err
+}
+
+// lockWriter returns the locked writer of the partition at path, creating it
+// if needed. When rotate is set, creating a writer retires the other writers
+// of the same directory, which hold older days.
+func (s *Storage) lockWriter(path string, rotate bool) *partitionWriter {
+	for {
+		s.mu.Lock()
+		p, ok := s.writers[path]
+		if !ok {
+			p = &partitionWriter{}
+			s.writers[path] = p
+		}
+		s.mu.Unlock()
+
+		if !ok && rotate {
+			s.rotate(path)
+		}
+
+		p.mu.Lock()
+		if !p.retired {
+			return p
+		}
+		p.mu.Unlock()
+	}
+}
+
+// rotate retires the writers of the other partitions in the directory of path.
+func (s *Storage) rotate(path string) {
+	dir := filepath.Dir(path)
+
+	s.mu.Lock()
+	var rotated []*partitionWriter
+	for other, p := range s.writers {
+		if other != path && filepath.Dir(other) == dir && !s.compacting[other] {
+			rotated = append(rotated, p)
+			delete(s.writers, other)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, p := range rotated {
+		p.mu.Lock()
+		if err := p.close(); err != nil {
+			log.Printf("Error closing storage partition in %s: %v\n", dir, err)
+		}
+		p.retired = true
+		p.mu.Unlock()
+	}
+}
+
+// retire closes and removes the writer of the partition at path, whose lock
+// the caller holds.
+func (s *Storage) retire(path string, p *partitionWriter) error {
+	s.mu.Lock()
+	delete(s.writers, path)
+	s.mu.Unlock()
+
+	p.retired = true
+	return p.close()
+}
+
+func (s *Storage) appendRecord(path string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	p := s.lockWriter(path, true)
+	defer p.mu.Unlock()
+
+	return p.append(path, data)
+}
+
+// readPartitionLocked reads the partition at path like readPartition, after
+// flushing its writer, if any, and holding the writer's lock while reading.
+func (s *Storage) readPartitionLocked(path string, decode func(line []byte) error) error {
+	s.mu.Lock()
+	p, ok := s.writers[path]
+	s.mu.Unlock()
+	if !ok {
+		return readPartition(path, decode)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.flush(); err != nil {
+		return err
+	}
+	return readPartition(path, decode)
+}
+
+// Close flushes and closes every open partition.
+func (s *Storage) Close() error {
+	s.mu.Lock()
+	writers := s.writers
+	s.writers = make(map[string]*partitionWriter)
+	s.mu.Unlock()
+
+	var firstErr error
+	for _, p := range writers {
+		p.mu.Lock()
+		if err := p.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		p.retired = true
+		p.mu.Unlock()
+	}
+	return firstErr
+}
+
+// readPartition calls decode for every line of the partition at path. A
+// missing partition is not an error.
+func readPartition(path string, decode func(line []byte) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		if err := decode(line); err != nil {
+			return fmt.Errorf("failed to decode %s line %d: %w", path, lineNo, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// writePartition atomically replaces the partition at path with values.
+func writePartition(path string, count int, value func(i int) interface{}) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	for i := 0; i < count; i++ {
+		data, err := json.Marshal(value(i))
+		if err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return err
+		}
+		if err := w.WriteByte('\n'); err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// partitionDays returns the UTC days covered by the range [from, to].
+func partitionDays(from, to time.Time) []time.Time {
+	var days []time.Time
+	start := from.UTC().Truncate(24 * time.Hour)
+	for day := start; !day.After(to.UTC()); day = day.Add(24 * time.Hour) {
+		days = append(days, day)
+	}
+	return days
+}
+
+func (s *Storage) AppendCandle(symbol, interval string, candle Candlestick) error {
+	return s.appendRecord(s.candlePartition(symbol, interval, candle.OpenTime), candle)
+}
+
+func (s *Storage) AppendTrade(symbol string, trade Trade) error {
+	return s.appendRecord(s.tradePartition(symbol, time.UnixMilli(trade.Time)), trade)
+}
+
+func (s *Storage) AppendOrderBook(symbol string, snapshot OrderBookSnapshot) error {
+	return s.appendRecord(s.bookPartition(symbol, snapshot.Time), snapshot)
+}
+
+// QueryCandles returns the stored candles for symbol and interval whose open
+// time falls within [from, to], ordered by open time.
+func (s *Storage) QueryCandles(symbol, interval string, from, to time.Time) ([]Candlestick, error) {
+	var candles []Candlestick
+	for _, day := range partitionDays(from, to) {
+		err := s.readPartitionLocked(s.candlePartition(symbol, interval, day), func(line []byte) error {
+			var candle Candlestick
+			if err := json.Unmarshal(line, &candle); err != nil {
+				return err
+			}
+			if !candle.OpenTime.Before(from) && !candle.OpenTime.After(to) {
+				candles = append(candles, candle)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(candles, func(i, j int) bool {
+		return candles[i].OpenTime.Before(candles[j].OpenTime)
+	})
+	return candles, nil
+}
+
+// QueryTrades returns the stored trades for symbol executed within [from, to],
+// ordered by trade time.
+func (s *Storage) QueryTrades(symbol string, from, to time.Time) ([]Trade, error) {
+	fromMs, toMs := from.UnixMilli(), to.UnixMilli()
+
+	var trades []Trade
+	for _, day := range partitionDays(from, to) {
+		err := s.readPartitionLocked(s.tradePartition(symbol, day), func(line []byte) error {
+			var trade Trade
+			if err := json.Unmarshal(line, &trade); err != nil {
+				return err
+			}
+			if trade.Time >= fromMs && trade.Time <= toMs {
+				trades = append(trades, trade)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(trades, func(i, j int) bool {
+		return trades[i].Time < trades[j].Time
+	})
+	return trades, nil
+}
+
+// QueryOrderBooks returns the stored order book snapshots for symbol taken
+// within [from, to], ordered by time.
+func (s *Storage) QueryOrderBooks(symbol string, from, to time.Time) ([]OrderBookSnapshot, error) {
+	var snapshots []OrderBookSnapshot
+	for _, day := range partitionDays(from, to) {
+		err := s.readPartitionLocked(s.bookPartition(symbol, day), func(line []byte) error {
+			var snapshot OrderBookSnapshot
+			if err := json.Unmarshal(line, &snapshot); err != nil {
+				return err
+			}
+			if !snapshot.Time.Before(from) && !snapshot.Time.After(to) {
+				snapshots = append(snapshots, snapshot)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].Time.Before(snapshots[j].Time)
+	})
+	return snapshots, nil
+}
+
+// compactCandlePartition rewrites a candle partition ordered by open time,
+// keeping only the last record written for each open time.
+func compactCandlePartition(path string) error {
+	byOpenTime := make(map[int64]Candlestick)
+	err := readPartition(path, func(line []byte) error {
+		var candle Candlestick
+		if err := json.Unmarshal(line, &candle); err != nil {
+			return err
+		}
+		byOpenTime[candle.OpenTime.UnixNano()] = candle
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	candles := make([]Candlestick, 0, len(byOpenTime))
+	for _, candle := range byOpenTime {
+		candles = append(candles, candle)
+	}
+	sort.Slice(candles, func(i, j int) bool {
+		return candles[i].OpenTime.Before(candles[j].OpenTime)
+	})
+
+	return writePartition(path, len(candles), func(i int) interface{} { return candles[i] })
+}
+
+// compactTradePartition rewrites a trade partition ordered by trade time,
+// dropping duplicate aggregate trade IDs.
+func compactTradePartition(path string) error {
+	byID := make(map[int64]Trade)
+	err := readPartition(path, func(line []byte) error {
+		var trade Trade
+		if err := json.Unmarshal(line, &trade); err != nil {
+			return err
+		}
+		byID[trade.ID] = trade
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	trades := make([]Trade, 0, len(byID))
+	for _, trade := range byID {
+		trades = append(trades, trade)
+	}
+	sort.Slice(trades, func(i, j int) bool {
+		if trades[i].Time == trades[j].Time {
+			return trades[i].ID < trades[j].ID
+		}
+		return trades[i].Time < trades[j].Time
+	})
+
+	return writePartition(path, len(trades), func(i int) interface{} { return trades[i] })
+}
+
+// compactPartition compacts the partition at path with compact unless it is
+// unchanged since its last compaction. Its writer is retired first, a later
+// append reopens the compacted file.
+func (s *Storage) compactPartition(path string, compact func(path string) error) error {
+	rel, err := filepath.Rel(s.root, path)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.compacting[path] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.compacting, path)
+		s.mu.Unlock()
+	}()
+
+	// The writer stays registered and locked until the partition is replaced,
+	// appends meanwhile wait for it and then reopen the compacted file
+	p := s.lockWriter(path, false)
+	defer p.mu.Unlock()
+	if err := p.close(); err != nil {
+		return err
+	}
+	err = s.compactLocked(path, rel, compact)
+	if retireErr := s.retire(path, p); err == nil {
+		err = retireErr
+	}
+	return err
+}
+
+func (s *Storage) compactLocked(path, rel string, compact func(path string) error) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	compacted := s.compacted[rel] == info.ModTime().UnixNano()
+	s.mu.Unlock()
+	if compacted {
+		return nil
+	}
+
+	if err := compact(path); err != nil {
+		return err
+	}
+	if info, err = os.Stat(path); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.compacted[rel] = info.ModTime().UnixNano()
+	s.mu.Unlock()
+	return nil
+}
+
+// saveCompacted atomically writes the compaction state to CompactionStateFile.
+func (s *Storage) saveCompacted() error {
+	s.mu.Lock()
+	data, err := json.Marshal(s.compacted)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(s.root, CompactionStateFile)
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Compact compacts every candle and trade partition for days before cutoff.
+// Partitions that have not been appended to since their last compaction are
+// skipped.
+func (s *Storage) Compact(cutoff time.Time) error {
+	cutoffDay := partitionDay(cutoff)
+
+	err := filepath.Walk(s.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, PartitionExt) {
+			return nil
+		}
+		if strings.TrimSuffix(info.Name(), PartitionExt) >= cutoffDay {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.root, path)
+		if err != nil {
+			return err
+		}
+		switch strings.SplitN(rel, string(filepath.Separator), 2)[0] {
+		case CandlePartitionDir:
+			return s.compactPartition(path, compactCandlePartition)
+		case TradePartitionDir:
+			return s.compactPartition(path, compactTradePartition)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return s.saveCompacted()
+}
+
+// compactionRoutine periodically compacts all partitions of past days.
+func compactionRoutine(store *Storage, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := store.Compact(time.Now()); err != nil {
+			log.Printf("Error compacting storage: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStorageAppendAndQuery(t *testing.T) {
+	store, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// Candles span midnight, so they land in two partitions
+	start := time.Date(2024, 1, 1, 23, 58, 0, 0, time.UTC)
+	candles := minuteCandles(start, 4)
+	for _, candle := range candles {
+		if err := store.AppendCandle("BTCUSDT", "1m", candle); err != nil {
+			t.Fatal(err)
+		}
+	}
+	trades := []Trade{{ID: 2, Price: 2, Quantity: 1, Time: start.UnixMilli() + 2}, {ID: 1, Price: 1, Quantity: 1, Time: start.UnixMilli() + 1}}
+	for _, trade := range trades {
+		if err := store.AppendTrade("BTCUSDT", trade); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Buffered records are visible to queries
+	got, err := store.QueryCandles("BTCUSDT", "1m", start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(candles) {
+		t.Fatalf("got %d candles, want %d", len(got), len(candles))
+	}
+	for i := range got {
+		if !got[i].OpenTime.Equal(candles[i].OpenTime) || got[i].Close != candles[i].Close {
+			t.Errorf("candle %d = %+v, want %+v", i, got[i], candles[i])
+		}
+	}
+	gotTrades, err := store.QueryTrades("BTCUSDT", start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gotTrades) != 2 || gotTrades[0].ID != 1 || gotTrades[1].ID != 2 {
+		t.Errorf("got trades %+v, want IDs 1 and 2 in time order", gotTrades)
+	}
+
+	// Opening the second day rotated the first day's partition out
+	store.mu.Lock()
+	open := len(store.writers)
+	store.mu.Unlock()
+	if open != 2 {
+		t.Errorf("%d partitions open, want one candle and one trade partition", open)
+	}
+}
+
+func TestStorageCloseFlushes(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, candle := range minuteCandles(start, 10) {
+		if err := store.AppendCandle("BTCUSDT", "1m", candle); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := reopened.QueryCandles("BTCUSDT", "1m", start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 10 {
+		t.Errorf("read back %d candles after Close, want 10", len(got))
+	}
+}
+
+func TestStorageCompact(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	candles := minuteCandles(start, 3)
+	// Out of order, with a duplicate whose last write wins
+	updated := candles[1]
+	updated.Close = 42
+	for _, candle := range []Candlestick{candles[2], candles[0], candles[1], updated} {
+		if err := store.AppendCandle("BTCUSDT", "1m", candle); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, trade := range []Trade{{ID: 3, Time: start.UnixMilli() + 3}, {ID: 1, Time: start.UnixMilli() + 1}, {ID: 3, Time: start.UnixMilli() + 3}} {
+		if err := store.AppendTrade("BTCUSDT", trade); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := store.Compact(start.Add(48 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	var lines int
+	path := store.candlePartition("BTCUSDT", "1m", start)
+	if err := readPartition(path, func(line []byte) error { lines++; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if lines != 3 {
+		t.Errorf("compacted candle partition has %d lines, want 3", lines)
+	}
+	got, err := store.QueryCandles("BTCUSDT", "1m", start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[1].Close != 42 {
+		t.Errorf("got %+v, want 3 candles with the updated second one", got)
+	}
+	trades, err := store.QueryTrades("BTCUSDT", start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trades) != 2 || trades[0].ID != 1 || trades[1].ID != 3 {
+		t.Errorf("got trades %+v, want IDs 1 and 3", trades)
+	}
+
+	// The compaction state survives a restart, unchanged partitions are skipped
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, CompactionStateFile)); err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.Compact(start.Add(48 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !after.ModTime().Equal(before.ModTime()) {
+		t.Error("an unchanged partition was compacted again after a restart")
+	}
+
+	// A late append makes the partition due again
+	if err := reopened.AppendCandle("BTCUSDT", "1m", candles[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.Compact(start.Add(48 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	lines = 0
+	if err := readPartition(path, func(line []byte) error { lines++; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if lines != 3 {
+		t.Errorf("partition has %d lines after the late append, want 3", lines)
+	}
+}
+
+func TestStorageCompactDuringAppends(t *testing.T) {
+	store, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// Late trades of a past day keep arriving while it is compacted
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	const n = 20000
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < n; i++ {
+			if err := store.AppendTrade("BTCUSDT", Trade{ID: int64(i), Time: start.UnixMilli() + int64(i)}); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	for appending := true; appending; {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			appending = false
+		default:
+		}
+		if err := store.Compact(start.Add(48 * time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	trades, err := store.QueryTrades("BTCUSDT", start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trades) != n {
+		t.Errorf("got %d trades, want %d", len(trades), n)
+	}
+}
@@ -0,0 +1,567 @@
+#!/usr/bin/env python3
+"""Generates the reference values the indicator tests compare against.
+
+The values come from this independent pure-Python implementation of the
+published definitions (Wilder, Appel, Bollinger, Lane, TA-Lib's SAR), not from
+the Go code under test. Run it from the testdata directory, followed by
+reference_talib.py, which replaces the values TA-Lib defines the same way with
+TA-Lib's own and checks the others against it:
+
+    python3 reference.py && python3 reference_talib.py
+"""
+
+import csv
+import math
+
+NAN = float("nan")
+
+
+def ohlcv(n=150, seed=42):
+    """A deterministic random walk rounded to cents, written to ohlcv.csv."""
+    state = seed
+
+    def rand():
+        nonlocal state
+        state = (state * 6364136223846793005 + 1442695040888963407) % 2**64
+        return (state >> 11) / 2**53
+
+    rows, close = [], 100.0
+    for i in range(n):
+        open_ = close
+        close = round(open_ * (1 + (rand() - 0.5) * 0.04), 2)
+        high = round(max(open_, close) * (1 + rand() * 0.01), 2)
+        low = round(min(open_, close) * (1 - rand() * 0.01), 2)
+        volume = round(100 + rand() * 900, 2)
+        rows.append((1700000000 + 60 * i, open_, high, low, close, volume))
+    return rows
+
+
+def sma(x, n):
+    out = [NAN] * len(x)
+    start = next(i for i, v in enumerate(x) if not math.isnan(v))
+    for i in range(start + n - 1, len(x)):
+        out[i] = sum(x[i - n + 1 : i + 1]) / n
+    return out
+
+
+def ema(x, n, alpha=None):
+    alpha = 2 / (n + 1) if alpha is None else alpha
+    out = [NAN] * len(x)
+    start = next(i for i, v in enumerate(x) if not math.isnan(v))
+    out[start + n - 1] = sum(x[start : start + n]) / n
+    for i in range(start + n, len(x)):
+        out[i] = alpha * x[i] + (1 - alpha) * out[i - 1]
+    return out
+
+
+def rma(x, n):
+    return ema(x, n, 1 / n)
+
+
+def wma(x, n):
+    out = [NAN] * len(x)
+    start = next(i for i, v in enumerate(x) if not math.isnan(v))
+    for i in range(start + n - 1, len(x)):
+        out[i] = sum(x[i - n + 1 + j] * (j + 1) for j in range(n)) / (n * (n + 1) / 2)
+    return out
+
+
+def hma(x, n):
+    half, full = wma(x, n // 2), wma(x, n)
+    return wma([2 * a - b for a, b in zip(half, full)], int(math.sqrt(n)))
+
+
+def rsi(x, n):
+    out = [NAN] * len(x)
+    changes = [x[i] - x[i - 1] for i in range(1, len(x))]
+    gain = sum(max(c, 0) for c in changes[:n]) / n
+    loss = sum(max(-c, 0) for c in changes[:n]) / n
+    for i in range(n, len(x)):
+        if i > n:
+            c = changes[i - 1]
+            gain = (gain * (n - 1) + max(c, 0)) / n
+            loss = (loss * (n - 1) + max(-c, 0)) / n
+        if loss == 0:
+            out[i] = 50 if gain == 0 else 100
+        else:
+            out[i] = 100 - 100 / (1 + gain / loss)
+    return out
+
+
+def macd(x, short, long, signal):
+    line = [a - b for a, b in zip(ema(x, short), ema(x, long))]
+    sig = ema(line, signal)
+    return line, sig, [a - b for a, b in zip(line, sig)]
+
+
+def bbands(x, n, k):
+    mid = sma(x, n)
+    upper, lower = [NAN] * len(x), [NAN] * len(x)
+    for i in range(n - 1, len(x)):
+        sd = math.sqrt(sum((v - mid[i]) ** 2 for v in x[i - n + 1 : i + 1]) / n)
+        upper[i], lower[i] = mid[i] + k * sd, mid[i] - k * sd
+    return upper, mid, lower
+
+
+def true_range(h, l, c):
+    return [NAN] + [max(h[i] - l[i], abs(h[i] - c[i - 1]), abs(l[i] - c[i - 1])) for i in range(1, len(c))]
+
+
+def atr(h, l, c, n):
+    return rma(true_range(h, l, c), n)
+
+
+def dmi(h, l, c, n):
+    plus, minus = [NAN], [NAN]
+    for i in range(1, len(c)):
+        up, down = h[i] - h[i - 1], l[i - 1] - l[i]
+        plus.append(up if up > down and up > 0 else 0)
+        minus.append(down if down > up and down > 0 else 0)
+    tr, sp, sm = rma(true_range(h, l, c), n), rma(plus, n), rma(minus, n)
+    di_plus = [NAN if math.isnan(t) else (0 if t == 0 else 100 * p / t) for t, p in zip(tr, sp)]
+    di_minus = [NAN if math.isnan(t) else (0 if t == 0 else 100 * m / t) for t, m in zip(tr, sm)]
+    return di_plus, di_minus
+
+
+def adx(h, l, c, n):
+    di_plus, di_minus = dmi(h, l, c, n)
+    dx = [p if math.isnan(p) else (0 if p + m == 0 else 100 * abs(p - m) / (p + m)) for p, m in zip(di_plus, di_minus)]
+    return rma(dx, n)
+
+
+def stochastic(h, l, c, n, d):
+    k = [NAN] * len(c)
+    for i in range(n - 1, len(c)):
+        hh, ll = max(h[i - n + 1 : i + 1]), min(l[i - n + 1 : i + 1])
+        k[i] = 0 if hh == ll else 100 * (c[i] - ll) / (hh - ll)
+    return k, sma(k, d)
+
+
+def sar(h, l, start=0.02, step=0.02, maximum=0.2):
+    out = [NAN] * len(h)
+    up, down = h[1] - h[0], l[0] - l[1]
+    long = not (down > up and down > 0)
+    sar_, ep = (l[0], h[1]) if long else (h[0], l[1])
+    af, new_high, new_low = start, h[0], l[0]
+    for i in range(1, len(h)):
+        prev_high, prev_low, new_high, new_low = new_high, new_low, h[i], l[i]
+        if long:
+            if new_low <= sar_:
+                long, sar_ = False, max(ep, prev_high, new_high)
+                out[i], af, ep = sar_, start, new_low
+                sar_ = max(sar_ + af * (ep - sar_), prev_high, new_high)
+            else:
+                out[i] = sar_
+                if new_high > ep:
+                    ep, af = new_high, min(af + step, maximum)
+                sar_ = min(sar_ + af * (ep - sar_), prev_low, new_low)
+        else:
+            if new_high >= sar_:
+                long, sar_ = True, min(ep, prev_low, new_low)
+                out[i], af, ep = sar_, start, new_high
+                sar_ = min(sar_ + af * (ep - sar_), prev_low, new_low)
+            else:
+                out[i] = sar_
+                if new_low < ep:
+                    ep, af = new_low, min(af + step, maximum)
+                sar_ = max(sar_ + af * (ep - sar_), prev_high, new_high)
+    return out
+
+
+def supertrend(h, l, c, n, mult):
+    a = atr(h, l, c, n)
+    line, direction = [NAN] * len(c), [NAN] * len(c)
+    for i in range(n, len(c)):
+        mid = (h[i] + l[i]) / 2
+        basic_upper, basic_lower = mid + mult * a[i], mid - mult * a[i]
+        if i == n:
+            upper, lower, direction[i] = basic_upper, basic_lower, -1
+            line[i] = upper
+            continue
+        upper = basic_upper if basic_upper < upper or c[i - 1] > upper else upper
+        lower = basic_lower if basic_lower > lower or c[i - 1] < lower else lower
+        if direction[i - 1] < 0:
+            direction[i] = 1 if c[i] > upper else -1
+        else:
+            direction[i] = -1 if c[i] < lower else 1
+        line[i] = lower if direction[i] > 0 else upper
+    return line, direction
+
+
+def aroon(h, l, n):
+    up, down = [NAN] * len(h), [NAN] * len(h)
+    for i in range(n, len(h)):
+        highs, lows = h[i - n : i + 1], l[i - n : i + 1]
+        # The most recent extreme wins ties
+        since_high = n - max(j for j, v in enumerate(highs) if v == max(highs))
+        since_low = n - max(j for j, v in enumerate(lows) if v == min(lows))
+        up[i], down[i] = 100 * (n - since_high) / n, 100 * (n - since_low) / n
+    return up, down, [a - b for a, b in zip(up, down)]
+
+
+def cci(h, l, c, n):
+    tp = [(a + b + d) / 3 for a, b, d in zip(h, l, c)]
+    out = [NAN] * len(c)
+    for i in range(n - 1, len(c)):
+        window = tp[i - n + 1 : i + 1]
+        mean = sum(window) / n
+        md = sum(abs(v - mean) for v in window) / n
+        out[i] = 0 if md == 0 else (tp[i] - mean) / (0.015 * md)
+    return out
+
+
+def willr(h, l, c, n):
+    out = [NAN] * len(c)
+    for i in range(n - 1, len(c)):
+        hh, ll = max(h[i - n + 1 : i + 1]), min(l[i - n + 1 : i + 1])
+        out[i] = 0 if hh == ll else -100 * (hh - c[i]) / (hh - ll)
+    return out
+
+
+def trix(x, n):
+    e = ema(ema(ema(x, n), n), n)
+    return [NAN] + [NAN if math.isnan(e[i - 1]) else 100 * (e[i] / e[i - 1] - 1) for i in range(1, len(x))]
+
+
+def kama(x, n, fast, slow):
+    fast_sc, slow_sc = 2 / (fast + 1), 2 / (slow + 1)
+    out, prev = [NAN] * len(x), x[n - 1]
+    for i in range(n, len(x)):
+        noise = sum(abs(x[j] - x[j - 1]) for j in range(i - n + 1, i + 1))
+        er = 0 if noise == 0 else abs(x[i] - x[i - n]) / noise
+        sc = (er * (fast_sc - slow_sc) + slow_sc) ** 2
+        prev = out[i] = prev + sc * (x[i] - prev)
+    return out
+
+
+def vortex(h, l, c, n):
+    tr = true_range(h, l, c)
+    plus, minus = [NAN] * len(c), [NAN] * len(c)
+    for i in range(n, len(c)):
+        r = range(i - n + 1, i + 1)
+        total = sum(tr[j] for j in r)
+        plus[i] = sum(abs(h[j] - l[j - 1]) for j in r) / total
+        minus[i] = sum(abs(l[j] - h[j - 1]) for j in r) / total
+    return plus, minus
+
+
+def ultosc(h, l, c, short, medium, long):
+    bp = [0] + [c[i] - min(l[i], c[i - 1]) for i in range(1, len(c))]
+    tr = [0] + [max(h[i], c[i - 1]) - min(l[i], c[i - 1]) for i in range(1, len(c))]
+
+    def avg(i, n):
+        return sum(bp[i - n + 1 : i + 1]) / sum(tr[i - n + 1 : i + 1])
+
+    out = [NAN] * len(c)
+    for i in range(long, len(c)):
+        out[i] = 100 * (4 * avg(i, short) + 2 * avg(i, medium) + avg(i, long)) / 7
+    return out
+
+
+def stochrsi(x, rsi_n, stoch_n, k_n, d_n):
+    r = rsi(x, rsi_n)
+    raw = [NAN] * len(x)
+    for i in range(rsi_n + stoch_n - 1, len(x)):
+        window = r[i - stoch_n + 1 : i + 1]
+        hh, ll = max(window), min(window)
+        raw[i] = 0 if hh == ll else 100 * (r[i] - ll) / (hh - ll)
+    k = sma(raw, k_n)
+    return k, sma(k, d_n)
+
+
+def super_smoother(x, period):
+    a1 = math.exp(-math.sqrt(2) * math.pi / period)
+    c2 = 2 * a1 * math.cos(math.sqrt(2) * math.pi / period)
+    c3 = -a1 * a1
+    c1 = 1 - c2 - c3
+    filt = list(x[:2])
+    for i in range(2, len(x)):
+        filt.append(c1 * (x[i] + x[i - 1]) / 2 + c2 * filt[i - 1] + c3 * filt[i - 2])
+    return [NAN] * (period - 1) + filt[period - 1 :]
+
+
+def mesa(x, fast, slow, warmup=32):
+    """Ehlers' Hilbert transform discriminator and MAMA, following the
+    EasyLanguage listing of "MESA Adaptive Moving Averages"."""
+    n = len(x)
+    smooth, detrender, i1, q1, i2, q2, re, im, period, smooth_period, phase = ([0.0] * n for _ in range(11))
+    mama, fama = [x[0]] * n, [x[0]] * n
+
+    def hilbert(v, i):
+        return 0.0962 * v[i] + 0.5769 * v[i - 2] - 0.5769 * v[i - 4] - 0.0962 * v[i - 6]
+
+    for i in range(n):
+        if i < 6:
+            smooth[i] = x[i]
+        else:
+            smooth[i] = (4 * x[i] + 3 * x[i - 1] + 2 * x[i - 2] + x[i - 3]) / 10
+            gain = 0.075 * period[i - 1] + 0.54
+            detrender[i] = hilbert(smooth, i) * gain
+            q1[i] = hilbert(detrender, i) * gain
+            i1[i] = detrender[i - 3]
+            ji, jq = hilbert(i1, i) * gain, hilbert(q1, i) * gain
+            i2[i] = 0.2 * (i1[i] - jq) + 0.8 * i2[i - 1]
+            q2[i] = 0.2 * (q1[i] + ji) + 0.8 * q2[i - 1]
+            re[i] = 0.2 * (i2[i] * i2[i - 1] + q2[i] * q2[i - 1]) + 0.8 * re[i - 1]
+            im[i] = 0.2 * (i2[i] * q2[i - 1] - q2[i] * i2[i - 1]) + 0.8 * im[i - 1]
+            p = period[i - 1]
+            if im[i] != 0 and re[i] != 0:
+                p = 360 / math.degrees(math.atan(im[i] / re[i]))
+            p = min(max(p, 0.67 * period[i - 1]), 1.5 * period[i - 1])
+            p = min(max(p, 6), 50)
+            period[i] = 0.2 * p + 0.8 * period[i - 1]
+            smooth_period[i] = 0.33 * period[i] + 0.67 * smooth_period[i - 1]
+            phase[i] = math.degrees(math.atan(q1[i] / i1[i])) if i1[i] != 0 else phase[i - 1]
+        if i > 0:
+            alpha = max(fast / max(phase[i - 1] - phase[i], 1), slow)
+            mama[i] = alpha * x[i] + (1 - alpha) * mama[i - 1]
+            fama[i] = 0.5 * alpha * mama[i] + (1 - 0.5 * alpha) * fama[i - 1]
+
+    def drop(v):
+        return [NAN] * (warmup - 1) + v[warmup - 1 :]
+
+    return drop(smooth_period), drop(mama), drop(fama)
+
+
+def sample_stdev(x):
+    mean = sum(x) / len(x)
+    return math.sqrt(sum((v - mean) ** 2 for v in x) / (len(x) - 1))
+
+
+def hv(c, n):
+    r = [NAN] + [math.log(c[i] / c[i - 1]) for i in range(1, len(c))]
+    return [NAN] * n + [sample_stdev(r[i - n + 1 : i + 1]) for i in range(n, len(c))]
+
+
+def rolling_vol(terms, n):
+    return [NAN] * (n - 1) + [math.sqrt(max(sum(terms[i - n + 1 : i + 1]) / n, 0)) for i in range(n - 1, len(terms))]
+
+
+def parkinson(h, l, n):
+    return rolling_vol([math.log(a / b) ** 2 / (4 * math.log(2)) for a, b in zip(h, l)], n)
+
+
+def garman_klass(o, h, l, c, n):
+    terms = [0.5 * math.log(hi / lo) ** 2 - (2 * math.log(2) - 1) * math.log(cl / op) ** 2 for op, hi, lo, cl in zip(o, h, l, c)]
+    return rolling_vol(terms, n)
+
+
+def rs_term(o, h, l, c):
+    return math.log(h / c) * math.log(h / o) + math.log(l / c) * math.log(l / o)
+
+
+def rogers_satchell(o, h, l, c, n):
+    return rolling_vol([rs_term(*bar) for bar in zip(o, h, l, c)], n)
+
+
+def yang_zhang(o, h, l, c, n):
+    k = 0.34 / (1.34 + (n + 1) / (n - 1))
+    out = [NAN] * len(c)
+    for i in range(n, len(c)):
+        r = range(i - n + 1, i + 1)
+        overnight = sample_stdev([math.log(o[j] / c[j - 1]) for j in r]) ** 2
+        open_close = sample_stdev([math.log(c[j] / o[j]) for j in r]) ** 2
+        rs = sum(rs_term(o[j], h[j], l[j], c[j]) for j in r) / n
+        out[i] = math.sqrt(max(overnight + k * open_close + (1 - k) * rs, 0))
+    return out
+
+
+def percentile(x, n):
+    out = [NAN] * len(x)
+    for i in range(n, len(x)):
+        previous = x[i - n : i]
+        if math.isnan(x[i]) or any(math.isnan(v) for v in previous):
+            continue
+        out[i] = 100 * sum(v < x[i] for v in previous) / n
+    return out
+
+
+def rank(x, n):
+    out = [NAN] * len(x)
+    for i in range(n - 1, len(x)):
+        window = x[i - n + 1 : i + 1]
+        if any(math.isnan(v) for v in window):
+            continue
+        lo, hi = min(window), max(window)
+        out[i] = 0 if hi == lo else 100 * (x[i] - lo) / (hi - lo)
+    return out
+
+
+def obv(c, v):
+    out = [v[0]]
+    for i in range(1, len(c)):
+        sign = (c[i] > c[i - 1]) - (c[i] < c[i - 1])
+        out.append(out[-1] + sign * v[i])
+    return out
+
+
+def mfi(h, l, c, v, n):
+    tp = [(a + b + d) / 3 for a, b, d in zip(h, l, c)]
+    out = [NAN] * len(c)
+    for i in range(n, len(c)):
+        r = range(i - n + 1, i + 1)
+        pos = sum(tp[j] * v[j] for j in r if tp[j] > tp[j - 1])
+        neg = sum(tp[j] * v[j] for j in r if tp[j] < tp[j - 1])
+        out[i] = (50 if pos == 0 else 100) if neg == 0 else 100 - 100 / (1 + pos / neg)
+    return out
+
+
+def clv(h, l, c):
+    return [0 if a == b else ((d - b) - (a - d)) / (a - b) for a, b, d in zip(h, l, c)]
+
+
+def ad(h, l, c, v):
+    out, total = [], 0
+    for m, vol in zip(clv(h, l, c), v):
+        total += m * vol
+        out.append(total)
+    return out
+
+
+def cmf(h, l, c, v, n):
+    mfv = [m * vol for m, vol in zip(clv(h, l, c), v)]
+    return [NAN] * (n - 1) + [sum(mfv[i - n + 1 : i + 1]) / sum(v[i - n + 1 : i + 1]) for i in range(n - 1, len(c))]
+
+
+def chaikin_osc(h, l, c, v, short, long):
+    line = ad(h, l, c, v)
+    return [a - b for a, b in zip(ema(line, short), ema(line, long))]
+
+
+def eom(h, l, v, n, scale=100000000):
+    emv = [NAN] + [((h[i] + l[i]) / 2 - (h[i - 1] + l[i - 1]) / 2) / (v[i] / scale / (h[i] - l[i])) for i in range(1, len(h))]
+    return sma(emv, n)
+
+
+def force_index(c, v, n):
+    return ema([NAN] + [(c[i] - c[i - 1]) * v[i] for i in range(1, len(c))], n)
+
+
+def donchian(h, l, n):
+    upper, lower = [NAN] * len(h), [NAN] * len(h)
+    for i in range(n - 1, len(h)):
+        upper[i], lower[i] = max(h[i - n + 1 : i + 1]), min(l[i - n + 1 : i + 1])
+    return upper, [(a + b) / 2 for a, b in zip(upper, lower)], lower
+
+
+def ichimoku(h, l, c, tenkan_n, kijun_n, senkou_n, displacement):
+    """The senkou spans are the cloud drawn at each bar, i.e. the values of
+    displacement bars earlier; chikou is the close displacement bars later."""
+    tenkan, kijun, senkou_b = donchian(h, l, tenkan_n)[1], donchian(h, l, kijun_n)[1], donchian(h, l, senkou_n)[1]
+    n = len(c)
+    senkou_a = [NAN] * displacement + [(tenkan[i] + kijun[i]) / 2 for i in range(n - displacement)]
+    senkou_b = [NAN] * displacement + senkou_b[: n - displacement]
+    chikou = c[displacement:] + [NAN] * displacement
+    return tenkan, kijun, senkou_a, senkou_b, chikou
+
+
+def keltner(h, l, c, n, atr_n, mult):
+    mid, a = ema(c, n), atr(h, l, c, atr_n)
+    return [m + mult * r for m, r in zip(mid, a)], mid, [m - mult * r for m, r in zip(mid, a)]
+
+
+def envelopes(x, n, percent):
+    mid = sma(x, n)
+    return [m * (1 + percent / 100) for m in mid], mid, [m * (1 - percent / 100) for m in mid]
+
+
+def percent_b(x, n, k):
+    upper, _, lower = bbands(x, n, k)
+    return [0.5 if u == d else (v - d) / (u - d) for v, u, d in zip(x, upper, lower)]
+
+
+def bandwidth(x, n, k):
+    upper, mid, lower = bbands(x, n, k)
+    return [(u - d) / m for u, m, d in zip(upper, mid, lower)]
+
+
+def write(path, columns):
+    names = list(columns)
+    with open(path, "w", newline="") as f:
+        w = csv.writer(f)
+        w.writerow(names)
+        for i in range(len(columns[names[0]])):
+            w.writerow(["" if math.isnan(columns[k][i]) else repr(columns[k][i]) for k in names])
+
+
+def main():
+    rows = ohlcv()
+    with open("ohlcv.csv", "w", newline="") as f:
+        w = csv.writer(f)
+        w.writerow(["time", "open", "high", "low", "close", "volume"])
+        w.writerows(rows)
+
+    o = [r[1] for r in rows]
+    h = [r[2] for r in rows]
+    l = [r[3] for r in rows]
+    c = [r[4] for r in rows]
+    v = [r[5] for r in rows]
+
+    columns = {
+        "sma20": sma(c, 20),
+        "ema20": ema(c, 20),
+        "wma20": wma(c, 20),
+        "hma20": hma(c, 20),
+        "rsi14": rsi(c, 14),
+    }
+    columns["macd_line"], columns["macd_signal"], columns["macd_hist"] = macd(c, 12, 26, 9)
+    columns["bb_upper"], columns["bb_middle"], columns["bb_lower"] = bbands(c, 20, 2)
+    columns["atr14"] = atr(h, l, c, 14)
+    columns["di_plus14"], columns["di_minus14"] = dmi(h, l, c, 14)
+    columns["adx14"] = adx(h, l, c, 14)
+    columns["stoch_k14"], columns["stoch_d3"] = stochastic(h, l, c, 14, 3)
+    columns["sar"] = sar(h, l)
+    write("indicators.csv", columns)
+
+    columns = {}
+    columns["supertrend"], columns["supertrend_dir"] = supertrend(h, l, c, 10, 3)
+    columns["aroon_up"], columns["aroon_down"], columns["aroon_osc"] = aroon(h, l, 25)
+    columns["cci20"] = cci(h, l, c, 20)
+    columns["willr14"] = willr(h, l, c, 14)
+    columns["trix15"] = trix(c, 15)
+    columns["kama10"] = kama(c, 10, 2, 30)
+    columns["vortex_plus14"], columns["vortex_minus14"] = vortex(h, l, c, 14)
+    columns["ultosc"] = ultosc(h, l, c, 7, 14, 28)
+    columns["stochrsi_k"], columns["stochrsi_d"] = stochrsi(c, 14, 14, 3, 3)
+    columns["di_plus7"], columns["di_minus7"] = dmi(h, l, c, 7)
+    write("oscillators.csv", columns)
+
+    columns = {"supersmoother10": super_smoother(c, 10)}
+    columns["dcperiod"], columns["mama"], columns["fama"] = mesa(c, 0.5, 0.05)
+    write("cycles.csv", columns)
+
+    columns = {
+        "hv20": hv(c, 20),
+        "parkinson20": parkinson(h, l, 20),
+        "garmanklass20": garman_klass(o, h, l, c, 20),
+        "rogerssatchell20": rogers_satchell(o, h, l, c, 20),
+        "yangzhang20": yang_zhang(o, h, l, c, 20),
+    }
+    columns["yangzhang_percentile50"] = percentile(columns["yangzhang20"], 50)
+    columns["yangzhang_rank50"] = rank(columns["yangzhang20"], 50)
+    write("volatility.csv", columns)
+
+    columns = {
+        "obv": obv(c, v),
+        "mfi14": mfi(h, l, c, v, 14),
+        "ad": ad(h, l, c, v),
+        "cmf20": cmf(h, l, c, v, 20),
+        "chaikin_osc": chaikin_osc(h, l, c, v, 3, 10),
+        "eom14": eom(h, l, v, 14),
+        "force13": force_index(c, v, 13),
+    }
+    write("volume.csv", columns)
+
+    columns = {}
+    columns["donchian_upper20"], columns["donchian_middle20"], columns["donchian_lower20"] = donchian(h, l, 20)
+    (columns["tenkan"], columns["kijun"], columns["senkou_a"], columns["senkou_b"],
+     columns["chikou"]) = ichimoku(h, l, c, 9, 26, 52, 26)
+    columns["keltner_upper"], columns["keltner_middle"], columns["keltner_lower"] = keltner(h, l, c, 20, 10, 2)
+    columns["envelope_upper"], columns["envelope_middle"], columns["envelope_lower"] = envelopes(c, 20, 2.5)
+    columns["percent_b20"] = percent_b(c, 20, 2)
+    columns["bandwidth20"] = bandwidth(c, 20, 2)
+    write("channels.csv", columns)
+
+
+if __name__ == "__main__":
+    main()
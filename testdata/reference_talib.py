@@ -0,0 +1,144 @@
+#!/usr/bin/env python3
+"""Regenerates the reference values that TA-Lib defines from TA-Lib itself.
+
+Run it from the testdata directory after reference.py, with the TA-Lib
+Python wrapper and numpy installed:
+
+    python3 reference.py && python3 reference_talib.py
+
+The columns TA-Lib computes the same way, warm-up included, are replaced in the
+CSV files reference.py wrote, and the largest change of each is printed. The
+others are left as reference.py wrote them. TA-Lib seeds MACD, the directional
+indicators, the Chaikin oscillator, KAMA and MAMA differently, so those are
+only checked against TA-Lib on the last bars, once the seed no longer matters.
+"""
+
+import csv
+import math
+import sys
+
+import numpy as np
+import talib
+
+# Relative difference allowed on the checked columns
+CHECK_TOLERANCE = 1e-3
+CHECK_BARS = 20
+
+
+def read(path):
+    with open(path, newline="") as f:
+        rows = list(csv.reader(f))
+    return {name: [float(row[j]) if row[j] else math.nan for row in rows[1:]] for j, name in enumerate(rows[0])}, rows[0]
+
+
+def write(path, columns, names):
+    with open(path, "w", newline="") as f:
+        w = csv.writer(f)
+        w.writerow(names)
+        for i in range(len(columns[names[0]])):
+            w.writerow(["" if math.isnan(columns[k][i]) else repr(float(columns[k][i])) for k in names])
+
+
+def replace(path, generated):
+    columns, names = read(path)
+    for name, values in generated.items():
+        old = np.array(columns[name])
+        new = np.asarray(values, dtype=float)
+        if (np.isnan(old) != np.isnan(new)).any():
+            print(f"{path} {name}: warm-up differs from reference.py")
+        diff = np.nanmax(np.abs(new - old) / np.maximum(1, np.abs(old)))
+        print(f"{path} {name}: largest change {diff:.3g}")
+        columns[name] = list(new)
+    write(path, columns, names)
+
+
+def check(path, generated):
+    columns, _ = read(path)
+    ok = True
+    for name, values in generated.items():
+        old = np.array(columns[name][-CHECK_BARS:])
+        new = np.asarray(values, dtype=float)[-CHECK_BARS:]
+        diff = np.max(np.abs(new - old) / np.maximum(1, np.abs(old)))
+        print(f"{path} {name}: differs from TA-Lib by {diff:.3g} on the last {CHECK_BARS} bars")
+        ok = ok and diff <= CHECK_TOLERANCE
+    return ok
+
+
+def main():
+    data, _ = read("ohlcv.csv")
+    o, h, l, c, v = (np.array(data[k]) for k in ("open", "high", "low", "close", "volume"))
+
+    # TA-Lib delays every output of a function to its last one, the first
+    # output is taken alone where the fixture starts it earlier
+    stoch_k, _ = talib.STOCHF(h, l, c, fastk_period=14, fastd_period=1)
+    _, stoch_d = talib.STOCHF(h, l, c, fastk_period=14, fastd_period=3, fastd_matype=talib.MA_Type.SMA)
+    bb_upper, bb_middle, bb_lower = talib.BBANDS(c, timeperiod=20, nbdevup=2, nbdevdn=2, matype=talib.MA_Type.SMA)
+    replace("indicators.csv", {
+        "sma20": talib.SMA(c, 20),
+        "ema20": talib.EMA(c, 20),
+        "wma20": talib.WMA(c, 20),
+        "rsi14": talib.RSI(c, 14),
+        "bb_upper": bb_upper,
+        "bb_middle": bb_middle,
+        "bb_lower": bb_lower,
+        "atr14": talib.ATR(h, l, c, 14),
+        "stoch_k14": stoch_k,
+        "stoch_d3": stoch_d,
+        "sar": talib.SAR(h, l, acceleration=0.02, maximum=0.2),
+    })
+
+    rsi = talib.RSI(c, 14)
+    stochrsi_k, _ = talib.STOCH(rsi, rsi, rsi, fastk_period=14, slowk_period=3, slowk_matype=talib.MA_Type.SMA,
+                                slowd_period=1)
+    _, stochrsi_d = talib.STOCH(rsi, rsi, rsi, fastk_period=14, slowk_period=3, slowk_matype=talib.MA_Type.SMA,
+                                slowd_period=3, slowd_matype=talib.MA_Type.SMA)
+    aroon_down, aroon_up = talib.AROON(h, l, 25)
+    replace("oscillators.csv", {
+        "aroon_up": aroon_up,
+        "aroon_down": aroon_down,
+        "aroon_osc": talib.AROONOSC(h, l, 25),
+        "cci20": talib.CCI(h, l, c, 20),
+        "willr14": talib.WILLR(h, l, c, 14),
+        "trix15": talib.TRIX(c, 15),
+        "ultosc": talib.ULTOSC(h, l, c, 7, 14, 28),
+        "stochrsi_k": stochrsi_k,
+        "stochrsi_d": stochrsi_d,
+    })
+
+    replace("volume.csv", {
+        "obv": talib.OBV(c, v),
+        "mfi14": talib.MFI(h, l, c, v, 14),
+        "ad": talib.AD(h, l, c, v),
+    })
+
+    macd_line, macd_signal, macd_hist = talib.MACD(c, 12, 26, 9)
+    mama, fama = talib.MAMA(c, fastlimit=0.5, slowlimit=0.05)
+    ok = all([
+        check("indicators.csv", {
+            "macd_line": macd_line,
+            "macd_signal": macd_signal,
+            "macd_hist": macd_hist,
+            "di_plus14": talib.PLUS_DI(h, l, c, 14),
+            "di_minus14": talib.MINUS_DI(h, l, c, 14),
+            "adx14": talib.ADX(h, l, c, 14),
+        }),
+        check("oscillators.csv", {
+            "kama10": talib.KAMA(c, 10),
+            "di_plus7": talib.PLUS_DI(h, l, c, 7),
+            "di_minus7": talib.MINUS_DI(h, l, c, 7),
+        }),
+        check("cycles.csv", {
+            "dcperiod": talib.HT_DCPERIOD(c),
+            "mama": mama,
+            "fama": fama,
+        }),
+        check("volume.csv", {
+            "chaikin_osc": talib.ADOSC(h, l, c, v, 3, 10),
+        }),
+    ])
+    if not ok:
+        sys.exit("reference values disagree with TA-Lib")
+
+
+if __name__ == "__main__":
+    main()
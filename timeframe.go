@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// barEnd returns the time at which a candle is closed. Binance close times are
+// the last millisecond of the bar, klineToCandlestick truncates them to the
+// second, so both are rounded up to the next whole second.
+func barEnd(candle Candlestick) time.Time {
+	return candle.CloseTime.Truncate(time.Second).Add(time.Second)
+}
+
+// weekEpoch is the first Monday after the Unix epoch. Weekly bars open on
+// Mondays, like Binance's.
+var weekEpoch = time.Unix(4*24*60*60, 0)
+
+// alignTime returns the open time of the bar of length d containing t. Bars
+// are counted from the Unix epoch, or from weekEpoch for whole weeks.
+func alignTime(t time.Time, d time.Duration) time.Time {
+	epoch := time.Unix(0, 0)
+	if d%(7*24*time.Hour) == 0 {
+		epoch = weekEpoch
+	}
+	offset := t.Sub(epoch) % d
+	if offset < 0 {
+		offset += d
+	}
+	return t.Add(-offset)
+}
+
+// checkTimeframe returns an error unless interval is a whole multiple of the
+// base duration, so that its bars are made of whole base bars.
+func checkTimeframe(base time.Duration, interval string) error {
+	duration, err := intervalDuration(interval)
+	if err != nil {
+		return err
+	}
+	if duration < base || duration%base != 0 {
+		return fmt.Errorf("interval %s is not a multiple of the base interval %s", interval, base)
+	}
+	return nil
+}
+
+// baseDuration returns the length of the bars of data, 0 without candles.
+func baseDuration(data []Candlestick) time.Duration {
+	if len(data) == 0 {
+		return 0
+	}
+	return barEnd(data[0]).Sub(data[0].OpenTime)
+}
+
+// resampleCandles aggregates candles ordered by time into bars of a higher
+// interval aligned by alignTime. The first bar is dropped unless the candles
+// start at its open and the last bar unless they reach its end, so every
+// returned bar is complete and closed.
+func resampleCandles(data []Candlestick, interval string) ([]Candlestick, error) {
+	duration, err := intervalDuration(interval)
+	if err != nil {
+		return nil, err
+	}
+
+	var bars []Candlestick
+	for _, candle := range data {
+		openTime := alignTime(candle.OpenTime.UTC(), duration)
+		if len(bars) == 0 || !bars[len(bars)-1].OpenTime.Equal(openTime) {
+			bars = append(bars, Candlestick{
+				OpenTime:  openTime,
+				Open:      candle.Open,
+				High:      candle.High,
+				Low:       candle.Low,
+				CloseTime: openTime.Add(duration - time.Millisecond),
+			})
+		}
+
+		bar := &bars[len(bars)-1]
+		bar.High = math.Max(bar.High, candle.High)
+		bar.Low = math.Min(bar.Low, candle.Low)
+		bar.Close = candle.Close
+		bar.Volume += candle.Volume
+		bar.QuoteAssetVolume += candle.QuoteAssetVolume
+		bar.TakerBuyBaseAssetVolume += candle.TakerBuyBaseAssetVolume
+		bar.TakerBuyQuoteAssetVolume += candle.TakerBuyQuoteAssetVolume
+	}
+
+	if len(bars) > 0 && data[0].OpenTime.After(bars[0].OpenTime) {
+		bars = bars[1:]
+	}
+	if len(bars) > 0 && barEnd(data[len(data)-1]).Before(barEnd(bars[len(bars)-1])) {
+		bars = bars[:len(bars)-1]
+	}
+	return bars, nil
+}
+
+// alignToTimeframe maps values computed on higher-interval bars onto the
+// lower-interval candles. Each lower candle gets the value of the latest higher
+// bar that had closed by the time the lower candle closed, so no value is
+// used before it was known. Candles before the first closed higher bar are NaN.
+func alignToTimeframe(higher []Candlestick, values []float64, lower []Candlestick) []float64 {
+	aligned := nanSlice(len(lower))
+	j := -1
+	for i, candle := range lower {
+		end := barEnd(candle)
+		for j+1 < len(higher) && j+1 < len(values) && !barEnd(higher[j+1]).After(end) {
+			j++
+		}
+		if j >= 0 {
+			aligned[i] = values[j]
+		}
+	}
+	return aligned
+}
+
+// MultiTimeframe gives access to higher-interval views of a base candle
+// series. Frames are resampled from the base candles on first use unless they
+// were set from another source, e.g. klines fetched at that interval.
+type MultiTimeframe struct {
+	Base   []Candlestick
+	frames map[string][]Candlestick
+}
+
+func NewMultiTimeframe(base []Candlestick) *MultiTimeframe {
+	return &MultiTimeframe{Base: base, frames: make(map[string][]Candlestick)}
+}
+
+// SetFrame sets the candles of an interval. Bars that had not closed by the
+// end of the base series are ignored when aligning.
+func (m *MultiTimeframe) SetFrame(interval string, candles []Candlestick) {
+	m.frames[interval] = candles
+}
+
+// Frame returns the candles of the interval.
+func (m *MultiTimeframe) Frame(interval string) ([]Candlestick, error) {
+	if candles, ok := m.frames[interval]; ok {
+		return candles, nil
+	}
+	candles, err := resampleCandles(m.Base, interval)
+	if err != nil {
+		return nil, err
+	}
+	m.frames[interval] = candles
+	return candles, nil
+}
+
+// Evaluate computes an indicator on the interval's candles and aligns it to
+// the base series. The interval must be a multiple of the base interval.
+func (m *MultiTimeframe) Evaluate(interval string, indicator func(data []Candlestick) []float64) ([]float64, error) {
+	if base := baseDuration(m.Base); base > 0 {
+		if err := checkTimeframe(base, interval); err != nil {
+			return nil, err
+		}
+	}
+	candles, err := m.Frame(interval)
+	if err != nil {
+		return nil, fmt.Errorf("timeframe %s: %w", interval, err)
+	}
+	return alignToTimeframe(candles, indicator(candles), m.Base), nil
+}
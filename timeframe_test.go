@@ -0,0 +1,100 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// minuteCandles returns n one-minute candles from start with a rising close.
+func minuteCandles(start time.Time, n int) []Candlestick {
+	data := make([]Candlestick, n)
+	for i := range data {
+		price := 100 + float64(i) + math.Sin(float64(i))
+		data[i] = testCandle(start.Add(time.Duration(i)*time.Minute), price)
+	}
+	return data
+}
+
+func TestAlignTime(t *testing.T) {
+	tests := []struct {
+		t        time.Time
+		interval time.Duration
+		want     time.Time
+	}{
+		{time.Date(2024, 3, 5, 13, 47, 12, 0, time.UTC), time.Hour, time.Date(2024, 3, 5, 13, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 3, 5, 13, 47, 12, 0, time.UTC), 4 * time.Hour, time.Date(2024, 3, 5, 12, 0, 0, 0, time.UTC)},
+		// 3 day bars are counted from 1970-01-01
+		{time.Date(2024, 3, 5, 13, 0, 0, 0, time.UTC), 3 * 24 * time.Hour, time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC)},
+		// Weeks start on Monday
+		{time.Date(2024, 3, 7, 13, 0, 0, 0, time.UTC), 7 * 24 * time.Hour, time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{time.Date(1969, 12, 31, 23, 30, 0, 0, time.UTC), time.Hour, time.Date(1969, 12, 31, 23, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := alignTime(tt.t, tt.interval); !got.Equal(tt.want) {
+			t.Errorf("alignTime(%v, %v) = %v, want %v", tt.t, tt.interval, got.UTC(), tt.want)
+		}
+	}
+}
+
+func TestTimeframeMultiple(t *testing.T) {
+	for _, expr := range []string{`tf("5m", close)`, `tf("1h", ema(close, 3))`, `tf("1h", tf("4h", close))`} {
+		study, err := CompileStudy(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := study.ValidateInterval("1m"); err != nil {
+			t.Errorf("%s: %v", expr, err)
+		}
+	}
+
+	for _, expr := range []string{`tf("1s", close)`, `tf("90s", close)`, `tf("4h", tf("90m", close))`} {
+		study, err := CompileStudy(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := study.ValidateInterval("1m"); err == nil {
+			t.Errorf("%s accepted on 1m candles", expr)
+		}
+	}
+
+	// Without validation, evaluation still refuses to resample below the base
+	study, _ := CompileStudy(`tf("1s", close)`)
+	data := minuteCandles(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), 10)
+	for i, value := range study.Evaluate(data) {
+		if !math.IsNaN(value) {
+			t.Fatalf("tf(\"1s\") on 1m candles is %v at %d", value, i)
+		}
+	}
+}
+
+func TestTimeframeNoLookAhead(t *testing.T) {
+	data := minuteCandles(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), 4*60)
+	study, err := CompileStudy(`tf("1h", ema(close, 2))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	full := study.Evaluate(data)
+
+	for i := range data {
+		// Evaluating live, with bar i the last one, gives the same value
+		live := study.Evaluate(data[:i+1])[i]
+		if math.IsNaN(live) != math.IsNaN(full[i]) || !math.IsNaN(live) && live != full[i] {
+			t.Fatalf("value at %v is %v live and %v afterwards", data[i].OpenTime, live, full[i])
+		}
+
+		// The 1h value only changes when an hour closes, at minute 59
+		if i > 0 && data[i].OpenTime.Minute() != 59 && !math.IsNaN(full[i-1]) && full[i] != full[i-1] {
+			t.Fatalf("value changed within an open hour at %v", data[i].OpenTime)
+		}
+	}
+
+	// The first 1h value appears when the second hour closes, as the EMA has
+	// a window of 2
+	if !math.IsNaN(full[118]) || math.IsNaN(full[119]) {
+		t.Errorf("first value at the wrong bar: %v, %v", full[118], full[119])
+	}
+	if want := (data[59].Close + data[119].Close) / 2; full[119] != want {
+		t.Errorf("first value = %v, want %v", full[119], want)
+	}
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// calculateHeikinAshi returns the Heikin-Ashi candles. The close is the mean
+// of the bar's prices and the open the midpoint of the previous Heikin-Ashi
+// body, starting from the first candle's body.
+func calculateHeikinAshi(data []Candlestick) []Candlestick {
+	ha := make([]Candlestick, len(data))
+	for i, candle := range data {
+		ha[i] = candle
+		ha[i].Close = (candle.Open + candle.High + candle.Low + candle.Close) / 4
+		if i == 0 {
+			ha[i].Open = (candle.Open + candle.Close) / 2
+		} else {
+			ha[i].Open = (ha[i-1].Open + ha[i-1].Close) / 2
+		}
+		ha[i].High = math.Max(candle.High, math.Max(ha[i].Open, ha[i].Close))
+		ha[i].Low = math.Min(candle.Low, math.Min(ha[i].Open, ha[i].Close))
+	}
+	return ha
+}
+
+// BarBuilder builds price-driven bars from a stream of prices. Add returns the
+// bars completed by the price, Current the bar in progress. Bars are returned
+// as candles so the indicators can run on them; OpenTime and CloseTime are the
+// times of the first and last price of the bar.
+type BarBuilder interface {
+	Add(t time.Time, price, volume float64) []Candlestick
+	Current() (Candlestick, bool)
+}
+
+// barsFromCandles feeds each candle to the builder as the price path open,
+// low, high, close for up candles and open, high, low, close for down
+// candles, with the volume on the close.
+func barsFromCandles(data []Candlestick, builder BarBuilder) []Candlestick {
+	var bars []Candlestick
+	for _, candle := range data {
+		path := []float64{candle.Open, candle.High, candle.Low}
+		if candle.Close >= candle.Open {
+			path[1], path[2] = candle.Low, candle.High
+		}
+		for _, price := range path {
+			bars = append(bars, builder.Add(candle.OpenTime, price, 0)...)
+		}
+		bars = append(bars, builder.Add(candle.CloseTime, candle.Close, candle.Volume)...)
+	}
+	return bars
+}
+
+// barsFromTrades feeds trades ordered by time to the builder.
+func barsFromTrades(trades []Trade, builder BarBuilder) []Candlestick {
+	var bars []Candlestick
+	for _, trade := range trades {
+		bars = append(bars, builder.Add(time.UnixMilli(trade.Time), trade.Price, trade.Quantity)...)
+	}
+	return bars
+}
+
+// transformSeries feeds the candles to the builder and returns the close,
+// open, high, low and direction (1 up, -1 down) of the latest bar completed
+// by each candle, NaN before the first one.
+func transformSeries(data []Candlestick, builder BarBuilder) [][]float64 {
+	series := make([][]float64, 5)
+	for i := range series {
+		series[i] = nanSlice(len(data))
+	}
+
+	var last *Candlestick
+	for i := range data {
+		if bars := barsFromCandles(data[i:i+1], builder); len(bars) > 0 {
+			last = &bars[len(bars)-1]
+		}
+		if last == nil {
+			continue
+		}
+		series[0][i], series[1][i], series[2][i], series[3][i] = last.Close, last.Open, last.High, last.Low
+		series[4][i] = 1
+		if last.Close < last.Open {
+			series[4][i] = -1
+		}
+	}
+	return series
+}
+
+// TransformATRWindow is the ATR window sizing the bars of the registry's
+// transforms when no size is given.
+const TransformATRWindow = 14
+
+// transformSize returns size, or the latest ATR of the candles when size is
+// 0. It returns 0, which builds no bars, when there are too few candles.
+func transformSize(data []Candlestick, size float64) float64 {
+	if size != 0 {
+		return size
+	}
+	atr, err := atrBoxSize(data, TransformATRWindow)
+	if err != nil {
+		return 0
+	}
+	return atr
+}
+
+// atrBoxSize returns the latest ATR of the candles, the usual box size of
+// ATR-based Renko charts.
+func atrBoxSize(data []Candlestick, window int) (float64, error) {
+	high := make([]float64, len(data))
+	low := make([]float64, len(data))
+	for i, candle := range data {
+		high[i] = candle.High
+		low[i] = candle.Low
+	}
+	atr := calculateATR(high, low, closePrices(data), window)
+	if len(atr) == 0 || math.IsNaN(atr[len(atr)-1]) || atr[len(atr)-1] <= 0 {
+		return 0, fmt.Errorf("not enough candles for an ATR of %d bars", window)
+	}
+	return atr[len(atr)-1], nil
+}
+
+// pendingBar collects the time and volume of the prices since the last
+// completed bar.
+type pendingBar struct {
+	started   bool
+	openTime  time.Time
+	closeTime time.Time
+	volume    float64
+}
+
+func (p *pendingBar) add(t time.Time, volume float64) {
+	if !p.started {
+		p.started = true
+		p.openTime = t
+	}
+	p.closeTime = t
+	p.volume += volume
+}
+
+// take returns a bar from open to close carrying the pending time and volume,
+// and resets the pending state to start at the bar's close time.
+func (p *pendingBar) take(open, close float64) Candlestick {
+	bar := Candlestick{
+		OpenTime:  p.openTime,
+		Open:      open,
+		High:      math.Max(open, close),
+		Low:       math.Min(open, close),
+		Close:     close,
+		Volume:    p.volume,
+		CloseTime: p.closeTime,
+	}
+	p.openTime = p.closeTime
+	p.volume = 0
+	return bar
+}
+
+// RenkoBuilder builds Renko bricks of BoxSize. A brick needs the price to move
+// one box beyond the top or bottom of the last brick, so continuing the trend
+// takes one box and a reversal two.
+type RenkoBuilder struct {
+	BoxSize float64
+
+	pending     pendingBar
+	top, bottom float64
+	close, last float64
+}
+
+func NewRenkoBuilder(boxSize float64) *RenkoBuilder {
+	return &RenkoBuilder{BoxSize: boxSize}
+}
+
+func (b *RenkoBuilder) Add(t time.Time, price, volume float64) []Candlestick {
+	if b.BoxSize <= 0 {
+		return nil
+	}
+	if !b.pending.started {
+		b.top, b.bottom, b.close = price, price, price
+	}
+	b.pending.add(t, volume)
+	b.last = price
+
+	var bricks []Candlestick
+	for {
+		switch {
+		case price >= b.top+b.BoxSize:
+			bricks = append(bricks, b.pending.take(b.top, b.top+b.BoxSize))
+			b.bottom, b.top = b.top, b.top+b.BoxSize
+			b.close = b.top
+		case price <= b.bottom-b.BoxSize:
+			bricks = append(bricks, b.pending.take(b.bottom, b.bottom-b.BoxSize))
+			b.top, b.bottom = b.bottom, b.bottom-b.BoxSize
+			b.close = b.bottom
+		default:
+			return bricks
+		}
+	}
+}
+
+// Current returns the move since the last brick, from its close to the latest
+// price.
+func (b *RenkoBuilder) Current() (Candlestick, bool) {
+	if !b.pending.started {
+		return Candlestick{}, false
+	}
+	pending := b.pending
+	return pending.take(b.close, b.last), true
+}
+
+// RangeBarBuilder builds bars that each span Range from high to low. A bar is
+// completed as soon as the price moves beyond its range, at the edge of the
+// range, and the next bar opens there; gaps produce several bars.
+type RangeBarBuilder struct {
+	Range float64
+
+	pending pendingBar
+	bar     Candlestick
+}
+
+func NewRangeBarBuilder(rangeSize float64) *RangeBarBuilder {
+	return &RangeBarBuilder{Range: rangeSize}
+}
+
+func (b *RangeBarBuilder) Add(t time.Time, price, volume float64) []Candlestick {
+	if b.Range <= 0 {
+		return nil
+	}
+	if !b.pending.started {
+		b.bar = Candlestick{Open: price, High: price, Low: price, Close: price}
+	}
+	b.pending.add(t, volume)
+
+	var bars []Candlestick
+	for {
+		var edge float64
+		switch {
+		case price > b.bar.Low+b.Range:
+			edge = b.bar.Low + b.Range
+			b.bar.High = edge
+		case price < b.bar.High-b.Range:
+			edge = b.bar.High - b.Range
+			b.bar.Low = edge
+		default:
+			b.bar.High = math.Max(b.bar.High, price)
+			b.bar.Low = math.Min(b.bar.Low, price)
+			b.bar.Close = price
+			return bars
+		}
+
+		completed := b.pending.take(b.bar.Open, edge)
+		completed.High, completed.Low = b.bar.High, b.bar.Low
+		bars = append(bars, completed)
+		b.bar = Candlestick{Open: edge, High: edge, Low: edge, Close: edge}
+	}
+}
+
+func (b *RangeBarBuilder) Current() (Candlestick, bool) {
+	if !b.pending.started {
+		return Candlestick{}, false
+	}
+	pending := b.pending
+	bar := pending.take(b.bar.Open, b.bar.Close)
+	bar.High, bar.Low = b.bar.High, b.bar.Low
+	return bar, true
+}
+
+// KagiBuilder builds Kagi lines. A line follows the price in its direction
+// and a new line in the other direction starts once the price reverses by
+// Reversal from the line's extreme. Up lines are candles from their start up
+// to their end, down lines the opposite.
+type KagiBuilder struct {
+	Reversal float64
+
+	pending    pendingBar
+	start, end float64
+	direction  int
+}
+
+func NewKagiBuilder(reversal float64) *KagiBuilder {
+	return &KagiBuilder{Reversal: reversal}
+}
+
+func (b *KagiBuilder) Add(t time.Time, price, volume float64) []Candlestick {
+	if b.Reversal <= 0 {
+		return nil
+	}
+	if !b.pending.started {
+		b.start, b.end = price, price
+	}
+	b.pending.add(t, volume)
+
+	switch {
+	case b.direction == 0:
+		if math.Abs(price-b.start) >= b.Reversal {
+			b.end = price
+			b.direction = 1
+			if price < b.start {
+				b.direction = -1
+			}
+		}
+	case b.direction > 0 && price > b.end, b.direction < 0 && price < b.end:
+		b.end = price
+	case b.direction > 0 && price <= b.end-b.Reversal, b.direction < 0 && price >= b.end+b.Reversal:
+		line := b.pending.take(b.start, b.end)
+		b.start, b.end = b.end, price
+		b.direction = -b.direction
+		return []Candlestick{line}
+	}
+	return nil
+}
+
+func (b *KagiBuilder) Current() (Candlestick, bool) {
+	if b.direction == 0 {
+		return Candlestick{}, false
+	}
+	pending := b.pending
+	return pending.take(b.start, b.end), true
+}
+
+// PointAndFigureBuilder builds point and figure columns on a grid of
+// BoxSize. X columns rise one box at a time, O columns fall, and a new column
+// starts once the price reverses by ReversalBoxes boxes. X columns are
+// candles from their lowest to their highest box, O columns the opposite.
+type PointAndFigureBuilder struct {
+	BoxSize       float64
+	ReversalBoxes int
+
+	pending   pendingBar
+	low, high float64
+	direction int
+}
+
+func NewPointAndFigureBuilder(boxSize float64, reversalBoxes int) *PointAndFigureBuilder {
+	return &PointAndFigureBuilder{BoxSize: boxSize, ReversalBoxes: reversalBoxes}
+}
+
+// boxes returns the number of whole boxes in distance.
+func (b *PointAndFigureBuilder) boxes(distance float64) float64 {
+	// Allow for rounding errors of prices that are exactly on the grid
+	return math.Floor(distance/b.BoxSize + 1e-9)
+}
+
+func (b *PointAndFigureBuilder) Add(t time.Time, price, volume float64) []Candlestick {
+	if b.BoxSize <= 0 || b.ReversalBoxes <= 0 {
+		return nil
+	}
+	if !b.pending.started {
+		b.low = b.boxes(price) * b.BoxSize
+		b.high = b.low
+	}
+	b.pending.add(t, volume)
+
+	reversal := float64(b.ReversalBoxes) * b.BoxSize
+	switch {
+	case b.direction >= 0 && price >= b.high+b.BoxSize:
+		b.high += b.boxes(price-b.high) * b.BoxSize
+		b.direction = 1
+	case b.direction <= 0 && price <= b.low-b.BoxSize:
+		b.low -= b.boxes(b.low-price) * b.BoxSize
+		b.direction = -1
+	case b.direction > 0 && price <= b.high-reversal:
+		column := b.pending.take(b.low, b.high)
+		b.high, b.low = b.high-b.BoxSize, b.high-b.boxes(b.high-price)*b.BoxSize
+		b.direction = -1
+		return []Candlestick{column}
+	case b.direction < 0 && price >= b.low+reversal:
+		column := b.pending.take(b.high, b.low)
+		b.low, b.high = b.low+b.BoxSize, b.low+b.boxes(price-b.low)*b.BoxSize
+		b.direction = 1
+		return []Candlestick{column}
+	}
+	return nil
+}
+
+func (b *PointAndFigureBuilder) Current() (Candlestick, bool) {
+	if b.direction == 0 {
+		return Candlestick{}, false
+	}
+	pending := b.pending
+	if b.direction < 0 {
+		return pending.take(b.high, b.low), true
+	}
+	return pending.take(b.low, b.high), true
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// feedPrices adds the prices a second apart, each with a volume of 1, and
+// returns the open and close of the completed bars.
+func feedPrices(builder BarBuilder, prices ...float64) [][2]float64 {
+	start := time.Unix(1700000040, 0)
+	var bars [][2]float64
+	for i, price := range prices {
+		for _, bar := range builder.Add(start.Add(time.Duration(i)*time.Second), price, 1) {
+			bars = append(bars, [2]float64{bar.Open, bar.Close})
+		}
+	}
+	return bars
+}
+
+func sameBars(a, b [][2]float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if math.Abs(a[i][0]-b[i][0]) > 1e-9 || math.Abs(a[i][1]-b[i][1]) > 1e-9 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestBarBuilders(t *testing.T) {
+	tests := []struct {
+		name    string
+		builder BarBuilder
+		prices  []float64
+		want    [][2]float64
+		current [2]float64
+	}{
+		// A brick per box, a gap makes two bricks and a reversal needs two
+		// boxes and opens at the bottom of the last brick
+		{"renko", NewRenkoBuilder(10), []float64{100, 105, 110, 131, 112, 105},
+			[][2]float64{{100, 110}, {110, 120}, {120, 130}, {120, 110}}, [2]float64{110, 105}},
+		// Bars close at the edge of the range, a gap fills several bars and a
+		// reversal closes the range from the high
+		{"range", NewRangeBarBuilder(10), []float64{100, 105, 111, 135, 124},
+			[][2]float64{{100, 110}, {110, 120}, {120, 130}, {130, 125}}, [2]float64{125, 124}},
+		// A line follows the price until it reverses by 10, a gap reverses
+		// once
+		{"kagi", NewKagiBuilder(10), []float64{100, 105, 112, 120, 111, 110, 95, 130},
+			[][2]float64{{100, 120}, {120, 95}}, [2]float64{95, 130}},
+		// Columns move by whole boxes, gaps add several boxes and a reversal
+		// needs three boxes
+		{"pnf", NewPointAndFigureBuilder(10, 3), []float64{100, 125, 135, 105, 100, 75, 105, 110},
+			[][2]float64{{100, 130}, {120, 80}}, [2]float64{90, 110}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := feedPrices(tt.builder, tt.prices...); !sameBars(got, tt.want) {
+				t.Errorf("got bars %v, want %v", got, tt.want)
+			}
+			current, ok := tt.builder.Current()
+			if !ok || current.Open != tt.current[0] || current.Close != tt.current[1] {
+				t.Errorf("current bar = %v to %v, want %v", current.Open, current.Close, tt.current)
+			}
+		})
+	}
+}
+
+func TestBarBuilderVolume(t *testing.T) {
+	// Each brick carries the volume and times of the trades since the last
+	// one
+	start := time.Unix(1700000040, 0)
+	trades := []Trade{
+		{Price: 100, Quantity: 1, Time: start.UnixMilli()},
+		{Price: 105, Quantity: 2, Time: start.UnixMilli() + 1000},
+		{Price: 110, Quantity: 3, Time: start.UnixMilli() + 2000},
+		{Price: 121, Quantity: 4, Time: start.UnixMilli() + 3000},
+	}
+	bricks := barsFromTrades(trades, NewRenkoBuilder(10))
+	if len(bricks) != 2 {
+		t.Fatalf("got %d bricks, want 2", len(bricks))
+	}
+	if bricks[0].Volume != 6 || !bricks[0].OpenTime.Equal(start) || !bricks[0].CloseTime.Equal(start.Add(2*time.Second)) {
+		t.Errorf("first brick = %+v, want a volume of 6 from the first to the third trade", bricks[0])
+	}
+	if bricks[1].Volume != 4 || !bricks[1].OpenTime.Equal(start.Add(2*time.Second)) {
+		t.Errorf("second brick = %+v, want a volume of 4 from the third trade", bricks[1])
+	}
+}
+
+func TestHeikinAshi(t *testing.T) {
+	data := []Candlestick{
+		{Open: 100, High: 110, Low: 95, Close: 105},
+		{Open: 105, High: 120, Low: 104, Close: 118},
+	}
+	want := []Candlestick{
+		{Open: 102.5, High: 110, Low: 95, Close: 102.5},
+		{Open: 102.5, High: 120, Low: 102.5, Close: 111.75},
+	}
+	for i, got := range calculateHeikinAshi(data) {
+		if got != want[i] {
+			t.Errorf("bar %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+}
+
+func TestTransformStudies(t *testing.T) {
+	data := pathCandles(1, 100, 105, 112, 131, 112, 105)
+	nan := math.NaN()
+	tests := []struct {
+		expr string
+		want []float64
+	}{
+		// The latest brick completed by each candle
+		{"renko(10)", []float64{nan, nan, 110, 130, 130, 110}},
+		{"renko(10).direction", []float64{nan, nan, 1, 1, 1, -1}},
+		{"heikinashi.open", mapCandles(calculateHeikinAshi(data), func(c Candlestick) float64 { return c.Open })},
+	}
+	for _, tt := range tests {
+		study, err := CompileStudy(tt.expr)
+		if err != nil {
+			t.Fatalf("CompileStudy(%s): %v", tt.expr, err)
+		}
+		assertSeries(t, tt.expr, study.Evaluate(data), tt.want)
+	}
+
+	// Without a size the bars are sized by the ATR, which needs enough candles
+	study, err := CompileStudy("pnf.close")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSeries(t, "pnf.close", study.Evaluate(data), nanSlice(len(data)))
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"log"
+	"math"
+	"sort"
+	"time"
+)
+
+// The volatility scan is published under VolatilityKeyPrefix+"scan".
+const VolatilityKeyPrefix = "volatility:"
+
+// VolatilityEstimator computes a rolling volatility over window bars,
+// annualized with periodsPerYear (1 gives per-bar volatility).
+type VolatilityEstimator func(data []Candlestick, window int, periodsPerYear float64) []float64
+
+// periodsPerYear returns the number of bars of the interval in a year. Crypto
+// markets trade around the clock, so a year is 365 full days.
+func periodsPerYear(interval string) (float64, error) {
+	duration, err := intervalDuration(interval)
+	if err != nil {
+		return 0, err
+	}
+	return float64(365*24*time.Hour) / float64(duration), nil
+}
+
+// rollingVolatility returns sqrt(mean(variance) * periodsPerYear) over window
+// bars, where variance[i] is the per-bar variance term of the estimator.
+func rollingVolatility(variance []float64, window int, periodsPerYear float64) []float64 {
+	mean := calculateSMA(variance, window)
+	if mean == nil {
+		return nil
+	}
+	volatility := make([]float64, len(mean))
+	for i, value := range mean {
+		volatility[i] = math.Sqrt(math.Max(value, 0) * periodsPerYear)
+	}
+	return volatility
+}
+
+// rollingSampleVariance returns the sample variance (n-1) over window bars.
+func rollingSampleVariance(data []float64, window int) []float64 {
+	start := firstValid(data)
+	if window < 2 || len(data)-start < window {
+		return nil
+	}
+	variance := nanSlice(len(data))
+	sum, sumSquares := 0.0, 0.0
+	for i := start; i < len(data); i++ {
+		sum += data[i]
+		sumSquares += data[i] * data[i]
+		if i-start >= window {
+			sum -= data[i-window]
+			sumSquares -= data[i-window] * data[i-window]
+		}
+		if i-start >= window-1 {
+			n := float64(window)
+			variance[i] = math.Max((sumSquares-sum*sum/n)/(n-1), 0)
+		}
+	}
+	return variance
+}
+
+// calculateCloseToCloseVolatility is the sample standard deviation of log
+// returns.
+func calculateCloseToCloseVolatility(data []Candlestick, window int, periodsPerYear float64) []float64 {
+	if len(data) < 2 {
+		return nil
+	}
+	returns := nanSlice(len(data))
+	for i := 1; i < len(data); i++ {
+		returns[i] = math.Log(data[i].Close / data[i-1].Close)
+	}
+
+	variance := rollingSampleVariance(returns, window)
+	if variance == nil {
+		return nil
+	}
+	volatility := make([]float64, len(data))
+	for i, value := range variance {
+		volatility[i] = math.Sqrt(value * periodsPerYear)
+	}
+	return volatility
+}
+
+// calculateParkinsonVolatility uses the high-low range.
+func calculateParkinsonVolatility(data []Candlestick, window int, periodsPerYear float64) []float64 {
+	variance := make([]float64, len(data))
+	for i, candle := range data {
+		hl := math.Log(candle.High / candle.Low)
+		variance[i] = hl * hl / (4 * math.Ln2)
+	}
+	return rollingVolatility(variance, window, periodsPerYear)
+}
+
+// calculateGarmanKlassVolatility uses the high-low range and the open-close
+// move.
+func calculateGarmanKlassVolatility(data []Candlestick, window int, periodsPerYear float64) []float64 {
+	variance := make([]float64, len(data))
+	for i, candle := range data {
+		hl := math.Log(candle.High / candle.Low)
+		co := math.Log(candle.Close / candle.Open)
+		variance[i] = 0.5*hl*hl - (2*math.Ln2-1)*co*co
+	}
+	return rollingVolatility(variance, window, periodsPerYear)
+}
+
+func rogersSatchellVariance(candle Candlestick) float64 {
+	hc := math.Log(candle.High / candle.Close)
+	ho := math.Log(candle.High / candle.Open)
+	lc := math.Log(candle.Low / candle.Close)
+	lo := math.Log(candle.Low / candle.Open)
+	return hc*ho + lc*lo
+}
+
+// calculateRogersSatchellVolatility is unbiased for trending prices.
+func calculateRogersSatchellVolatility(data []Candlestick, window int, periodsPerYear float64) []float64 {
+	variance := make([]float64, len(data))
+	for i, candle := range data {
+		variance[i] = rogersSatchellVariance(candle)
+	}
+	return rollingVolatility(variance, window, periodsPerYear)
+}
+
+// calculateYangZhangVolatility combines the open-to-previous-close variance,
+// the open-to-close variance and the Rogers-Satchell variance. The first bar
+// has no previous close, so the first value is at index window.
+func calculateYangZhangVolatility(data []Candlestick, window int, periodsPerYear float64) []float64 {
+	if window < 2 || len(data) <= window {
+		return nil
+	}
+
+	overnight := nanSlice(len(data))
+	openClose := nanSlice(len(data))
+	rs := nanSlice(len(data))
+	for i := 1; i < len(data); i++ {
+		overnight[i] = math.Log(data[i].Open / data[i-1].Close)
+		openClose[i] = math.Log(data[i].Close / data[i].Open)
+		rs[i] = rogersSatchellVariance(data[i])
+	}
+
+	overnightVariance := rollingSampleVariance(overnight, window)
+	openCloseVariance := rollingSampleVariance(openClose, window)
+	rsMean := calculateSMA(rs, window)
+
+	n := float64(window)
+	k := 0.34 / (1.34 + (n+1)/(n-1))
+	volatility := make([]float64, len(data))
+	for i := range data {
+		variance := overnightVariance[i] + k*openCloseVariance[i] + (1-k)*rsMean[i]
+		volatility[i] = math.Sqrt(math.Max(variance, 0) * periodsPerYear)
+	}
+	return volatility
+}
+
+// calculateVolatilityPercentile returns the percentage of the previous
+// lookback values that are below the current value.
+func calculateVolatilityPercentile(volatility []float64, lookback int) []float64 {
+	percentile := nanSlice(len(volatility))
+	if lookback <= 0 {
+		return percentile
+	}
+	for i := range volatility {
+		if math.IsNaN(volatility[i]) || i < lookback {
+			continue
+		}
+		below, count := 0, 0
+		for j := i - lookback; j < i; j++ {
+			if math.IsNaN(volatility[j]) {
+				continue
+			}
+			count++
+			if volatility[j] < volatility[i] {
+				below++
+			}
+		}
+		if count == lookback {
+			percentile[i] = float64(below) / float64(count) * 100
+		}
+	}
+	return percentile
+}
+
+// calculateVolatilityRank returns where the current value sits between the
+// lowest and highest of the last lookback values including itself, from 0 to
+// 100.
+func calculateVolatilityRank(volatility []float64, lookback int) []float64 {
+	rank := nanSlice(len(volatility))
+	if lookback <= 0 {
+		return rank
+	}
+	for i := lookback - 1; i < len(volatility); i++ {
+		lowest, highest := math.Inf(1), math.Inf(-1)
+		valid := true
+		for j := i - lookback + 1; j <= i; j++ {
+			if math.IsNaN(volatility[j]) {
+				valid = false
+				break
+			}
+			lowest = math.Min(lowest, volatility[j])
+			highest = math.Max(highest, volatility[j])
+		}
+		if !valid {
+			continue
+		}
+		if highest == lowest {
+			rank[i] = 0
+			continue
+		}
+		rank[i] = (volatility[i] - lowest) / (highest - lowest) * 100
+	}
+	return rank
+}
+
+// VolatilitySnapshot is the latest volatility reading of a symbol.
+type VolatilitySnapshot struct {
+	Symbol     string
+	Volatility float64
+	Percentile float64
+	Rank       float64
+}
+
+// VolatilityOptions sets the estimator of the volatility scan, its window and
+// the lookback of the percentile and rank. The defaults fit in the candles
+// fetched at startup.
+type VolatilityOptions struct {
+	Estimator VolatilityEstimator
+	Window    int
+	Lookback  int
+}
+
+func defaultVolatilityOptions() VolatilityOptions {
+	return VolatilityOptions{
+		Estimator: calculateYangZhangVolatility,
+		Window:    20,
+		Lookback:  CandleHistoryLimit / 2,
+	}
+}
+
+// scanVolatility computes the latest annualized volatility of every cached
+// symbol from its closed candles and returns them ordered from most to least
+// volatile. Symbols without enough candles are left out.
+func scanVolatility(cache *Cache, symbols []string, interval string, estimator VolatilityEstimator, window, lookback int) ([]VolatilitySnapshot, error) {
+	perYear, err := periodsPerYear(interval)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var snapshots []VolatilitySnapshot
+	for _, symbol := range symbols {
+		candles, err := cache.GetCandlesticks(symbol)
+		if err != nil {
+			log.Printf("Error reading candles for symbol %s: %v\n", symbol, err)
+			continue
+		}
+		volatility := estimator(closedCandles(candles, now), window, perYear)
+		if len(volatility) == 0 || math.IsNaN(volatility[len(volatility)-1]) {
+			continue
+		}
+
+		last := len(volatility) - 1
+		snapshots = append(snapshots, VolatilitySnapshot{
+			Symbol:     symbol,
+			Volatility: volatility[last],
+			Percentile: calculateVolatilityPercentile(volatility, lookback)[last],
+			Rank:       calculateVolatilityRank(volatility, lookback)[last],
+		})
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Volatility > snapshots[j].Volatility
+	})
+	return snapshots, nil
+}
+
+// volatilityRoutine periodically publishes the volatility scan of all symbols.
+func volatilityRoutine(cache *Cache, symbols []string, interval string, opts VolatilityOptions, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for ; true; <-ticker.C {
+		snapshots, err := scanVolatility(cache, symbols, interval, opts.Estimator, opts.Window, opts.Lookback)
+		if err != nil {
+			log.Printf("Error scanning volatility: %v\n", err)
+			continue
+		}
+		if err := cache.Put(VolatilityKeyPrefix+"scan", snapshots, 0); err != nil {
+			log.Printf("Error publishing volatility scan: %v\n", err)
+		}
+	}
+}
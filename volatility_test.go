@@ -0,0 +1,79 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestVolatilityReference(t *testing.T) {
+	data := loadOHLCV(t)
+	want := loadReference(t, "volatility.csv")
+
+	yangZhang := calculateYangZhangVolatility(data, 20, 1)
+	tests := []struct {
+		name string
+		got  []float64
+	}{
+		{"hv20", calculateCloseToCloseVolatility(data, 20, 1)},
+		{"parkinson20", calculateParkinsonVolatility(data, 20, 1)},
+		{"garmanklass20", calculateGarmanKlassVolatility(data, 20, 1)},
+		{"rogerssatchell20", calculateRogersSatchellVolatility(data, 20, 1)},
+		{"yangzhang20", yangZhang},
+		{"yangzhang_percentile50", calculateVolatilityPercentile(yangZhang, 50)},
+		{"yangzhang_rank50", calculateVolatilityRank(yangZhang, 50)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertSeries(t, tt.name, tt.got, want[tt.name])
+		})
+	}
+
+	// Annualizing scales the per-bar volatility by the square root of the
+	// bars in a year
+	perYear, err := periodsPerYear("1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	annual := calculateParkinsonVolatility(data, 20, perYear)
+	for i := range annual {
+		want := want["parkinson20"][i] * math.Sqrt(525600)
+		if math.IsNaN(want) != math.IsNaN(annual[i]) || math.Abs(annual[i]-want) > 1e-9*math.Max(1, want) {
+			t.Fatalf("annualized parkinson20[%d] = %v, want %v", i, annual[i], want)
+		}
+	}
+}
+
+func TestScanVolatility(t *testing.T) {
+	cache := newTestCache(t)
+	start := time.Unix(1700000040, 0)
+	candles := map[string][]Candlestick{
+		"CALMUSDT":  walkCandles(start, CandleHistoryLimit, func(i int) float64 { return 0.001 * math.Sin(float64(i)) }),
+		"WILDUSDT":  walkCandles(start, CandleHistoryLimit, func(i int) float64 { return 0.02 * math.Sin(float64(i)) }),
+		"SHORTUSDT": walkCandles(start, 10, func(i int) float64 { return 0.05 * math.Sin(float64(i)) }),
+	}
+	symbols := []string{"CALMUSDT", "SHORTUSDT", "WILDUSDT"}
+	for _, symbol := range symbols {
+		if err := cache.SetCandlesticks(symbol, candles[symbol]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The symbol with too few candles is left out, the others are ranked
+	// from most to least volatile
+	opts := defaultVolatilityOptions()
+	snapshots, err := scanVolatility(cache, symbols, "1m", calculateCloseToCloseVolatility, opts.Window, opts.Lookback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 || snapshots[0].Symbol != "WILDUSDT" || snapshots[1].Symbol != "CALMUSDT" {
+		t.Fatalf("got %+v, want WILDUSDT then CALMUSDT", snapshots)
+	}
+
+	// The default lookback fits in the startup history
+	for _, snapshot := range snapshots {
+		if math.IsNaN(snapshot.Percentile) || math.IsNaN(snapshot.Rank) {
+			t.Errorf("%s has no percentile or rank with %d candles", snapshot.Symbol, CandleHistoryLimit)
+		}
+	}
+}
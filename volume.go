@@ -0,0 +1,177 @@
+package main
+
+import "math"
+
+// easeOfMovementScale scales volume in the Ease of Movement box ratio so the
+// result is in a readable range.
+const easeOfMovementScale = 100000000
+
+// closeLocationValue is where the close sits within the bar's range, from -1
+// at the low to +1 at the high. A bar without range is 0.
+func closeLocationValue(candle Candlestick) float64 {
+	if candle.High == candle.Low {
+		return 0
+	}
+	return ((candle.Close - candle.Low) - (candle.High - candle.Close)) / (candle.High - candle.Low)
+}
+
+func calculateOBV(data []Candlestick) []float64 {
+	if len(data) == 0 {
+		return nil
+	}
+	obv := make([]float64, len(data))
+	obv[0] = data[0].Volume
+
+	for i := 1; i < len(data); i++ {
+		switch {
+		case data[i].Close > data[i-1].Close:
+			obv[i] = obv[i-1] + data[i].Volume
+		case data[i].Close < data[i-1].Close:
+			obv[i] = obv[i-1] - data[i].Volume
+		default:
+			obv[i] = obv[i-1]
+		}
+	}
+	return obv
+}
+
+// calculateMoneyFlowIndex is a volume-weighted RSI of the typical price. A
+// window without negative flow is 100, a window without any flow is 50.
+func calculateMoneyFlowIndex(data []Candlestick, window int) []float64 {
+	if window <= 0 || len(data) <= window {
+		return nil
+	}
+	mfi := nanSlice(len(data))
+
+	typicalPrice := make([]float64, len(data))
+	for i, candle := range data {
+		typicalPrice[i] = (candle.High + candle.Low + candle.Close) / 3
+	}
+
+	positiveFlow := make([]float64, len(data))
+	negativeFlow := make([]float64, len(data))
+	for i := 1; i < len(data); i++ {
+		rawMoneyFlow := typicalPrice[i] * data[i].Volume
+		if typicalPrice[i] > typicalPrice[i-1] {
+			positiveFlow[i] = rawMoneyFlow
+		} else if typicalPrice[i] < typicalPrice[i-1] {
+			negativeFlow[i] = rawMoneyFlow
+		}
+	}
+
+	positiveSum, negativeSum := 0.0, 0.0
+	for i := 1; i < len(data); i++ {
+		positiveSum += positiveFlow[i]
+		negativeSum += negativeFlow[i]
+		if i > window {
+			positiveSum -= positiveFlow[i-window]
+			negativeSum -= negativeFlow[i-window]
+		}
+		if i >= window {
+			mfi[i] = relativeStrengthIndex(positiveSum, negativeSum)
+		}
+	}
+	return mfi
+}
+
+func calculateAccumulationDistribution(data []Candlestick) []float64 {
+	if len(data) == 0 {
+		return nil
+	}
+	ad := make([]float64, len(data))
+	sum := 0.0
+	for i, candle := range data {
+		sum += closeLocationValue(candle) * candle.Volume
+		ad[i] = sum
+	}
+	return ad
+}
+
+func calculateChaikinMoneyFlow(data []Candlestick, window int) []float64 {
+	if window <= 0 || len(data) < window {
+		return nil
+	}
+	cmf := nanSlice(len(data))
+
+	moneyFlowVolume := make([]float64, len(data))
+	for i, candle := range data {
+		moneyFlowVolume[i] = closeLocationValue(candle) * candle.Volume
+	}
+
+	mfvSum, vSum := 0.0, 0.0
+	for i := range data {
+		mfvSum += moneyFlowVolume[i]
+		vSum += data[i].Volume
+		if i >= window {
+			mfvSum -= moneyFlowVolume[i-window]
+			vSum -= data[i-window].Volume
+		}
+		if i >= window-1 && vSum != 0 {
+			cmf[i] = mfvSum / vSum
+		}
+	}
+	return cmf
+}
+
+// calculateChaikinOscillator is the difference between a short and a long EMA
+// of the accumulation/distribution line, commonly 3 and 10.
+func calculateChaikinOscillator(data []Candlestick, shortWindow, longWindow int) []float64 {
+	return calculateChaikinOscillatorWithMA(data, shortWindow, longWindow, ExponentialMA)
+}
+
+func calculateChaikinOscillatorWithMA(data []Candlestick, shortWindow, longWindow int, maType MAType) []float64 {
+	ad := calculateAccumulationDistribution(data)
+	shortMA := calculateMA(ad, maType, shortWindow)
+	longMA := calculateMA(ad, maType, longWindow)
+	if shortMA == nil || longMA == nil {
+		return nil
+	}
+
+	oscillator := make([]float64, len(data))
+	for i := range data {
+		oscillator[i] = shortMA[i] - longMA[i]
+	}
+	return oscillator
+}
+
+// calculateEaseOfMovement returns the SMA over window of the one-bar ease of
+// movement, with volume scaled by easeOfMovementScale.
+func calculateEaseOfMovement(data []Candlestick, window int) []float64 {
+	if window <= 0 || len(data) <= window {
+		return nil
+	}
+
+	emv := nanSlice(len(data))
+	for i := 1; i < len(data); i++ {
+		distance := (data[i].High+data[i].Low)/2 - (data[i-1].High+data[i-1].Low)/2
+		priceRange := data[i].High - data[i].Low
+		if priceRange == 0 || data[i].Volume == 0 {
+			emv[i] = 0
+			continue
+		}
+		boxRatio := (data[i].Volume / easeOfMovementScale) / priceRange
+		emv[i] = distance / boxRatio
+	}
+
+	return calculateSMA(emv, window)
+}
+
+// calculateForceIndex returns the EMA over window of the price change times
+// volume.
+func calculateForceIndex(data []Candlestick, window int) []float64 {
+	return calculateForceIndexWithMA(data, window, ExponentialMA)
+}
+
+func calculateForceIndexWithMA(data []Candlestick, window int, maType MAType) []float64 {
+	if len(data) < 2 {
+		return nil
+	}
+
+	force := make([]float64, len(data))
+	force[0] = math.NaN()
+	for i := 1; i < len(data); i++ {
+		force[i] = (data[i].Close - data[i-1].Close) * data[i].Volume
+	}
+
+	return calculateMA(force, maType, window)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVolumeIndicatorsReference(t *testing.T) {
+	data := loadOHLCV(t)
+	want := loadReference(t, "volume.csv")
+
+	tests := []struct {
+		name string
+		got  []float64
+	}{
+		{"obv", calculateOBV(data)},
+		{"mfi14", calculateMoneyFlowIndex(data, 14)},
+		{"ad", calculateAccumulationDistribution(data)},
+		{"cmf20", calculateChaikinMoneyFlow(data, 20)},
+		{"chaikin_osc", calculateChaikinOscillator(data, 3, 10)},
+		{"eom14", calculateEaseOfMovement(data, 14)},
+		{"force13", calculateForceIndex(data, 13)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertSeries(t, tt.name, tt.got, want[tt.name])
+		})
+	}
+}
+
+func TestMoneyFlowIndexWithoutFlow(t *testing.T) {
+	start := time.Unix(1700000040, 0)
+	flat := make([]Candlestick, 20)
+	for i := range flat {
+		flat[i] = testCandle(start.Add(time.Duration(i)*time.Minute), 100)
+	}
+	rising := walkCandles(start, 20, func(int) float64 { return 0.01 })
+
+	// Without any flow the index is neutral, without negative flow it is 100
+	for _, tt := range []struct {
+		name string
+		data []Candlestick
+		want float64
+	}{{"flat", flat, 50}, {"rising", rising, 100}} {
+		if got := calculateMoneyFlowIndex(tt.data, 14); got[19] != tt.want {
+			t.Errorf("%s MFI = %v, want %v", tt.name, got[19], tt.want)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// VolumeProfileLevel is the volume traded within one price bin. Price is the
+// lower edge of the bin.
+type VolumeProfileLevel struct {
+	Price      float64
+	Volume     float64
+	BuyVolume  float64
+	SellVolume float64
+}
+
+// VolumeProfile is the distribution of volume over price for a time range.
+// Levels are ordered by ascending price. Value area bounds are the outer edges
+// of the bins containing the requested share of the volume around the point of
+// control. High and low volume nodes are the local maxima above and the local
+// minima below the mean bin volume.
+type VolumeProfile struct {
+	BinSize         float64
+	Levels          []VolumeProfileLevel
+	PointOfControl  float64
+	ValueAreaHigh   float64
+	ValueAreaLow    float64
+	HighVolumeNodes []float64
+	LowVolumeNodes  []float64
+}
+
+// maxVolumeProfileBins bounds the number of bins of a volume profile.
+const maxVolumeProfileBins = 1000
+
+// newVolumeProfile returns empty bins of binSize covering [low, high]. The bin
+// size is widened when it would take more than maxVolumeProfileBins bins.
+func newVolumeProfile(low, high, binSize float64) *VolumeProfile {
+	if minBinSize := (high - low) / (maxVolumeProfileBins - 2); binSize < minBinSize {
+		binSize = minBinSize
+	}
+	start := math.Floor(low/binSize) * binSize
+	count := int(math.Floor((high-start)/binSize)) + 1
+
+	levels := make([]VolumeProfileLevel, count)
+	for i := range levels {
+		levels[i].Price = start + float64(i)*binSize
+	}
+	return &VolumeProfile{BinSize: binSize, Levels: levels}
+}
+
+func (p *VolumeProfile) bin(price float64) int {
+	i := int(math.Floor((price - p.Levels[0].Price) / p.BinSize))
+	if i < 0 {
+		return 0
+	}
+	if i >= len(p.Levels) {
+		return len(p.Levels) - 1
+	}
+	return i
+}
+
+func (p *VolumeProfile) add(price, buyVolume, sellVolume float64) {
+	level := &p.Levels[p.bin(price)]
+	level.BuyVolume += buyVolume
+	level.SellVolume += sellVolume
+	level.Volume += buyVolume + sellVolume
+}
+
+// addRange spreads volume evenly over the bins between low and high.
+func (p *VolumeProfile) addRange(low, high, buyVolume, sellVolume float64) {
+	first, last := p.bin(low), p.bin(high)
+	share := 1 / float64(last-first+1)
+	for i := first; i <= last; i++ {
+		p.Levels[i].BuyVolume += buyVolume * share
+		p.Levels[i].SellVolume += sellVolume * share
+		p.Levels[i].Volume += (buyVolume + sellVolume) * share
+	}
+}
+
+// finalize computes the point of control, the value area holding valueArea
+// (e.g. 0.7) of the volume, and the volume nodes.
+func (p *VolumeProfile) finalize(valueArea float64) {
+	total := 0.0
+	poc := 0
+	for i, level := range p.Levels {
+		total += level.Volume
+		if level.Volume > p.Levels[poc].Volume {
+			poc = i
+		}
+	}
+	p.PointOfControl = p.Levels[poc].Price + p.BinSize/2
+
+	// Grow the value area from the point of control towards the heavier side
+	low, high := poc, poc
+	covered := p.Levels[poc].Volume
+	for covered < valueArea*total && (low > 0 || high < len(p.Levels)-1) {
+		below, above := -1.0, -1.0
+		if low > 0 {
+			below = p.Levels[low-1].Volume
+		}
+		if high < len(p.Levels)-1 {
+			above = p.Levels[high+1].Volume
+		}
+		if above >= below {
+			high++
+			covered += above
+		} else {
+			low--
+			covered += below
+		}
+	}
+	p.ValueAreaLow = p.Levels[low].Price
+	p.ValueAreaHigh = p.Levels[high].Price + p.BinSize
+
+	mean := total / float64(len(p.Levels))
+	for i := 1; i < len(p.Levels)-1; i++ {
+		volume := p.Levels[i].Volume
+		center := p.Levels[i].Price + p.BinSize/2
+		if volume > mean && volume > p.Levels[i-1].Volume && volume >= p.Levels[i+1].Volume {
+			p.HighVolumeNodes = append(p.HighVolumeNodes, center)
+		}
+		if volume < mean && volume < p.Levels[i-1].Volume && volume <= p.Levels[i+1].Volume {
+			p.LowVolumeNodes = append(p.LowVolumeNodes, center)
+		}
+	}
+}
+
+// buildTradeVolumeProfile builds the volume profile of the trades executed
+// within [from, to]. It returns nil when there are no such trades.
+func buildTradeVolumeProfile(trades []Trade, from, to time.Time, binSize, valueArea float64) *VolumeProfile {
+	fromMs, toMs := from.UnixMilli(), to.UnixMilli()
+
+	var selected []Trade
+	low, high := math.Inf(1), math.Inf(-1)
+	for _, trade := range trades {
+		if trade.Time < fromMs || trade.Time > toMs {
+			continue
+		}
+		selected = append(selected, trade)
+		low = math.Min(low, trade.Price)
+		high = math.Max(high, trade.Price)
+	}
+	if len(selected) == 0 || binSize <= 0 {
+		return nil
+	}
+
+	profile := newVolumeProfile(low, high, binSize)
+	for _, trade := range selected {
+		buyVolume, sellVolume := tradeVolumes(trade)
+		profile.add(trade.Price, buyVolume, sellVolume)
+	}
+	profile.finalize(valueArea)
+	return profile
+}
+
+// buildCandleVolumeProfile approximates the volume profile of the candles
+// opened within [from, to] by spreading each candle's volume evenly over its
+// range. It returns nil when there are no such candles.
+func buildCandleVolumeProfile(data []Candlestick, from, to time.Time, binSize, valueArea float64) *VolumeProfile {
+	var selected []Candlestick
+	low, high := math.Inf(1), math.Inf(-1)
+	for _, candle := range data {
+		if candle.OpenTime.Before(from) || candle.OpenTime.After(to) {
+			continue
+		}
+		selected = append(selected, candle)
+		low = math.Min(low, candle.Low)
+		high = math.Max(high, candle.High)
+	}
+	if len(selected) == 0 || binSize <= 0 {
+		return nil
+	}
+
+	profile := newVolumeProfile(low, high, binSize)
+	for _, candle := range selected {
+		buyVolume := candle.TakerBuyBaseAssetVolume
+		profile.addRange(candle.Low, candle.High, buyVolume, candle.Volume-buyVolume)
+	}
+	profile.finalize(valueArea)
+	return profile
+}
+
+// VWAP is a volume-weighted average price series with the volume-weighted
+// standard deviation of price around it.
+type VWAP struct {
+	VWAP   []float64
+	StdDev []float64
+}
+
+// Bands returns the bands numStdDev standard deviations above and below the
+// VWAP.
+func (v VWAP) Bands(numStdDev float64) ([]float64, []float64) {
+	upper := make([]float64, len(v.VWAP))
+	lower := make([]float64, len(v.VWAP))
+	for i := range v.VWAP {
+		upper[i] = v.VWAP[i] + numStdDev*v.StdDev[i]
+		lower[i] = v.VWAP[i] - numStdDev*v.StdDev[i]
+	}
+	return upper, lower
+}
+
+// accumulateVWAP computes a VWAP of the typical price that restarts at every
+// bar for which reset returns true. Bars before the first reset are NaN.
+func accumulateVWAP(data []Candlestick, reset func(i int) bool) VWAP {
+	result := VWAP{VWAP: nanSlice(len(data)), StdDev: nanSlice(len(data))}
+
+	started := false
+	var pv, ppv, v float64
+	for i, candle := range data {
+		if reset(i) {
+			started = true
+			pv, ppv, v = 0, 0, 0
+		}
+		if !started {
+			continue
+		}
+
+		price := (candle.High + candle.Low + candle.Close) / 3
+		pv += price * candle.Volume
+		ppv += price * price * candle.Volume
+		v += candle.Volume
+		if v == 0 {
+			continue
+		}
+
+		vwap := pv / v
+		result.VWAP[i] = vwap
+		result.StdDev[i] = math.Sqrt(math.Max(ppv/v-vwap*vwap, 0))
+	}
+	return result
+}
+
+// calculateSessionVWAP returns a VWAP that resets at each session start. Session
+// starts are offsets from UTC midnight, e.g. 0 for daily sessions or 0, 8h and
+// 16h for three sessions a day.
+func calculateSessionVWAP(data []Candlestick, sessionStarts []time.Duration) VWAP {
+	starts := append([]time.Duration(nil), sessionStarts...)
+	if len(starts) == 0 {
+		starts = []time.Duration{0}
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+
+	// sessionOf returns the start of the session containing t
+	sessionOf := func(t time.Time) time.Time {
+		t = t.UTC()
+		midnight := t.Truncate(24 * time.Hour)
+		session := midnight.Add(starts[len(starts)-1] - 24*time.Hour)
+		for _, start := range starts {
+			if !midnight.Add(start).After(t) {
+				session = midnight.Add(start)
+			}
+		}
+		return session
+	}
+
+	return accumulateVWAP(data, func(i int) bool {
+		return i == 0 || !sessionOf(data[i].OpenTime).Equal(sessionOf(data[i-1].OpenTime))
+	})
+}
+
+// calculateAnchoredVWAP returns a VWAP starting at the first bar opened at or
+// after anchor.
+func calculateAnchoredVWAP(data []Candlestick, anchor time.Time) VWAP {
+	anchorIndex := sort.Search(len(data), func(i int) bool {
+		return !data[i].OpenTime.Before(anchor)
+	})
+	return accumulateVWAP(data, func(i int) bool { return i == anchorIndex })
+}
+
+// calculateRollingVWAP returns the VWAP of the typical price over the last
+// window bars.
+func calculateRollingVWAP(data []Candlestick, window int) VWAP {
+	result := VWAP{VWAP: nanSlice(len(data)), StdDev: nanSlice(len(data))}
+	if window <= 0 {
+		return result
+	}
+
+	var pv, ppv, v float64
+	price := func(candle Candlestick) float64 {
+		return (candle.High + candle.Low + candle.Close) / 3
+	}
+	for i, candle := range data {
+		p := price(candle)
+		pv += p * candle.Volume
+		ppv += p * p * candle.Volume
+		v += candle.Volume
+		if i >= window {
+			old := data[i-window]
+			p := price(old)
+			pv -= p * old.Volume
+			ppv -= p * p * old.Volume
+			v -= old.Volume
+		}
+		if i < window-1 || v <= 0 {
+			continue
+		}
+
+		vwap := pv / v
+		result.VWAP[i] = vwap
+		result.StdDev[i] = math.Sqrt(math.Max(ppv/v-vwap*vwap, 0))
+	}
+	return result
+}
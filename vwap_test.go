@@ -0,0 +1,108 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestVolumeProfileBinCap(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	trades := []Trade{
+		{Price: 100, Quantity: 1, Time: start.UnixMilli()},
+		{Price: 150, Quantity: 2, Time: start.UnixMilli() + 1},
+		{Price: 200, Quantity: 3, BuyerIsMaker: true, Time: start.UnixMilli() + 2},
+	}
+
+	profile := buildTradeVolumeProfile(trades, start, start.Add(time.Second), 1e-9, 0.7)
+	if profile == nil {
+		t.Fatal("expected a profile")
+	}
+	if len(profile.Levels) > maxVolumeProfileBins {
+		t.Errorf("got %d bins, want at most %d", len(profile.Levels), maxVolumeProfileBins)
+	}
+
+	total := 0.0
+	for _, level := range profile.Levels {
+		total += level.Volume
+	}
+	if math.Abs(total-6) > 1e-9 {
+		t.Errorf("total volume = %v, want 6", total)
+	}
+	if profile.PointOfControl < 199 || profile.PointOfControl > 200.5 {
+		t.Errorf("point of control = %v, want about 200", profile.PointOfControl)
+	}
+
+	// A bin size that fits is kept
+	profile = buildTradeVolumeProfile(trades, start, start.Add(time.Second), 10, 0.7)
+	if profile.BinSize != 10 || len(profile.Levels) != 11 {
+		t.Errorf("got %d bins of %v, want 11 bins of 10", len(profile.Levels), profile.BinSize)
+	}
+}
+
+// vwapCandles returns hourly candles trading at the prices with the volumes.
+func vwapCandles(start time.Time, prices, volumes []float64) []Candlestick {
+	data := make([]Candlestick, len(prices))
+	for i := range data {
+		openTime := start.Add(time.Duration(i) * time.Hour)
+		data[i] = Candlestick{OpenTime: openTime, CloseTime: openTime.Add(time.Hour - time.Second),
+			Open: prices[i], High: prices[i], Low: prices[i], Close: prices[i], Volume: volumes[i]}
+	}
+	return data
+}
+
+func TestSessionVWAP(t *testing.T) {
+	// Daily sessions reset at midnight
+	start := time.Date(2024, 1, 1, 22, 0, 0, 0, time.UTC)
+	data := vwapCandles(start, []float64{10, 20, 30, 40}, []float64{1, 3, 1, 1})
+	vwap := calculateSessionVWAP(data, nil)
+	assertSeries(t, "vwap", vwap.VWAP, []float64{10, 17.5, 30, 35})
+	assertSeries(t, "stddev", vwap.StdDev, []float64{0, math.Sqrt(18.75), 0, 5})
+
+	upper, lower := vwap.Bands(2)
+	assertSeries(t, "upper", upper, []float64{10, 17.5 + 2*math.Sqrt(18.75), 30, 45})
+	assertSeries(t, "lower", lower, []float64{10, 17.5 - 2*math.Sqrt(18.75), 30, 25})
+
+	// Sessions are sorted and the hours before the first start of a day
+	// belong to the last session of the day before
+	start = time.Date(2024, 1, 1, 6, 0, 0, 0, time.UTC)
+	data = vwapCandles(start, []float64{10, 20, 30, 40, 50}, []float64{1, 1, 1, 1, 1})
+	for i := range data[3:] {
+		data[3+i].OpenTime = data[3+i].OpenTime.Add(14 * time.Hour)
+	}
+	// Bars open at 06:00, 07:00, 08:00, 23:00 and 00:00
+	vwap = calculateSessionVWAP(data, []time.Duration{8 * time.Hour, 23 * time.Hour})
+	assertSeries(t, "vwap", vwap.VWAP, []float64{10, 15, 30, 40, 45})
+
+	// Zero volume leaves the VWAP undefined until some volume trades
+	data = vwapCandles(start, []float64{10, 20}, []float64{0, 2})
+	assertSeries(t, "vwap", calculateSessionVWAP(data, nil).VWAP, []float64{math.NaN(), 20})
+}
+
+func TestAnchoredVWAP(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	data := vwapCandles(start, []float64{10, 20, 30, 40}, []float64{1, 1, 1, 3})
+	nan := math.NaN()
+
+	// An anchor between bars starts at the next bar and never resets
+	vwap := calculateAnchoredVWAP(data, start.Add(90*time.Minute))
+	assertSeries(t, "vwap", vwap.VWAP, []float64{nan, nan, 30, 37.5})
+	assertSeries(t, "stddev", vwap.StdDev, []float64{nan, nan, 0, math.Sqrt(18.75)})
+
+	assertSeries(t, "vwap", calculateAnchoredVWAP(data, start).VWAP, []float64{10, 15, 20, 30})
+	assertSeries(t, "vwap", calculateAnchoredVWAP(data, start.Add(5*time.Hour)).VWAP, nanSlice(4))
+}
+
+func TestRollingVWAP(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	data := vwapCandles(start, []float64{10, 20, 30, 40, 50}, []float64{1, 3, 1, 0, 0})
+	nan := math.NaN()
+
+	// Bars leave the window as new ones come in, a window without volume is
+	// undefined
+	vwap := calculateRollingVWAP(data, 2)
+	assertSeries(t, "vwap", vwap.VWAP, []float64{nan, 17.5, 22.5, 30, nan})
+	assertSeries(t, "stddev", vwap.StdDev, []float64{nan, math.Sqrt(18.75), math.Sqrt(18.75), 0, nan})
+
+	assertSeries(t, "vwap", calculateRollingVWAP(data, 0).VWAP, nanSlice(5))
+}
@@ -14,27 +14,94 @@ func logWsError(prefix string, symbol string, err error) {
 	fmt.Printf("%s error for symbol %s: %v\n", prefix, symbol, err)
 }
 
-func websocketRoutine(cache *Cache, symbol string, interval string, wg *sync.WaitGroup) {
+// StreamSource opens the kline, aggregate trade and partial depth streams for a
+// symbol.
+// The handlers passed in are the same whether events come from Binance or from
+// a recording.
+type StreamSource interface {
+	KlineServe(symbol string, interval string, handler binance.WsKlineHandler, errHandler binance.ErrHandler) (doneC, stopC chan struct{}, err error)
+	AggTradeServe(symbol string, handler binance.WsAggTradeHandler, errHandler binance.ErrHandler) (doneC, stopC chan struct{}, err error)
+	PartialDepthServe(symbol string, levels string, handler binance.WsPartialDepthHandler, errHandler binance.ErrHandler) (doneC, stopC chan struct{}, err error)
+}
+
+type liveStreamSource struct{}
+
+func (liveStreamSource) KlineServe(symbol string, interval string, handler binance.WsKlineHandler, errHandler binance.ErrHandler) (chan struct{}, chan struct{}, error) {
+	return binance.WsKlineServe(symbol, interval, handler, errHandler)
+}
+
+func (liveStreamSource) AggTradeServe(symbol string, handler binance.WsAggTradeHandler, errHandler binance.ErrHandler) (chan struct{}, chan struct{}, error) {
+	return binance.WsAggTradeServe(symbol, handler, errHandler)
+}
+
+func (liveStreamSource) PartialDepthServe(symbol string, levels string, handler binance.WsPartialDepthHandler, errHandler binance.ErrHandler) (chan struct{}, chan struct{}, error) {
+	return binance.WsPartialDepthServe(symbol, levels, handler, errHandler)
+}
+
+// wsSource is the stream source used by the WebSocket routines. main swaps it
+// for a recording or replay source when configured.
+var wsSource StreamSource = liveStreamSource{}
+
+// OrderBookDepthLevels is the number of levels per side of the order book
+// snapshots streamed by Binance, one of 5, 10 or 20.
+const OrderBookDepthLevels = "20"
+
+func websocketRoutine(cache *Cache, store *Storage, flow *OrderFlowTracker, indices *IndexTracker, symbol string, interval string, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	tradeChan := make(chan float64, 1)
 
 	wg.Add(2) // Increment wait group counter for the two new goroutines
-	go startTradeWebSocket(symbol, tradeChan, wg)
-	go startKlineWebSocket(symbol, interval, cache, tradeChan, wg)
+	go startTradeWebSocket(symbol, store, flow, indices, tradeChan, wg)
+	go startKlineWebSocket(symbol, interval, cache, store, tradeChan, wg)
 }
 
-func startTradeWebSocket(symbol string, tradeChan chan float64, wg *sync.WaitGroup) {
+func startTradeWebSocket(symbol string, store *Storage, flow *OrderFlowTracker, indices *IndexTracker, tradeChan chan float64, wg *sync.WaitGroup) {
 	defer wg.Done()
 	attempt := 0
 	for {
-		doneC, _, err := binance.WsAggTradeServe(symbol, func(event *binance.WsAggTradeEvent) {
+		doneC, _, err := wsSource.AggTradeServe(symbol, func(event *binance.WsAggTradeEvent) {
 			price, err := strconv.ParseFloat(event.Price, 64)
 			if err != nil {
 				log.Printf("Error parsing trade price for symbol %s: %v\n", symbol, err)
 				return
 			}
-			tradeChan <- price
+
+			quantity, err := strconv.ParseFloat(event.Quantity, 64)
+			if err != nil {
+				log.Printf("Error parsing trade quantity for symbol %s: %v\n", symbol, err)
+				return
+			}
+			trade := Trade{
+				ID:           event.AggTradeID,
+				Price:        price,
+				Quantity:     quantity,
+				BuyerIsMaker: event.IsBuyerMaker,
+				Time:         event.TradeTime,
+			}
+
+			if store != nil {
+				if err := store.AppendTrade(symbol, trade); err != nil {
+					log.Printf("Error storing trade for symbol %s: %v\n", symbol, err)
+				}
+			}
+			if flow != nil {
+				flow.AddTrade(symbol, trade)
+			}
+			if indices != nil {
+				indices.AddTrade(symbol, trade)
+			}
+
+			// The kline handler only needs the latest price, replace the
+			// previous one when it was not taken yet
+			select {
+			case <-tradeChan:
+			default:
+			}
+			select {
+			case tradeChan <- price:
+			default:
+			}
 		}, func(err error) {
 			logWsError("WebSocket (trade channel)", symbol, err)
 		})
@@ -50,11 +117,11 @@ func startTradeWebSocket(symbol string, tradeChan chan float64, wg *sync.WaitGro
 	}
 }
 
-func startKlineWebSocket(symbol string, interval string, cache *Cache, tradeChan chan float64, wg *sync.WaitGroup) {
+func startKlineWebSocket(symbol string, interval string, cache *Cache, store *Storage, tradeChan chan float64, wg *sync.WaitGroup) {
 	defer wg.Done()
 	attempt := 0
 	for {
-		doneC, _, err := binance.WsKlineServe(symbol, interval, func(event *binance.WsKlineEvent) {
+		doneC, _, err := wsSource.KlineServe(symbol, interval, func(event *binance.WsKlineEvent) {
 			closePrice := event.Kline.Close
 			if !event.Kline.IsFinal {
 				select {
@@ -87,12 +154,21 @@ func startKlineWebSocket(symbol string, interval string, cache *Cache, tradeChan
 				return
 			}
 
+			// Only finalized candles are persisted, in-progress updates stay in the cache
+			if event.Kline.IsFinal && store != nil {
+				if err := store.AppendCandle(symbol, interval, *candlestick); err != nil {
+					log.Printf("Error storing candle for symbol %s: %v\n", symbol, err)
+				}
+			}
+
 			errr := cache.UpdateKlines(symbol, *candlestick)
 			if errr != nil {
 				return
 			}
 			fmt.Printf("Received update for %s: %+v\n", symbol, candlestick)
-		}, nil)
+		}, func(err error) {
+			logWsError("WebSocket (kline channel)", symbol, err)
+		})
 
 		if err != nil {
 			logWsError("WebSocket (kline channel)", symbol, err)
@@ -105,17 +181,20 @@ func startKlineWebSocket(symbol string, interval string, cache *Cache, tradeChan
 	}
 }
 
-func orderBookWebSocketRoutine(orderBookCache *Cache, symbol string, wg *sync.WaitGroup) {
+func orderBookWebSocketRoutine(orderBookCache *Cache, store *Storage, symbol string, wg *sync.WaitGroup) {
 	// Make sure to call wg.Done() when the function exits
 	defer wg.Done()
 
-	depthChan := make(chan *binance.WsDepthEvent, 1)
+	depthChan := make(chan *binance.WsPartialDepthEvent, 1)
 
 	go startOrderBookWebSocket(symbol, depthChan)
 
 	for {
 		select {
-		case depthEvent := <-depthChan:
+		case depthEvent, ok := <-depthChan:
+			if !ok {
+				return
+			}
 			// Process the depthEvent data
 			fmt.Printf("Received order book update for %s\n", symbol)
 			orderBook, err := depthEventToOrderBook(depthEvent)
@@ -123,18 +202,30 @@ func orderBookWebSocketRoutine(orderBookCache *Cache, symbol string, wg *sync.Wa
 				log.Printf("Error converting depth event for symbol %s: %v\n", symbol, err)
 				continue
 			}
-			err = orderBookCache.Set(symbol, orderBook, time.Minute*5) // IMPORTANT ! This place is suspicious, it is necessary to investigate and know it thoroughly.
+			// Partial depth events carry no time, snapshots are stamped on receipt
+			snapshot := OrderBookSnapshot{
+				Time:      time.Now(),
+				OrderBook: *orderBook,
+			}
+			err = orderBookCache.Put(OrderBookKeyPrefix+symbol, snapshot, time.Minute*5)
 			if err != nil {
 				log.Printf("Error updating order book cache for symbol %s: %v\n", symbol, err)
 			}
+
+			if store != nil {
+				if err := store.AppendOrderBook(symbol, snapshot); err != nil {
+					log.Printf("Error storing order book for symbol %s: %v\n", symbol, err)
+				}
+			}
 		}
 	}
 }
 
-func startOrderBookWebSocket(symbol string, depthChan chan *binance.WsDepthEvent) {
+func startOrderBookWebSocket(symbol string, depthChan chan *binance.WsPartialDepthEvent) {
+	defer close(depthChan)
 	attempt := 0
 	for {
-		doneC, _, err := binance.WsDepthServe(symbol, func(event *binance.WsDepthEvent) {
+		doneC, _, err := wsSource.PartialDepthServe(symbol, OrderBookDepthLevels, func(event *binance.WsPartialDepthEvent) {
 			depthChan <- event
 		}, func(err error) {
 			logWsError("WebSocket (order book channel)", symbol, err)
@@ -151,7 +242,7 @@ func startOrderBookWebSocket(symbol string, depthChan chan *binance.WsDepthEvent
 	}
 }
 
-func depthEventToOrderBook(event *binance.WsDepthEvent) (*OrderBook, error) {
+func depthEventToOrderBook(event *binance.WsPartialDepthEvent) (*OrderBook, error) {
 	bids, err := depthItemsToOrderBookEntries(event.Bids, nil)
 	if err != nil {
 		return nil, err